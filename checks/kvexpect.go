@@ -22,6 +22,7 @@ const (
 	memdPakFieldBucketName     = 1 << 5
 	memdPakFieldScopeName      = 1 << 6
 	memdPakFieldCollectionName = 1 << 7
+	memdPakFieldTraceContext   = 1 << 8
 )
 
 // KvExpect represents a Kv expectation.
@@ -37,6 +38,7 @@ type KvExpect struct {
 	expectBucketName     string
 	expectScopeName      string
 	expectCollectionName string
+	expectTraceContext   []byte
 	expectFns            []func(mock.KvClient, *memd.Packet) bool
 }
 
@@ -70,6 +72,9 @@ func (e KvExpect) String() string {
 	if e.expectFields&memdPakFieldCollectionName != 0 {
 		addExpectation("CollectionName: %s", e.expectCollectionName)
 	}
+	if e.expectFields&memdPakFieldTraceContext != 0 {
+		addExpectation("TraceContext: %s (%v)", e.expectTraceContext, e.expectTraceContext)
+	}
 	if e.expectSource != nil {
 		addExpectation("withSource=YES")
 	}
@@ -152,6 +157,14 @@ func (e KvExpect) CollectionName(name string) *KvExpect {
 	return &e
 }
 
+// TraceContext specifies a specific open tracing span context which is expected
+// to have been sent with the packet.
+func (e KvExpect) TraceContext(traceCtx []byte) *KvExpect {
+	e.expectFields |= memdPakFieldTraceContext
+	e.expectTraceContext = traceCtx
+	return &e
+}
+
 // Custom allows specifying custom logic to use to check the packet.
 func (e KvExpect) Custom(chkFn func(mock.KvClient, *memd.Packet) bool) *KvExpect {
 	e.expectFns = append(e.expectFns, chkFn)
@@ -179,6 +192,11 @@ func (e KvExpect) match(source mock.KvClient, pak *memd.Packet) bool {
 	if e.expectFields&memdPakFieldCollectionID != 0 && pak.CollectionID != e.expectCollectionID {
 		shouldReject = true
 	}
+	if e.expectFields&memdPakFieldTraceContext != 0 {
+		if pak.OpenTracingFrame == nil || !bytes.Equal(pak.OpenTracingFrame.TraceContext, e.expectTraceContext) {
+			shouldReject = true
+		}
+	}
 
 	bucket := source.SelectedBucket()
 	if e.expectFields&(memdPakFieldBucketName) != 0 {
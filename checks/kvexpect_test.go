@@ -0,0 +1,39 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// testNoBucketKvClient is a kv client which has not selected a bucket, any
+// other methods are not expected to be used.
+type testNoBucketKvClient struct {
+	mock.KvClient
+}
+
+func (c *testNoBucketKvClient) SelectedBucket() mock.Bucket {
+	return nil
+}
+
+func TestKvExpectTraceContext(t *testing.T) {
+	expect := KvExpect{}.Cmd(memd.CmdGet).TraceContext([]byte("span-1"))
+	source := &testNoBucketKvClient{}
+
+	if expect.match(source, &memd.Packet{Command: memd.CmdGet}) {
+		t.Fatalf("expected packet without a trace context not to match")
+	}
+	if expect.match(source, &memd.Packet{
+		Command:          memd.CmdGet,
+		OpenTracingFrame: &memd.OpenTracingFrame{TraceContext: []byte("span-2")},
+	}) {
+		t.Fatalf("expected packet with a different trace context not to match")
+	}
+	if !expect.match(source, &memd.Packet{
+		Command:          memd.CmdGet,
+		OpenTracingFrame: &memd.OpenTracingFrame{TraceContext: []byte("span-1")},
+	}) {
+		t.Fatalf("expected packet with the trace context to match")
+	}
+}
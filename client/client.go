@@ -180,6 +180,7 @@ func (c *Client) roundTripCommand(req map[string]interface{}) (map[string]interf
 type CreateClusterResult struct {
 	ConnStr         string
 	ManagementAddrs []string
+	CACert          string
 }
 
 // CreateCluster instantiates a new CAVES test cluster.
@@ -197,9 +198,11 @@ func (c *Client) CreateCluster(clusterID string) (*CreateClusterResult, error) {
 	for _, m := range mgmtInterfaces {
 		mgmtAddrs = append(mgmtAddrs, m.(string))
 	}
+	caCert, _ := resp["ca_cert"].(string)
 	return &CreateClusterResult{
 		ConnStr:         resp["connstr"].(string),
 		ManagementAddrs: mgmtAddrs,
+		CACert:          caCert,
 	}, nil
 }
 
@@ -304,3 +307,35 @@ func (c *Client) AddBucketCluster(clusterID string, name, typ string, replicas u
 	})
 	return err
 }
+
+// RotateCertificatesCluster rotates the certificates of a specific cluster,
+// returning the PEM encoded certificate of its new certificate authority.
+func (c *Client) RotateCertificatesCluster(clusterID string) (string, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":    "rotatecerts",
+		"cluster": clusterID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	caCert, _ := resp["ca_cert"].(string)
+	return caCert, nil
+}
+
+// IssueClientCertCluster issues a client certificate with a specific common
+// name from a cluster, returning the PEM encoded certificate and private key.
+func (c *Client) IssueClientCertCluster(clusterID, commonName string) (string, string, error) {
+	resp, err := c.roundTripCommand(map[string]interface{}{
+		"type":        "issueclientcert",
+		"cluster":     clusterID,
+		"common_name": commonName,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	cert, _ := resp["cert"].(string)
+	key, _ := resp["key"].(string)
+	return cert, key, nil
+}
@@ -13,13 +13,15 @@ type CmdHello struct {
 
 // CmdCreateCluster requests a new mock cluster be created.
 type CmdCreateCluster struct {
-	ClusterID string `json:"id"`
+	ClusterID     string `json:"id"`
+	ServerVersion string `json:"server_version,omitempty"`
 }
 
 // CmdCreatedCluster represents the reply to a create cluster request.
 type CmdCreatedCluster struct {
 	MgmtAddrs []string `json:"mgmt_addrs"`
 	ConnStr   string   `json:"connstr"`
+	CACert    string   `json:"ca_cert,omitempty"`
 }
 
 // CmdTimeTravel allows a test run or cluster to be time travelled.
@@ -43,6 +45,7 @@ type CmdStartTesting struct {
 type CmdStartedTesting struct {
 	MgmtAddrs []string `json:"mgmt_addrs"`
 	ConnStr   string   `json:"connstr"`
+	CACert    string   `json:"ca_cert,omitempty"`
 }
 
 // CmdEndTesting indicates to stop a particular report.
@@ -92,22 +95,48 @@ type CmdAddBucket struct {
 type CmdAddedBucket struct {
 }
 
+// CmdRotateCertificates requests the certificates of a mock cluster be rotated.
+type CmdRotateCertificates struct {
+	ClusterID string `json:"cluster"`
+}
+
+// CmdRotatedCertificates represents the reply to a rotate certificates request.
+type CmdRotatedCertificates struct {
+	CACert string `json:"ca_cert"`
+}
+
+// CmdIssueClientCert requests a client certificate be issued by a mock cluster.
+type CmdIssueClientCert struct {
+	ClusterID  string `json:"cluster"`
+	CommonName string `json:"common_name"`
+}
+
+// CmdIssuedClientCert represents the reply to an issue client certificate request.
+type CmdIssuedClientCert struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
 var cmdsMap = map[string]reflect.Type{
-	"hello":          reflect.TypeOf(CmdHello{}),
-	"createcluster":  reflect.TypeOf(CmdCreateCluster{}),
-	"createdcluster": reflect.TypeOf(CmdCreatedCluster{}),
-	"starttesting":   reflect.TypeOf(CmdStartTesting{}),
-	"startedtesting": reflect.TypeOf(CmdStartedTesting{}),
-	"endtesting":     reflect.TypeOf(CmdEndTesting{}),
-	"endedtesting":   reflect.TypeOf(CmdEndedTesting{}),
-	"starttest":      reflect.TypeOf(CmdStartTest{}),
-	"startedtest":    reflect.TypeOf(CmdStartedTest{}),
-	"endtest":        reflect.TypeOf(CmdEndTest{}),
-	"endedtest":      reflect.TypeOf(CmdEndedTest{}),
-	"timetravel":     reflect.TypeOf(CmdTimeTravel{}),
-	"timetravelled":  reflect.TypeOf(CmdTimeTravelled{}),
-	"addbucket":      reflect.TypeOf(CmdAddBucket{}),
-	"addedbucket":    reflect.TypeOf(CmdAddedBucket{}),
+	"hello":            reflect.TypeOf(CmdHello{}),
+	"createcluster":    reflect.TypeOf(CmdCreateCluster{}),
+	"createdcluster":   reflect.TypeOf(CmdCreatedCluster{}),
+	"starttesting":     reflect.TypeOf(CmdStartTesting{}),
+	"startedtesting":   reflect.TypeOf(CmdStartedTesting{}),
+	"endtesting":       reflect.TypeOf(CmdEndTesting{}),
+	"endedtesting":     reflect.TypeOf(CmdEndedTesting{}),
+	"starttest":        reflect.TypeOf(CmdStartTest{}),
+	"startedtest":      reflect.TypeOf(CmdStartedTest{}),
+	"endtest":          reflect.TypeOf(CmdEndTest{}),
+	"endedtest":        reflect.TypeOf(CmdEndedTest{}),
+	"timetravel":       reflect.TypeOf(CmdTimeTravel{}),
+	"timetravelled":    reflect.TypeOf(CmdTimeTravelled{}),
+	"addbucket":        reflect.TypeOf(CmdAddBucket{}),
+	"addedbucket":      reflect.TypeOf(CmdAddedBucket{}),
+	"rotatecerts":      reflect.TypeOf(CmdRotateCertificates{}),
+	"rotatedcerts":     reflect.TypeOf(CmdRotatedCertificates{}),
+	"issueclientcert":  reflect.TypeOf(CmdIssueClientCert{}),
+	"issuedclientcert": reflect.TypeOf(CmdIssuedClientCert{}),
 }
 
 // EncodeCommandPacket encodes a packet from a structure to bytes bytes.
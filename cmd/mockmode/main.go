@@ -9,6 +9,7 @@ import (
 
 type stdoutData struct {
 	ConnStr string `json:"connstr"`
+	CACert  string `json:"ca_cert"`
 }
 
 func writeStdoutData(data *stdoutData) error {
@@ -43,6 +44,7 @@ func (m *Main) Go() {
 
 	err = writeStdoutData(&stdoutData{
 		ConnStr: cluster.ConnectionString(),
+		CACert:  string(cluster.CACertificate()),
 	})
 	if err != nil {
 		panic(err)
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockcert"
 	"github.com/couchbaselabs/gocaves/mock/mockimpl"
 )
 
@@ -17,8 +18,17 @@ type clusterManager struct {
 	Clusters []*namedCluster
 }
 
-func (m *clusterManager) NewCluster(clusterID string) (*namedCluster, error) {
-	mock, err := mockimpl.NewDefaultCluster()
+func (m *clusterManager) NewCluster(clusterID string, serverVersionStr string) (*namedCluster, error) {
+	serverVersion := mock.ServerVersionLatest
+	if serverVersionStr != "" {
+		var err error
+		serverVersion, err = mock.ParseServerVersion(serverVersionStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mock, err := mockimpl.NewDefaultClusterWithVersion(serverVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +94,33 @@ func (m *clusterManager) AddBucket(clusterID, name, typ string, replicas uint) e
 	})
 	return err
 }
+
+func (m *clusterManager) RotateCertificates(clusterID string) ([]byte, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, errors.New("invalid cluster id")
+	}
+
+	err := ncluster.Mock.RotateCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	return ncluster.Mock.CACertificate(), nil
+}
+
+func (m *clusterManager) IssueClientCert(clusterID, commonName string) ([]byte, []byte, error) {
+	ncluster := m.Get(clusterID)
+	if ncluster == nil {
+		return nil, nil, errors.New("invalid cluster id")
+	}
+
+	cert, err := ncluster.Mock.IssueClientCertificate(mockcert.IssueClientCertificateOptions{
+		CommonName: commonName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return mockcert.EncodeCertificatePEM(cert)
+}
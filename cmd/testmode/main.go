@@ -79,7 +79,7 @@ func (m *Main) maybeSendReport(report *jsonRunReport) {
 func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 	switch pktTyped := pkt.(type) {
 	case *api.CmdCreateCluster:
-		cluster, err := m.clusterMgr.NewCluster(pktTyped.ClusterID)
+		cluster, err := m.clusterMgr.NewCluster(pktTyped.ClusterID, pktTyped.ServerVersion)
 		if err != nil {
 			log.Printf("failed to create cluster: %s", err)
 			return &api.CmdCreatedCluster{}
@@ -88,6 +88,7 @@ func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 		return &api.CmdCreatedCluster{
 			MgmtAddrs: cluster.Mock.MgmtAddrs(),
 			ConnStr:   cluster.Mock.ConnectionString(),
+			CACert:    string(cluster.Mock.CACertificate()),
 		}
 	case *api.CmdStartTesting:
 		run, err := m.testRuns.NewRun(pktTyped.RunID, pktTyped.ClientName)
@@ -99,6 +100,7 @@ func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 		return &api.CmdStartedTesting{
 			MgmtAddrs: run.RunGroup.DefaultCluster().MgmtAddrs(),
 			ConnStr:   run.RunGroup.DefaultCluster().ConnectionString(),
+			CACert:    string(run.RunGroup.DefaultCluster().CACertificate()),
 		}
 
 	case *api.CmdEndTesting:
@@ -158,6 +160,27 @@ func (m *Main) handleAPIRequest(pkt interface{}) interface{} {
 		}
 
 		return &api.CmdAddedBucket{}
+	case *api.CmdRotateCertificates:
+		caCert, err := m.clusterMgr.RotateCertificates(pktTyped.ClusterID)
+		if err != nil {
+			log.Printf("failed to rotate certificates: %s", err)
+			return &api.CmdRotatedCertificates{}
+		}
+
+		return &api.CmdRotatedCertificates{
+			CACert: string(caCert),
+		}
+	case *api.CmdIssueClientCert:
+		cert, key, err := m.clusterMgr.IssueClientCert(pktTyped.ClusterID, pktTyped.CommonName)
+		if err != nil {
+			log.Printf("failed to issue client certificate: %s", err)
+			return &api.CmdIssuedClientCert{}
+		}
+
+		return &api.CmdIssuedClientCert{
+			Cert: string(cert),
+			Key:  string(key),
+		}
 	}
 
 	return nil
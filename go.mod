@@ -8,6 +8,7 @@ require (
 	github.com/couchbaselabs/gocaves/client v0.0.0-20211201195517-a39ea9ff2037
 	github.com/dop251/goja v0.0.0-20210427212725-462d53687b0d
 	github.com/go-bindata/go-bindata v3.1.2+incompatible
+	github.com/golang/snappy v0.0.1
 	github.com/google/uuid v1.1.1
 	github.com/stretchr/testify v1.5.1
 )
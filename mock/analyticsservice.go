@@ -1,6 +1,9 @@
 package mock
 
-import "github.com/couchbaselabs/gocaves/mock/mockauth"
+import (
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockn1ql"
+)
 
 // AnalyticsService represents a analytics service running somewhere in the cluster.
 type AnalyticsService interface {
@@ -22,3 +25,15 @@ type AnalyticsService interface {
 	// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 	CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string, request *HTTPRequest) bool
 }
+
+// AnalyticsEngine represents the analytics engine shared by the analytics services of a cluster.
+type AnalyticsEngine interface {
+	// Execute executes an analytics query.
+	Execute(opts mockn1ql.AnalyticsExecuteOptions) (*mockn1ql.ExecuteResults, error)
+
+	// GetAllDatasets returns all of the datasets in the cluster.
+	GetAllDatasets() []*mockn1ql.Dataset
+
+	// PendingMutations returns the number of mutations each dataset has yet to ingest.
+	PendingMutations(keyspaces mockn1ql.KeyspaceResolver) map[string]map[string]int
+}
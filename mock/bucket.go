@@ -56,6 +56,27 @@ const (
 	CompressionModeActive CompressionMode = "active"
 )
 
+// DurabilityLevel specifies the minimum durability level which is applied to
+// all mutations performed against a bucket.
+type DurabilityLevel string
+
+const (
+	// DurabilityLevelNone specifies that mutations have no minimum durability.
+	DurabilityLevelNone DurabilityLevel = "none"
+
+	// DurabilityLevelMajority specifies that mutations must be replicated to a
+	// majority of the nodes hosting the vbucket.
+	DurabilityLevelMajority DurabilityLevel = "majority"
+
+	// DurabilityLevelMajorityAndPersistActive specifies that mutations must be
+	// replicated to a majority of nodes and persisted on the active node.
+	DurabilityLevelMajorityAndPersistActive DurabilityLevel = "majorityAndPersistActive"
+
+	// DurabilityLevelPersistToMajority specifies that mutations must be
+	// persisted on a majority of the nodes hosting the vbucket.
+	DurabilityLevelPersistToMajority DurabilityLevel = "persistToMajority"
+)
+
 // NewBucketOptions allows you to specify initial options for a new bucket
 type NewBucketOptions struct {
 	Name                string
@@ -65,6 +86,8 @@ type NewBucketOptions struct {
 	RamQuota            uint64
 	ReplicaIndexEnabled bool
 	CompressionMode     CompressionMode
+	DurabilityMinLevel  DurabilityLevel
+	MaxTTL              uint32
 }
 
 // UpdateBucketOptions allows you to specify options for updating a bucket
@@ -74,6 +97,10 @@ type UpdateBucketOptions struct {
 	RamQuota            uint64
 	ReplicaIndexEnabled bool
 	CompressionMode     CompressionMode
+	DurabilityMinLevel  DurabilityLevel
+
+	// MaxTTL is left unchanged when nil, as zero disables the maximum TTL.
+	MaxTTL *uint32
 }
 
 // Bucket represents an instance of a bucket.
@@ -87,6 +114,9 @@ type Bucket interface {
 	// BucketType returns the type of bucket this is.
 	BucketType() BucketType
 
+	// Cluster returns the Cluster this bucket is part of.
+	Cluster() Cluster
+
 	// NumReplicas returns the number of configured replicas for this bucket
 	NumReplicas() uint
 
@@ -104,8 +134,9 @@ type Bucket interface {
 	// be very explicit such that vbNode = (vbId % numNode), and replicas are just ++.
 	UpdateVbMap(nodeList []string)
 
-	// GetVbServerInfo returns the vb nodes, then the vb map, then the ordered list of all nodes
-	GetVbServerInfo(reqNode ClusterNode) ([]ClusterNode, [][]int, []ClusterNode)
+	// GetVbServerInfo returns the vb nodes, then the vb map, then the forward vb map
+	// (which is nil unless a rebalance is running), then the ordered list of all nodes
+	GetVbServerInfo(reqNode ClusterNode) ([]ClusterNode, [][]int, [][]int, []ClusterNode)
 
 	// VbucketOwnership returns the replica index associated with the provided node.
 	// A response of -1 means it does not own any replicas for that vbucket.
@@ -132,4 +163,12 @@ type Bucket interface {
 
 	// CompressionMode returns the compression mode used by this bucket.
 	CompressionMode() CompressionMode
+
+	// DurabilityMinLevel returns the minimum durability level applied to all
+	// mutations performed against this bucket.
+	DurabilityMinLevel() DurabilityLevel
+
+	// MaxTTL returns the maximum TTL, in seconds, of documents in this bucket.
+	// A value of 0 indicates that there is no maximum.
+	MaxTTL() uint32
 }
@@ -1,8 +1,10 @@
 package mock
 
 import (
+	"crypto/tls"
 	"time"
 
+	"github.com/couchbaselabs/gocaves/mock/mockcert"
 	"github.com/couchbaselabs/gocaves/mock/mocktime"
 )
 
@@ -13,6 +15,18 @@ type NewClusterOptions struct {
 	InitialNode    NewNodeOptions
 	ReplicaLatency time.Duration
 	PersistLatency time.Duration
+	IndexLatency   time.Duration
+	ServerVersion  ServerVersion
+}
+
+// RebalanceOptions specifies how a rebalance moves vbuckets between nodes.
+type RebalanceOptions struct {
+	// BatchSize is the number of vbuckets moved in each step of the rebalance.
+	// A batch size of zero moves every vbucket at once.
+	BatchSize uint
+
+	// BatchInterval is the (mock) time which passes between each step.
+	BatchInterval time.Duration
 }
 
 // Cluster represents an instance of a mock cluster
@@ -23,9 +37,47 @@ type Cluster interface {
 	// ConfigRev returns the current configuration revision for this cluster.
 	ConfigRev() uint
 
+	// ServerVersion returns the version of the server this cluster is emulating.
+	ServerVersion() ServerVersion
+
 	// AddNode will add a new node to a cluster.
 	AddNode(opts NewNodeOptions) (ClusterNode, error)
 
+	// RemoveNode will rebalance a node out of the cluster and shut it down.
+	RemoveNode(node ClusterNode) error
+
+	// HardFailover will fail a node over as if it had crashed.  Its replicas
+	// are promoted and all of its services start dropping connections.
+	HardFailover(node ClusterNode) error
+
+	// GracefulFailover will fail a node over once every vbucket it is active
+	// for can be handed to a replica.  The node itself keeps running.
+	GracefulFailover(node ClusterNode) error
+
+	// RecoverNode will add a failed over node back into the cluster.
+	RecoverNode(node ClusterNode, recoveryType RecoveryType) error
+
+	// Rebalance will move the vbuckets of every bucket such that they are laid
+	// out over the active nodes.  The vbuckets are moved in batches over time,
+	// with this call returning as soon as the rebalance has started.
+	Rebalance(opts RebalanceOptions) error
+
+	// RebalanceProgress returns whether a rebalance is running, and if so how
+	// far through it is, between 0 and 1.
+	RebalanceProgress() (bool, float64)
+
+	// CACertificate returns the PEM encoded certificate of the certificate
+	// authority which issues the certificates of the nodes in this cluster.
+	CACertificate() []byte
+
+	// RotateCertificates replaces the certificate authority of this cluster and
+	// issues each of the nodes a new certificate from it.
+	RotateCertificates() error
+
+	// IssueClientCertificate issues a certificate from the certificate authority
+	// of this cluster which clients can use to authenticate themselves.
+	IssueClientCertificate(opts mockcert.IssueClientCertificateOptions) (*tls.Certificate, error)
+
 	// AddBucket will add a new bucket to a cluster.
 	AddBucket(opts NewBucketOptions) (Bucket, error)
 
@@ -59,6 +111,18 @@ type Cluster interface {
 	// Chrono returns the chrono object in use by the cluster.
 	Chrono() *mocktime.Chrono
 
+	// QueryEngine returns the query engine for the cluster.
+	QueryEngine() QueryEngine
+
+	// AnalyticsEngine returns the analytics engine for the cluster.
+	AnalyticsEngine() AnalyticsEngine
+
+	// SearchEngine returns the search engine for the cluster.
+	SearchEngine() SearchEngine
+
+	// EventingEngine returns the eventing engine for the cluster.
+	EventingEngine() EventingEngine
+
 	// Users returns the user service for the cluster.
 	Users() UserManager
 
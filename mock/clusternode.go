@@ -1,11 +1,67 @@
 package mock
 
+// AlternateAddress specifies an alternate network address which a node can
+// be reached at, such as the address used from outside of a container.
+type AlternateAddress struct {
+	// Hostname is the hostname of the node on this network.
+	Hostname string
+
+	// Ports maps the names of services, as used in the nodesExt section of
+	// configs (kv, kvSSL, mgmt, mgmtSSL, ...), to the port which they are
+	// accessible on from this network.  Leaving this empty indicates that
+	// the services are accessible on the same ports as on the default network.
+	Ports map[string]int
+}
+
 // NewNodeOptions allows the specification of initial options for a new node.
 type NewNodeOptions struct {
 	Features []ClusterNodeFeature
 	Services []ServiceType
+
+	// Hostname specifies the hostname which the node advertises in configs,
+	// defaulting to 127.0.0.1.  Nodes always listen on all interfaces, so this
+	// must resolve to the local machine.
+	Hostname string
+
+	// AlternateAddresses specifies the addresses which the node can be reached
+	// at on other networks, keyed by the name of the network (ie: external).
+	AlternateAddresses map[string]AlternateAddress
+}
+
+// NodeMembership specifies the membership state of a node within its cluster.
+type NodeMembership uint
+
+// The following lists the possible node membership states
+const (
+	NodeMembershipActive         = NodeMembership(1)
+	NodeMembershipInactiveFailed = NodeMembership(2)
+)
+
+// Name returns the name of this membership state as used in cluster configs.
+func (m NodeMembership) Name() string {
+	switch m {
+	case NodeMembershipActive:
+		return "active"
+	case NodeMembershipInactiveFailed:
+		return "inactiveFailed"
+	}
+
+	return ""
 }
 
+// RecoveryType specifies how a failed over node is brought back into the cluster.
+type RecoveryType uint
+
+// The following lists the possible recovery types
+const (
+	// RecoveryTypeDelta recovers a node with the data it already holds, so it
+	// returns to the same place in the vbucket map which it had before.
+	RecoveryTypeDelta = RecoveryType(1)
+
+	// RecoveryTypeFull recovers a node as if it were a newly added node.
+	RecoveryTypeFull = RecoveryType(2)
+)
+
 // ClusterNode specifies a node within a cluster instance.
 type ClusterNode interface {
 	// ID returns the uuid of this node.
@@ -32,9 +88,19 @@ type ClusterNode interface {
 	// AnalyticsService returns the analytics service for this node.
 	AnalyticsService() AnalyticsService
 
+	// EventingService returns the eventing service for this node.
+	EventingService() EventingService
+
+	// Membership returns the membership state of this node within its cluster.
+	Membership() NodeMembership
+
 	// ErrorMap returns the error map for this node.
 	ErrorMap() *ErrorMap
 
 	// HostName returns the address for this node.
 	Hostname() string
+
+	// AlternateAddresses returns the addresses which this node can be reached
+	// at on other networks, keyed by the name of the network.
+	AlternateAddresses() map[string]AlternateAddress
 }
@@ -76,6 +76,52 @@ func (m *CollectionManifest) GetByID(collectionID uint32) (string, string) {
 	return scope.Name, col.Name
 }
 
+// CheckCollectionID verifies that a collection with the specified ID exists,
+// returning ErrCollectionNotFound if it does not.
+func (m *CollectionManifest) CheckCollectionID(collectionID uint32) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	col, ok := m.Collections[collectionID]
+	if !ok || col == nil {
+		return ErrCollectionNotFound
+	}
+
+	return nil
+}
+
+// CheckScopeID verifies that a scope with the specified ID exists, returning
+// ErrScopeNotFound if it does not.
+func (m *CollectionManifest) CheckScopeID(scopeID uint32) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	scope, ok := m.Scopes[scopeID]
+	if !ok || scope == nil {
+		return ErrScopeNotFound
+	}
+
+	return nil
+}
+
+// GetMaxTTL returns the maximum TTL, in seconds, of documents within a
+// collection.  A value of 0 indicates that the collection has no maximum.
+func (m *CollectionManifest) GetMaxTTL(collectionID uint32) (uint32, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	col, ok := m.Collections[collectionID]
+	if !ok || col == nil {
+		return 0, ErrCollectionNotFound
+	}
+
+	return col.MaxTTL, nil
+}
+
+// UID returns the uid of the current version of the manifest.
+func (m *CollectionManifest) UID() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Rev
+}
+
 // GetByName retrieves a collection uid by scope and collection name.
 func (m *CollectionManifest) GetByName(scope, collection string) (uint64, uint32, error) {
 	m.lock.Lock()
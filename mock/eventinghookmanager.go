@@ -0,0 +1,17 @@
+package mock
+
+// EventingHookFunc implements a hook for handling an eventing request.
+// NOTE: It is safe and expected that a hook may alter the packet.
+type EventingHookFunc func(source EventingService, req *HTTPRequest, next func() *HTTPResponse) *HTTPResponse
+
+// EventingHookManager implements a tree of hooks which can handle an eventing request.
+type EventingHookManager interface {
+	// Child returns a child hook manager to this hook manager.
+	Child() EventingHookManager
+
+	// Add adds a new hook at the end of the processing chain.
+	Add(fn EventingHookFunc)
+
+	// Destroy removes all this managers hooks from the root manager.
+	Destroy()
+}
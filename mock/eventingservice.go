@@ -0,0 +1,63 @@
+package mock
+
+import (
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockeventing"
+)
+
+// EventingService represents an eventing service running somewhere in the cluster.
+type EventingService interface {
+	// Node returns the node which owns this service.
+	Node() ClusterNode
+
+	// Hostname returns the hostname where this service can be accessed.
+	Hostname() string
+
+	// ListenPort returns the port this service is listening on.
+	ListenPort() int
+
+	// ListenPortTLS returns the TLS port this service is listening on.
+	ListenPortTLS() int
+
+	// Close will shut down this service once it is no longer needed.
+	Close() error
+
+	// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
+	CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string, request *HTTPRequest) bool
+}
+
+// EventingEngine represents the eventing engine shared by the eventing services of a cluster.
+type EventingEngine interface {
+	// UpsertFunction creates or updates an eventing function.
+	UpsertFunction(def *mockeventing.Function, keyspaces mockeventing.KeyspaceResolver) error
+
+	// GetFunction returns the definition of an eventing function.
+	GetFunction(scope mockeventing.FunctionScope, name string) (*mockeventing.Function, error)
+
+	// GetAllFunctions returns the definitions of all of the eventing functions.
+	GetAllFunctions() []*mockeventing.Function
+
+	// DropFunction removes an eventing function.
+	DropFunction(scope mockeventing.FunctionScope, name string) error
+
+	// DeployFunction starts running an eventing function.
+	DeployFunction(scope mockeventing.FunctionScope, name string, keyspaces mockeventing.KeyspaceResolver) error
+
+	// UndeployFunction stops running an eventing function.
+	UndeployFunction(scope mockeventing.FunctionScope, name string) error
+
+	// PauseFunction pauses an eventing function.
+	PauseFunction(scope mockeventing.FunctionScope, name string) error
+
+	// ResumeFunction resumes a paused eventing function.
+	ResumeFunction(scope mockeventing.FunctionScope, name string) error
+
+	// FunctionsStatus returns the status of all of the eventing functions.
+	FunctionsStatus() []*mockeventing.FunctionStatus
+
+	// FunctionLog returns the application log of an eventing function.
+	FunctionLog(scope mockeventing.FunctionScope, name string) ([]string, error)
+
+	// UndeployBucketFunctions undeploys the eventing functions which depend on a bucket.
+	UndeployBucketFunctions(bucket string)
+}
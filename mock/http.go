@@ -3,6 +3,7 @@ package mock
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -19,6 +20,10 @@ type HTTPRequest struct {
 	Form    url.Values
 	Context context.Context
 	Flusher http.Flusher
+
+	// TLS holds the state of the TLS connection the request arrived on, and
+	// is nil for requests which did not arrive via TLS.
+	TLS *tls.ConnectionState
 }
 
 // PeekBody will return the full body and swap the reader with a
@@ -0,0 +1,44 @@
+package mock
+
+import "github.com/couchbase/gocbcore/v9/memd"
+
+// The following are memcached protocol constants which are not defined by
+// the version of the memd package that we use.
+const (
+	// CmdMagicServerReq is the magic used for requests which the server
+	// pushes to clients over a duplex connection.
+	CmdMagicServerReq = memd.CmdMagic(0x82)
+
+	// CmdMagicServerRes is the magic used for client responses to requests
+	// which were pushed by the server.
+	CmdMagicServerRes = memd.CmdMagic(0x83)
+
+	// CmdClusterMapChangeNotification is the server request used to push a
+	// new cluster map to clients which have negotiated it.
+	CmdClusterMapChangeNotification = memd.CmdCode(0x01)
+
+	// CmdRangeScanCreate creates a new range scan against a vbucket.
+	CmdRangeScanCreate = memd.CmdCode(0xda)
+
+	// CmdRangeScanContinue requests the next batch of items from a range scan.
+	CmdRangeScanContinue = memd.CmdCode(0xdb)
+
+	// CmdRangeScanCancel cancels a range scan.
+	CmdRangeScanCancel = memd.CmdCode(0xdc)
+
+	// StatusRangeScanCancelled indicates that a range scan was cancelled while
+	// it was being continued.
+	StatusRangeScanCancelled = memd.StatusCode(0xa5)
+
+	// StatusRangeScanMore indicates that a range scan continue has reached one
+	// of its limits, and more items remain.
+	StatusRangeScanMore = memd.StatusCode(0xa6)
+
+	// StatusRangeScanComplete indicates that a range scan has returned all of
+	// its items.
+	StatusRangeScanComplete = memd.StatusCode(0xa7)
+
+	// StatusVbUUIDNotEqual indicates that the vbucket uuid specified in a
+	// request does not match that of the vbucket.
+	StatusVbUUIDNotEqual = memd.StatusCode(0xa8)
+)
@@ -0,0 +1,113 @@
+package mockauth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ClientCertAuthState represents whether clients may, or must, authenticate
+// using a client certificate.
+type ClientCertAuthState string
+
+// The following is a list of possible client certificate auth states.
+const (
+	ClientCertAuthStateDisable   = ClientCertAuthState("disable")
+	ClientCertAuthStateEnable    = ClientCertAuthState("enable")
+	ClientCertAuthStateMandatory = ClientCertAuthState("mandatory")
+)
+
+// The following is a list of the certificate fields a username can be read from.
+const (
+	ClientCertPathSubjectCN  = "subject.cn"
+	ClientCertPathSanURI     = "san.uri"
+	ClientCertPathSanDNSName = "san.dnsname"
+	ClientCertPathSanEmail   = "san.email"
+)
+
+// ClientCertPrefix is a rule for extracting a username from a field of a
+// client certificate.  The username is whatever follows the prefix, up to the
+// first occurrence of the delimiter.
+type ClientCertPrefix struct {
+	Path      string
+	Prefix    string
+	Delimiter string
+}
+
+// ClientCertAuthSettings specifies how client certificates are used to
+// authenticate users.
+type ClientCertAuthSettings struct {
+	State    ClientCertAuthState
+	Prefixes []ClientCertPrefix
+}
+
+// Validate checks that these settings are valid.
+func (s ClientCertAuthSettings) Validate() error {
+	switch s.State {
+	case ClientCertAuthStateDisable:
+	case ClientCertAuthStateEnable, ClientCertAuthStateMandatory:
+		if len(s.Prefixes) == 0 {
+			return errors.New("at least one prefix must be specified to enable client certificate authentication")
+		}
+	default:
+		return fmt.Errorf("invalid client certificate auth state: %s", s.State)
+	}
+
+	for _, prefix := range s.Prefixes {
+		switch prefix.Path {
+		case ClientCertPathSubjectCN, ClientCertPathSanURI, ClientCertPathSanDNSName, ClientCertPathSanEmail:
+		default:
+			return fmt.Errorf("invalid client certificate path: %s", prefix.Path)
+		}
+	}
+
+	return nil
+}
+
+func clientCertPathValues(cert *x509.Certificate, path string) []string {
+	switch path {
+	case ClientCertPathSubjectCN:
+		return []string{cert.Subject.CommonName}
+	case ClientCertPathSanURI:
+		var uris []string
+		for _, uri := range cert.URIs {
+			uris = append(uris, uri.String())
+		}
+		return uris
+	case ClientCertPathSanDNSName:
+		return cert.DNSNames
+	case ClientCertPathSanEmail:
+		return cert.EmailAddresses
+	}
+	return nil
+}
+
+// UsernameForCertificate returns the username which a client certificate
+// identifies, using the first of the prefix rules which matches it.
+func (s ClientCertAuthSettings) UsernameForCertificate(cert *x509.Certificate) (string, bool) {
+	if s.State == ClientCertAuthStateDisable {
+		return "", false
+	}
+
+	for _, prefix := range s.Prefixes {
+		for _, value := range clientCertPathValues(cert, prefix.Path) {
+			if !strings.HasPrefix(value, prefix.Prefix) {
+				continue
+			}
+
+			username := strings.TrimPrefix(value, prefix.Prefix)
+			if prefix.Delimiter != "" {
+				if delimIdx := strings.Index(username, prefix.Delimiter); delimIdx >= 0 {
+					username = username[:delimIdx]
+				}
+			}
+
+			if username != "" {
+				return username, true
+			}
+		}
+	}
+
+	return "", false
+}
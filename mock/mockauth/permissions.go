@@ -29,4 +29,5 @@ const (
 	PermissionBucketManage
 	PermissionSettings
 	PermissionSelect
+	PermissionEventingManage
 )
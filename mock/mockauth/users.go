@@ -3,6 +3,7 @@ package mockauth
 import (
 	"errors"
 	"strings"
+	"sync"
 )
 
 // UserRole represents the roles of a user.
@@ -121,11 +122,22 @@ type Engine struct {
 	users  []*User
 	groups []*Group
 	roles  []*ClusterRole
+
+	// clientCertAuth is read during TLS handshakes, so it is protected by a
+	// lock of its own.
+	clientCertAuthLock sync.Mutex
+	clientCertAuth     ClientCertAuthSettings
 }
 
 // NewEngine creates a new user management engine.
 func NewEngine() *Engine {
 	return &Engine{
+		clientCertAuth: ClientCertAuthSettings{
+			State: ClientCertAuthStateDisable,
+			Prefixes: []ClientCertPrefix{
+				{Path: ClientCertPathSubjectCN},
+			},
+		},
 		roles: []*ClusterRole{
 			{Role: "admin"},
 			{Role: "ro_admin"},
@@ -166,6 +178,7 @@ func NewEngine() *Engine {
 			{Role: "analytics_admin"},
 			{Role: "mobile_sync_gateway"},
 			{Role: "external_stats_reader"},
+			{Role: "eventing_admin"},
 		},
 	}
 }
@@ -276,15 +289,37 @@ func (e *Engine) DropUser(username string) error {
 	return nil
 }
 
+// ClientCertAuth returns the current client certificate auth settings.
+func (e *Engine) ClientCertAuth() ClientCertAuthSettings {
+	e.clientCertAuthLock.Lock()
+	defer e.clientCertAuthLock.Unlock()
+
+	return e.clientCertAuth
+}
+
+// SetClientCertAuth updates the client certificate auth settings.
+func (e *Engine) SetClientCertAuth(settings ClientCertAuthSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	e.clientCertAuthLock.Lock()
+	defer e.clientCertAuthLock.Unlock()
+
+	e.clientCertAuth = settings
+	return nil
+}
+
 var roleToPermissions = map[string][]Permission{
 	"admin": {PermissionDataRead, PermissionDataWrite, PermissionUserRead, PermissionUserManage, PermissionViewsRead, PermissionViewsManage,
 		PermissionDCPRead, PermissionSearchRead, PermissionSearchManage, PermissionQueryRead, PermissionQueryWrite, PermissionQueryDelete,
 		PermissionQueryManage, PermissionAnalyticsRead, PermissionsAnalyticsManage, PermissionSyncGateway, PermissionStatsRead,
 		PermissionReplicationTarget, PermissionReplicationManage, PermissionClusterRead, PermissionClusterManage, PermissionBucketManage,
-		PermissionSelect, PermissionSettings},
+		PermissionSelect, PermissionSettings, PermissionEventingManage},
 	"ro_admin": {PermissionUserRead, PermissionClusterRead},
 	"cluster_admin": {PermissionUserRead, PermissionStatsRead, PermissionReplicationTarget, PermissionReplicationManage,
 		PermissionClusterRead, PermissionClusterManage},
 	"security_admin": {PermissionUserRead, PermissionUserManage, PermissionClusterRead},
 	"bucket_admin":   {PermissionReplicationTarget, PermissionReplicationManage, PermissionClusterRead, PermissionBucketManage},
+	"eventing_admin": {PermissionClusterRead, PermissionEventingManage},
 }
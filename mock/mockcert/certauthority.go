@@ -0,0 +1,220 @@
+package mockcert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	// certBackdate is how far in the past certificates become valid, which
+	// avoids problems with clients whose clocks are slightly behind ours.
+	certBackdate = 1 * time.Hour
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+)
+
+// CertAuthority represents a certificate authority which is able to issue
+// certificates which are signed by it.
+type CertAuthority struct {
+	cert    *x509.Certificate
+	certPem []byte
+	key     crypto.Signer
+}
+
+// NewCertAuthorityOptions specifies options for creating a new CertAuthority.
+type NewCertAuthorityOptions struct {
+	CommonName string
+}
+
+// NewCertAuthority generates a new self-signed certificate authority.
+func NewCertAuthority(opts NewCertAuthorityOptions) (*CertAuthority, error) {
+	if opts.CommonName == "" {
+		opts.CommonName = "Couchbase Server CA"
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := genSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: opts.CommonName,
+		},
+		NotBefore:             now.Add(-certBackdate),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertAuthority{
+		cert:    cert,
+		certPem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}),
+		key:     key,
+	}, nil
+}
+
+// Certificate returns the certificate of this certificate authority.
+func (ca *CertAuthority) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificatePEM returns the PEM encoded certificate of this certificate authority.
+func (ca *CertAuthority) CertificatePEM() []byte {
+	return ca.certPem
+}
+
+// CertPool returns a pool containing only this certificate authority, for use
+// when verifying certificates which it has issued.
+func (ca *CertAuthority) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// IssueServerCertificateOptions specifies options for issuing a server certificate.
+type IssueServerCertificateOptions struct {
+	CommonName string
+
+	// Hostnames lists the names the server can be reached at, each of which
+	// may be either a DNS name or an IP address.
+	Hostnames []string
+}
+
+// IssueServerCertificate issues a new certificate which a server can use to
+// identify itself to clients for any of the specified hostnames.
+func (ca *CertAuthority) IssueServerCertificate(opts IssueServerCertificateOptions) (*tls.Certificate, error) {
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: opts.CommonName,
+		},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, hostname := range opts.Hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	return ca.issueCertificate(template)
+}
+
+// IssueClientCertificateOptions specifies options for issuing a client certificate.
+type IssueClientCertificateOptions struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+}
+
+// IssueClientCertificate issues a new certificate which a client can use to
+// authenticate itself to a server.
+func (ca *CertAuthority) IssueClientCertificate(opts IssueClientCertificateOptions) (*tls.Certificate, error) {
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: opts.CommonName,
+		},
+		DNSNames:       opts.DNSNames,
+		EmailAddresses: opts.EmailAddresses,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, uriStr := range opts.URIs {
+		uri, err := url.Parse(uriStr)
+		if err != nil {
+			return nil, err
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+
+	return ca.issueCertificate(template)
+}
+
+// EncodeCertificatePEM encodes a certificate chain and its private key into
+// PEM blocks, such as for handing to a client which is not written in Go.
+func EncodeCertificatePEM(cert *tls.Certificate) ([]byte, []byte, error) {
+	var certPem []byte
+	for _, certDer := range cert.Certificate {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})...)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+
+	return certPem, keyPem, nil
+}
+
+func (ca *CertAuthority) issueCertificate(template *x509.Certificate) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := genSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template.SerialNumber = serialNumber
+	template.NotBefore = now.Add(-certBackdate)
+	template.NotAfter = now.Add(certValidity)
+
+	certDer, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{certDer, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+func genSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, serialNumberLimit)
+}
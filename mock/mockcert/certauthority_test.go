@@ -0,0 +1,96 @@
+package mockcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestIssueServerCertificate(t *testing.T) {
+	ca, err := NewCertAuthority(NewCertAuthorityOptions{})
+	if err != nil {
+		t.Fatalf("failed to create ca: %s", err)
+	}
+
+	block, _ := pem.Decode(ca.CertificatePEM())
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("failed to decode ca pem")
+	}
+
+	cert, err := ca.IssueServerCertificate(IssueServerCertificateOptions{
+		CommonName: "node",
+		Hostnames:  []string{"localhost", "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %s", err)
+	}
+
+	for _, hostname := range []string{"localhost", "127.0.0.1"} {
+		_, err := cert.Leaf.Verify(x509.VerifyOptions{
+			DNSName: hostname,
+			Roots:   ca.CertPool(),
+		})
+		if err != nil {
+			t.Fatalf("failed to verify certificate for %s: %s", hostname, err)
+		}
+	}
+
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{
+		DNSName: "example.com",
+		Roots:   ca.CertPool(),
+	})
+	if err == nil {
+		t.Fatalf("expected certificate to be invalid for an unknown hostname")
+	}
+
+	otherCa, err := NewCertAuthority(NewCertAuthorityOptions{})
+	if err != nil {
+		t.Fatalf("failed to create ca: %s", err)
+	}
+
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{
+		DNSName: "localhost",
+		Roots:   otherCa.CertPool(),
+	})
+	if err == nil {
+		t.Fatalf("expected certificate to be invalid for a different ca")
+	}
+}
+
+func TestIssueClientCertificate(t *testing.T) {
+	ca, err := NewCertAuthority(NewCertAuthorityOptions{})
+	if err != nil {
+		t.Fatalf("failed to create ca: %s", err)
+	}
+
+	cert, err := ca.IssueClientCertificate(IssueClientCertificateOptions{
+		CommonName:     "user",
+		EmailAddresses: []string{"user@example.com"},
+		URIs:           []string{"www.example.com/user"},
+	})
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %s", err)
+	}
+
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("failed to verify certificate: %s", err)
+	}
+
+	certPem, keyPem, err := EncodeCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("failed to encode certificate: %s", err)
+	}
+
+	decodedCert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatalf("failed to decode encoded certificate: %s", err)
+	}
+	if len(decodedCert.Certificate) != 2 {
+		t.Fatalf("expected encoded certificate to include the ca")
+	}
+}
@@ -2,6 +2,7 @@ package mockdb
 
 import (
 	"errors"
+	"hash/crc32"
 	"math/rand"
 	"time"
 
@@ -131,12 +132,35 @@ func (b *Bucket) Update(vbID, collectionID uint, key []byte, fn UpdateFunc) (*Do
 	return doc, nil
 }
 
+// PrepareSyncWrite prepares a durable update of a document in the bucket.  The
+// update is not visible until the returned SyncWrite is committed.
+func (b *Bucket) PrepareSyncWrite(vbID, collectionID uint, key []byte, fn UpdateFunc) (*SyncWrite, error) {
+	vbucket := b.GetVbucket(vbID)
+	if vbucket == nil {
+		return nil, errors.New("invalid vbucket")
+	}
+
+	return vbucket.prepareSyncWrite(collectionID, key, fn)
+}
+
 // Remove removes a document from the master replica of a vbucket.
 func (b *Bucket) Remove(vbIdx uint, key []byte) (*Document, error) {
 	// Removing a document is explicitly not supported.  See Vbucket::remove
 	return nil, errors.New("not supported")
 }
 
+// NumVbuckets returns the number of vbuckets in this bucket.
+func (b *Bucket) NumVbuckets() uint {
+	return uint(len(b.vbuckets))
+}
+
+// VbucketForKey returns the index of the vbucket which a key maps to, using
+// the same hashing as the SDKs do.
+func (b *Bucket) VbucketForKey(key []byte) uint {
+	crc := crc32.ChecksumIEEE(key)
+	return uint((crc>>16)&0x7fff) % uint(len(b.vbuckets))
+}
+
 // GetVbucket will return the Vbucket object for a particular replica and
 // vbucket index within this particular bucket store.
 func (b *Bucket) GetVbucket(vbIdx uint) *Vbucket {
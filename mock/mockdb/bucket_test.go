@@ -159,3 +159,72 @@ func TestGetRandomExpired(t *testing.T) {
 		t.Fatalf("expected doc not found, was: %s", err)
 	}
 }
+
+func TestFailoverLog(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	bucket, err := NewBucket(NewBucketOptions{
+		Chrono:         chrono,
+		NumReplicas:    1,
+		NumVbuckets:    4,
+		ReplicaLatency: 50 * time.Millisecond,
+		PersistLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	vb := bucket.GetVbucket(2)
+	changeCh := vb.WatchChanges()
+
+	_, err = bucket.Insert(&Document{
+		VbID:  2,
+		Key:   []byte("test"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	select {
+	case <-changeCh:
+	default:
+		t.Fatalf("change channel was not closed by the insert")
+	}
+
+	snap := bucket.Snapshot()
+
+	_, err = bucket.Insert(&Document{
+		VbID:  2,
+		Key:   []byte("test2"),
+		Value: []byte("hello world"),
+		Cas:   GenerateNewCas(chrono.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+
+	if vb.HighSeqNo() != 2 {
+		t.Fatalf("high seqno was not updated")
+	}
+
+	err = bucket.Rollback(snap)
+	if err != nil {
+		t.Fatalf("failed to rollback: %v", err)
+	}
+
+	if vb.HighSeqNo() != 1 {
+		t.Fatalf("high seqno was not rolled back")
+	}
+
+	failoverLog := vb.FailoverLog()
+	if len(failoverLog) != 2 {
+		t.Fatalf("expected 2 failover log entries, got %d", len(failoverLog))
+	}
+	if failoverLog[0].SeqNo != 1 || failoverLog[1].SeqNo != 0 {
+		t.Fatalf("failover log entries were not newest first")
+	}
+	if failoverLog[0].VbUUID == failoverLog[1].VbUUID {
+		t.Fatalf("rollback did not generate a new vbuuid")
+	}
+}
@@ -10,3 +10,11 @@ var ErrDocNotFound = errors.New("document not found")
 
 // ErrValueTooBig is thrown when a document was set with a value that is too large.
 var ErrValueTooBig = errors.New("document value too large")
+
+// ErrSyncWriteInProgress is thrown when a document is modified while a durable write
+// to the same document is still pending.
+var ErrSyncWriteInProgress = errors.New("sync write in progress")
+
+// ErrSyncWriteRecommitInProgress is thrown when a document is modified while a durable
+// write to the same document is being re-committed by a new active vbucket.
+var ErrSyncWriteRecommitInProgress = errors.New("sync write recommit in progress")
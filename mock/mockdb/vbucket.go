@@ -7,7 +7,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/golang/snappy"
 )
 
 // Document represents one document stored in the vbucket.  For the purposes
@@ -32,6 +34,36 @@ type Document struct {
 	RevID        uint64
 }
 
+// InflateValue returns a value with any snappy compression indicated by its
+// datatype removed.
+func InflateValue(datatype uint8, value []byte) []byte {
+	if datatype&uint8(memd.DatatypeFlagCompressed) == 0 {
+		return value
+	}
+
+	inflated, err := snappy.Decode(nil, value)
+	if err != nil {
+		// Compressed values are validated before they are stored, so this
+		// can only be the empty value of a deleted document.
+		return value
+	}
+
+	return inflated
+}
+
+// InflatedValue returns the value of the document with any snappy compression
+// removed.  Values are only held compressed when a client wrote them that way
+// to a bucket in passive compression mode.
+func (d *Document) InflatedValue() []byte {
+	return InflateValue(d.Datatype, d.Value)
+}
+
+// Inflate removes any snappy compression from the value of the document.
+func (d *Document) Inflate() {
+	d.Value = d.InflatedValue()
+	d.Datatype &^= uint8(memd.DatatypeFlagCompressed)
+}
+
 func copyDocument(src *Document) *Document {
 	var dst Document
 
@@ -75,6 +107,8 @@ type Vbucket struct {
 	replicaLatency time.Duration
 	persistLatency time.Duration
 	revData        []VbRevData
+	changeCh       chan struct{}
+	syncWrites     map[syncWriteKey]*SyncWrite
 }
 
 type newVbucketOptions struct {
@@ -96,9 +130,18 @@ func newVbucket(opts newVbucketOptions) (*Vbucket, error) {
 		replicaLatency: opts.ReplicaLatency,
 		persistLatency: opts.PersistLatency,
 		revData:        revData,
+		changeCh:       make(chan struct{}),
+		syncWrites:     make(map[syncWriteKey]*SyncWrite),
 	}, nil
 }
 
+// notifyChangeLocked wakes up anyone who is waiting for changes to this
+// vbucket and then resets the change channel for future watchers.
+func (s *Vbucket) notifyChangeLocked() {
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+}
+
 func (s *Vbucket) maxSeqNoLocked() uint64 {
 	return s.maxSeqNo
 }
@@ -168,6 +211,7 @@ func (s *Vbucket) pushDocMutationLocked(doc *Document) *Document {
 	newDoc.RevID++
 
 	s.documents = append(s.documents, newDoc)
+	s.notifyChangeLocked()
 
 	return copyDocument(newDoc)
 }
@@ -217,6 +261,37 @@ func (s *Vbucket) CurrentMetaState(repIdx uint) VbMetaState {
 	}
 }
 
+// HighSeqNo returns the highest seqno which has been assigned in the vbucket.
+func (s *Vbucket) HighSeqNo() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.maxSeqNoLocked()
+}
+
+// FailoverLog returns the history of this vbucket, ordered from the most
+// recent entry to the oldest entry.
+func (s *Vbucket) FailoverLog() []VbRevData {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := make([]VbRevData, 0, len(s.revData))
+	for histIdx := len(s.revData) - 1; histIdx >= 0; histIdx-- {
+		entries = append(entries, s.revData[histIdx])
+	}
+
+	return entries
+}
+
+// WatchChanges returns a channel which is closed the next time this vbucket is
+// modified, either by a mutation, a rollback or a flush.
+func (s *Vbucket) WatchChanges() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.changeCh
+}
+
 // GetAll returns all documents in the vbucket.
 func (s *Vbucket) GetAll(repIdx, collectionID uint) ([]*Document, error) {
 	s.lock.Lock()
@@ -369,6 +444,25 @@ func (s *Vbucket) update(collectionID uint, key []byte, fn UpdateFunc) (*Documen
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	newDoc, err := s.applyUpdateLocked(collectionID, key, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pushDocMutationLocked(newDoc), nil
+}
+
+// applyUpdateLocked executes an UpdateFunc against the current state of a
+// document and validates the result, without actually storing it.
+func (s *Vbucket) applyUpdateLocked(collectionID uint, key []byte, fn UpdateFunc) (*Document, error) {
+	// Documents which have a durable write pending cannot be modified.
+	if syncWrite := s.syncWrites[syncWriteKey{collectionID, string(key)}]; syncWrite != nil {
+		if syncWrite.recommitting {
+			return nil, ErrSyncWriteRecommitInProgress
+		}
+		return nil, ErrSyncWriteInProgress
+	}
+
 	// Try to find the document as input to the functor.
 	foundDoc := s.findDocLocked(0, collectionID, key)
 
@@ -378,16 +472,106 @@ func (s *Vbucket) update(collectionID uint, key []byte, fn UpdateFunc) (*Documen
 		return nil, err
 	}
 
+	// If no error was returned and no document was returned, ignore the write.
+	if newDoc == nil {
+		return nil, errors.New("functor did not return a document")
+	}
+
 	if len(newDoc.Value) > 20*1024*1024 {
 		return nil, ErrValueTooBig
 	}
 
-	// If no error was returned and no document was returned, ignore the write.
-	if newDoc == nil {
-		return nil, errors.New("functor did not return a document")
+	return newDoc, nil
+}
+
+type syncWriteKey struct {
+	collectionID uint
+	key          string
+}
+
+// SyncWrite represents a durable write which has been prepared against a
+// vbucket but not yet committed.  While it is pending, no other modifications
+// of the same document are permitted.
+type SyncWrite struct {
+	vbucket      *Vbucket
+	key          syncWriteKey
+	doc          *Document
+	recommitting bool
+}
+
+// prepareSyncWrite validates a document update and then reserves the document
+// until the returned SyncWrite is committed or aborted.
+// NOTE: This must never be called on a replica vbucket.
+func (s *Vbucket) prepareSyncWrite(collectionID uint, key []byte, fn UpdateFunc) (*SyncWrite, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	newDoc, err := s.applyUpdateLocked(collectionID, key, fn)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.pushDocMutationLocked(newDoc), nil
+	syncWrite := &SyncWrite{
+		vbucket: s,
+		key:     syncWriteKey{collectionID, string(key)},
+		doc:     copyDocument(newDoc),
+	}
+	s.syncWrites[syncWrite.key] = syncWrite
+
+	return syncWrite, nil
+}
+
+// Commit stores the prepared document in the vbucket and releases it for
+// further modifications.
+func (w *SyncWrite) Commit() (*Document, error) {
+	s := w.vbucket
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.syncWrites[w.key] != w {
+		// The vbucket was flushed or rolled back while we were pending.
+		return nil, ErrDocNotFound
+	}
+	delete(s.syncWrites, w.key)
+
+	return s.pushDocMutationLocked(w.doc), nil
+}
+
+// Abort discards the prepared document and releases it for further
+// modifications.
+func (w *SyncWrite) Abort() {
+	s := w.vbucket
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.syncWrites[w.key] == w {
+		delete(s.syncWrites, w.key)
+	}
+}
+
+// RecommitSyncWrites marks all pending durable writes in this vbucket as being
+// re-committed, as happens when a new node takes over as the active.
+func (s *Vbucket) RecommitSyncWrites() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, syncWrite := range s.syncWrites {
+		syncWrite.recommitting = true
+	}
+}
+
+// ReplicaLatency returns how long it takes for each replica of this vbucket
+// to observe a mutation.
+func (s *Vbucket) ReplicaLatency() time.Duration {
+	return s.replicaLatency
+}
+
+// PersistLatency returns how long it takes for a mutation to be persisted
+// once it has been observed.
+func (s *Vbucket) PersistLatency() time.Duration {
+	return s.persistLatency
 }
 
 // Compact will compact all of the mutations within a vbucket such that no two
@@ -476,11 +660,13 @@ func (s *Vbucket) rollback(snap *vbucketSnapshot) error {
 
 	s.documents = newMutations
 	s.maxSeqNo = snap.SeqNo
+	s.syncWrites = make(map[syncWriteKey]*SyncWrite)
 
 	s.revData = append(s.revData, VbRevData{
-		VbUUID: 0,
+		VbUUID: generateNewVbUUID(),
 		SeqNo:  s.maxSeqNo,
 	})
+	s.notifyChangeLocked()
 
 	return nil
 }
@@ -492,6 +678,7 @@ func (s *Vbucket) Flush() {
 	defer s.lock.Unlock()
 
 	s.documents = make([]*Document, 0)
+	s.syncWrites = make(map[syncWriteKey]*SyncWrite)
 	s.revData = []VbRevData{
 		{
 			VbUUID: generateNewVbUUID(),
@@ -499,4 +686,5 @@ func (s *Vbucket) Flush() {
 		},
 	}
 	s.maxSeqNo = 0
+	s.notifyChangeLocked()
 }
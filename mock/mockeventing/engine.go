@@ -0,0 +1,374 @@
+package mockeventing
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/google/uuid"
+)
+
+// maxLogLines is the number of application log lines kept for each function.
+const maxLogLines = 1000
+
+// FunctionState represents the deployment state of a function.
+type FunctionState string
+
+// These are the states which a function can be in.
+const (
+	FunctionStateUndeployed = FunctionState("undeployed")
+	FunctionStateDeployed   = FunctionState("deployed")
+	FunctionStatePaused     = FunctionState("paused")
+)
+
+// FunctionStatus represents the status of a function, in the same form as
+// used by the REST API of the eventing service.
+type FunctionStatus struct {
+	Name                  string        `json:"name"`
+	CompositeStatus       FunctionState `json:"composite_status"`
+	NumBootstrappingNodes int           `json:"num_bootstrapping_nodes"`
+	NumDeployedNodes      int           `json:"num_deployed_nodes"`
+	DeploymentStatus      bool          `json:"deployment_status"`
+	ProcessingStatus      bool          `json:"processing_status"`
+	RedeployRequired      bool          `json:"redeploy_required"`
+	FunctionScope         FunctionScope `json:"function_scope"`
+}
+
+// function holds the state of a single eventing function.
+type function struct {
+	def       *Function
+	state     FunctionState
+	keyspaces KeyspaceResolver
+	worker    *worker
+
+	logLock sync.Mutex
+	logs    []string
+}
+
+type functionKey struct {
+	scope FunctionScope
+	name  string
+}
+
+// Engine represents the mock eventing engine.
+type Engine struct {
+	chrono *mocktime.Chrono
+
+	lock      sync.Mutex
+	functions map[functionKey]*function
+}
+
+// NewEngineOptions provides options when creating a new engine.
+type NewEngineOptions struct {
+	Chrono *mocktime.Chrono
+}
+
+// NewEngine creates a new eventing engine.
+func NewEngine(opts NewEngineOptions) *Engine {
+	if opts.Chrono == nil {
+		opts.Chrono = &mocktime.Chrono{}
+	}
+
+	return &Engine{
+		chrono:    opts.Chrono,
+		functions: make(map[functionKey]*function),
+	}
+}
+
+func (e *Engine) getFunctionLocked(scope FunctionScope, name string) (*function, error) {
+	fn := e.functions[functionKey{scope: scope, name: name}]
+	if fn == nil {
+		return nil, newError(ErrFunctionNotFound, "function %s does not exist", name)
+	}
+	return fn, nil
+}
+
+// updateSettings records the state of a function within its settings, which
+// is where the real eventing service reports it.
+func (fn *function) updateSettings() {
+	fn.def.Settings["deployment_status"] = fn.state != FunctionStateUndeployed
+	fn.def.Settings["processing_status"] = fn.state == FunctionStateDeployed
+}
+
+// appendLog adds a line to the application log of a function.
+func (e *Engine) appendLog(fn *function, line string) {
+	fn.logLock.Lock()
+	defer fn.logLock.Unlock()
+
+	fn.logs = append(fn.logs, e.chrono.Now().Format(time.RFC3339)+" "+line)
+	if len(fn.logs) > maxLogLines {
+		fn.logs = fn.logs[len(fn.logs)-maxLogLines:]
+	}
+}
+
+// UpsertFunction creates a new function, or updates an existing one.  Only
+// functions which are undeployed or paused may be updated.
+func (e *Engine) UpsertFunction(def *Function, keyspaces KeyspaceResolver) error {
+	def = copyFunction(def)
+	if def.Settings == nil {
+		def.Settings = make(map[string]interface{})
+	}
+
+	if err := def.validate(keyspaces); err != nil {
+		return err
+	}
+
+	scope := def.scope()
+	def.FunctionScope = &scope
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	key := functionKey{scope: scope, name: def.Name}
+	fn := e.functions[key]
+	if fn == nil {
+		fn = &function{
+			state: FunctionStateUndeployed,
+		}
+		e.functions[key] = fn
+	} else if fn.state == FunctionStateDeployed {
+		return newError(ErrFunctionDeployed, "function %s is deployed, it must be paused or undeployed to be updated", def.Name)
+	}
+
+	if def.HandlerUUID == 0 {
+		def.HandlerUUID = rand.Uint32()
+	}
+	if def.FunctionInstanceID == "" {
+		def.FunctionInstanceID = uuid.New().String()
+	}
+	if _, ok := def.Settings["dcp_stream_boundary"]; !ok {
+		def.Settings["dcp_stream_boundary"] = "everything"
+	}
+	if _, ok := def.Settings["language_compatibility"]; !ok {
+		def.Settings["language_compatibility"] = "6.6.2"
+	}
+	if _, ok := def.Settings["log_level"]; !ok {
+		def.Settings["log_level"] = "INFO"
+	}
+
+	fn.def = def
+	fn.keyspaces = keyspaces
+	fn.updateSettings()
+
+	return nil
+}
+
+// GetFunction returns the definition of a function.
+func (e *Engine) GetFunction(scope FunctionScope, name string) (*Function, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyFunction(fn.def), nil
+}
+
+// GetAllFunctions returns the definitions of all functions, ordered by name.
+func (e *Engine) GetAllFunctions() []*Function {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	defs := make([]*Function, 0, len(e.functions))
+	for _, fn := range e.functions {
+		defs = append(defs, copyFunction(fn.def))
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+
+	return defs
+}
+
+// DropFunction removes a function, which must first be undeployed.
+func (e *Engine) DropFunction(scope FunctionScope, name string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return err
+	}
+	if fn.state != FunctionStateUndeployed {
+		return newError(ErrFunctionNotUndeployed, "function %s must be undeployed before it is dropped", name)
+	}
+
+	delete(e.functions, functionKey{scope: scope, name: name})
+	return nil
+}
+
+// DeployFunction starts running a function against the mutations of its
+// source keyspace.
+func (e *Engine) DeployFunction(scope FunctionScope, name string, keyspaces KeyspaceResolver) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return err
+	}
+	if fn.state != FunctionStateUndeployed {
+		return newError(ErrFunctionDeployed, "function %s is already deployed", name)
+	}
+
+	// The keyspaces may have been dropped since the function was created.
+	def := copyFunction(fn.def)
+	if err := def.validate(keyspaces); err != nil {
+		return err
+	}
+
+	w, err := newWorker(def, keyspaces, func(line string) {
+		e.appendLog(fn, line)
+	})
+	if err != nil {
+		return err
+	}
+
+	fn.keyspaces = keyspaces
+	fn.worker = w
+	fn.state = FunctionStateDeployed
+	fn.updateSettings()
+	w.Start()
+
+	return nil
+}
+
+// UndeployFunction stops running a function, discarding its progress.
+func (e *Engine) UndeployFunction(scope FunctionScope, name string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return err
+	}
+	if fn.state == FunctionStateUndeployed {
+		return newError(ErrFunctionNotDeployed, "function %s is not deployed", name)
+	}
+
+	fn.worker.Stop()
+	fn.worker = nil
+	fn.state = FunctionStateUndeployed
+	fn.updateSettings()
+
+	return nil
+}
+
+// PauseFunction stops running a function, keeping its progress so that it
+// continues from the same point once it is resumed.
+func (e *Engine) PauseFunction(scope FunctionScope, name string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return err
+	}
+	switch fn.state {
+	case FunctionStateUndeployed:
+		return newError(ErrFunctionNotDeployed, "function %s is not deployed", name)
+	case FunctionStatePaused:
+		return newError(ErrFunctionPaused, "function %s is already paused", name)
+	}
+
+	fn.worker.Stop()
+	fn.state = FunctionStatePaused
+	fn.updateSettings()
+
+	return nil
+}
+
+// ResumeFunction continues running a paused function, using the latest
+// definition of the function.
+func (e *Engine) ResumeFunction(scope FunctionScope, name string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return err
+	}
+	switch fn.state {
+	case FunctionStateUndeployed:
+		return newError(ErrFunctionNotDeployed, "function %s is not deployed", name)
+	case FunctionStateDeployed:
+		return newError(ErrFunctionDeployed, "function %s is not paused", name)
+	}
+
+	if err := fn.worker.Resume(copyFunction(fn.def), fn.keyspaces); err != nil {
+		return err
+	}
+
+	fn.state = FunctionStateDeployed
+	fn.updateSettings()
+	fn.worker.Start()
+
+	return nil
+}
+
+// FunctionsStatus returns the status of every function, ordered by name.
+func (e *Engine) FunctionsStatus() []*FunctionStatus {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	statuses := make([]*FunctionStatus, 0, len(e.functions))
+	for key, fn := range e.functions {
+		statuses = append(statuses, &FunctionStatus{
+			Name:             key.name,
+			CompositeStatus:  fn.state,
+			DeploymentStatus: fn.state != FunctionStateUndeployed,
+			ProcessingStatus: fn.state == FunctionStateDeployed,
+			FunctionScope:    key.scope,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// FunctionLog returns the application log of a function.
+func (e *Engine) FunctionLog(scope FunctionScope, name string) ([]string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fn, err := e.getFunctionLocked(scope, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fn.logLock.Lock()
+	defer fn.logLock.Unlock()
+
+	return append([]string{}, fn.logs...), nil
+}
+
+// UndeployBucketFunctions undeploys all of the functions which use a bucket as
+// their source or metadata keyspace, as happens when the bucket is dropped.
+func (e *Engine) UndeployBucketFunctions(bucket string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, fn := range e.functions {
+		if fn.state == FunctionStateUndeployed {
+			continue
+		}
+
+		cfg := fn.def.DeploymentConfig
+		if cfg.SourceBucket != bucket && cfg.MetadataBucket != bucket {
+			continue
+		}
+
+		fn.worker.Stop()
+		fn.worker = nil
+		fn.state = FunctionStateUndeployed
+		fn.updateSettings()
+		e.appendLog(fn, fmt.Sprintf("function undeployed as bucket %s was dropped", bucket))
+	}
+}
@@ -0,0 +1,54 @@
+package mockeventing
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error represents an error returned by the eventing service.  The name is
+// what clients use to identify the error, the description is free-form.
+type Error struct {
+	Name        string
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Description)
+}
+
+// Is allows errors.Is to match an error against one of the errors below,
+// regardless of its description.
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.Name == e.Name
+}
+
+func newError(base *Error, format string, args ...interface{}) *Error {
+	return &Error{
+		Name:        base.Name,
+		Code:        base.Code,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// This is a list of errors we support
+var (
+	ErrFunctionNotFound      = &Error{Name: "ERR_APP_NOT_FOUND_TS", Code: 10, Description: "function not found"}
+	ErrFunctionNotDeployed   = &Error{Name: "ERR_APP_NOT_DEPLOYED", Code: 11, Description: "function not deployed"}
+	ErrFunctionDeployed      = &Error{Name: "ERR_APP_ALREADY_DEPLOYED", Code: 12, Description: "function already deployed"}
+	ErrFunctionNotUndeployed = &Error{Name: "ERR_APP_NOT_UNDEPLOYED", Code: 13, Description: "function not undeployed"}
+	ErrFunctionPaused        = &Error{Name: "ERR_APP_PAUSED", Code: 14, Description: "function is paused"}
+	ErrCompilation           = &Error{Name: "ERR_HANDLER_COMPILATION", Code: 20, Description: "handler compilation failed"}
+	ErrInvalidConfig         = &Error{Name: "ERR_INVALID_CONFIG", Code: 21, Description: "invalid function configuration"}
+	ErrBucketMissing         = &Error{Name: "ERR_BUCKET_MISSING", Code: 22, Description: "bucket does not exist"}
+	ErrCollectionMissing     = &Error{Name: "ERR_COLLECTION_MISSING", Code: 23, Description: "collection does not exist"}
+	ErrIdenticalKeyspace     = &Error{Name: "ERR_SRC_MB_SAME", Code: 24, Description: "source and metadata keyspaces are the same"}
+)
+
+// These errors are returned by a KeyspaceResolver to indicate why it was
+// unable to resolve a keyspace.
+var (
+	ErrKeyspaceBucketNotFound     = errors.New("bucket not found")
+	ErrKeyspaceCollectionNotFound = errors.New("collection not found")
+)
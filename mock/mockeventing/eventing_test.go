@@ -0,0 +1,315 @@
+package mockeventing
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
+
+func newTestKeyspaces(t *testing.T, buckets ...string) (KeyspaceResolver, map[string]*Keyspace) {
+	keyspaces := make(map[string]*Keyspace)
+	for _, name := range buckets {
+		bucket, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+			Chrono:      &mocktime.Chrono{},
+			NumVbuckets: 4,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+
+		keyspaces[name] = &Keyspace{
+			Store: bucket,
+			Proc:  kvproc.New(bucket, make([]int, bucket.NumVbuckets()), nil, nil),
+		}
+	}
+
+	return func(bucket, scope, collection string) (*Keyspace, error) {
+		keyspace := keyspaces[bucket]
+		if keyspace == nil {
+			return nil, ErrKeyspaceBucketNotFound
+		}
+		if scope != "_default" || collection != "_default" {
+			return nil, ErrKeyspaceCollectionNotFound
+		}
+		return keyspace, nil
+	}, keyspaces
+}
+
+func testFunction(name, code string) *Function {
+	return &Function{
+		Name: name,
+		Code: code,
+		DeploymentConfig: DeploymentConfig{
+			SourceBucket:   "src",
+			MetadataBucket: "meta",
+			Buckets: []BucketBinding{{
+				Alias:      "dst",
+				BucketName: "dst",
+				Access:     "rw",
+			}},
+		},
+	}
+}
+
+func writeTestDoc(t *testing.T, keyspace *Keyspace, key, value string) {
+	_, err := keyspace.Proc.Set(kvproc.StoreOptions{
+		Vbucket: keyspace.Store.VbucketForKey([]byte(key)),
+		Key:     []byte(key),
+		Value:   []byte(value),
+	})
+	if err != nil {
+		t.Fatalf("failed to write document: %v", err)
+	}
+}
+
+func deleteTestDoc(t *testing.T, keyspace *Keyspace, key string) {
+	_, err := keyspace.Proc.Delete(kvproc.DeleteOptions{
+		Vbucket: keyspace.Store.VbucketForKey([]byte(key)),
+		Key:     []byte(key),
+	})
+	if err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+}
+
+// waitForDoc waits for the handler to write a document, or for it to be
+// removed if value is empty.
+func waitForDoc(t *testing.T, keyspace *Keyspace, key, value string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := keyspace.Proc.Get(kvproc.GetOptions{
+			Vbucket: keyspace.Store.VbucketForKey([]byte(key)),
+			Key:     []byte(key),
+		})
+		if value == "" && err == kvproc.ErrDocNotFound {
+			return
+		}
+		if value != "" && err == nil && string(res.Value) == value {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				t.Fatalf("timed out waiting for %s: %v", key, err)
+			}
+			t.Fatalf("timed out waiting for %s, value was %s", key, res.Value)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFunctionManagement(t *testing.T) {
+	keyspaces, _ := newTestKeyspaces(t, "src", "meta", "dst")
+	engine := NewEngine(NewEngineOptions{})
+	scope := FunctionScope{Bucket: "*", Scope: "*"}
+
+	assertErr := func(err error, expected *Error) {
+		t.Helper()
+		if !errors.Is(err, expected) {
+			t.Fatalf("expected %s, got %v", expected.Name, err)
+		}
+	}
+
+	bad := testFunction("bad", "function OnUpdate(doc, meta) {")
+	assertErr(engine.UpsertFunction(bad, keyspaces), ErrCompilation)
+
+	bad = testFunction("bad", "function OnUpdate(doc, meta) {}")
+	bad.DeploymentConfig.MetadataBucket = "missing"
+	assertErr(engine.UpsertFunction(bad, keyspaces), ErrBucketMissing)
+
+	bad = testFunction("bad", "function OnUpdate(doc, meta) {}")
+	bad.DeploymentConfig.MetadataBucket = "src"
+	assertErr(engine.UpsertFunction(bad, keyspaces), ErrIdenticalKeyspace)
+
+	bad = testFunction("bad", "function OnUpdate(doc, meta) {}")
+	bad.DeploymentConfig.SourceScope = "missing"
+	assertErr(engine.UpsertFunction(bad, keyspaces), ErrCollectionMissing)
+
+	fn := testFunction("copier", "function OnUpdate(doc, meta) {}")
+	if err := engine.UpsertFunction(fn, keyspaces); err != nil {
+		t.Fatalf("failed to create function: %v", err)
+	}
+
+	def, err := engine.GetFunction(scope, "copier")
+	if err != nil {
+		t.Fatalf("failed to get function: %v", err)
+	}
+	if def.Code != fn.Code || def.HandlerUUID == 0 || def.Settings["deployment_status"] != false {
+		t.Fatalf("unexpected function definition: %+v", def)
+	}
+
+	_, err = engine.GetFunction(FunctionScope{Bucket: "src", Scope: "_default"}, "copier")
+	assertErr(err, ErrFunctionNotFound)
+
+	assertErr(engine.UndeployFunction(scope, "copier"), ErrFunctionNotDeployed)
+	assertErr(engine.PauseFunction(scope, "copier"), ErrFunctionNotDeployed)
+
+	if err := engine.DeployFunction(scope, "copier", keyspaces); err != nil {
+		t.Fatalf("failed to deploy function: %v", err)
+	}
+	assertErr(engine.DeployFunction(scope, "copier", keyspaces), ErrFunctionDeployed)
+	assertErr(engine.UpsertFunction(fn, keyspaces), ErrFunctionDeployed)
+	assertErr(engine.DropFunction(scope, "copier"), ErrFunctionNotUndeployed)
+	assertErr(engine.ResumeFunction(scope, "copier"), ErrFunctionDeployed)
+
+	if err := engine.PauseFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to pause function: %v", err)
+	}
+	assertErr(engine.PauseFunction(scope, "copier"), ErrFunctionPaused)
+
+	statuses := engine.FunctionsStatus()
+	if len(statuses) != 1 || statuses[0].CompositeStatus != FunctionStatePaused ||
+		!statuses[0].DeploymentStatus || statuses[0].ProcessingStatus {
+		t.Fatalf("unexpected function status: %+v", statuses[0])
+	}
+
+	// Paused functions can be updated.
+	if err := engine.UpsertFunction(fn, keyspaces); err != nil {
+		t.Fatalf("failed to update paused function: %v", err)
+	}
+	if err := engine.ResumeFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to resume function: %v", err)
+	}
+	if err := engine.UndeployFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to undeploy function: %v", err)
+	}
+	if err := engine.DropFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to drop function: %v", err)
+	}
+	if len(engine.GetAllFunctions()) != 0 {
+		t.Fatalf("function was not dropped")
+	}
+}
+
+func TestFunctionHandlers(t *testing.T) {
+	keyspaces, stores := newTestKeyspaces(t, "src", "meta", "dst")
+	engine := NewEngine(NewEngineOptions{})
+	scope := FunctionScope{Bucket: "*", Scope: "*"}
+
+	writeTestDoc(t, stores["src"], "existing", `{"name":"old"}`)
+
+	fn := testFunction("copier", `
+function OnUpdate(doc, meta) {
+	log("updated", meta.id, doc);
+	dst[meta.id] = {name: doc.name, prefix: PREFIX};
+
+	var res = couchbase.insert(dst, {id: "inserted"}, {n: 1});
+	if (!res.success && !res.error.key_already_exists) {
+		throw new Error("insert failed");
+	}
+}
+
+function OnDelete(meta, options) {
+	delete dst[meta.id];
+}`)
+	fn.DeploymentConfig.Constants = []ConstantBinding{{Value: "PREFIX", Literal: `"copy"`}}
+
+	if err := engine.UpsertFunction(fn, keyspaces); err != nil {
+		t.Fatalf("failed to create function: %v", err)
+	}
+	if err := engine.DeployFunction(scope, "copier", keyspaces); err != nil {
+		t.Fatalf("failed to deploy function: %v", err)
+	}
+
+	waitForDoc(t, stores["dst"], "existing", `{"name":"old","prefix":"copy"}`)
+	waitForDoc(t, stores["dst"], "inserted", `{"n":1}`)
+
+	writeTestDoc(t, stores["src"], "new", `{"name":"new"}`)
+	waitForDoc(t, stores["dst"], "new", `{"name":"new","prefix":"copy"}`)
+
+	deleteTestDoc(t, stores["src"], "existing")
+	waitForDoc(t, stores["dst"], "existing", "")
+
+	// Mutations made while paused are processed once the function resumes.
+	if err := engine.PauseFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to pause function: %v", err)
+	}
+	writeTestDoc(t, stores["src"], "paused", `{"name":"paused"}`)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := stores["dst"].Proc.Get(kvproc.GetOptions{
+		Vbucket: stores["dst"].Store.VbucketForKey([]byte("paused")),
+		Key:     []byte("paused"),
+	}); err != kvproc.ErrDocNotFound {
+		t.Fatalf("paused function processed a mutation")
+	}
+
+	if err := engine.ResumeFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to resume function: %v", err)
+	}
+	waitForDoc(t, stores["dst"], "paused", `{"name":"paused","prefix":"copy"}`)
+
+	logs, err := engine.FunctionLog(scope, "copier")
+	if err != nil {
+		t.Fatalf("failed to get function log: %v", err)
+	}
+	if len(logs) == 0 || !strings.Contains(logs[0], `updated existing {"name":"old"}`) {
+		t.Fatalf("unexpected function log: %v", logs)
+	}
+
+	if err := engine.UndeployFunction(scope, "copier"); err != nil {
+		t.Fatalf("failed to undeploy function: %v", err)
+	}
+}
+
+func TestFunctionBindingAccess(t *testing.T) {
+	keyspaces, stores := newTestKeyspaces(t, "src", "meta", "dst")
+	engine := NewEngine(NewEngineOptions{})
+	scope := FunctionScope{Bucket: "*", Scope: "*"}
+
+	// Handlers may write back to their own source keyspace without being
+	// invoked again for their own mutations.
+	fn := testFunction("counter", `
+function OnUpdate(doc, meta) {
+	try {
+		dst[meta.id] = doc;
+	} catch (e) {
+		log("write failed");
+	}
+
+	src[meta.id] = {count: (doc.count || 0) + 1};
+}`)
+	fn.DeploymentConfig.Buckets = []BucketBinding{
+		{Alias: "dst", BucketName: "dst", Access: "r"},
+		{Alias: "src", BucketName: "src", Access: "rw"},
+	}
+	fn.Settings = map[string]interface{}{
+		"dcp_stream_boundary": "from_now",
+	}
+
+	writeTestDoc(t, stores["src"], "before", `{"count":0}`)
+
+	if err := engine.UpsertFunction(fn, keyspaces); err != nil {
+		t.Fatalf("failed to create function: %v", err)
+	}
+	if err := engine.DeployFunction(scope, "counter", keyspaces); err != nil {
+		t.Fatalf("failed to deploy function: %v", err)
+	}
+
+	writeTestDoc(t, stores["src"], "after", `{"count":0}`)
+	waitForDoc(t, stores["src"], "after", `{"count":1}`)
+
+	time.Sleep(20 * time.Millisecond)
+	waitForDoc(t, stores["src"], "after", `{"count":1}`)
+	waitForDoc(t, stores["src"], "before", `{"count":0}`)
+	waitForDoc(t, stores["dst"], "after", "")
+
+	logs, _ := engine.FunctionLog(scope, "counter")
+	if len(logs) != 1 || !strings.HasSuffix(logs[0], "write failed") {
+		t.Fatalf("unexpected function log: %v", logs)
+	}
+
+	if err := engine.UndeployFunction(scope, "counter"); err != nil {
+		t.Fatalf("failed to undeploy function: %v", err)
+	}
+
+	bytes, _ := json.Marshal(engine.FunctionsStatus())
+	if !strings.Contains(string(bytes), `"composite_status":"undeployed"`) {
+		t.Fatalf("unexpected function status: %s", bytes)
+	}
+}
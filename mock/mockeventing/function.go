@@ -0,0 +1,209 @@
+package mockeventing
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+var functionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][0-9A-Za-z_\-]{0,99}$`)
+
+// Function represents an eventing function, in the same form as used by the
+// REST API of the eventing service.
+type Function struct {
+	Name               string                 `json:"appname"`
+	Code               string                 `json:"appcode"`
+	DeploymentConfig   DeploymentConfig       `json:"depcfg"`
+	Version            string                 `json:"version"`
+	EnforceSchema      bool                   `json:"enforce_schema"`
+	HandlerUUID        uint32                 `json:"handleruuid"`
+	FunctionInstanceID string                 `json:"function_instance_id"`
+	Settings           map[string]interface{} `json:"settings"`
+	FunctionScope      *FunctionScope         `json:"function_scope,omitempty"`
+}
+
+// DeploymentConfig specifies the keyspaces and bindings of a function.
+type DeploymentConfig struct {
+	SourceBucket       string                   `json:"source_bucket"`
+	SourceScope        string                   `json:"source_scope,omitempty"`
+	SourceCollection   string                   `json:"source_collection,omitempty"`
+	MetadataBucket     string                   `json:"metadata_bucket"`
+	MetadataScope      string                   `json:"metadata_scope,omitempty"`
+	MetadataCollection string                   `json:"metadata_collection,omitempty"`
+	Buckets            []BucketBinding          `json:"buckets,omitempty"`
+	Curl               []map[string]interface{} `json:"curl,omitempty"`
+	Constants          []ConstantBinding        `json:"constants,omitempty"`
+}
+
+// BucketBinding makes a keyspace available to a handler under an alias.
+type BucketBinding struct {
+	Alias          string `json:"alias"`
+	BucketName     string `json:"bucket_name"`
+	ScopeName      string `json:"scope_name,omitempty"`
+	CollectionName string `json:"collection_name,omitempty"`
+	Access         string `json:"access"`
+}
+
+// ConstantBinding makes a literal value available to a handler under an alias.
+type ConstantBinding struct {
+	Value   string `json:"value"`
+	Literal string `json:"literal"`
+}
+
+// FunctionScope identifies the scope which a function belongs to.  Functions
+// which were created without a scope belong to the "*" scope of the "*" bucket.
+type FunctionScope struct {
+	Bucket string `json:"bucket"`
+	Scope  string `json:"scope"`
+}
+
+// Keyspace represents a collection which a function reads from or writes to.
+type Keyspace struct {
+	Store        *mockdb.Bucket
+	CollectionID uint
+	Proc         *kvproc.Engine
+}
+
+// KeyspaceResolver is used by the engine to look up the keyspaces used by a
+// function.  It should return ErrKeyspaceBucketNotFound or
+// ErrKeyspaceCollectionNotFound if the keyspace does not exist.
+type KeyspaceResolver func(bucket, scope, collection string) (*Keyspace, error)
+
+// keyspacePath identifies a keyspace by name.
+type keyspacePath struct {
+	bucket     string
+	scope      string
+	collection string
+}
+
+func newKeyspacePath(bucket, scope, collection string) keyspacePath {
+	if scope == "" {
+		scope = "_default"
+	}
+	if collection == "" {
+		collection = "_default"
+	}
+
+	return keyspacePath{
+		bucket:     bucket,
+		scope:      scope,
+		collection: collection,
+	}
+}
+
+func (p keyspacePath) String() string {
+	return p.bucket + "." + p.scope + "." + p.collection
+}
+
+func (p keyspacePath) resolve(keyspaces KeyspaceResolver) (*Keyspace, error) {
+	return keyspaces(p.bucket, p.scope, p.collection)
+}
+
+func (f *Function) sourcePath() keyspacePath {
+	cfg := f.DeploymentConfig
+	return newKeyspacePath(cfg.SourceBucket, cfg.SourceScope, cfg.SourceCollection)
+}
+
+func (f *Function) metadataPath() keyspacePath {
+	cfg := f.DeploymentConfig
+	return newKeyspacePath(cfg.MetadataBucket, cfg.MetadataScope, cfg.MetadataCollection)
+}
+
+func (b *BucketBinding) path() keyspacePath {
+	return newKeyspacePath(b.BucketName, b.ScopeName, b.CollectionName)
+}
+
+// scope returns the scope of the function, applying the default.
+func (f *Function) scope() FunctionScope {
+	if f.FunctionScope == nil || f.FunctionScope.Bucket == "" {
+		return FunctionScope{Bucket: "*", Scope: "*"}
+	}
+	return *f.FunctionScope
+}
+
+func (f *Function) stringSetting(name, defaultValue string) string {
+	if val, ok := f.Settings[name].(string); ok && val != "" {
+		return val
+	}
+	return defaultValue
+}
+
+func (f *Function) numberSetting(name string, defaultValue float64) float64 {
+	if val, ok := f.Settings[name].(float64); ok && val > 0 {
+		return val
+	}
+	return defaultValue
+}
+
+func copyFunction(fn *Function) *Function {
+	var out Function
+	bytes, _ := json.Marshal(fn)
+	_ = json.Unmarshal(bytes, &out)
+	return &out
+}
+
+// validateKeyspace checks that a keyspace used by a function exists.
+func validateKeyspace(path keyspacePath, keyspaces KeyspaceResolver) error {
+	_, err := path.resolve(keyspaces)
+	switch err {
+	case nil:
+		return nil
+	case ErrKeyspaceBucketNotFound:
+		return newError(ErrBucketMissing, "bucket %s does not exist", path.bucket)
+	case ErrKeyspaceCollectionNotFound:
+		return newError(ErrCollectionMissing, "collection %s does not exist", path)
+	}
+	return err
+}
+
+// validate checks that a function definition is usable, including compiling
+// its handler code.
+func (f *Function) validate(keyspaces KeyspaceResolver) error {
+	if !functionNameRegexp.MatchString(f.Name) {
+		return newError(ErrInvalidConfig, "function name %q is invalid", f.Name)
+	}
+
+	switch f.stringSetting("dcp_stream_boundary", "everything") {
+	case "everything", "from_now", "from_prior":
+	default:
+		return newError(ErrInvalidConfig, "invalid dcp_stream_boundary: %v", f.Settings["dcp_stream_boundary"])
+	}
+
+	if f.DeploymentConfig.SourceBucket == "" || f.DeploymentConfig.MetadataBucket == "" {
+		return newError(ErrInvalidConfig, "source and metadata buckets must be specified")
+	}
+	if f.sourcePath() == f.metadataPath() {
+		return newError(ErrIdenticalKeyspace, "source keyspace %s cannot be the same as the metadata keyspace", f.sourcePath())
+	}
+	if err := validateKeyspace(f.sourcePath(), keyspaces); err != nil {
+		return err
+	}
+	if err := validateKeyspace(f.metadataPath(), keyspaces); err != nil {
+		return err
+	}
+
+	aliases := make(map[string]bool)
+	for _, binding := range f.DeploymentConfig.Buckets {
+		if binding.Alias == "" || aliases[binding.Alias] {
+			return newError(ErrInvalidConfig, "bucket binding alias %q is invalid or duplicated", binding.Alias)
+		}
+		aliases[binding.Alias] = true
+
+		if binding.Access != "r" && binding.Access != "rw" {
+			return newError(ErrInvalidConfig, "bucket binding %s has invalid access %q", binding.Alias, binding.Access)
+		}
+		if err := validateKeyspace(binding.path(), keyspaces); err != nil {
+			return err
+		}
+	}
+	for _, constant := range f.DeploymentConfig.Constants {
+		if constant.Value == "" || aliases[constant.Value] {
+			return newError(ErrInvalidConfig, "constant binding alias %q is invalid or duplicated", constant.Value)
+		}
+		aliases[constant.Value] = true
+	}
+
+	return compileHandler(f)
+}
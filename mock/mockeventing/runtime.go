@@ -0,0 +1,436 @@
+package mockeventing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+	"github.com/dop251/goja"
+)
+
+const (
+	// jsonDatatype is the datatype flag set on documents written by handlers.
+	jsonDatatype = 0x01
+
+	// jsonCommonFlags are the common flags which mark a document as JSON.
+	jsonCommonFlags = 0x02000000
+)
+
+// These are the error codes which the advanced bucket operations report, they
+// are the same as the ones used by the real eventing service.
+const (
+	bucketOpErrKeyExists   = 12
+	bucketOpErrKeyNotFound = 13
+	bucketOpErrGeneric     = 1
+)
+
+// compileHandler checks that the code of a function can be compiled.
+func compileHandler(fn *Function) error {
+	_, err := goja.Compile(fn.Name, fn.Code, false)
+	if err != nil {
+		return newError(ErrCompilation, "handler compilation failed: %s", err)
+	}
+	return nil
+}
+
+// handlerRuntime is a JavaScript runtime which is executing the handler code
+// of a function.  A runtime may only be used by a single goroutine.
+type handlerRuntime struct {
+	fn        *Function
+	keyspaces KeyspaceResolver
+	logf      func(format string, args ...interface{})
+	timeout   time.Duration
+
+	vm       *goja.Runtime
+	onUpdate goja.Callable
+	onDelete goja.Callable
+	bindings map[*goja.Object]*bucketBinding
+
+	// onWrite is called for every document which the handler writes.
+	onWrite func(path keyspacePath, key string, cas uint64)
+}
+
+func newHandlerRuntime(fn *Function, keyspaces KeyspaceResolver, logf func(format string, args ...interface{}),
+	onWrite func(path keyspacePath, key string, cas uint64)) (*handlerRuntime, error) {
+	rt := &handlerRuntime{
+		fn:        fn,
+		keyspaces: keyspaces,
+		logf:      logf,
+		timeout:   time.Duration(fn.numberSetting("execution_timeout", 60)) * time.Second,
+		vm:        goja.New(),
+		bindings:  make(map[*goja.Object]*bucketBinding),
+		onWrite:   onWrite,
+	}
+	rt.vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	if err := rt.vm.Set("log", rt.log); err != nil {
+		return nil, err
+	}
+	if err := rt.vm.Set("couchbase", rt.advancedBucketOps()); err != nil {
+		return nil, err
+	}
+
+	for _, binding := range fn.DeploymentConfig.Buckets {
+		b := &bucketBinding{
+			rt:       rt,
+			path:     binding.path(),
+			readOnly: binding.Access != "rw",
+		}
+		obj := rt.vm.NewDynamicObject(b)
+		rt.bindings[obj] = b
+
+		if err := rt.vm.Set(binding.Alias, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, constant := range fn.DeploymentConfig.Constants {
+		// Constants are substituted into the handler as literals, anything
+		// which isn't a valid literal is treated as a string.
+		val, err := rt.vm.RunString("(" + constant.Literal + ")")
+		if err != nil {
+			val = rt.vm.ToValue(constant.Literal)
+		}
+		if err := rt.vm.Set(constant.Value, val); err != nil {
+			return nil, err
+		}
+	}
+
+	prg, err := goja.Compile(fn.Name, fn.Code, false)
+	if err != nil {
+		return nil, newError(ErrCompilation, "handler compilation failed: %s", err)
+	}
+	if _, err := rt.vm.RunProgram(prg); err != nil {
+		return nil, newError(ErrCompilation, "handler initialisation failed: %s", err)
+	}
+
+	rt.onUpdate, _ = goja.AssertFunction(rt.vm.Get("OnUpdate"))
+	rt.onDelete, _ = goja.AssertFunction(rt.vm.Get("OnDelete"))
+
+	return rt, nil
+}
+
+func (rt *handlerRuntime) log(call goja.FunctionCall) goja.Value {
+	parts := make([]string, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		if str, ok := arg.Export().(string); ok {
+			parts[i] = str
+			continue
+		}
+
+		bytes, err := json.Marshal(arg.Export())
+		if err != nil {
+			parts[i] = arg.String()
+			continue
+		}
+		parts[i] = string(bytes)
+	}
+
+	rt.logf("%s", strings.Join(parts, " "))
+	return goja.Undefined()
+}
+
+// call invokes a handler, interrupting it if it runs for longer than the
+// execution timeout of the function.
+func (rt *handlerRuntime) call(name string, fn goja.Callable, args ...goja.Value) {
+	timer := time.AfterFunc(rt.timeout, func() {
+		rt.vm.Interrupt("execution timeout")
+	})
+	_, err := fn(goja.Undefined(), args...)
+	timer.Stop()
+	rt.vm.ClearInterrupt()
+
+	if err != nil {
+		rt.logf("%s: exception: %s", name, err)
+	}
+}
+
+func (rt *handlerRuntime) documentMeta(path keyspacePath, doc *mockdb.Document) map[string]interface{} {
+	meta := map[string]interface{}{
+		"id":    string(doc.Key),
+		"cas":   strconv.FormatUint(doc.Cas, 10),
+		"flags": doc.Flags,
+		"vb":    doc.VbID,
+		"seq":   doc.SeqNo,
+		"keyspace": map[string]interface{}{
+			"bucket_name":     path.bucket,
+			"scope_name":      path.scope,
+			"collection_name": path.collection,
+		},
+	}
+	if !doc.Expiry.IsZero() && !doc.IsDeleted {
+		meta["expiration"] = doc.Expiry.Unix()
+	} else {
+		meta["expiration"] = 0
+	}
+	if doc.Datatype&jsonDatatype != 0 || json.Valid(doc.InflatedValue()) {
+		meta["datatype"] = "json"
+	} else {
+		meta["datatype"] = "binary"
+	}
+
+	return meta
+}
+
+// decodeValue converts a document value into the value passed to a handler.
+func (rt *handlerRuntime) decodeValue(value []byte) goja.Value {
+	var parsed interface{}
+	if err := json.Unmarshal(value, &parsed); err != nil {
+		return rt.vm.ToValue(string(value))
+	}
+	return rt.vm.ToValue(parsed)
+}
+
+// HandleMutation passes a single mutation from the source keyspace to the
+// handlers of the function.
+func (rt *handlerRuntime) HandleMutation(path keyspacePath, doc *mockdb.Document) {
+	meta := rt.vm.ToValue(rt.documentMeta(path, doc))
+
+	if doc.IsDeleted {
+		if rt.onDelete != nil {
+			rt.call("OnDelete", rt.onDelete, meta, rt.vm.ToValue(map[string]interface{}{
+				"expired": false,
+			}))
+		}
+		return
+	}
+
+	if rt.onUpdate != nil {
+		rt.call("OnUpdate", rt.onUpdate, rt.decodeValue(doc.InflatedValue()), meta)
+	}
+}
+
+// throw raises a JavaScript exception from within a Go callback.
+func (rt *handlerRuntime) throw(format string, args ...interface{}) {
+	panic(rt.vm.NewGoError(fmt.Errorf(format, args...)))
+}
+
+// bucketBinding implements the map-like object which a bucket binding is
+// exposed to a handler as.
+type bucketBinding struct {
+	rt       *handlerRuntime
+	path     keyspacePath
+	readOnly bool
+}
+
+func (b *bucketBinding) keyspace() *Keyspace {
+	keyspace, err := b.path.resolve(b.rt.keyspaces)
+	if err != nil {
+		b.rt.throw("keyspace %s is not available: %s", b.path, err)
+	}
+	return keyspace
+}
+
+func (b *bucketBinding) get(key string) (*kvproc.GetResult, error) {
+	keyspace := b.keyspace()
+	return keyspace.Proc.Get(kvproc.GetOptions{
+		Vbucket:      keyspace.Store.VbucketForKey([]byte(key)),
+		CollectionID: keyspace.CollectionID,
+		Key:          []byte(key),
+	})
+}
+
+type storeFunc func(proc *kvproc.Engine, opts kvproc.StoreOptions) (*kvproc.StoreResult, error)
+
+func (b *bucketBinding) store(key string, val goja.Value, cas uint64, fn storeFunc) (*kvproc.StoreResult, error) {
+	if b.readOnly {
+		b.rt.throw("cannot write to read-only bucket binding of %s", b.path)
+	}
+
+	value, err := json.Marshal(val.Export())
+	if err != nil {
+		b.rt.throw("cannot encode value: %s", err)
+	}
+
+	keyspace := b.keyspace()
+	res, err := fn(keyspace.Proc, kvproc.StoreOptions{
+		Vbucket:      keyspace.Store.VbucketForKey([]byte(key)),
+		CollectionID: keyspace.CollectionID,
+		Key:          []byte(key),
+		Cas:          cas,
+		Datatype:     jsonDatatype,
+		Flags:        jsonCommonFlags,
+		Value:        value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.rt.onWrite(b.path, key, res.Cas)
+	return res, nil
+}
+
+func (b *bucketBinding) remove(key string, cas uint64) (*kvproc.DeleteResult, error) {
+	if b.readOnly {
+		b.rt.throw("cannot delete from read-only bucket binding of %s", b.path)
+	}
+
+	keyspace := b.keyspace()
+	res, err := keyspace.Proc.Delete(kvproc.DeleteOptions{
+		Vbucket:      keyspace.Store.VbucketForKey([]byte(key)),
+		CollectionID: keyspace.CollectionID,
+		Key:          []byte(key),
+		Cas:          cas,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.rt.onWrite(b.path, key, res.Cas)
+	return res, nil
+}
+
+// Get implements goja.DynamicObject.
+func (b *bucketBinding) Get(key string) goja.Value {
+	res, err := b.get(key)
+	if err == kvproc.ErrDocNotFound {
+		return nil
+	} else if err != nil {
+		b.rt.throw("failed to read %s from %s: %s", key, b.path, err)
+	}
+
+	return b.rt.decodeValue(mockdb.InflateValue(res.Datatype, res.Value))
+}
+
+// Set implements goja.DynamicObject.
+func (b *bucketBinding) Set(key string, val goja.Value) bool {
+	if _, err := b.store(key, val, 0, (*kvproc.Engine).Set); err != nil {
+		b.rt.throw("failed to write %s to %s: %s", key, b.path, err)
+	}
+	return true
+}
+
+// Has implements goja.DynamicObject.
+func (b *bucketBinding) Has(key string) bool {
+	_, err := b.get(key)
+	return err == nil
+}
+
+// Delete implements goja.DynamicObject.
+func (b *bucketBinding) Delete(key string) bool {
+	_, err := b.remove(key, 0)
+	if err != nil && err != kvproc.ErrDocNotFound {
+		b.rt.throw("failed to delete %s from %s: %s", key, b.path, err)
+	}
+	return true
+}
+
+// Keys implements goja.DynamicObject.  Bindings cannot be iterated.
+func (b *bucketBinding) Keys() []string {
+	return nil
+}
+
+// advancedBucketOps returns the couchbase object, which provides the advanced
+// bucket operations that report failures rather than throwing.
+func (rt *handlerRuntime) advancedBucketOps() map[string]interface{} {
+	findBinding := func(binding goja.Value) *bucketBinding {
+		obj, ok := binding.(*goja.Object)
+		if ok {
+			if b := rt.bindings[obj]; b != nil {
+				return b
+			}
+		}
+		rt.throw("first argument must be a bucket binding")
+		return nil
+	}
+
+	metaKey := func(meta goja.Value) (string, uint64) {
+		var parsed struct {
+			ID  string `json:"id"`
+			Cas string `json:"cas"`
+		}
+		bytes, _ := json.Marshal(meta.Export())
+		if err := json.Unmarshal(bytes, &parsed); err != nil || parsed.ID == "" {
+			rt.throw("second argument must be a meta object with an id")
+		}
+		cas, _ := strconv.ParseUint(parsed.Cas, 10, 64)
+		return parsed.ID, cas
+	}
+
+	failure := func(err error) map[string]interface{} {
+		errObj := map[string]interface{}{
+			"desc": err.Error(),
+		}
+		switch err {
+		case kvproc.ErrDocNotFound:
+			errObj["code"] = bucketOpErrKeyNotFound
+			errObj["name"] = "LCB_KEY_ENOENT"
+			errObj["key_not_found"] = true
+		case kvproc.ErrDocExists:
+			errObj["code"] = bucketOpErrKeyExists
+			errObj["name"] = "LCB_KEY_EEXISTS"
+			errObj["key_already_exists"] = true
+		case kvproc.ErrCasMismatch:
+			errObj["code"] = bucketOpErrKeyExists
+			errObj["name"] = "LCB_KEY_EEXISTS"
+			errObj["cas_mismatch"] = true
+		default:
+			errObj["code"] = bucketOpErrGeneric
+			errObj["name"] = "LCB_ERROR"
+		}
+
+		return map[string]interface{}{
+			"success": false,
+			"error":   errObj,
+		}
+	}
+
+	success := func(key string, cas uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"success": true,
+			"meta": map[string]interface{}{
+				"id":  key,
+				"cas": strconv.FormatUint(cas, 10),
+			},
+		}
+	}
+
+	storeOp := func(fn storeFunc, useCas bool) func(binding, meta, doc goja.Value) map[string]interface{} {
+		return func(binding, meta, doc goja.Value) map[string]interface{} {
+			b := findBinding(binding)
+			key, cas := metaKey(meta)
+			if !useCas {
+				cas = 0
+			}
+
+			res, err := b.store(key, doc, cas, fn)
+			if err != nil {
+				return failure(err)
+			}
+			return success(key, res.Cas)
+		}
+	}
+
+	return map[string]interface{}{
+		"get": func(binding, meta goja.Value) map[string]interface{} {
+			b := findBinding(binding)
+			key, _ := metaKey(meta)
+
+			res, err := b.get(key)
+			if err != nil {
+				return failure(err)
+			}
+
+			result := success(key, res.Cas)
+			result["doc"] = rt.decodeValue(mockdb.InflateValue(res.Datatype, res.Value))
+			return result
+		},
+		"insert":  storeOp((*kvproc.Engine).Add, false),
+		"upsert":  storeOp((*kvproc.Engine).Set, false),
+		"replace": storeOp((*kvproc.Engine).Replace, true),
+		"delete": func(binding, meta goja.Value) map[string]interface{} {
+			b := findBinding(binding)
+			key, cas := metaKey(meta)
+
+			res, err := b.remove(key, cas)
+			if err != nil {
+				return failure(err)
+			}
+			return success(key, res.Cas)
+		},
+	}
+}
@@ -0,0 +1,207 @@
+package mockeventing
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// worker feeds the mutations of the source keyspace of a deployed function to
+// its handlers, keeping track of how far through each vbucket it has got.
+type worker struct {
+	fn        *Function
+	keyspaces KeyspaceResolver
+	log       func(line string)
+	rt        *handlerRuntime
+
+	source  keyspacePath
+	seqNos  []uint64
+	vbUUIDs []uint64
+
+	// ownWrites holds the cas of the documents which the handler wrote to its
+	// own source keyspace, so that it is not invoked for its own mutations.
+	ownWrites map[string]uint64
+
+	lock   sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newWorker(def *Function, keyspaces KeyspaceResolver, log func(line string)) (*worker, error) {
+	w := &worker{
+		log:       log,
+		source:    def.sourcePath(),
+		ownWrites: make(map[string]uint64),
+	}
+
+	if err := w.Resume(def, keyspaces); err != nil {
+		return nil, err
+	}
+
+	source, err := w.source.resolve(keyspaces)
+	if err != nil {
+		return nil, err
+	}
+	w.resetPositions(source.Store)
+
+	// Functions which only handle new mutations start from the current end of
+	// each vbucket rather than the beginning.
+	if def.stringSetting("dcp_stream_boundary", "everything") == "from_now" {
+		for vbIdx := range w.seqNos {
+			vbucket := source.Store.GetVbucket(uint(vbIdx))
+			w.seqNos[vbIdx] = vbucket.HighSeqNo()
+			w.vbUUIDs[vbIdx] = vbucket.FailoverLog()[0].VbUUID
+		}
+	}
+
+	return w, nil
+}
+
+func (w *worker) logf(format string, args ...interface{}) {
+	w.log(fmt.Sprintf(format, args...))
+}
+
+func (w *worker) resetPositions(store *mockdb.Bucket) {
+	numVbuckets := store.NumVbuckets()
+	w.seqNos = make([]uint64, numVbuckets)
+	w.vbUUIDs = make([]uint64, numVbuckets)
+}
+
+// Resume prepares a stopped worker to run a new definition of its function,
+// keeping the progress that it has made so far.
+func (w *worker) Resume(def *Function, keyspaces KeyspaceResolver) error {
+	rt, err := newHandlerRuntime(def, keyspaces, w.logf, w.recordWrite)
+	if err != nil {
+		return err
+	}
+
+	w.fn = def
+	w.keyspaces = keyspaces
+	w.rt = rt
+	return nil
+}
+
+// Start begins processing mutations in the background.
+func (w *worker) Start() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stopCh != nil {
+		return
+	}
+
+	w.stopCh = make(chan struct{})
+	w.doneCh = make(chan struct{})
+	go w.run(w.stopCh, w.doneCh)
+}
+
+// Stop stops processing mutations, waiting for any handler which is currently
+// running to complete.
+func (w *worker) Stop() {
+	w.lock.Lock()
+	stopCh, doneCh := w.stopCh, w.doneCh
+	w.stopCh, w.doneCh = nil, nil
+	w.lock.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+
+	close(stopCh)
+	<-doneCh
+}
+
+func (w *worker) recordWrite(path keyspacePath, key string, cas uint64) {
+	if path == w.source {
+		w.ownWrites[key] = cas
+	}
+}
+
+func (w *worker) isOwnWrite(doc *mockdb.Document) bool {
+	key := string(doc.Key)
+	if cas, ok := w.ownWrites[key]; ok && cas == doc.Cas {
+		delete(w.ownWrites, key)
+		return true
+	}
+	return false
+}
+
+func (w *worker) run(stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	for {
+		cases := []reflect.SelectCase{{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(stopCh),
+		}}
+
+		source, err := w.source.resolve(w.keyspaces)
+		if err != nil {
+			w.logf("source keyspace %s is not available: %s", w.source, err)
+		} else {
+			if source.Store.NumVbuckets() != uint(len(w.seqNos)) {
+				w.resetPositions(source.Store)
+			}
+
+			for vbIdx := range w.seqNos {
+				vbucket := source.Store.GetVbucket(uint(vbIdx))
+
+				// We grab the change channel before we read so we cannot miss
+				// any mutations which occur while we are processing.
+				cases = append(cases, reflect.SelectCase{
+					Dir:  reflect.SelectRecv,
+					Chan: reflect.ValueOf(vbucket.WatchChanges()),
+				})
+
+				w.processVbucket(source, uint(vbIdx), vbucket)
+
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+			}
+		}
+
+		chosen, _, _ := reflect.Select(cases)
+		if chosen == 0 {
+			return
+		}
+	}
+}
+
+func (w *worker) processVbucket(source *Keyspace, vbIdx uint, vbucket *mockdb.Vbucket) {
+	highSeqNo := vbucket.HighSeqNo()
+
+	// If the vbucket was rolled back or flushed, we carry on from wherever it
+	// now ends rather than replaying the mutations we have already seen.
+	vbUUID := vbucket.FailoverLog()[0].VbUUID
+	if w.vbUUIDs[vbIdx] != vbUUID {
+		if w.seqNos[vbIdx] > highSeqNo {
+			w.seqNos[vbIdx] = highSeqNo
+		}
+		w.vbUUIDs[vbIdx] = vbUUID
+	}
+
+	if highSeqNo <= w.seqNos[vbIdx] {
+		return
+	}
+
+	docs, _, err := vbucket.GetAllWithin(0, w.seqNos[vbIdx], highSeqNo)
+	if err != nil {
+		w.logf("failed to read mutations of vbucket %d: %s", vbIdx, err)
+		return
+	}
+
+	for _, doc := range docs {
+		if doc.CollectionID != source.CollectionID || w.isOwnWrite(doc) {
+			continue
+		}
+
+		w.rt.HandleMutation(w.source, doc)
+	}
+
+	w.seqNos[vbIdx] = highSeqNo
+}
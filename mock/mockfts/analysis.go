@@ -0,0 +1,141 @@
+package mockfts
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token represents a single term produced by analyzing some text, along with
+// its position and the byte offsets it was found at.
+type token struct {
+	term     string
+	position int
+	start    int
+	end      int
+}
+
+// analyzer converts text into the terms which are indexed or searched for.
+type analyzer func(text string) []token
+
+// englishStopWords are the stop words which the standard analyzer removes.
+var englishStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true, "this": true, "to": true,
+	"was": true, "will": true, "with": true,
+}
+
+// tokenize splits text into words, where a word is a run of characters for
+// which isWordChar returns true.
+func tokenize(text string, isWordChar func(r rune) bool) []token {
+	var tokens []token
+
+	start := -1
+	for i, r := range text {
+		if isWordChar(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{term: text[start:i], start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: text[start:], start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+func isUnicodeWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func isNotSpace(r rune) bool {
+	return !unicode.IsSpace(r)
+}
+
+// filterTokens lowercases tokens and removes any stop words, assigning
+// positions to the tokens which remain.  Positions start at 1.
+func filterTokens(tokens []token, stopWords map[string]bool) []token {
+	out := tokens[:0]
+	for i, tok := range tokens {
+		tok.term = strings.ToLower(tok.term)
+		tok.position = i + 1
+		if stopWords[tok.term] {
+			continue
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+func standardAnalyzer(text string) []token {
+	return filterTokens(tokenize(text, isUnicodeWordChar), englishStopWords)
+}
+
+func simpleAnalyzer(text string) []token {
+	return filterTokens(tokenize(text, isLetter), nil)
+}
+
+func whitespaceAnalyzer(text string) []token {
+	tokens := tokenize(text, isNotSpace)
+	for i := range tokens {
+		tokens[i].position = i + 1
+	}
+	return tokens
+}
+
+func keywordAnalyzer(text string) []token {
+	return []token{{
+		term:     text,
+		position: 1,
+		start:    0,
+		end:      len(text),
+	}}
+}
+
+// englishAnalyzer behaves like the standard analyzer but also stems terms.
+// We only strip the most common plural suffixes, which is enough for simple
+// searches to behave sensibly.
+func englishAnalyzer(text string) []token {
+	tokens := standardAnalyzer(text)
+	for i, tok := range tokens {
+		tokens[i].term = stemEnglish(tok.term)
+	}
+	return tokens
+}
+
+func stemEnglish(term string) string {
+	if utf8.RuneCountInString(term) <= 3 {
+		return term
+	}
+
+	switch {
+	case strings.HasSuffix(term, "ies"):
+		return strings.TrimSuffix(term, "ies") + "i"
+	case strings.HasSuffix(term, "ss"):
+		return term
+	case strings.HasSuffix(term, "s"):
+		return strings.TrimSuffix(term, "s")
+	}
+	return term
+}
+
+var builtinAnalyzers = map[string]analyzer{
+	"standard":   standardAnalyzer,
+	"simple":     simpleAnalyzer,
+	"whitespace": whitespaceAnalyzer,
+	"keyword":    keywordAnalyzer,
+	"web":        standardAnalyzer,
+	"en":         englishAnalyzer,
+}
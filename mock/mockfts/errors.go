@@ -0,0 +1,47 @@
+package mockfts
+
+import (
+	"errors"
+	"fmt"
+)
+
+// This is a list of errors we support
+var (
+	ErrIndexNotFound = errors.New("index not found")
+	ErrIndexExists   = errors.New("cannot create index because an index with the same name already exists")
+	ErrUUIDMismatch  = errors.New("index uuid mismatch")
+
+	ErrInvalidIndex = errors.New("invalid index definition")
+	ErrInvalidQuery = errors.New("invalid query")
+
+	ErrQueryDisallowed = errors.New("queries are disallowed for this index")
+)
+
+// These errors are returned by a SourceResolver to indicate why it was unable
+// to resolve the source of an index.
+var (
+	ErrSourceNotFound = errors.New("source not found")
+	ErrAccessDenied   = errors.New("access denied")
+)
+
+// Error represents an error with a specific message which wraps one of the
+// errors above.
+type Error struct {
+	Err error
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Err: err,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
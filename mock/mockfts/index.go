@@ -0,0 +1,367 @@
+package mockfts
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/google/uuid"
+)
+
+// IndexTypeFullText is the type of the indexes which can be created.
+const IndexTypeFullText = "fulltext-index"
+
+var indexNameRegexp = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z_\-]*$`)
+
+// IndexDefinition represents the definition of a search index, in the same
+// form as used by the REST API of the search service.
+type IndexDefinition struct {
+	Type         string                 `json:"type"`
+	Name         string                 `json:"name"`
+	UUID         string                 `json:"uuid"`
+	SourceType   string                 `json:"sourceType"`
+	SourceName   string                 `json:"sourceName"`
+	SourceUUID   string                 `json:"sourceUUID"`
+	Params       map[string]interface{} `json:"params"`
+	PlanParams   map[string]interface{} `json:"planParams"`
+	SourceParams map[string]interface{} `json:"sourceParams"`
+}
+
+// Source represents the collection which the documents of an index come from.
+type Source struct {
+	Store        *mockdb.Bucket
+	CollectionID uint
+}
+
+// SourceResolver is used by the engine to look up the collections which the
+// documents of an index are sourced from.  It should return ErrSourceNotFound
+// or ErrAccessDenied if the collection cannot be used.
+type SourceResolver func(bucket, scope, collection string) (*Source, error)
+
+// index holds the state of a single search index.
+type index struct {
+	def     *IndexDefinition
+	mapping *indexMapping
+
+	ingestPaused    bool
+	pausedAt        time.Time
+	queryDisallowed bool
+	planFrozen      bool
+}
+
+// Engine represents the mock search engine.
+type Engine struct {
+	chrono *mocktime.Chrono
+
+	lock    sync.Mutex
+	indexes map[string]*index
+}
+
+// NewEngineOptions provides options when creating a new engine.
+type NewEngineOptions struct {
+	Chrono *mocktime.Chrono
+}
+
+// NewEngine creates a new search engine.
+func NewEngine(opts NewEngineOptions) *Engine {
+	if opts.Chrono == nil {
+		opts.Chrono = &mocktime.Chrono{}
+	}
+
+	return &Engine{
+		chrono:  opts.Chrono,
+		indexes: make(map[string]*index),
+	}
+}
+
+// newIndexUUID generates a uuid in the form used by the search service.
+func newIndexUUID() string {
+	return strings.Replace(uuid.New().String(), "-", "", -1)[:16]
+}
+
+func copyDefinition(def *IndexDefinition) *IndexDefinition {
+	var out IndexDefinition
+	bytes, _ := json.Marshal(def)
+	_ = json.Unmarshal(bytes, &out)
+	return &out
+}
+
+// updatePlanParams records the control state of an index within its plan
+// params, which is where the real search service keeps it.
+func (idx *index) updatePlanParams() {
+	planParams := make(map[string]interface{})
+	for key, val := range idx.def.PlanParams {
+		planParams[key] = val
+	}
+
+	if idx.ingestPaused || idx.queryDisallowed {
+		planParams["nodePlanParams"] = map[string]interface{}{
+			"": map[string]interface{}{
+				"": map[string]interface{}{
+					"canRead":  !idx.queryDisallowed,
+					"canWrite": !idx.ingestPaused,
+				},
+			},
+		}
+	} else {
+		delete(planParams, "nodePlanParams")
+	}
+
+	if idx.planFrozen {
+		planParams["planFrozen"] = true
+	} else {
+		delete(planParams, "planFrozen")
+	}
+
+	idx.def.PlanParams = planParams
+}
+
+// UpsertIndex creates a new index, or updates an existing one.  Updating an
+// index requires the uuid of the current definition, which is then replaced.
+func (e *Engine) UpsertIndex(def *IndexDefinition, sources SourceResolver) (*IndexDefinition, error) {
+	if !indexNameRegexp.MatchString(def.Name) {
+		return nil, newError(ErrInvalidIndex, "manager_api: CreateIndex, indexName is invalid, indexName: %s", def.Name)
+	}
+	if def.Type != IndexTypeFullText {
+		return nil, newError(ErrInvalidIndex, "manager_api: CreateIndex, unknown indexType: %s", def.Type)
+	}
+
+	mapping, err := parseIndexMapping(def.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	if sources != nil {
+		if _, err := sources(def.SourceName, "_default", "_default"); err != nil {
+			if errors.Is(err, ErrAccessDenied) {
+				return nil, err
+			}
+			return nil, newError(ErrInvalidIndex,
+				"manager_api: failed to connect to or retrieve information from source, sourceType: %s, sourceName: %s",
+				def.SourceType, def.SourceName)
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	idx := &index{}
+	if existing := e.indexes[def.Name]; existing != nil {
+		if def.UUID == "" {
+			return nil, newError(ErrIndexExists,
+				"manager_api: cannot create index because an index with the same name already exists: %s", def.Name)
+		}
+		if def.UUID != existing.def.UUID {
+			return nil, newError(ErrUUIDMismatch,
+				"manager_api: could not update index, current index uuid: %s, did not match input uuid: %s",
+				existing.def.UUID, def.UUID)
+		}
+
+		// The control state of an index survives it being updated.
+		*idx = *existing
+	} else if def.UUID != "" {
+		return nil, newError(ErrIndexNotFound, "manager_api: index not found, indexName: %s", def.Name)
+	}
+
+	idx.def = copyDefinition(def)
+	idx.def.UUID = newIndexUUID()
+	if idx.def.Params == nil {
+		idx.def.Params = make(map[string]interface{})
+	}
+	if idx.def.SourceParams == nil {
+		idx.def.SourceParams = make(map[string]interface{})
+	}
+	if idx.def.SourceType == "" {
+		idx.def.SourceType = "gocbcore"
+	}
+	idx.mapping = mapping
+	idx.updatePlanParams()
+
+	e.indexes[def.Name] = idx
+
+	return copyDefinition(idx.def), nil
+}
+
+// GetIndex returns the definition of an index.
+func (e *Engine) GetIndex(name string) (*IndexDefinition, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	idx := e.indexes[name]
+	if idx == nil {
+		return nil, ErrIndexNotFound
+	}
+
+	return copyDefinition(idx.def), nil
+}
+
+// GetAllIndexes returns the definitions of all indexes, ordered by name.
+func (e *Engine) GetAllIndexes() []*IndexDefinition {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	defs := make([]*IndexDefinition, 0, len(e.indexes))
+	for _, idx := range e.indexes {
+		defs = append(defs, copyDefinition(idx.def))
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+
+	return defs
+}
+
+// DeleteIndex removes an index, returning the definition it had.
+func (e *Engine) DeleteIndex(name string) (*IndexDefinition, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	idx := e.indexes[name]
+	if idx == nil {
+		return nil, ErrIndexNotFound
+	}
+	delete(e.indexes, name)
+
+	return idx.def, nil
+}
+
+// DropBucketIndexes removes all of the indexes sourced from a bucket.
+func (e *Engine) DropBucketIndexes(bucket string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for name, idx := range e.indexes {
+		if idx.def.SourceName == bucket {
+			delete(e.indexes, name)
+		}
+	}
+}
+
+func (e *Engine) updateIndex(name string, fn func(idx *index)) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	idx := e.indexes[name]
+	if idx == nil {
+		return ErrIndexNotFound
+	}
+
+	fn(idx)
+	idx.updatePlanParams()
+
+	return nil
+}
+
+// SetIngestPaused pauses or resumes the ingestion of mutations into an index.
+// A paused index continues to serve the documents it had already ingested.
+func (e *Engine) SetIngestPaused(name string, paused bool) error {
+	now := e.chrono.Now()
+	return e.updateIndex(name, func(idx *index) {
+		if paused && !idx.ingestPaused {
+			idx.pausedAt = now
+		}
+		idx.ingestPaused = paused
+	})
+}
+
+// SetQueryAllowed allows or disallows querying of an index.
+func (e *Engine) SetQueryAllowed(name string, allowed bool) error {
+	return e.updateIndex(name, func(idx *index) {
+		idx.queryDisallowed = !allowed
+	})
+}
+
+// SetPlanFrozen freezes or unfreezes the partition plan of an index.
+func (e *Engine) SetPlanFrozen(name string, frozen bool) error {
+	return e.updateIndex(name, func(idx *index) {
+		idx.planFrozen = frozen
+	})
+}
+
+// loadDocuments indexes all of the documents which an index has ingested.
+func (e *Engine) loadDocuments(name string, sources SourceResolver) (*index, []*indexedDocument, error) {
+	e.lock.Lock()
+	existing := e.indexes[name]
+	if existing == nil {
+		e.lock.Unlock()
+		return nil, nil, ErrIndexNotFound
+	}
+	idx := *existing
+	e.lock.Unlock()
+
+	snapshotTime := e.chrono.Now()
+	if idx.ingestPaused {
+		snapshotTime = idx.pausedAt
+	}
+
+	var docs []*indexedDocument
+	for _, collection := range idx.mapping.collections() {
+		parts := strings.SplitN(collection, ".", 2)
+		source, err := sources(idx.def.SourceName, parts[0], parts[1])
+		if errors.Is(err, ErrSourceNotFound) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		allDocs, err := source.Store.GetAll(0, source.CollectionID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Only the most recent version of each document which has been
+		// ingested is indexed.
+		latest := make(map[string]*mockdb.Document)
+		var keys []string
+		for _, doc := range allDocs {
+			if doc.ModifiedTime.After(snapshotTime) {
+				continue
+			}
+
+			key := string(doc.Key)
+			if _, ok := latest[key]; !ok {
+				keys = append(keys, key)
+			}
+			latest[key] = doc
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			doc := latest[key]
+			if doc.IsDeleted {
+				continue
+			}
+
+			var value map[string]interface{}
+			if err := json.Unmarshal(doc.InflatedValue(), &value); err != nil {
+				// Only JSON objects are indexed.
+				continue
+			}
+
+			mapping := idx.mapping.mappingForDocument(key, collection, value)
+			if mapping == nil {
+				continue
+			}
+
+			docs = append(docs, idx.mapping.indexDocument(key, collection, value, mapping))
+		}
+	}
+
+	return &idx, docs, nil
+}
+
+// DocCount returns the number of documents which an index contains.
+func (e *Engine) DocCount(name string, sources SourceResolver) (int, error) {
+	_, docs, err := e.loadDocuments(name, sources)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(docs), nil
+}
@@ -0,0 +1,610 @@
+package mockfts
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTypeField = "type"
+	defaultAnalyzer  = "standard"
+	compositeField   = "_all"
+
+	defaultCollection = "_default._default"
+)
+
+// fieldMapping describes how a single property of a document is indexed.
+type fieldMapping struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Analyzer     string `json:"analyzer"`
+	Store        bool   `json:"store"`
+	Index        *bool  `json:"index"`
+	IncludeInAll *bool  `json:"include_in_all"`
+}
+
+func (f *fieldMapping) indexed() bool {
+	return f.Index == nil || *f.Index
+}
+
+func (f *fieldMapping) includedInAll() bool {
+	return f.IncludeInAll == nil || *f.IncludeInAll
+}
+
+// documentMapping describes how an object within a document is indexed.  A
+// dynamic mapping indexes every property which is not explicitly mapped.
+type documentMapping struct {
+	Enabled         bool                        `json:"enabled"`
+	Dynamic         bool                        `json:"dynamic"`
+	DefaultAnalyzer string                      `json:"default_analyzer"`
+	Properties      map[string]*documentMapping `json:"properties"`
+	Fields          []*fieldMapping             `json:"fields"`
+}
+
+func (m *documentMapping) UnmarshalJSON(data []byte) error {
+	type plainMapping documentMapping
+	mapping := plainMapping{
+		Enabled: true,
+		Dynamic: true,
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return err
+	}
+	*m = documentMapping(mapping)
+	return nil
+}
+
+type customAnalyzer struct {
+	Type         string   `json:"type"`
+	Tokenizer    string   `json:"tokenizer"`
+	TokenFilters []string `json:"token_filters"`
+}
+
+type docConfig struct {
+	Mode             string `json:"mode"`
+	TypeField        string `json:"type_field"`
+	DocIDPrefixDelim string `json:"docid_prefix_delim"`
+	DocIDRegexp      string `json:"docid_regexp"`
+}
+
+// indexMapping describes how the documents of an index are indexed, it
+// follows the format used by the real search service.
+type indexMapping struct {
+	DefaultMapping  *documentMapping            `json:"default_mapping"`
+	Types           map[string]*documentMapping `json:"types"`
+	TypeField       string                      `json:"type_field"`
+	DefaultAnalyzer string                      `json:"default_analyzer"`
+	DefaultField    string                      `json:"default_field"`
+	StoreDynamic    *bool                       `json:"store_dynamic"`
+	IndexDynamic    *bool                       `json:"index_dynamic"`
+	Analysis        struct {
+		Analyzers map[string]*customAnalyzer `json:"analyzers"`
+	} `json:"analysis"`
+
+	docConfig   docConfig
+	docIDRegexp *regexp.Regexp
+	analyzers   map[string]analyzer
+
+	// fieldAnalyzers holds the analyzers of explicitly mapped fields.
+	fieldAnalyzers map[string]string
+}
+
+// parseIndexMapping parses the mapping held in the params of an index
+// definition.  An index without a mapping dynamically indexes every document.
+func parseIndexMapping(params map[string]interface{}) (*indexMapping, error) {
+	mapping := &indexMapping{}
+
+	if mappingVal, ok := params["mapping"]; ok && mappingVal != nil {
+		bytes, _ := json.Marshal(mappingVal)
+		if err := json.Unmarshal(bytes, mapping); err != nil {
+			return nil, newError(ErrInvalidIndex, "error parsing mapping: %s", err)
+		}
+	}
+	if docConfigVal, ok := params["doc_config"]; ok && docConfigVal != nil {
+		bytes, _ := json.Marshal(docConfigVal)
+		if err := json.Unmarshal(bytes, &mapping.docConfig); err != nil {
+			return nil, newError(ErrInvalidIndex, "error parsing doc_config: %s", err)
+		}
+	}
+
+	if mapping.DefaultMapping == nil {
+		mapping.DefaultMapping = &documentMapping{
+			Enabled: true,
+			Dynamic: true,
+		}
+	}
+	if mapping.TypeField == "" {
+		mapping.TypeField = defaultTypeField
+	}
+	if mapping.docConfig.TypeField == "" {
+		mapping.docConfig.TypeField = mapping.TypeField
+	}
+	if mapping.DefaultAnalyzer == "" {
+		mapping.DefaultAnalyzer = defaultAnalyzer
+	}
+	if mapping.DefaultField == "" {
+		mapping.DefaultField = compositeField
+	}
+
+	if mapping.docConfig.Mode == "docid_regexp" || mapping.docConfig.Mode == "scope.collection.docid_regexp" {
+		var err error
+		mapping.docIDRegexp, err = regexp.Compile(mapping.docConfig.DocIDRegexp)
+		if err != nil {
+			return nil, newError(ErrInvalidIndex, "error parsing docid_regexp: %s", err)
+		}
+	}
+
+	mapping.analyzers = make(map[string]analyzer)
+	for name, fn := range builtinAnalyzers {
+		mapping.analyzers[name] = fn
+	}
+	for name, custom := range mapping.Analysis.Analyzers {
+		fn, err := buildCustomAnalyzer(custom)
+		if err != nil {
+			return nil, newError(ErrInvalidIndex, "error building analyzer %s: %s", name, err)
+		}
+		mapping.analyzers[name] = fn
+	}
+
+	mapping.fieldAnalyzers = make(map[string]string)
+	if err := mapping.checkMapping("", mapping.DefaultMapping); err != nil {
+		return nil, err
+	}
+	for _, typeMapping := range mapping.Types {
+		if err := mapping.checkMapping("", typeMapping); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := mapping.analyzers[mapping.DefaultAnalyzer]; !ok {
+		return nil, newError(ErrInvalidIndex, "unknown analyzer named: %s", mapping.DefaultAnalyzer)
+	}
+
+	return mapping, nil
+}
+
+func buildCustomAnalyzer(custom *customAnalyzer) (analyzer, error) {
+	var isWordChar func(r rune) bool
+	switch custom.Tokenizer {
+	case "unicode", "":
+		isWordChar = isUnicodeWordChar
+	case "letter":
+		isWordChar = isLetter
+	case "whitespace":
+		isWordChar = isNotSpace
+	case "single":
+		isWordChar = nil
+	default:
+		return nil, newError(ErrInvalidIndex, "unknown tokenizer named: %s", custom.Tokenizer)
+	}
+
+	lower := false
+	var stopWords map[string]bool
+	for _, filter := range custom.TokenFilters {
+		switch filter {
+		case "to_lower":
+			lower = true
+		case "stop_en":
+			stopWords = englishStopWords
+		default:
+			return nil, newError(ErrInvalidIndex, "unknown token filter named: %s", filter)
+		}
+	}
+
+	return func(text string) []token {
+		var tokens []token
+		if isWordChar == nil {
+			tokens = keywordAnalyzer(text)
+		} else {
+			tokens = tokenize(text, isWordChar)
+		}
+
+		out := tokens[:0]
+		for i, tok := range tokens {
+			if lower {
+				tok.term = strings.ToLower(tok.term)
+			}
+			tok.position = i + 1
+			if stopWords[tok.term] {
+				continue
+			}
+			out = append(out, tok)
+		}
+		return out
+	}, nil
+}
+
+// checkMapping validates a document mapping, recording the analyzers of any
+// explicitly mapped fields as it goes.
+func (m *indexMapping) checkMapping(path string, mapping *documentMapping) error {
+	if mapping.DefaultAnalyzer != "" {
+		if _, ok := m.analyzers[mapping.DefaultAnalyzer]; !ok {
+			return newError(ErrInvalidIndex, "unknown analyzer named: %s", mapping.DefaultAnalyzer)
+		}
+	}
+
+	for _, field := range mapping.Fields {
+		switch field.Type {
+		case "text", "number", "datetime", "boolean", "geopoint":
+		default:
+			return newError(ErrInvalidIndex, "unknown field type: %s", field.Type)
+		}
+
+		if field.Analyzer != "" {
+			if _, ok := m.analyzers[field.Analyzer]; !ok {
+				return newError(ErrInvalidIndex, "unknown analyzer named: %s", field.Analyzer)
+			}
+			m.fieldAnalyzers[fieldPath(parentPath(path), field.Name, path)] = field.Analyzer
+		}
+	}
+
+	for name, child := range mapping.Properties {
+		if err := m.checkMapping(joinPath(path, name), child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// analyzerFor returns the analyzer to use for a field when the query does not
+// specify one.
+func (m *indexMapping) analyzerFor(field, name string) (analyzer, error) {
+	if name == "" {
+		name = m.fieldAnalyzers[field]
+	}
+	if name == "" {
+		name = m.DefaultAnalyzer
+	}
+
+	fn, ok := m.analyzers[name]
+	if !ok {
+		return nil, newError(ErrInvalidQuery, "no analyzer named '%s' registered", name)
+	}
+	return fn, nil
+}
+
+func (m *indexMapping) isCollectionMode() bool {
+	return strings.HasPrefix(m.docConfig.Mode, "scope.collection")
+}
+
+// collections returns the collections, in the form scope.collection, which
+// the documents of the index are sourced from.
+func (m *indexMapping) collections() []string {
+	if !m.isCollectionMode() {
+		return []string{defaultCollection}
+	}
+
+	found := make(map[string]bool)
+	if m.DefaultMapping.Enabled && len(m.DefaultMapping.Properties)+len(m.DefaultMapping.Fields) > 0 {
+		found[defaultCollection] = true
+	}
+	for name, typeMapping := range m.Types {
+		if !typeMapping.Enabled {
+			continue
+		}
+		parts := strings.SplitN(name, ".", 3)
+		if len(parts) >= 2 {
+			found[parts[0]+"."+parts[1]] = true
+		}
+	}
+
+	collections := make([]string, 0, len(found))
+	for name := range found {
+		collections = append(collections, name)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// documentType determines the type of a document according to the doc_config
+// of the index.
+func (m *indexMapping) documentType(id string, value map[string]interface{}) string {
+	mode := strings.TrimPrefix(m.docConfig.Mode, "scope.collection.")
+	switch mode {
+	case "docid_prefix":
+		if idx := strings.Index(id, m.docConfig.DocIDPrefixDelim); idx > 0 && m.docConfig.DocIDPrefixDelim != "" {
+			return id[:idx]
+		}
+		return ""
+	case "docid_regexp":
+		return m.docIDRegexp.FindString(id)
+	}
+
+	typ, _ := value[m.docConfig.TypeField].(string)
+	return typ
+}
+
+// mappingForDocument returns the document mapping which applies to a
+// document, or nil if the document should not be indexed.
+func (m *indexMapping) mappingForDocument(id, collection string, value map[string]interface{}) *documentMapping {
+	typ := m.documentType(id, value)
+
+	if m.isCollectionMode() {
+		if typeMapping, ok := m.Types[collection+"."+typ]; ok && typ != "" {
+			return enabledMapping(typeMapping)
+		}
+		if typeMapping, ok := m.Types[collection]; ok {
+			return enabledMapping(typeMapping)
+		}
+		if collection != defaultCollection {
+			return nil
+		}
+	} else if typeMapping, ok := m.Types[typ]; ok && typ != "" {
+		return enabledMapping(typeMapping)
+	}
+
+	return enabledMapping(m.DefaultMapping)
+}
+
+func enabledMapping(mapping *documentMapping) *documentMapping {
+	if !mapping.Enabled {
+		return nil
+	}
+	return mapping
+}
+
+// location records where a term was found within a field.
+type location struct {
+	pos            int
+	start          int
+	end            int
+	arrayPositions []uint64
+}
+
+// fieldText records a text value which was indexed, for highlighting.
+type fieldText struct {
+	text           string
+	arrayPositions []uint64
+}
+
+// indexedField holds everything indexed for one field of a document.
+type indexedField struct {
+	name         string
+	includeInAll bool
+	terms        map[string][]*location
+	numTerms     int
+	texts        []fieldText
+	numbers      []float64
+	times        []time.Time
+	bools        []bool
+	stored       []interface{}
+}
+
+// indexedDocument represents a single document which has been indexed.
+type indexedDocument struct {
+	id         string
+	collection string
+	fields     map[string]*indexedField
+}
+
+func (d *indexedDocument) field(name string) *indexedField {
+	field := d.fields[name]
+	if field == nil {
+		field = &indexedField{
+			name:  name,
+			terms: make(map[string][]*location),
+		}
+		d.fields[name] = field
+	}
+	return field
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func parentPath(path string) string {
+	if idx := strings.LastIndex(path, "."); idx >= 0 {
+		return path[:idx]
+	}
+	return ""
+}
+
+// fieldPath returns the name of a field which maps the property at path.
+func fieldPath(parent, name, path string) string {
+	if name == "" {
+		return path
+	}
+	return joinPath(parent, name)
+}
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseDate(text string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// indexDocument indexes a document using the given document mapping.
+func (m *indexMapping) indexDocument(id, collection string, value map[string]interface{},
+	mapping *documentMapping) *indexedDocument {
+	doc := &indexedDocument{
+		id:         id,
+		collection: collection,
+		fields:     make(map[string]*indexedField),
+	}
+
+	analyzerName := mapping.DefaultAnalyzer
+	if analyzerName == "" {
+		analyzerName = m.DefaultAnalyzer
+	}
+	m.indexObject(doc, "", value, mapping, analyzerName, nil)
+
+	return doc
+}
+
+func (m *indexMapping) indexObject(doc *indexedDocument, path string, obj map[string]interface{},
+	mapping *documentMapping, analyzerName string, arrayPositions []uint64) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := joinPath(path, key)
+
+		if child, ok := mapping.Properties[key]; ok {
+			if !child.Enabled {
+				continue
+			}
+			childAnalyzer := analyzerName
+			if child.DefaultAnalyzer != "" {
+				childAnalyzer = child.DefaultAnalyzer
+			}
+			m.indexValue(doc, childPath, obj[key], child, childAnalyzer, arrayPositions)
+		} else if mapping.Dynamic {
+			dynamic := &documentMapping{
+				Enabled: true,
+				Dynamic: true,
+			}
+			m.indexValue(doc, childPath, obj[key], dynamic, analyzerName, arrayPositions)
+		}
+	}
+}
+
+func (m *indexMapping) indexValue(doc *indexedDocument, path string, value interface{},
+	mapping *documentMapping, analyzerName string, arrayPositions []uint64) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		m.indexObject(doc, path, value, mapping, analyzerName, arrayPositions)
+	case []interface{}:
+		for i, item := range value {
+			itemPositions := make([]uint64, len(arrayPositions), len(arrayPositions)+1)
+			copy(itemPositions, arrayPositions)
+			m.indexValue(doc, path, item, mapping, analyzerName, append(itemPositions, uint64(i)))
+		}
+	case nil:
+	default:
+		if len(mapping.Fields) > 0 {
+			for _, field := range mapping.Fields {
+				m.indexField(doc, fieldPath(parentPath(path), field.Name, path), value, field, analyzerName,
+					arrayPositions)
+			}
+		} else if mapping.Dynamic {
+			m.indexDynamic(doc, path, value, analyzerName, arrayPositions)
+		}
+	}
+}
+
+func (m *indexMapping) indexDynamic(doc *indexedDocument, path string, value interface{}, analyzerName string,
+	arrayPositions []uint64) {
+	if m.IndexDynamic != nil && !*m.IndexDynamic {
+		return
+	}
+
+	field := &fieldMapping{
+		Store:    m.StoreDynamic == nil || *m.StoreDynamic,
+		Analyzer: analyzerName,
+	}
+	switch value := value.(type) {
+	case string:
+		if _, ok := parseDate(value); ok {
+			field.Type = "datetime"
+		} else {
+			field.Type = "text"
+		}
+	case float64:
+		field.Type = "number"
+	case bool:
+		field.Type = "boolean"
+	default:
+		return
+	}
+
+	m.indexField(doc, path, value, field, analyzerName, arrayPositions)
+}
+
+func (m *indexMapping) indexField(doc *indexedDocument, name string, value interface{}, mapping *fieldMapping,
+	analyzerName string, arrayPositions []uint64) {
+	if !mapping.indexed() && !mapping.Store {
+		return
+	}
+
+	field := doc.field(name)
+	field.includeInAll = field.includeInAll || mapping.includedInAll()
+
+	switch mapping.Type {
+	case "text":
+		text, ok := value.(string)
+		if !ok {
+			return
+		}
+
+		if mapping.indexed() {
+			if mapping.Analyzer != "" {
+				analyzerName = mapping.Analyzer
+			}
+			fn := m.analyzers[analyzerName]
+			if fn == nil {
+				fn = m.analyzers[m.DefaultAnalyzer]
+			}
+
+			for _, tok := range fn(text) {
+				field.terms[tok.term] = append(field.terms[tok.term], &location{
+					pos:            tok.position,
+					start:          tok.start,
+					end:            tok.end,
+					arrayPositions: arrayPositions,
+				})
+				field.numTerms++
+			}
+		}
+		if mapping.Store {
+			field.texts = append(field.texts, fieldText{
+				text:           text,
+				arrayPositions: arrayPositions,
+			})
+		}
+	case "number":
+		num, ok := value.(float64)
+		if !ok {
+			return
+		}
+		if mapping.indexed() {
+			field.numbers = append(field.numbers, num)
+		}
+	case "datetime":
+		text, ok := value.(string)
+		if !ok {
+			return
+		}
+		t, ok := parseDate(text)
+		if !ok {
+			return
+		}
+		if mapping.indexed() {
+			field.times = append(field.times, t)
+		}
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return
+		}
+		if mapping.indexed() {
+			field.bools = append(field.bools, b)
+		}
+	default:
+		return
+	}
+
+	if mapping.Store {
+		field.stored = append(field.stored, value)
+	}
+}
@@ -0,0 +1,1044 @@
+package mockfts
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// docMatch represents a document which matched a query, along with its score
+// and where the matched terms were found, keyed by field and then term.
+type docMatch struct {
+	score     float64
+	locations map[string]map[string][]*location
+}
+
+func newDocMatch() *docMatch {
+	return &docMatch{
+		locations: make(map[string]map[string][]*location),
+	}
+}
+
+func (m *docMatch) addLocations(field, term string, locs []*location) {
+	terms := m.locations[field]
+	if terms == nil {
+		terms = make(map[string][]*location)
+		m.locations[field] = terms
+	}
+	terms[term] = append(terms[term], locs...)
+}
+
+func (m *docMatch) merge(other *docMatch) {
+	m.score += other.score
+	for field, terms := range other.locations {
+		for term, locs := range terms {
+			m.addLocations(field, term, locs)
+		}
+	}
+}
+
+// query represents a parsed search query.
+type query interface {
+	match(c *searchContext, doc *indexedDocument) (*docMatch, error)
+}
+
+// searchContext holds the documents being searched, along with the term
+// statistics used for scoring.
+type searchContext struct {
+	mapping  *indexMapping
+	docs     []*indexedDocument
+	docFreqs map[string]int
+}
+
+func newSearchContext(mapping *indexMapping, docs []*indexedDocument) *searchContext {
+	return &searchContext{
+		mapping:  mapping,
+		docs:     docs,
+		docFreqs: make(map[string]int),
+	}
+}
+
+// resolveField converts an unspecified field into the default field.
+func (c *searchContext) resolveField(field string) string {
+	if field == "" {
+		return c.mapping.DefaultField
+	}
+	return field
+}
+
+// fieldsOf returns the fields of a document which a query against the named
+// field searches, the composite field searches every field included in it.
+func (c *searchContext) fieldsOf(doc *indexedDocument, name string) []*indexedField {
+	if name != compositeField {
+		if field := doc.fields[name]; field != nil {
+			return []*indexedField{field}
+		}
+		return nil
+	}
+
+	var fields []*indexedField
+	for _, field := range doc.fields {
+		if field.includeInAll && len(field.terms) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+	return fields
+}
+
+// idf returns the inverse document frequency of a term within a field.
+func (c *searchContext) idf(field, term string) float64 {
+	key := field + "\x00" + term
+	docFreq, ok := c.docFreqs[key]
+	if !ok {
+		for _, doc := range c.docs {
+			if doc.fields[field] != nil && len(doc.fields[field].terms[term]) > 0 {
+				docFreq++
+			}
+		}
+		c.docFreqs[key] = docFreq
+	}
+
+	return 1 + math.Log(float64(len(c.docs))/float64(docFreq+1))
+}
+
+// matchTerms matches any terms of a field which satisfy a predicate.
+func (c *searchContext) matchTerms(doc *indexedDocument, field string, boost float64,
+	pred func(term string) bool) *docMatch {
+	var match *docMatch
+	for _, f := range c.fieldsOf(doc, c.resolveField(field)) {
+		for term, locs := range f.terms {
+			if !pred(term) {
+				continue
+			}
+
+			if match == nil {
+				match = newDocMatch()
+			}
+
+			idf := c.idf(f.name, term)
+			norm := 1 / math.Sqrt(float64(f.numTerms))
+			match.score += math.Sqrt(float64(len(locs))) * idf * idf * norm * boost
+			match.addLocations(f.name, term, locs)
+		}
+	}
+	return match
+}
+
+type termQuery struct {
+	field        string
+	term         string
+	fuzziness    int
+	prefixLength int
+	boost        float64
+}
+
+func (q *termQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	return c.matchTerms(doc, q.field, q.boost, func(term string) bool {
+		if term == q.term {
+			return true
+		}
+		if q.fuzziness == 0 {
+			return false
+		}
+		termRunes, queryRunes := []rune(term), []rune(q.term)
+		if q.prefixLength > 0 {
+			if len(termRunes) < q.prefixLength || len(queryRunes) < q.prefixLength ||
+				string(termRunes[:q.prefixLength]) != string(queryRunes[:q.prefixLength]) {
+				return false
+			}
+		}
+		return levenshtein(termRunes, queryRunes) <= q.fuzziness
+	}), nil
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type prefixQuery struct {
+	field  string
+	prefix string
+	boost  float64
+}
+
+func (q *prefixQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	return c.matchTerms(doc, q.field, q.boost, func(term string) bool {
+		return strings.HasPrefix(term, q.prefix)
+	}), nil
+}
+
+type regexpQuery struct {
+	field string
+	re    *regexp.Regexp
+	boost float64
+}
+
+func (q *regexpQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	return c.matchTerms(doc, q.field, q.boost, q.re.MatchString), nil
+}
+
+// wildcardToRegexp converts a wildcard pattern, where * matches any number of
+// characters and ? matches exactly one, into an anchored regular expression.
+func wildcardToRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// matchQuery analyzes its text and matches the resulting terms.
+type matchQuery struct {
+	field        string
+	text         string
+	analyzer     string
+	fuzziness    int
+	prefixLength int
+	operator     string
+	boost        float64
+}
+
+func (q *matchQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	field := c.resolveField(q.field)
+	fn, err := c.mapping.analyzerFor(field, q.analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := fn(q.text)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	queries := make([]query, len(tokens))
+	for i, tok := range tokens {
+		queries[i] = &termQuery{
+			field:        field,
+			term:         tok.term,
+			fuzziness:    q.fuzziness,
+			prefixLength: q.prefixLength,
+			boost:        q.boost,
+		}
+	}
+
+	if q.operator == "and" {
+		return (&conjunctionQuery{queries: queries, boost: 1}).match(c, doc)
+	}
+	return (&disjunctionQuery{queries: queries, boost: 1}).match(c, doc)
+}
+
+// phraseQuery matches terms which appear next to each other, in order.  When
+// text is set it is analyzed to produce the terms.
+type phraseQuery struct {
+	field    string
+	text     string
+	analyzer string
+	terms    []string
+	boost    float64
+}
+
+func sameArrayPositions(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *phraseQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	field := c.resolveField(q.field)
+
+	// offsets holds the position of each term relative to the first, which
+	// accounts for any stop words removed during analysis.
+	terms := q.terms
+	offsets := make([]int, len(terms))
+	for i := range terms {
+		offsets[i] = i
+	}
+	if terms == nil {
+		fn, err := c.mapping.analyzerFor(field, q.analyzer)
+		if err != nil {
+			return nil, err
+		}
+		for _, tok := range fn(q.text) {
+			terms = append(terms, tok.term)
+			offsets = append(offsets, tok.position)
+		}
+		for i := len(offsets) - 1; i >= 0; i-- {
+			offsets[i] -= offsets[0]
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var match *docMatch
+	for _, f := range c.fieldsOf(doc, field) {
+		phraseLocs := make([][]*location, len(terms))
+		for _, first := range f.terms[terms[0]] {
+			found := []*location{first}
+			for i := 1; i < len(terms); i++ {
+				var next *location
+				for _, loc := range f.terms[terms[i]] {
+					if loc.pos == first.pos+offsets[i] && sameArrayPositions(loc.arrayPositions, first.arrayPositions) {
+						next = loc
+						break
+					}
+				}
+				if next == nil {
+					found = nil
+					break
+				}
+				found = append(found, next)
+			}
+			for i, loc := range found {
+				phraseLocs[i] = append(phraseLocs[i], loc)
+			}
+		}
+		if len(phraseLocs[0]) == 0 {
+			continue
+		}
+
+		if match == nil {
+			match = newDocMatch()
+		}
+		norm := 1 / math.Sqrt(float64(f.numTerms))
+		for i, term := range terms {
+			idf := c.idf(f.name, term)
+			match.score += math.Sqrt(float64(len(phraseLocs[i]))) * idf * idf * norm * q.boost
+			match.addLocations(f.name, term, phraseLocs[i])
+		}
+	}
+
+	return match, nil
+}
+
+type numericRangeQuery struct {
+	field        string
+	min          *float64
+	max          *float64
+	inclusiveMin bool
+	inclusiveMax bool
+	boost        float64
+}
+
+func (q *numericRangeQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	field := doc.fields[c.resolveField(q.field)]
+	if field == nil {
+		return nil, nil
+	}
+
+	for _, num := range field.numbers {
+		if q.min != nil && (num < *q.min || (num == *q.min && !q.inclusiveMin)) {
+			continue
+		}
+		if q.max != nil && (num > *q.max || (num == *q.max && !q.inclusiveMax)) {
+			continue
+		}
+		return &docMatch{score: q.boost}, nil
+	}
+	return nil, nil
+}
+
+type dateRangeQuery struct {
+	field          string
+	start          *time.Time
+	end            *time.Time
+	inclusiveStart bool
+	inclusiveEnd   bool
+	boost          float64
+}
+
+func (q *dateRangeQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	field := doc.fields[c.resolveField(q.field)]
+	if field == nil {
+		return nil, nil
+	}
+
+	for _, t := range field.times {
+		if q.start != nil && (t.Before(*q.start) || (t.Equal(*q.start) && !q.inclusiveStart)) {
+			continue
+		}
+		if q.end != nil && (t.After(*q.end) || (t.Equal(*q.end) && !q.inclusiveEnd)) {
+			continue
+		}
+		return &docMatch{score: q.boost}, nil
+	}
+	return nil, nil
+}
+
+type boolFieldQuery struct {
+	field string
+	value bool
+	boost float64
+}
+
+func (q *boolFieldQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	field := doc.fields[c.resolveField(q.field)]
+	if field == nil {
+		return nil, nil
+	}
+
+	for _, b := range field.bools {
+		if b == q.value {
+			return &docMatch{score: q.boost}, nil
+		}
+	}
+	return nil, nil
+}
+
+type docIDQuery struct {
+	ids   map[string]bool
+	boost float64
+}
+
+func (q *docIDQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	if !q.ids[doc.id] {
+		return nil, nil
+	}
+	return &docMatch{score: q.boost}, nil
+}
+
+type matchAllQuery struct {
+	boost float64
+}
+
+func (q *matchAllQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	return &docMatch{score: q.boost}, nil
+}
+
+type matchNoneQuery struct {
+}
+
+func (q *matchNoneQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	return nil, nil
+}
+
+type conjunctionQuery struct {
+	queries []query
+	boost   float64
+}
+
+func (q *conjunctionQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	if len(q.queries) == 0 {
+		return nil, nil
+	}
+
+	match := newDocMatch()
+	for _, sub := range q.queries {
+		subMatch, err := sub.match(c, doc)
+		if err != nil || subMatch == nil {
+			return nil, err
+		}
+		match.merge(subMatch)
+	}
+	match.score *= q.boost
+	return match, nil
+}
+
+type disjunctionQuery struct {
+	queries []query
+	min     int
+	boost   float64
+}
+
+func (q *disjunctionQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	match := newDocMatch()
+	matched := 0
+	for _, sub := range q.queries {
+		subMatch, err := sub.match(c, doc)
+		if err != nil {
+			return nil, err
+		}
+		if subMatch != nil {
+			match.merge(subMatch)
+			matched++
+		}
+	}
+
+	if matched == 0 || matched < q.min {
+		return nil, nil
+	}
+
+	// Documents which match more of the disjuncts score more highly.
+	match.score *= float64(matched) / float64(len(q.queries)) * q.boost
+	return match, nil
+}
+
+type booleanQuery struct {
+	must    *conjunctionQuery
+	should  *disjunctionQuery
+	mustNot *disjunctionQuery
+	boost   float64
+}
+
+func (q *booleanQuery) match(c *searchContext, doc *indexedDocument) (*docMatch, error) {
+	if q.mustNot != nil && len(q.mustNot.queries) > 0 {
+		notMatch, err := q.mustNot.match(c, doc)
+		if err != nil || notMatch != nil {
+			return nil, err
+		}
+	}
+
+	match := newDocMatch()
+	hasMust := q.must != nil && len(q.must.queries) > 0
+	if hasMust {
+		mustMatch, err := q.must.match(c, doc)
+		if err != nil || mustMatch == nil {
+			return nil, err
+		}
+		match.merge(mustMatch)
+	}
+
+	if q.should != nil && len(q.should.queries) > 0 {
+		shouldMatch, err := q.should.match(c, doc)
+		if err != nil {
+			return nil, err
+		}
+		if shouldMatch == nil && (!hasMust || q.should.min > 0) {
+			return nil, nil
+		}
+		if shouldMatch != nil {
+			match.merge(shouldMatch)
+		}
+	} else if !hasMust {
+		// A query with only must_not clauses matches everything else.
+		match.score = 1
+	}
+
+	match.score *= q.boost
+	return match, nil
+}
+
+// parseQuery parses a query, determining its type from the fields it holds in
+// the same way that the real search service does.
+func parseQuery(data json.RawMessage) (query, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, newError(ErrInvalidQuery, "error parsing query: %s", err)
+	}
+
+	var opts struct {
+		Field          string          `json:"field"`
+		Boost          *float64        `json:"boost"`
+		Analyzer       string          `json:"analyzer"`
+		Fuzziness      int             `json:"fuzziness"`
+		PrefixLength   int             `json:"prefix_length"`
+		Operator       json.RawMessage `json:"operator"`
+		Min            json.RawMessage `json:"min"`
+		Max            *float64        `json:"max"`
+		InclusiveMin   *bool           `json:"inclusive_min"`
+		InclusiveMax   *bool           `json:"inclusive_max"`
+		Start          *string         `json:"start"`
+		End            *string         `json:"end"`
+		InclusiveStart *bool           `json:"inclusive_start"`
+		InclusiveEnd   *bool           `json:"inclusive_end"`
+	}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return nil, newError(ErrInvalidQuery, "error parsing query: %s", err)
+	}
+
+	boost := 1.0
+	if opts.Boost != nil {
+		boost = *opts.Boost
+	}
+
+	has := func(name string) bool {
+		_, ok := fields[name]
+		return ok
+	}
+	stringField := func(name string) (string, error) {
+		var val string
+		if err := json.Unmarshal(fields[name], &val); err != nil {
+			return "", newError(ErrInvalidQuery, "error parsing %s query: %s", name, err)
+		}
+		return val, nil
+	}
+
+	switch {
+	case has("must") || has("should") || has("must_not"):
+		q := &booleanQuery{boost: boost}
+		var err error
+		if has("must") {
+			if q.must, err = parseConjunction(fields["must"]); err != nil {
+				return nil, err
+			}
+		}
+		if has("should") {
+			if q.should, err = parseDisjunction(fields["should"]); err != nil {
+				return nil, err
+			}
+		}
+		if has("must_not") {
+			if q.mustNot, err = parseDisjunction(fields["must_not"]); err != nil {
+				return nil, err
+			}
+		}
+		return q, nil
+
+	case has("conjuncts"):
+		return parseConjunction(data)
+
+	case has("disjuncts"):
+		return parseDisjunction(data)
+
+	case has("match"):
+		text, err := stringField("match")
+		if err != nil {
+			return nil, err
+		}
+		operator := "or"
+		if len(opts.Operator) > 0 {
+			var opStr string
+			var opNum int
+			if json.Unmarshal(opts.Operator, &opStr) == nil {
+				operator = strings.ToLower(opStr)
+			} else if json.Unmarshal(opts.Operator, &opNum) == nil && opNum == 1 {
+				operator = "and"
+			}
+			if operator != "or" && operator != "and" {
+				return nil, newError(ErrInvalidQuery, "invalid operator value: %s", operator)
+			}
+		}
+		return &matchQuery{
+			field:        opts.Field,
+			text:         text,
+			analyzer:     opts.Analyzer,
+			fuzziness:    opts.Fuzziness,
+			prefixLength: opts.PrefixLength,
+			operator:     operator,
+			boost:        boost,
+		}, nil
+
+	case has("match_phrase"):
+		text, err := stringField("match_phrase")
+		if err != nil {
+			return nil, err
+		}
+		return &phraseQuery{
+			field:    opts.Field,
+			text:     text,
+			analyzer: opts.Analyzer,
+			boost:    boost,
+		}, nil
+
+	case has("terms"):
+		var terms []string
+		if err := json.Unmarshal(fields["terms"], &terms); err != nil {
+			return nil, newError(ErrInvalidQuery, "error parsing phrase query: %s", err)
+		}
+		if len(terms) == 0 {
+			return nil, newError(ErrInvalidQuery, "phrase query must contain at least one term")
+		}
+		return &phraseQuery{
+			field: opts.Field,
+			terms: terms,
+			boost: boost,
+		}, nil
+
+	case has("term"):
+		term, err := stringField("term")
+		if err != nil {
+			return nil, err
+		}
+		return &termQuery{
+			field:        opts.Field,
+			term:         term,
+			fuzziness:    opts.Fuzziness,
+			prefixLength: opts.PrefixLength,
+			boost:        boost,
+		}, nil
+
+	case has("prefix"):
+		prefix, err := stringField("prefix")
+		if err != nil {
+			return nil, err
+		}
+		return &prefixQuery{
+			field:  opts.Field,
+			prefix: prefix,
+			boost:  boost,
+		}, nil
+
+	case has("wildcard"):
+		wildcard, err := stringField("wildcard")
+		if err != nil {
+			return nil, err
+		}
+		return &regexpQuery{
+			field: opts.Field,
+			re:    regexp.MustCompile(wildcardToRegexp(wildcard)),
+			boost: boost,
+		}, nil
+
+	case has("regexp"):
+		pattern, err := stringField("regexp")
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, newError(ErrInvalidQuery, "error parsing regexp query: %s", err)
+		}
+		return &regexpQuery{
+			field: opts.Field,
+			re:    re,
+			boost: boost,
+		}, nil
+
+	case has("min") || has("max"):
+		q := &numericRangeQuery{
+			field:        opts.Field,
+			max:          opts.Max,
+			inclusiveMin: true,
+			boost:        boost,
+		}
+		if len(opts.Min) > 0 {
+			var min float64
+			if err := json.Unmarshal(opts.Min, &min); err != nil {
+				// A string min is used by term range queries, which we do not support.
+				return nil, newError(ErrInvalidQuery, "unknown query type")
+			}
+			q.min = &min
+		}
+		if opts.InclusiveMin != nil {
+			q.inclusiveMin = *opts.InclusiveMin
+		}
+		if opts.InclusiveMax != nil {
+			q.inclusiveMax = *opts.InclusiveMax
+		}
+		return q, nil
+
+	case has("start") || has("end"):
+		q := &dateRangeQuery{
+			field:          opts.Field,
+			inclusiveStart: true,
+			boost:          boost,
+		}
+		if opts.Start != nil {
+			start, ok := parseDate(*opts.Start)
+			if !ok {
+				return nil, newError(ErrInvalidQuery, "unable to parse datetime with any of the layouts: %s", *opts.Start)
+			}
+			q.start = &start
+		}
+		if opts.End != nil {
+			end, ok := parseDate(*opts.End)
+			if !ok {
+				return nil, newError(ErrInvalidQuery, "unable to parse datetime with any of the layouts: %s", *opts.End)
+			}
+			q.end = &end
+		}
+		if q.start == nil && q.end == nil {
+			return nil, newError(ErrInvalidQuery, "date range query must specify at least one of start/end")
+		}
+		if opts.InclusiveStart != nil {
+			q.inclusiveStart = *opts.InclusiveStart
+		}
+		if opts.InclusiveEnd != nil {
+			q.inclusiveEnd = *opts.InclusiveEnd
+		}
+		return q, nil
+
+	case has("ids"):
+		var ids []string
+		if err := json.Unmarshal(fields["ids"], &ids); err != nil {
+			return nil, newError(ErrInvalidQuery, "error parsing docid query: %s", err)
+		}
+		q := &docIDQuery{
+			ids:   make(map[string]bool),
+			boost: boost,
+		}
+		for _, id := range ids {
+			q.ids[id] = true
+		}
+		return q, nil
+
+	case has("bool"):
+		var value bool
+		if err := json.Unmarshal(fields["bool"], &value); err != nil {
+			return nil, newError(ErrInvalidQuery, "error parsing boolean field query: %s", err)
+		}
+		return &boolFieldQuery{
+			field: opts.Field,
+			value: value,
+			boost: boost,
+		}, nil
+
+	case has("match_all"):
+		return &matchAllQuery{boost: boost}, nil
+
+	case has("match_none"):
+		return &matchNoneQuery{}, nil
+
+	case has("query"):
+		text, err := stringField("query")
+		if err != nil {
+			return nil, err
+		}
+		return parseQueryString(text, boost)
+	}
+
+	return nil, newError(ErrInvalidQuery, "unknown query type")
+}
+
+func parseConjunction(data json.RawMessage) (*conjunctionQuery, error) {
+	var parsed struct {
+		Conjuncts []json.RawMessage `json:"conjuncts"`
+		Boost     *float64          `json:"boost"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, newError(ErrInvalidQuery, "error parsing conjunction query: %s", err)
+	}
+
+	q := &conjunctionQuery{boost: 1}
+	if parsed.Boost != nil {
+		q.boost = *parsed.Boost
+	}
+	for _, sub := range parsed.Conjuncts {
+		subQuery, err := parseQuery(sub)
+		if err != nil {
+			return nil, err
+		}
+		q.queries = append(q.queries, subQuery)
+	}
+	return q, nil
+}
+
+func parseDisjunction(data json.RawMessage) (*disjunctionQuery, error) {
+	var parsed struct {
+		Disjuncts []json.RawMessage `json:"disjuncts"`
+		Min       int               `json:"min"`
+		Boost     *float64          `json:"boost"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, newError(ErrInvalidQuery, "error parsing disjunction query: %s", err)
+	}
+
+	q := &disjunctionQuery{min: parsed.Min, boost: 1}
+	if parsed.Boost != nil {
+		q.boost = *parsed.Boost
+	}
+	for _, sub := range parsed.Disjuncts {
+		subQuery, err := parseQuery(sub)
+		if err != nil {
+			return nil, err
+		}
+		q.queries = append(q.queries, subQuery)
+	}
+	if q.min > len(q.queries) {
+		return nil, newError(ErrInvalidQuery, "disjunction query has fewer than the minimum number of clauses to satisfy")
+	}
+	return q, nil
+}
+
+// splitQueryString splits a query string into its clauses, keeping quoted
+// phrases together.
+func splitQueryString(text string) []string {
+	var clauses []string
+	var sb strings.Builder
+	inQuotes := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			sb.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuotes:
+			if sb.Len() > 0 {
+				clauses = append(clauses, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		clauses = append(clauses, sb.String())
+	}
+	return clauses
+}
+
+// parseQueryString parses the query string syntax supported by the search
+// service.  Each clause is optionally prefixed by + (must) or - (must not),
+// may be restricted to a field with field:, and can be a "phrase", a
+// /regexp/, a wildcard, a fuzzy term~N, or a range such as >5.
+func parseQueryString(text string, boost float64) (query, error) {
+	q := &booleanQuery{
+		must:    &conjunctionQuery{boost: 1},
+		should:  &disjunctionQuery{boost: 1},
+		mustNot: &disjunctionQuery{boost: 1},
+		boost:   boost,
+	}
+
+	clauses := splitQueryString(text)
+	if len(clauses) == 0 {
+		return &matchNoneQuery{}, nil
+	}
+
+	for _, clause := range clauses {
+		occur := clause[0]
+		if occur == '+' || occur == '-' {
+			clause = clause[1:]
+		}
+
+		clauseQuery, err := parseQueryStringClause(clause)
+		if err != nil {
+			return nil, err
+		}
+
+		switch occur {
+		case '+':
+			q.must.queries = append(q.must.queries, clauseQuery)
+		case '-':
+			q.mustNot.queries = append(q.mustNot.queries, clauseQuery)
+		default:
+			q.should.queries = append(q.should.queries, clauseQuery)
+		}
+	}
+
+	return q, nil
+}
+
+func parseQueryStringClause(clause string) (query, error) {
+	if clause == "" {
+		return nil, newError(ErrInvalidQuery, "parse error: syntax error")
+	}
+
+	var field string
+	if !strings.HasPrefix(clause, "\"") && !strings.HasPrefix(clause, "/") {
+		if idx := strings.Index(clause, ":"); idx > 0 {
+			field = clause[:idx]
+			clause = clause[idx+1:]
+		}
+	}
+
+	clauseBoost := 1.0
+	if idx := strings.LastIndex(clause, "^"); idx > 0 {
+		if parsed, err := strconv.ParseFloat(clause[idx+1:], 64); err == nil {
+			clauseBoost = parsed
+			clause = clause[:idx]
+		}
+	}
+
+	switch {
+	case len(clause) >= 2 && strings.HasPrefix(clause, "\"") && strings.HasSuffix(clause, "\""):
+		return &phraseQuery{
+			field: field,
+			text:  clause[1 : len(clause)-1],
+			boost: clauseBoost,
+		}, nil
+
+	case len(clause) >= 2 && strings.HasPrefix(clause, "/") && strings.HasSuffix(clause, "/"):
+		re, err := regexp.Compile("^(?:" + clause[1:len(clause)-1] + ")$")
+		if err != nil {
+			return nil, newError(ErrInvalidQuery, "parse error: %s", err)
+		}
+		return &regexpQuery{field: field, re: re, boost: clauseBoost}, nil
+
+	case strings.HasPrefix(clause, ">") || strings.HasPrefix(clause, "<"):
+		inclusive := strings.HasPrefix(clause[1:], "=")
+		valueText := strings.TrimPrefix(clause[1:], "=")
+		value, err := strconv.ParseFloat(valueText, 64)
+		if err != nil {
+			return nil, newError(ErrInvalidQuery, "parse error: invalid numeric range %s", clause)
+		}
+		q := &numericRangeQuery{field: field, boost: clauseBoost}
+		if clause[0] == '>' {
+			q.min, q.inclusiveMin = &value, inclusive
+		} else {
+			q.max, q.inclusiveMax = &value, inclusive
+		}
+		return q, nil
+
+	case strings.ContainsAny(clause, "*?"):
+		return &regexpQuery{
+			field: field,
+			re:    regexp.MustCompile(wildcardToRegexp(clause)),
+			boost: clauseBoost,
+		}, nil
+	}
+
+	fuzziness := 0
+	if idx := strings.LastIndex(clause, "~"); idx > 0 {
+		fuzziness = 1
+		if suffix := clause[idx+1:]; suffix != "" {
+			parsed, err := strconv.Atoi(suffix)
+			if err != nil {
+				return nil, newError(ErrInvalidQuery, "parse error: invalid fuzziness %s", suffix)
+			}
+			fuzziness = parsed
+		}
+		clause = clause[:idx]
+	}
+
+	match := &matchQuery{
+		field:     field,
+		text:      clause,
+		fuzziness: fuzziness,
+		operator:  "or",
+		boost:     clauseBoost,
+	}
+
+	// Numbers with a field can match either the text or the numeric value.
+	if num, err := strconv.ParseFloat(clause, 64); err == nil && field != "" {
+		return &disjunctionQuery{
+			queries: []query{
+				match,
+				&numericRangeQuery{
+					field:        field,
+					min:          &num,
+					max:          &num,
+					inclusiveMin: true,
+					inclusiveMax: true,
+					boost:        clauseBoost,
+				},
+			},
+			boost: 1,
+		}, nil
+	}
+
+	return match, nil
+}
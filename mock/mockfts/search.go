@@ -0,0 +1,728 @@
+package mockfts
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSearchSize = 10
+
+// SearchRequest represents a search request, in the same form as is sent to
+// the query endpoint of the search service.
+type SearchRequest struct {
+	Query            json.RawMessage          `json:"query"`
+	Size             *int                     `json:"size,omitempty"`
+	From             int                      `json:"from"`
+	Highlight        *HighlightRequest        `json:"highlight,omitempty"`
+	Fields           []string                 `json:"fields,omitempty"`
+	Facets           map[string]*FacetRequest `json:"facets,omitempty"`
+	Explain          bool                     `json:"explain"`
+	Sort             []json.RawMessage        `json:"sort,omitempty"`
+	IncludeLocations bool                     `json:"includeLocations"`
+	Score            string                   `json:"score,omitempty"`
+	Collections      []string                 `json:"collections,omitempty"`
+	Ctl              *ControlRequest          `json:"ctl,omitempty"`
+}
+
+// HighlightRequest specifies how the matches within results are highlighted.
+type HighlightRequest struct {
+	Style  *string  `json:"style"`
+	Fields []string `json:"fields"`
+}
+
+// FacetRequest specifies a facet to calculate over the results of a search.
+type FacetRequest struct {
+	Size          int                  `json:"size"`
+	Field         string               `json:"field"`
+	NumericRanges []*NumericRangeFacet `json:"numeric_ranges,omitempty"`
+	DateRanges    []*DateRangeFacet    `json:"date_ranges,omitempty"`
+}
+
+// ControlRequest holds the control options of a search request.
+type ControlRequest struct {
+	Timeout     int64               `json:"timeout,omitempty"`
+	Consistency *ConsistencyRequest `json:"consistency,omitempty"`
+}
+
+// ConsistencyRequest specifies the consistency requirements of a search.
+type ConsistencyRequest struct {
+	Level   string                       `json:"level"`
+	Vectors map[string]map[string]uint64 `json:"vectors,omitempty"`
+}
+
+// SearchResults represents the results of a search.
+type SearchResults struct {
+	Status    SearchStatus            `json:"status"`
+	Request   *SearchRequest          `json:"request"`
+	Hits      []*SearchHit            `json:"hits"`
+	TotalHits int                     `json:"total_hits"`
+	MaxScore  float64                 `json:"max_score"`
+	Took      int64                   `json:"took"`
+	Facets    map[string]*FacetResult `json:"facets"`
+}
+
+// SearchStatus reports how many of the index partitions were searched.
+type SearchStatus struct {
+	Total      int               `json:"total"`
+	Failed     int               `json:"failed"`
+	Successful int               `json:"successful"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// SearchHit represents a single document which matched a search.
+type SearchHit struct {
+	Index       string                           `json:"index"`
+	ID          string                           `json:"id"`
+	Score       float64                          `json:"score"`
+	Explanation *Explanation                     `json:"explanation,omitempty"`
+	Locations   map[string]map[string][]Location `json:"locations,omitempty"`
+	Fragments   map[string][]string              `json:"fragments,omitempty"`
+	Fields      map[string]interface{}           `json:"fields,omitempty"`
+	Sort        []string                         `json:"sort"`
+}
+
+// Explanation describes how the score of a hit was calculated.
+type Explanation struct {
+	Value   float64 `json:"value"`
+	Message string  `json:"message"`
+}
+
+// Location describes where a term was matched within a field.
+type Location struct {
+	Pos            int      `json:"pos"`
+	Start          int      `json:"start"`
+	End            int      `json:"end"`
+	ArrayPositions []uint64 `json:"array_positions"`
+}
+
+// FacetResult represents the result of calculating a facet.
+type FacetResult struct {
+	Field         string               `json:"field"`
+	Total         int                  `json:"total"`
+	Missing       int                  `json:"missing"`
+	Other         int                  `json:"other"`
+	Terms         []*TermFacet         `json:"terms,omitempty"`
+	NumericRanges []*NumericRangeFacet `json:"numeric_ranges,omitempty"`
+	DateRanges    []*DateRangeFacet    `json:"date_ranges,omitempty"`
+}
+
+// TermFacet represents the number of hits with a particular term.
+type TermFacet struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// NumericRangeFacet represents a numeric range facet, along with the number
+// of hits within the range when returned as part of a result.
+type NumericRangeFacet struct {
+	Name  string   `json:"name"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int      `json:"count,omitempty"`
+}
+
+// DateRangeFacet represents a date range facet, along with the number of hits
+// within the range when returned as part of a result.
+type DateRangeFacet struct {
+	Name  string  `json:"name"`
+	Start *string `json:"start,omitempty"`
+	End   *string `json:"end,omitempty"`
+	Count int     `json:"count,omitempty"`
+}
+
+type searchHit struct {
+	doc   *indexedDocument
+	match *docMatch
+}
+
+// Search executes a search request against an index.
+func (e *Engine) Search(name string, req *SearchRequest, sources SourceResolver) (*SearchResults, error) {
+	start := time.Now()
+
+	e.lock.Lock()
+	existing := e.indexes[name]
+	queryDisallowed := existing != nil && existing.queryDisallowed
+	e.lock.Unlock()
+	if existing == nil {
+		return nil, ErrIndexNotFound
+	}
+	if queryDisallowed {
+		return nil, newError(ErrQueryDisallowed, "bleveIndexTargets: queries are disallowed for index: %s", name)
+	}
+
+	if req.Ctl != nil && req.Ctl.Consistency != nil {
+		switch req.Ctl.Consistency.Level {
+		case "", "not_bounded", "at_plus":
+		default:
+			return nil, newError(ErrInvalidQuery, "unsupported consistency level: %s", req.Ctl.Consistency.Level)
+		}
+	}
+
+	if len(req.Query) == 0 {
+		return nil, newError(ErrInvalidQuery, "query must be specified")
+	}
+	q, err := parseQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	sorts, err := parseSortOrder(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	size := defaultSearchSize
+	if req.Size != nil {
+		size = *req.Size
+	}
+	if size < 0 || req.From < 0 {
+		return nil, newError(ErrInvalidQuery, "size and from must not be negative")
+	}
+
+	idx, docs, err := e.loadDocuments(name, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make(map[string]bool)
+	for _, collection := range req.Collections {
+		collections[collection] = true
+	}
+
+	c := newSearchContext(idx.mapping, docs)
+	var hits []*searchHit
+	for _, doc := range docs {
+		if len(collections) > 0 {
+			parts := strings.SplitN(doc.collection, ".", 2)
+			if !collections[doc.collection] && !collections[parts[1]] {
+				continue
+			}
+		}
+
+		match, err := q.match(c, doc)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			continue
+		}
+		if req.Score == "none" {
+			match.score = 0
+		}
+
+		hits = append(hits, &searchHit{
+			doc:   doc,
+			match: match,
+		})
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return compareHits(sorts, hits[i], hits[j]) < 0
+	})
+
+	results := &SearchResults{
+		Status: SearchStatus{
+			Total:      1,
+			Successful: 1,
+		},
+		Request:   req,
+		Hits:      []*SearchHit{},
+		TotalHits: len(hits),
+	}
+
+	for _, hit := range hits {
+		if hit.match.score > results.MaxScore {
+			results.MaxScore = hit.match.score
+		}
+	}
+
+	if len(req.Facets) > 0 {
+		results.Facets = make(map[string]*FacetResult)
+		for facetName, facet := range req.Facets {
+			result, err := calculateFacet(facet, hits)
+			if err != nil {
+				return nil, err
+			}
+			results.Facets[facetName] = result
+		}
+	}
+
+	pindexName := fmt.Sprintf("%s_%s_%08x", idx.def.Name, idx.def.UUID, crc32.ChecksumIEEE([]byte(idx.def.UUID)))
+
+	if req.From < len(hits) {
+		pageEnd := req.From + size
+		if pageEnd > len(hits) {
+			pageEnd = len(hits)
+		}
+
+		for _, hit := range hits[req.From:pageEnd] {
+			results.Hits = append(results.Hits, buildHit(req, pindexName, sorts, hit))
+		}
+	}
+
+	results.Took = int64(time.Since(start))
+
+	return results, nil
+}
+
+func buildHit(req *SearchRequest, pindexName string, sorts []*sortField, hit *searchHit) *SearchHit {
+	out := &SearchHit{
+		Index: pindexName,
+		ID:    hit.doc.id,
+		Score: hit.match.score,
+	}
+
+	for _, s := range sorts {
+		out.Sort = append(out.Sort, s.hitValue(hit))
+	}
+
+	if req.Explain {
+		out.Explanation = &Explanation{
+			Value:   hit.match.score,
+			Message: fmt.Sprintf("sum of the scores of the terms matched in document %s", hit.doc.id),
+		}
+	}
+
+	if req.IncludeLocations || req.Highlight != nil {
+		locations := make(map[string]map[string][]Location)
+		for field, terms := range hit.match.locations {
+			locations[field] = make(map[string][]Location)
+			for term, locs := range terms {
+				for _, loc := range locs {
+					locations[field][term] = append(locations[field][term], Location{
+						Pos:            loc.pos,
+						Start:          loc.start,
+						End:            loc.end,
+						ArrayPositions: loc.arrayPositions,
+					})
+				}
+			}
+		}
+		if req.IncludeLocations && len(locations) > 0 {
+			out.Locations = locations
+		}
+	}
+
+	if req.Highlight != nil {
+		out.Fragments = highlightHit(req.Highlight, hit)
+	}
+
+	if len(req.Fields) > 0 {
+		out.Fields = make(map[string]interface{})
+		for _, field := range hit.doc.fields {
+			if len(field.stored) == 0 || !wantsField(req.Fields, field.name) {
+				continue
+			}
+			if len(field.stored) == 1 {
+				out.Fields[field.name] = field.stored[0]
+			} else {
+				out.Fields[field.name] = field.stored
+			}
+		}
+		if len(out.Fields) == 0 {
+			out.Fields = nil
+		}
+	}
+
+	return out
+}
+
+func wantsField(fields []string, name string) bool {
+	for _, field := range fields {
+		if field == "*" || field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightHit produces fragments of the stored text of each field which had
+// a match, with the matched terms marked up.
+func highlightHit(highlight *HighlightRequest, hit *searchHit) map[string][]string {
+	before, after := "<mark>", "</mark>"
+	if highlight.Style != nil && *highlight.Style == "ansi" {
+		before, after = "\x1b[43m", "\x1b[0m"
+	}
+
+	fragments := make(map[string][]string)
+	for fieldName, terms := range hit.match.locations {
+		if len(highlight.Fields) > 0 && !wantsField(highlight.Fields, fieldName) {
+			continue
+		}
+
+		field := hit.doc.fields[fieldName]
+		if field == nil {
+			continue
+		}
+
+		for _, text := range field.texts {
+			var locs []*location
+			for _, termLocs := range terms {
+				for _, loc := range termLocs {
+					if sameArrayPositions(loc.arrayPositions, text.arrayPositions) {
+						locs = append(locs, loc)
+					}
+				}
+			}
+			if len(locs) == 0 {
+				continue
+			}
+			sort.Slice(locs, func(i, j int) bool {
+				return locs[i].start < locs[j].start
+			})
+
+			var sb strings.Builder
+			lastEnd := 0
+			for _, loc := range locs {
+				if loc.start < lastEnd || loc.end > len(text.text) {
+					continue
+				}
+				sb.WriteString(text.text[lastEnd:loc.start])
+				sb.WriteString(before)
+				sb.WriteString(text.text[loc.start:loc.end])
+				sb.WriteString(after)
+				lastEnd = loc.end
+			}
+			sb.WriteString(text.text[lastEnd:])
+
+			fragments[fieldName] = append(fragments[fieldName], sb.String())
+		}
+	}
+
+	if len(fragments) == 0 {
+		return nil
+	}
+	return fragments
+}
+
+// sortField represents a single element of the sort order of a search.
+type sortField struct {
+	by           string
+	field        string
+	desc         bool
+	missingFirst bool
+	mode         string
+}
+
+func parseSortOrder(specs []json.RawMessage) ([]*sortField, error) {
+	if len(specs) == 0 {
+		return []*sortField{{by: "score", desc: true}}, nil
+	}
+
+	var sorts []*sortField
+	for _, spec := range specs {
+		var text string
+		if err := json.Unmarshal(spec, &text); err == nil {
+			s := &sortField{}
+			if strings.HasPrefix(text, "-") {
+				s.desc = true
+				text = text[1:]
+			}
+			switch text {
+			case "_score":
+				s.by = "score"
+			case "_id":
+				s.by = "id"
+			default:
+				s.by = "field"
+				s.field = text
+			}
+			sorts = append(sorts, s)
+			continue
+		}
+
+		var obj struct {
+			By      string `json:"by"`
+			Field   string `json:"field"`
+			Desc    bool   `json:"desc"`
+			Missing string `json:"missing"`
+			Mode    string `json:"mode"`
+		}
+		if err := json.Unmarshal(spec, &obj); err != nil {
+			return nil, newError(ErrInvalidQuery, "error parsing sort: %s", err)
+		}
+
+		switch obj.By {
+		case "score", "id":
+		case "field":
+			if obj.Field == "" {
+				return nil, newError(ErrInvalidQuery, "search sort must specify a field")
+			}
+		default:
+			return nil, newError(ErrInvalidQuery, "unknown sort by: %s", obj.By)
+		}
+
+		sorts = append(sorts, &sortField{
+			by:           obj.By,
+			field:        obj.Field,
+			desc:         obj.Desc,
+			missingFirst: obj.Missing == "first",
+			mode:         obj.Mode,
+		})
+	}
+
+	return sorts, nil
+}
+
+// sortValue represents the value of a field which a hit is sorted by.  Numbers
+// sort before dates, which sort before text.
+type sortValue struct {
+	kind int
+	num  float64
+	t    time.Time
+	text string
+}
+
+func compareSortValues(a, b *sortValue) int {
+	if a.kind != b.kind {
+		return a.kind - b.kind
+	}
+
+	switch {
+	case a.kind == 0 && a.num != b.num:
+		if a.num < b.num {
+			return -1
+		}
+		return 1
+	case a.kind == 1 && !a.t.Equal(b.t):
+		if a.t.Before(b.t) {
+			return -1
+		}
+		return 1
+	case a.kind == 2:
+		return strings.Compare(a.text, b.text)
+	}
+	return 0
+}
+
+func (s *sortField) fieldValue(doc *indexedDocument) *sortValue {
+	field := doc.fields[s.field]
+	if field == nil {
+		return nil
+	}
+
+	var values []*sortValue
+	for _, num := range field.numbers {
+		values = append(values, &sortValue{kind: 0, num: num})
+	}
+	for _, t := range field.times {
+		values = append(values, &sortValue{kind: 1, t: t})
+	}
+	for term := range field.terms {
+		values = append(values, &sortValue{kind: 2, text: term})
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	// By default the lowest value is used when sorting ascending and the
+	// highest when sorting descending.
+	useMax := s.mode == "max" || (s.mode != "min" && s.desc)
+	best := values[0]
+	for _, value := range values[1:] {
+		cmp := compareSortValues(value, best)
+		if (useMax && cmp > 0) || (!useMax && cmp < 0) {
+			best = value
+		}
+	}
+	return best
+}
+
+func (s *sortField) compare(a, b *searchHit) int {
+	var cmp int
+	switch s.by {
+	case "score":
+		switch {
+		case a.match.score < b.match.score:
+			cmp = -1
+		case a.match.score > b.match.score:
+			cmp = 1
+		}
+	case "id":
+		cmp = strings.Compare(a.doc.id, b.doc.id)
+	case "field":
+		aValue, bValue := s.fieldValue(a.doc), s.fieldValue(b.doc)
+		if aValue == nil || bValue == nil {
+			// Missing values are placed according to the sort rather than the
+			// direction of the sort.
+			switch {
+			case aValue == nil && bValue == nil:
+				return 0
+			case aValue == nil && s.missingFirst, bValue == nil && !s.missingFirst:
+				return -1
+			default:
+				return 1
+			}
+		}
+		cmp = compareSortValues(aValue, bValue)
+	}
+
+	if s.desc {
+		return -cmp
+	}
+	return cmp
+}
+
+func (s *sortField) hitValue(hit *searchHit) string {
+	switch s.by {
+	case "score":
+		return "_score"
+	case "id":
+		return hit.doc.id
+	}
+
+	value := s.fieldValue(hit.doc)
+	if value == nil {
+		return ""
+	}
+	switch value.kind {
+	case 0:
+		return strconv.FormatFloat(value.num, 'f', -1, 64)
+	case 1:
+		return value.t.Format(time.RFC3339)
+	}
+	return value.text
+}
+
+// compareHits compares two hits by each element of the sort order in turn,
+// falling back to their ids so that the order is always stable.
+func compareHits(sorts []*sortField, a, b *searchHit) int {
+	for _, s := range sorts {
+		if cmp := s.compare(a, b); cmp != 0 {
+			return cmp
+		}
+	}
+	return strings.Compare(a.doc.id, b.doc.id)
+}
+
+func calculateFacet(facet *FacetRequest, hits []*searchHit) (*FacetResult, error) {
+	result := &FacetResult{
+		Field: facet.Field,
+	}
+
+	switch {
+	case len(facet.NumericRanges) > 0:
+		for _, r := range facet.NumericRanges {
+			result.NumericRanges = append(result.NumericRanges, &NumericRangeFacet{
+				Name: r.Name,
+				Min:  r.Min,
+				Max:  r.Max,
+			})
+		}
+
+		for _, hit := range hits {
+			field := hit.doc.fields[facet.Field]
+			if field == nil || len(field.numbers) == 0 {
+				result.Missing++
+				continue
+			}
+
+			for _, num := range field.numbers {
+				matched := false
+				for _, r := range result.NumericRanges {
+					if (r.Min == nil || num >= *r.Min) && (r.Max == nil || num < *r.Max) {
+						r.Count++
+						result.Total++
+						matched = true
+					}
+				}
+				if !matched {
+					result.Other++
+				}
+			}
+		}
+
+	case len(facet.DateRanges) > 0:
+		type dateRange struct {
+			start *time.Time
+			end   *time.Time
+		}
+		ranges := make([]dateRange, len(facet.DateRanges))
+		for i, r := range facet.DateRanges {
+			if r.Start != nil {
+				start, ok := parseDate(*r.Start)
+				if !ok {
+					return nil, newError(ErrInvalidQuery, "error parsing facet start date: %s", *r.Start)
+				}
+				ranges[i].start = &start
+			}
+			if r.End != nil {
+				end, ok := parseDate(*r.End)
+				if !ok {
+					return nil, newError(ErrInvalidQuery, "error parsing facet end date: %s", *r.End)
+				}
+				ranges[i].end = &end
+			}
+			result.DateRanges = append(result.DateRanges, &DateRangeFacet{
+				Name:  r.Name,
+				Start: r.Start,
+				End:   r.End,
+			})
+		}
+
+		for _, hit := range hits {
+			field := hit.doc.fields[facet.Field]
+			if field == nil || len(field.times) == 0 {
+				result.Missing++
+				continue
+			}
+
+			for _, t := range field.times {
+				matched := false
+				for i, r := range ranges {
+					if (r.start == nil || !t.Before(*r.start)) && (r.end == nil || t.Before(*r.end)) {
+						result.DateRanges[i].Count++
+						result.Total++
+						matched = true
+					}
+				}
+				if !matched {
+					result.Other++
+				}
+			}
+		}
+
+	default:
+		counts := make(map[string]int)
+		for _, hit := range hits {
+			field := hit.doc.fields[facet.Field]
+			if field == nil || len(field.terms) == 0 {
+				result.Missing++
+				continue
+			}
+
+			for term := range field.terms {
+				counts[term]++
+				result.Total++
+			}
+		}
+
+		for term, count := range counts {
+			result.Terms = append(result.Terms, &TermFacet{
+				Term:  term,
+				Count: count,
+			})
+		}
+		sort.Slice(result.Terms, func(i, j int) bool {
+			if result.Terms[i].Count != result.Terms[j].Count {
+				return result.Terms[i].Count > result.Terms[j].Count
+			}
+			return result.Terms[i].Term < result.Terms[j].Term
+		})
+		if len(result.Terms) > facet.Size {
+			result.Terms = result.Terms[:facet.Size]
+		}
+
+		result.Other = result.Total
+		for _, term := range result.Terms {
+			result.Other -= term.Count
+		}
+	}
+
+	return result, nil
+}
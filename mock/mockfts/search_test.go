@@ -0,0 +1,294 @@
+package mockfts
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
+
+func newTestSources(t *testing.T) (SourceResolver, *mockdb.Bucket) {
+	bucket, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:      &mocktime.Chrono{},
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	return func(bucketName, scope, collection string) (*Source, error) {
+		if bucketName != "default" || scope != "_default" || collection != "_default" {
+			return nil, ErrSourceNotFound
+		}
+		return &Source{Store: bucket}, nil
+	}, bucket
+}
+
+func insertTestDoc(t *testing.T, bucket *mockdb.Bucket, key, value string) {
+	_, err := bucket.Insert(&mockdb.Document{
+		VbID:  bucket.VbucketForKey([]byte(key)),
+		Key:   []byte(key),
+		Value: []byte(value),
+		Cas:   mockdb.GenerateNewCas(bucket.Chrono().Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+}
+
+func mustSearch(t *testing.T, e *Engine, sources SourceResolver, request string) *SearchResults {
+	var req SearchRequest
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		t.Fatalf("invalid search request: %v", err)
+	}
+
+	res, err := e.Search("hotels", &req, sources)
+	if err != nil {
+		t.Fatalf("failed to execute search %s: %v", request, err)
+	}
+	return res
+}
+
+func hitIDs(res *SearchResults) []string {
+	ids := []string{}
+	for _, hit := range res.Hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func assertHits(t *testing.T, res *SearchResults, expected ...string) {
+	if expected == nil {
+		expected = []string{}
+	}
+	if ids := hitIDs(res); !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("unexpected hits:\nexpected: %v\nactual:   %v", expected, ids)
+	}
+}
+
+func TestIndexManagement(t *testing.T) {
+	sources, _ := newTestSources(t)
+	e := NewEngine(NewEngineOptions{})
+
+	def, err := e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "hotels",
+		SourceName: "default",
+	}, sources)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if def.UUID == "" {
+		t.Fatalf("expected the index to be assigned a uuid")
+	}
+
+	_, err = e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "hotels",
+		SourceName: "default",
+	}, sources)
+	if !errors.Is(err, ErrIndexExists) {
+		t.Fatalf("expected an index exists error but got %v", err)
+	}
+
+	_, err = e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "hotels",
+		UUID:       "wrong",
+		SourceName: "default",
+	}, sources)
+	if !errors.Is(err, ErrUUIDMismatch) {
+		t.Fatalf("expected a uuid mismatch error but got %v", err)
+	}
+
+	_, err = e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "missing",
+		SourceName: "nope",
+	}, sources)
+	if !errors.Is(err, ErrInvalidIndex) {
+		t.Fatalf("expected an invalid index error but got %v", err)
+	}
+
+	updated, err := e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "hotels",
+		UUID:       def.UUID,
+		SourceName: "default",
+	}, sources)
+	if err != nil {
+		t.Fatalf("failed to update index: %v", err)
+	}
+	if updated.UUID == def.UUID {
+		t.Fatalf("expected updating the index to change its uuid")
+	}
+
+	if err := e.SetQueryAllowed("hotels", false); err != nil {
+		t.Fatalf("failed to disallow queries: %v", err)
+	}
+	_, err = e.Search("hotels", &SearchRequest{Query: json.RawMessage(`{"match_all":{}}`)}, sources)
+	if !errors.Is(err, ErrQueryDisallowed) {
+		t.Fatalf("expected a query disallowed error but got %v", err)
+	}
+	if err := e.SetPlanFrozen("hotels", true); err != nil {
+		t.Fatalf("failed to freeze plan: %v", err)
+	}
+
+	got, err := e.GetIndex("hotels")
+	if err != nil {
+		t.Fatalf("failed to get index: %v", err)
+	}
+	if got.PlanParams["planFrozen"] != true || got.PlanParams["nodePlanParams"] == nil {
+		t.Fatalf("expected plan params to reflect the index controls but got %v", got.PlanParams)
+	}
+
+	if len(e.GetAllIndexes()) != 1 {
+		t.Fatalf("expected a single index")
+	}
+	if _, err := e.DeleteIndex("hotels"); err != nil {
+		t.Fatalf("failed to delete index: %v", err)
+	}
+	if _, err := e.GetIndex("hotels"); !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("expected an index not found error but got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	sources, bucket := newTestSources(t)
+	e := NewEngine(NewEngineOptions{
+		Chrono: bucket.Chrono(),
+	})
+
+	_, err := e.UpsertIndex(&IndexDefinition{
+		Type:       IndexTypeFullText,
+		Name:       "hotels",
+		SourceName: "default",
+		Params: map[string]interface{}{
+			"mapping": map[string]interface{}{
+				"types": map[string]interface{}{
+					"airline": map[string]interface{}{
+						"enabled": false,
+					},
+				},
+			},
+		},
+	}, sources)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	insertTestDoc(t, bucket, "hotel-1",
+		`{"type":"hotel","name":"The Grand Hotel","city":"London","rating":5,"opened":"1990-01-01T00:00:00Z","pets":true}`)
+	insertTestDoc(t, bucket, "hotel-2",
+		`{"type":"hotel","name":"Budget Inn","city":"London","rating":2,"opened":"2010-06-01T00:00:00Z","pets":false}`)
+	insertTestDoc(t, bucket, "hotel-3",
+		`{"type":"hotel","name":"Grand Plaza","city":"New York","rating":4,"opened":"2005-03-01T00:00:00Z"}`)
+	insertTestDoc(t, bucket, "airline-1", `{"type":"airline","name":"Grand Airways"}`)
+
+	count, err := e.DocCount("hotels", sources)
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 documents but got %d (%v)", count, err)
+	}
+
+	res := mustSearch(t, e, sources, `{"query":{"match":"grand","field":"name"},"sort":["_id"]}`)
+	assertHits(t, res, "hotel-1", "hotel-3")
+	if res.TotalHits != 2 {
+		t.Fatalf("expected 2 total hits but got %d", res.TotalHits)
+	}
+
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"match":"grnd","fuzziness":1}}`), "hotel-1", "hotel-3")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"term":"london","field":"city"},"sort":["_id"]}`),
+		"hotel-1", "hotel-2")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"prefix":"bud"}}`), "hotel-2")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"wildcard":"pl?z*","field":"name"}}`), "hotel-3")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"match_phrase":"grand hotel"}}`), "hotel-1")
+	assertHits(t, mustSearch(t, e, sources,
+		`{"query":{"min":3,"max":5,"inclusive_max":true,"field":"rating"},"sort":["-rating"]}`),
+		"hotel-1", "hotel-3")
+	assertHits(t, mustSearch(t, e, sources,
+		`{"query":{"start":"2000-01-01T00:00:00Z","field":"opened"},"sort":["opened"]}`),
+		"hotel-3", "hotel-2")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"bool":true,"field":"pets"}}`), "hotel-1")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"conjuncts":[
+		{"match":"grand","field":"name"},
+		{"match":"london","field":"city"}
+	]}}`), "hotel-1")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{
+		"must":{"conjuncts":[{"match":"london","field":"city"}]},
+		"must_not":{"disjuncts":[{"match":"budget"}]}
+	}}`), "hotel-1")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"query":"+city:london -rating:2"}}`), "hotel-1")
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"match_all":{}},"sort":["_id"],"size":1,"from":1}`),
+		"hotel-2")
+
+	// Higher scoring documents come first.
+	res = mustSearch(t, e, sources, `{"query":{"disjuncts":[{"match":"grand"},{"match":"london"}]}}`)
+	assertHits(t, res, "hotel-1", "hotel-2", "hotel-3")
+	if res.MaxScore != res.Hits[0].Score || res.Hits[0].Score <= res.Hits[1].Score {
+		t.Fatalf("expected hits to be ordered by score")
+	}
+
+	res = mustSearch(t, e, sources, `{
+		"query":{"match":"grand","field":"name"},
+		"sort":["_id"],
+		"fields":["city"],
+		"includeLocations":true,
+		"highlight":{"style":"html","fields":["name"]}
+	}`)
+	hit := res.Hits[0]
+	if hit.Fields["city"] != "London" {
+		t.Fatalf("unexpected fields %v", hit.Fields)
+	}
+	if !reflect.DeepEqual(hit.Fragments["name"], []string{"The <mark>Grand</mark> Hotel"}) {
+		t.Fatalf("unexpected fragments %v", hit.Fragments)
+	}
+	expectedLocation := Location{Pos: 2, Start: 4, End: 9}
+	if locs := hit.Locations["name"]["grand"]; len(locs) != 1 || !reflect.DeepEqual(locs[0], expectedLocation) {
+		t.Fatalf("unexpected locations %v", hit.Locations)
+	}
+
+	res = mustSearch(t, e, sources, `{
+		"query":{"match_all":{}},
+		"size":0,
+		"facets":{
+			"cities":{"field":"city","size":1},
+			"ratings":{"field":"rating","size":2,"numeric_ranges":[{"name":"low","max":3},{"name":"high","min":3}]},
+			"opened":{"field":"opened","size":1,"date_ranges":[{"name":"old","end":"2000-01-01T00:00:00Z"}]}
+		}
+	}`)
+	if len(res.Hits) != 0 || res.TotalHits != 3 {
+		t.Fatalf("expected no hits but 3 total hits")
+	}
+	cities := res.Facets["cities"]
+	if cities.Total != 4 || cities.Other != 2 || len(cities.Terms) != 1 || *cities.Terms[0] != (TermFacet{"london", 2}) {
+		t.Fatalf("unexpected term facet %+v", cities)
+	}
+	ratings := res.Facets["ratings"]
+	if ratings.NumericRanges[0].Count != 1 || ratings.NumericRanges[1].Count != 2 {
+		t.Fatalf("unexpected numeric range facet %+v", ratings)
+	}
+	if opened := res.Facets["opened"]; opened.DateRanges[0].Count != 1 || opened.Other != 2 {
+		t.Fatalf("unexpected date range facet %+v", opened)
+	}
+
+	// Mutations made while ingestion is paused are not visible.
+	if err := e.SetIngestPaused("hotels", true); err != nil {
+		t.Fatalf("failed to pause ingestion: %v", err)
+	}
+	bucket.Chrono().TimeTravel(time.Second)
+	insertTestDoc(t, bucket, "hotel-4", `{"type":"hotel","name":"Grand Budapest"}`)
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"match":"budapest"}}`))
+	if err := e.SetIngestPaused("hotels", false); err != nil {
+		t.Fatalf("failed to resume ingestion: %v", err)
+	}
+	assertHits(t, mustSearch(t, e, sources, `{"query":{"match":"budapest"}}`), "hotel-4")
+
+	_, err = e.Search("hotels", &SearchRequest{Query: json.RawMessage(`{"bogus":1}`)}, sources)
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected an invalid query error but got %v", err)
+	}
+}
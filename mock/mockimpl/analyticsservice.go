@@ -38,7 +38,7 @@ func newAnalyticsService(parent *clusterNodeInst, opts newAnalyticsServiceOption
 			Handlers: servers.HTTPServerHandlers{
 				NewRequestHandler: svc.handleNewRequest,
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig: parent.tlsConfig,
 		})
 		if err != nil {
 			return nil, err
@@ -56,7 +56,7 @@ func (s *analyticsService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *analyticsService) Hostname() string {
-	return "127.0.0.1"
+	return s.clusterNode.Hostname()
 }
 
 // ListenPort returns the port this service is listening on.
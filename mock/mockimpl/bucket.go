@@ -1,7 +1,9 @@
 package mockimpl
 
 import (
+	"fmt"
 	"log"
+	"sync"
 
 	"github.com/couchbaselabs/gocaves/mock/mockmr"
 
@@ -24,6 +26,8 @@ type bucketInst struct {
 	ramQuota            uint64
 	replicaIndexEnabled bool
 	compressionMode     mock.CompressionMode
+	durabilityMinLevel  mock.DurabilityLevel
+	maxTTL              uint32
 
 	// vbMap is an array for each vbucket, containing an array for
 	// each replica, containing the UUID of the node responsible.
@@ -32,6 +36,14 @@ type bucketInst struct {
 	// directly so we can avoid needing to have a cyclical dependancy.
 	vbMap [][]string
 
+	// vbMapForward is the vbmap which a running rebalance is moving towards,
+	// and is nil when no rebalance is running.
+	vbMapForward [][]string
+
+	// lock protects the vbmaps and config revision, as rebalances modify them
+	// in the background.
+	lock sync.Mutex
+
 	collManifest *mock.CollectionManifest
 
 	viewEngine *mockmr.Engine
@@ -45,6 +57,16 @@ func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, er
 		replicas = 0 // This should already be set to 0 by the caller but let's force it.
 	}
 
+	compressionMode := opts.CompressionMode
+	if compressionMode == "" {
+		compressionMode = mock.CompressionModePassive
+	}
+
+	durabilityMinLevel := opts.DurabilityMinLevel
+	if durabilityMinLevel == "" {
+		durabilityMinLevel = mock.DurabilityLevelNone
+	}
+
 	// We currently always use a single replica here.  We use this 1 replica for all
 	// replicas that are needed, and it is potentially unused if the buckets replica
 	// count is 0.
@@ -68,11 +90,13 @@ func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, er
 		numVbuckets:         vbuckets,
 		store:               bucketStore,
 		collManifest:        mock.NewCollectionManifest(),
-		viewEngine:          mockmr.NewEngine(),
+		viewEngine:          mockmr.NewEngine(mockmr.NewEngineOptions{Store: bucketStore}),
 		replicaIndexEnabled: opts.ReplicaIndexEnabled,
 		flushEnabled:        opts.FlushEnabled,
 		ramQuota:            opts.RamQuota,
-		compressionMode:     opts.CompressionMode,
+		compressionMode:     compressionMode,
+		durabilityMinLevel:  durabilityMinLevel,
+		maxTTL:              opts.MaxTTL,
 	}
 
 	// Initially set up the vbucket map with nothing in it.
@@ -83,37 +107,45 @@ func newBucket(parent *clusterInst, opts mock.NewBucketOptions) (*bucketInst, er
 }
 
 // ID returns the uuid of this bucket.
-func (b bucketInst) ID() string {
+func (b *bucketInst) ID() string {
 	return b.id
 }
 
 // Name returns the name of this bucket
-func (b bucketInst) Name() string {
+func (b *bucketInst) Name() string {
 	return b.name
 }
 
 // BucketType returns the type of bucket this is.
-func (b bucketInst) BucketType() mock.BucketType {
+func (b *bucketInst) BucketType() mock.BucketType {
 	return b.bucketType
 }
 
+// Cluster returns the Cluster this bucket is part of.
+func (b *bucketInst) Cluster() mock.Cluster {
+	return b.cluster
+}
+
 // NumReplicas returns the number of configured replicas for this bucket
-func (b bucketInst) NumReplicas() uint {
+func (b *bucketInst) NumReplicas() uint {
 	return b.numReplicas
 }
 
 // ConfigRev returns the current configuration revision for this bucket.
-func (b bucketInst) ConfigRev() uint {
+func (b *bucketInst) ConfigRev() uint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	return b.configRev
 }
 
 // CollectionManifest returns the collection manifest of this bucket.
-func (b bucketInst) CollectionManifest() *mock.CollectionManifest {
+func (b *bucketInst) CollectionManifest() *mock.CollectionManifest {
 	return b.collManifest
 }
 
 // Store returns the data-store for this bucket.
-func (b bucketInst) Store() *mockdb.Bucket {
+func (b *bucketInst) Store() *mockdb.Bucket {
 	return b.store
 }
 
@@ -121,18 +153,15 @@ func (b bucketInst) Store() *mockdb.Bucket {
 // specific nodes which are passed in.  Note that this rebalance is guarenteed to
 // be very explicit such that vbNode = (vbId % numNode), and replicas are just ++.
 func (b *bucketInst) UpdateVbMap(nodeList []string) {
+	b.setVbMap(b.genVbMap(nodeList))
+}
+
+// genVbMap generates the vbmap which UpdateVbMap would assign for a list of nodes.
+func (b *bucketInst) genVbMap(nodeList []string) [][]string {
 	numVbuckets := b.numVbuckets
 	numDataCopies := b.numReplicas + 1
 
-	// Setup the new vb map
 	newVbMap := make([][]string, numVbuckets)
-	for vbIdx := range newVbMap {
-		newVbMap[vbIdx] = make([]string, numDataCopies)
-		for repIdx := range newVbMap[vbIdx] {
-			newVbMap[vbIdx][repIdx] = ""
-		}
-	}
-
 	for vbIdx := range newVbMap {
 		newVbMap[vbIdx] = make([]string, numDataCopies)
 		for repIdx := range newVbMap[vbIdx] {
@@ -145,27 +174,193 @@ func (b *bucketInst) UpdateVbMap(nodeList []string) {
 		}
 	}
 
+	return newVbMap
+}
+
+// setVbMap replaces the vbmap of this bucket.
+func (b *bucketInst) setVbMap(newVbMap [][]string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.setVbMapLocked(newVbMap)
+}
+
+func (b *bucketInst) setVbMapLocked(newVbMap [][]string) {
+	// Any vbucket which is changing its active node needs to have its pending
+	// durable writes re-committed by the new active.
+	for vbIdx := range newVbMap {
+		if vbIdx < len(b.vbMap) && b.vbMap[vbIdx][0] != newVbMap[vbIdx][0] {
+			b.store.GetVbucket(uint(vbIdx)).RecommitSyncWrites()
+		}
+	}
+
 	b.vbMap = newVbMap
 
-	b.updateConfig()
+	b.configRev++
+}
+
+// startRebalance publishes the vbmap which a rebalance is moving the bucket
+// towards, returning the number of vbuckets which need to be moved.
+func (b *bucketInst) startRebalance(forwardVbMap [][]string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	numMoves := 0
+	for vbIdx, vb := range forwardVbMap {
+		if !vbEqual(b.vbMap[vbIdx], vb) {
+			numMoves++
+		}
+	}
+	if numMoves == 0 {
+		return 0
+	}
+
+	b.vbMapForward = forwardVbMap
+	b.configRev++
+
+	return numMoves
+}
+
+// stepRebalance moves up to maxMoves vbuckets to the nodes they are assigned to
+// in the forward vbmap, returning the number of vbuckets which were moved.  The
+// forward vbmap is removed once every vbucket has been moved.
+func (b *bucketInst) stepRebalance(maxMoves int) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.vbMapForward == nil {
+		return 0
+	}
+
+	newVbMap := make([][]string, len(b.vbMap))
+	copy(newVbMap, b.vbMap)
+
+	numMoves := 0
+	isDone := true
+	for vbIdx, vb := range b.vbMapForward {
+		if vbEqual(newVbMap[vbIdx], vb) {
+			continue
+		}
+		if numMoves >= maxMoves {
+			isDone = false
+			break
+		}
+
+		newVbMap[vbIdx] = vb
+		numMoves++
+	}
+
+	if isDone {
+		b.vbMapForward = nil
+	}
+	b.setVbMapLocked(newVbMap)
+
+	return numMoves
 }
 
-func (b *bucketInst) updateConfig() {
+// stopRebalance abandons a running rebalance, leaving any vbuckets which
+// have not yet been moved where they are.
+func (b *bucketInst) stopRebalance() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.vbMapForward == nil {
+		return
+	}
+
+	b.vbMapForward = nil
 	b.configRev++
 }
 
-// GetVbServerInfo returns the vb nodes, then the vb map, then the ordered list of all nodes
-func (b *bucketInst) GetVbServerInfo(reqNode mock.ClusterNode) ([]mock.ClusterNode, [][]int, []mock.ClusterNode) {
-	allNodes := b.cluster.nodes
+func vbEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkGracefulFailover verifies that every vbucket which a node is active for
+// has a replica which can take over from it.
+func (b *bucketInst) checkGracefulFailover(nodeID string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for vbIdx, vb := range b.vbMap {
+		if vb[0] != nodeID {
+			continue
+		}
+
+		hasReplica := false
+		for _, replicaID := range vb[1:] {
+			if replicaID != "" {
+				hasReplica = true
+				break
+			}
+		}
+		if !hasReplica {
+			return fmt.Errorf("bucket %s has no replica for vbucket %d", b.name, vbIdx)
+		}
+	}
+
+	return nil
+}
+
+// failoverNode removes a node from the vbmap, promoting the replicas of any
+// vbucket it held to take its place.  Vbuckets without a replica are left
+// without an active node.
+func (b *bucketInst) failoverNode(nodeID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	newVbMap := make([][]string, len(b.vbMap))
+	for vbIdx, vb := range b.vbMap {
+		newVb := make([]string, 0, len(vb))
+		for _, vbNodeID := range vb {
+			if vbNodeID != nodeID && vbNodeID != "" {
+				newVb = append(newVb, vbNodeID)
+			}
+		}
+		for len(newVb) < len(vb) {
+			newVb = append(newVb, "")
+		}
+		newVbMap[vbIdx] = newVb
+	}
+
+	b.setVbMapLocked(newVbMap)
+}
+
+// GetVbServerInfo returns the vb nodes, then the vb map, then the forward vb map
+// (which is nil unless a rebalance is running), then the ordered list of all nodes
+func (b *bucketInst) GetVbServerInfo(reqNode mock.ClusterNode) ([]mock.ClusterNode, [][]int, [][]int, []mock.ClusterNode) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Nodes which have been failed over are no longer part of bucket configs.
+	allNodes := b.cluster.activeNodes()
 
 	var nodeList uniqueClusterNodeList
 
-	idxdVbMap := make([][]int, len(b.vbMap))
-	for vbIdx, repMap := range b.vbMap {
-		idxdVbMap[vbIdx] = make([]int, len(repMap))
-		for repIdx, nodeID := range repMap {
-			idxdVbMap[vbIdx][repIdx] = nodeList.GetByID(allNodes, nodeID)
+	indexVbMap := func(vbMap [][]string) [][]int {
+		idxdVbMap := make([][]int, len(vbMap))
+		for vbIdx, repMap := range vbMap {
+			idxdVbMap[vbIdx] = make([]int, len(repMap))
+			for repIdx, nodeID := range repMap {
+				idxdVbMap[vbIdx][repIdx] = nodeList.GetByID(allNodes, nodeID)
+			}
 		}
+		return idxdVbMap
+	}
+
+	idxdVbMap := indexVbMap(b.vbMap)
+
+	var idxdFwdVbMap [][]int
+	if b.vbMapForward != nil {
+		idxdFwdVbMap = indexVbMap(b.vbMapForward)
 	}
 
 	// Grab the KV server list before we add the remaining nodes.
@@ -176,7 +371,7 @@ func (b *bucketInst) GetVbServerInfo(reqNode mock.ClusterNode) ([]mock.ClusterNo
 		nodeList.GetByID(allNodes, node.ID())
 	}
 
-	return kvNodes, idxdVbMap, nodeList
+	return kvNodes, idxdVbMap, idxdFwdVbMap, nodeList
 }
 
 func (b *bucketInst) VbucketOwnership(node mock.ClusterNode) []int {
@@ -184,6 +379,9 @@ func (b *bucketInst) VbucketOwnership(node mock.ClusterNode) []int {
 		return []int{0}
 	}
 
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
 	getRepIdx := func(vb []string) int {
 		for repIdx, nodeID := range vb {
 			if nodeID == node.ID() {
@@ -224,12 +422,32 @@ func (b *bucketInst) CompressionMode() mock.CompressionMode {
 	return b.compressionMode
 }
 
+func (b *bucketInst) DurabilityMinLevel() mock.DurabilityLevel {
+	return b.durabilityMinLevel
+}
+
+func (b *bucketInst) MaxTTL() uint32 {
+	return b.maxTTL
+}
+
 func (b *bucketInst) Update(opts mock.UpdateBucketOptions) error {
 	b.ramQuota = opts.RamQuota
 	b.flushEnabled = opts.FlushEnabled
 	b.replicaIndexEnabled = opts.ReplicaIndexEnabled
 	b.numReplicas = opts.NumReplicas
 
+	// The server leaves the compression mode, minimum durability level and
+	// maximum TTL alone if they aren't specified.
+	if opts.CompressionMode != "" {
+		b.compressionMode = opts.CompressionMode
+	}
+	if opts.DurabilityMinLevel != "" {
+		b.durabilityMinLevel = opts.DurabilityMinLevel
+	}
+	if opts.MaxTTL != nil {
+		b.maxTTL = *opts.MaxTTL
+	}
+
 	// TODO: When the store actually does something with num replicas we should probably update it here.
 
 	return nil
@@ -0,0 +1,466 @@
+package mockimpl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockcert"
+)
+
+func testNewFailoverCluster(t *testing.T, numReplicas uint) (mock.Cluster, mock.Bucket) {
+	cluster, err := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 16,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cluster.AddNode(mock.NewNodeOptions{}); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+
+	bucket, err := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: numReplicas,
+	})
+	if err != nil {
+		t.Fatalf("failed to add bucket: %v", err)
+	}
+
+	return cluster, bucket
+}
+
+func testVbMap(bucket mock.Bucket) [][]string {
+	_, vbMap, _, allNodes := bucket.GetVbServerInfo(nil)
+
+	out := make([][]string, len(vbMap))
+	for vbIdx, vb := range vbMap {
+		for _, nodeIdx := range vb {
+			if nodeIdx < 0 {
+				out[vbIdx] = append(out[vbIdx], "")
+			} else {
+				out[vbIdx] = append(out[vbIdx], allNodes[nodeIdx].ID())
+			}
+		}
+	}
+	return out
+}
+
+func testIsDropping(t *testing.T, port int) bool {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return true
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+func TestHardFailover(t *testing.T) {
+	cluster, bucket := testNewFailoverCluster(t, 1)
+	failedNode := cluster.Nodes()[1]
+	kvPort := failedNode.KvService().ListenPort()
+
+	origVbMap := testVbMap(bucket)
+	origClusterRev := cluster.ConfigRev()
+	origBucketRev := bucket.ConfigRev()
+
+	if testIsDropping(t, kvPort) {
+		t.Fatalf("expected kv service to accept connections before failover")
+	}
+
+	if err := cluster.HardFailover(failedNode); err != nil {
+		t.Fatalf("failed to fail over node: %v", err)
+	}
+
+	if cluster.ConfigRev() <= origClusterRev || bucket.ConfigRev() <= origBucketRev {
+		t.Fatalf("expected config revisions to increase after failover")
+	}
+	if failedNode.Membership() != mock.NodeMembershipInactiveFailed {
+		t.Fatalf("expected node to be marked as failed")
+	}
+
+	failedVbMap := testVbMap(bucket)
+	for vbIdx, vb := range failedVbMap {
+		for _, nodeID := range vb {
+			if nodeID == failedNode.ID() {
+				t.Fatalf("failed node still present in vbucket %d", vbIdx)
+			}
+		}
+		if origVbMap[vbIdx][0] == failedNode.ID() && vb[0] != origVbMap[vbIdx][1] {
+			t.Fatalf("expected replica to be promoted for vbucket %d", vbIdx)
+		}
+	}
+
+	if !testIsDropping(t, kvPort) {
+		t.Fatalf("expected kv service to drop connections after failover")
+	}
+
+	if err := cluster.HardFailover(failedNode); err == nil {
+		t.Fatalf("expected failing over a failed node to fail")
+	}
+
+	if err := cluster.RecoverNode(failedNode, mock.RecoveryTypeDelta); err != nil {
+		t.Fatalf("failed to recover node: %v", err)
+	}
+
+	if !reflect.DeepEqual(testVbMap(bucket), origVbMap) {
+		t.Fatalf("expected delta recovery to restore the original vbmap")
+	}
+	if testIsDropping(t, kvPort) {
+		t.Fatalf("expected kv service to accept connections after recovery")
+	}
+}
+
+func TestGracefulFailover(t *testing.T) {
+	cluster, bucket := testNewFailoverCluster(t, 0)
+	failedNode := cluster.Nodes()[1]
+
+	if err := cluster.GracefulFailover(failedNode); err == nil {
+		t.Fatalf("expected graceful failover without replicas to fail")
+	}
+
+	if err := cluster.DeleteBucket(bucket.Name()); err != nil {
+		t.Fatalf("failed to delete bucket: %v", err)
+	}
+	bucket, err := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "replicated",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to add bucket: %v", err)
+	}
+
+	origVbMap := testVbMap(bucket)
+
+	if err := cluster.GracefulFailover(failedNode); err != nil {
+		t.Fatalf("failed to gracefully fail over node: %v", err)
+	}
+
+	if testIsDropping(t, failedNode.KvService().ListenPort()) {
+		t.Fatalf("expected gracefully failed over node to keep running")
+	}
+	for _, ownership := range bucket.VbucketOwnership(failedNode) {
+		if ownership != -1 {
+			t.Fatalf("expected gracefully failed over node to own no vbuckets")
+		}
+	}
+
+	if err := cluster.RecoverNode(failedNode, mock.RecoveryTypeFull); err != nil {
+		t.Fatalf("failed to recover node: %v", err)
+	}
+
+	if reflect.DeepEqual(testVbMap(bucket), origVbMap) {
+		t.Fatalf("expected full recovery to move the node to a new position")
+	}
+	if cluster.Nodes()[2].ID() != failedNode.ID() {
+		t.Fatalf("expected fully recovered node to be added at the end of the cluster")
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	cluster, bucket := testNewFailoverCluster(t, 1)
+	removedNode := cluster.Nodes()[0]
+
+	if err := cluster.RemoveNode(removedNode); err != nil {
+		t.Fatalf("failed to remove node: %v", err)
+	}
+
+	if len(cluster.Nodes()) != 2 {
+		t.Fatalf("expected two nodes to remain")
+	}
+	for _, ownership := range bucket.VbucketOwnership(removedNode) {
+		if ownership != -1 {
+			t.Fatalf("expected removed node to own no vbuckets")
+		}
+	}
+
+	if err := cluster.RemoveNode(cluster.Nodes()[0]); err != nil {
+		t.Fatalf("failed to remove node: %v", err)
+	}
+	if err := cluster.RemoveNode(cluster.Nodes()[0]); err == nil {
+		t.Fatalf("expected removing the last node to fail")
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	cluster, bucket := testNewFailoverCluster(t, 1)
+
+	newNode, err := cluster.AddNode(mock.NewNodeOptions{})
+	if err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+
+	origVbMap := testVbMap(bucket)
+
+	err = cluster.Rebalance(mock.RebalanceOptions{
+		BatchSize:     4,
+		BatchInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to start rebalance: %v", err)
+	}
+
+	if isRunning, progress := cluster.RebalanceProgress(); !isRunning || progress != 0 {
+		t.Fatalf("expected rebalance to be running with no progress")
+	}
+	if !reflect.DeepEqual(testVbMap(bucket), origVbMap) {
+		t.Fatalf("expected no vbuckets to move before the first step")
+	}
+	if _, _, fwdVbMap, _ := bucket.GetVbServerInfo(nil); fwdVbMap == nil {
+		t.Fatalf("expected a forward vbmap while rebalancing")
+	}
+	if err := cluster.Rebalance(mock.RebalanceOptions{}); err == nil {
+		t.Fatalf("expected starting a second rebalance to fail")
+	}
+
+	lastProgress := 0.0
+	for numSteps := 0; ; numSteps++ {
+		if numSteps > 16 {
+			t.Fatalf("rebalance did not complete")
+		}
+
+		lastVbMap := testVbMap(bucket)
+		lastBucketRev := bucket.ConfigRev()
+
+		cluster.Chrono().TimeTravel(time.Hour)
+
+		// Timers fire on their own goroutines, so the step may not have been
+		// applied by the time we return from time travelling.
+		deadline := time.Now().Add(5 * time.Second)
+		for bucket.ConfigRev() <= lastBucketRev {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected bucket config revision to increase after each step")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		numMoved := 0
+		for vbIdx, vb := range testVbMap(bucket) {
+			if !reflect.DeepEqual(vb, lastVbMap[vbIdx]) {
+				numMoved++
+			}
+		}
+		if numMoved == 0 || numMoved > 4 {
+			t.Fatalf("expected each step to move between 1 and 4 vbuckets, moved %d", numMoved)
+		}
+
+		isRunning, progress := cluster.RebalanceProgress()
+		if !isRunning {
+			break
+		}
+		if progress <= lastProgress {
+			t.Fatalf("expected rebalance progress to increase after each step")
+		}
+		lastProgress = progress
+	}
+
+	if _, _, fwdVbMap, _ := bucket.GetVbServerInfo(nil); fwdVbMap != nil {
+		t.Fatalf("expected no forward vbmap once the rebalance completed")
+	}
+
+	ownsVbuckets := false
+	for _, ownership := range bucket.VbucketOwnership(newNode) {
+		if ownership == 0 {
+			ownsVbuckets = true
+		}
+	}
+	if !ownsVbuckets {
+		t.Fatalf("expected new node to be active for vbuckets after rebalance")
+	}
+}
+
+func testDialTLS(t *testing.T, port int, caPem []byte, serverName string) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPem) {
+		t.Fatalf("failed to parse ca certificate")
+	}
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestCertificates(t *testing.T) {
+	cluster, _ := testNewFailoverCluster(t, 0)
+	origCaPem := cluster.CACertificate()
+
+	for _, node := range cluster.Nodes() {
+		for _, serverName := range []string{"localhost", "127.0.0.1"} {
+			if err := testDialTLS(t, node.KvService().ListenPortTLS(), origCaPem, serverName); err != nil {
+				t.Fatalf("failed to verify kv certificate for %s: %v", serverName, err)
+			}
+			if err := testDialTLS(t, node.MgmtService().ListenPortTLS(), origCaPem, serverName); err != nil {
+				t.Fatalf("failed to verify mgmt certificate for %s: %v", serverName, err)
+			}
+		}
+		if err := testDialTLS(t, node.KvService().ListenPortTLS(), origCaPem, "example.com"); err == nil {
+			t.Fatalf("expected certificate to be invalid for an unknown hostname")
+		}
+	}
+
+	if err := cluster.RotateCertificates(); err != nil {
+		t.Fatalf("failed to rotate certificates: %v", err)
+	}
+
+	newCaPem := cluster.CACertificate()
+	if reflect.DeepEqual(newCaPem, origCaPem) {
+		t.Fatalf("expected ca certificate to change after rotation")
+	}
+
+	kvPort := cluster.Nodes()[0].KvService().ListenPortTLS()
+	if err := testDialTLS(t, kvPort, origCaPem, "localhost"); err == nil {
+		t.Fatalf("expected old ca to be rejected after rotation")
+	}
+	if err := testDialTLS(t, kvPort, newCaPem, "localhost"); err != nil {
+		t.Fatalf("failed to verify certificate after rotation: %v", err)
+	}
+}
+
+func testClientCertTLSConfig(t *testing.T, cluster mock.Cluster, cert *tls.Certificate) *tls.Config {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cluster.CACertificate()) {
+		t.Fatalf("failed to parse ca certificate")
+	}
+
+	config := &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config
+}
+
+func testClientCertHTTPStatus(t *testing.T, cluster mock.Cluster, cert *tls.Certificate) (int, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   testClientCertTLSConfig(t, cluster, cert),
+			DisableKeepAlives: true,
+		},
+	}
+
+	port := cluster.Nodes()[0].MgmtService().ListenPortTLS()
+	resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/pools/default/certificate", port))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+func TestClientCertAuth(t *testing.T) {
+	cluster, bucket := testNewFailoverCluster(t, 0)
+
+	err := cluster.Users().UpsertUser(mockauth.UpsertUserOptions{
+		Username: "certuser",
+		Roles:    []string{"admin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	userCert, err := cluster.IssueClientCertificate(mockcert.IssueClientCertificateOptions{
+		CommonName:     "certuser",
+		EmailAddresses: []string{"certuser@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("failed to issue client certificate: %v", err)
+	}
+	otherCert, err := cluster.IssueClientCertificate(mockcert.IssueClientCertificateOptions{
+		CommonName: "certuser",
+	})
+	if err != nil {
+		t.Fatalf("failed to issue client certificate: %v", err)
+	}
+
+	if status, err := testClientCertHTTPStatus(t, cluster, userCert); err != nil || status != 401 {
+		t.Fatalf("expected client certificate to be ignored while disabled (%d, %v)", status, err)
+	}
+
+	err = cluster.Users().SetClientCertAuth(mockauth.ClientCertAuthSettings{
+		State: mockauth.ClientCertAuthStateEnable,
+		Prefixes: []mockauth.ClientCertPrefix{
+			{Path: mockauth.ClientCertPathSanEmail, Delimiter: "@"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to enable client certificate auth: %v", err)
+	}
+
+	if status, err := testClientCertHTTPStatus(t, cluster, userCert); err != nil || status != 200 {
+		t.Fatalf("expected client certificate to authenticate (%d, %v)", status, err)
+	}
+	if status, err := testClientCertHTTPStatus(t, cluster, nil); err != nil || status != 401 {
+		t.Fatalf("expected connecting without a certificate to be allowed (%d, %v)", status, err)
+	}
+	if _, err := testClientCertHTTPStatus(t, cluster, otherCert); err == nil {
+		t.Fatalf("expected a certificate which does not identify a user to be rejected")
+	}
+
+	kvPort := cluster.Nodes()[0].KvService().ListenPortTLS()
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", kvPort), testClientCertTLSConfig(t, cluster, userCert))
+	if err != nil {
+		t.Fatalf("failed to connect to kv: %v", err)
+	}
+	defer conn.Close()
+
+	mconn := memd.NewConn(conn)
+	err = mconn.WritePacket(&memd.Packet{
+		Magic:   memd.CmdMagicReq,
+		Command: memd.CmdSelectBucket,
+		Key:     []byte(bucket.Name()),
+	})
+	if err != nil {
+		t.Fatalf("failed to write select bucket: %v", err)
+	}
+	resp, _, err := mconn.ReadPacket()
+	if err != nil {
+		t.Fatalf("failed to read select bucket response: %v", err)
+	}
+	if resp.Status != memd.StatusSuccess {
+		t.Fatalf("expected select bucket to succeed without sasl, got %v", resp.Status)
+	}
+
+	err = cluster.Users().SetClientCertAuth(mockauth.ClientCertAuthSettings{
+		State: mockauth.ClientCertAuthStateMandatory,
+		Prefixes: []mockauth.ClientCertPrefix{
+			{Path: mockauth.ClientCertPathSubjectCN},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to require client certificate auth: %v", err)
+	}
+
+	if _, err := testClientCertHTTPStatus(t, cluster, nil); err == nil {
+		t.Fatalf("expected connecting without a certificate to fail")
+	}
+	if status, err := testClientCertHTTPStatus(t, cluster, otherCert); err != nil || status != 200 {
+		t.Fatalf("expected client certificate to authenticate (%d, %v)", status, err)
+	}
+}
@@ -1,9 +1,16 @@
 package mockimpl
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"log"
+	"sync"
 
 	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockcert"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/servers"
 	"github.com/google/uuid"
 )
 
@@ -14,6 +21,12 @@ type clusterNodeInst struct {
 	id              string
 	errMap          *mock.ErrorMap
 	hostname        string
+	altAddrs        map[string]mock.AlternateAddress
+	membership      mock.NodeMembership
+
+	certLock  sync.Mutex
+	cert      *tls.Certificate
+	tlsConfig *tls.Config
 
 	kvService        *kvService
 	mgmtService      *mgmtService
@@ -21,6 +34,7 @@ type clusterNodeInst struct {
 	queryService     *queryService
 	searchService    *searchService
 	analyticsService *analyticsService
+	eventingService  *eventingService
 }
 
 func validateFeatures([]mock.ClusterNodeFeature) error {
@@ -37,13 +51,34 @@ func newClusterNode(parent *clusterInst, opts mock.NewNodeOptions) (*clusterNode
 		return nil, err
 	}
 
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = "127.0.0.1"
+	}
+
 	node := &clusterNodeInst{
 		id:              uuid.New().String(),
 		enabledFeatures: opts.Features,
 		cluster:         parent,
-		hostname:        "127.0.0.1",
+		hostname:        hostname,
+		altAddrs:        opts.AlternateAddresses,
+		membership:      mock.NodeMembershipActive,
+	}
+
+	// The configuration is looked up for each new connection so that the
+	// certificates and client certificate settings can be changed without
+	// needing to restart any of the services.
+	node.tlsConfig = &tls.Config{
+		GetConfigForClient: node.getTLSConfig,
 	}
 
+	cert, err := node.genCertificate(parent.getCertAuthority())
+	if err != nil {
+		log.Printf("cluster node failed to generate certificate: %s", err)
+		return nil, err
+	}
+	node.setCertificate(cert)
+
 	node.errMap, err = mock.NewErrorMap()
 	if err != nil {
 		log.Printf("cluster node failed to load error map: %s", err)
@@ -117,10 +152,95 @@ func newClusterNode(parent *clusterInst, opts mock.NewNodeOptions) (*clusterNode
 		node.analyticsService = analyticsService
 	}
 
+	if serviceTypeListContains(opts.Services, mock.ServiceTypeEventing) {
+		eventingService, err := newEventingService(node, newEventingServiceOptions{})
+		if err != nil {
+			log.Printf("cluster node failed to start eventing service: %s", err)
+			node.cleanup()
+			return nil, err
+		}
+
+		node.eventingService = eventingService
+	}
+
 	log.Printf("new cluster node created")
 	return node, nil
 }
 
+// genCertificate issues a new certificate for this node from a certificate authority.
+func (n *clusterNodeInst) genCertificate(ca *mockcert.CertAuthority) (*tls.Certificate, error) {
+	// Nodes always listen locally, so they can be reached at any of the
+	// loopback names as well as their own and their alternate hostnames.
+	hostnames := []string{n.hostname}
+	addHostname := func(hostname string) {
+		for _, existing := range hostnames {
+			if existing == hostname {
+				return
+			}
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	for _, altAddr := range n.altAddrs {
+		if altAddr.Hostname != "" {
+			addHostname(altAddr.Hostname)
+		}
+	}
+	for _, hostname := range []string{"localhost", "127.0.0.1", "::1"} {
+		addHostname(hostname)
+	}
+
+	return ca.IssueServerCertificate(mockcert.IssueServerCertificateOptions{
+		CommonName: n.hostname,
+		Hostnames:  hostnames,
+	})
+}
+
+func (n *clusterNodeInst) setCertificate(cert *tls.Certificate) {
+	n.certLock.Lock()
+	n.cert = cert
+	n.certLock.Unlock()
+}
+
+func (n *clusterNodeInst) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	n.certLock.Lock()
+	defer n.certLock.Unlock()
+
+	return n.cert, nil
+}
+
+func (n *clusterNodeInst) getTLSConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: n.getCertificate,
+	}
+
+	certAuth := n.cluster.auth.ClientCertAuth()
+	switch certAuth.State {
+	case mockauth.ClientCertAuthStateEnable:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case mockauth.ClientCertAuthStateMandatory:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return config, nil
+	}
+
+	config.ClientCAs = n.cluster.getCertAuthority().CertPool()
+
+	// Much like the real server, we reject any certificate which we are unable
+	// to map to a user rather than falling back to other forms of auth.
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 {
+			return nil
+		}
+
+		if _, ok := certAuth.UsernameForCertificate(verifiedChains[0][0]); !ok {
+			return errors.New("client certificate does not identify a user")
+		}
+		return nil
+	}
+
+	return config, nil
+}
+
 // HasFeature will indicate whether this cluster node has a specific feature enabled.
 func (n *clusterNodeInst) HasFeature(feature mock.ClusterNodeFeature) bool {
 	return clusterFeatureListContains(n.enabledFeatures, feature)
@@ -184,18 +304,93 @@ func (n *clusterNodeInst) AnalyticsService() mock.AnalyticsService {
 	return n.analyticsService
 }
 
+// EventingService returns the eventing service for this node.
+func (n *clusterNodeInst) EventingService() mock.EventingService {
+	if n.eventingService == nil {
+		return nil
+	}
+	return n.eventingService
+}
+
+// Membership returns the membership state of this node within its cluster.
+func (n *clusterNodeInst) Membership() mock.NodeMembership {
+	return n.membership
+}
+
 // ErrorMap returns the error map for this node.
 func (n *clusterNodeInst) ErrorMap() *mock.ErrorMap {
 	return n.errMap
 }
 
+// Hostname returns the address for this node.
 func (n *clusterNodeInst) Hostname() string {
 	return n.hostname
 }
 
+// AlternateAddresses returns the addresses which this node can be reached
+// at on other networks, keyed by the name of the network.
+func (n *clusterNodeInst) AlternateAddresses() map[string]mock.AlternateAddress {
+	return n.altAddrs
+}
+
+// httpServers returns all of the http servers which this node is running.
+func (n *clusterNodeInst) httpServers() []*servers.HTTPServer {
+	var srvs []*servers.HTTPServer
+	addServers := func(server, tlsServer *servers.HTTPServer) {
+		if server != nil {
+			srvs = append(srvs, server)
+		}
+		if tlsServer != nil {
+			srvs = append(srvs, tlsServer)
+		}
+	}
+
+	if n.mgmtService != nil {
+		addServers(n.mgmtService.server, n.mgmtService.tlsServer)
+	}
+	if n.viewService != nil {
+		addServers(n.viewService.server, n.viewService.tlsServer)
+	}
+	if n.queryService != nil {
+		addServers(n.queryService.server, n.queryService.tlsServer)
+	}
+	if n.searchService != nil {
+		addServers(n.searchService.server, n.searchService.tlsServer)
+	}
+	if n.analyticsService != nil {
+		addServers(n.analyticsService.server, n.analyticsService.tlsServer)
+	}
+	if n.eventingService != nil {
+		addServers(n.eventingService.server, n.eventingService.tlsServer)
+	}
+
+	return srvs
+}
+
+// setOffline makes all of the services on this node start or stop dropping
+// their connections, simulating the node having gone down.
+func (n *clusterNodeInst) setOffline(offline bool) {
+	if n.kvService != nil {
+		if n.kvService.server != nil {
+			n.kvService.server.SetOffline(offline)
+		}
+		if n.kvService.tlsServer != nil {
+			n.kvService.tlsServer.SetOffline(offline)
+		}
+	}
+
+	for _, srv := range n.httpServers() {
+		srv.SetOffline(offline)
+	}
+}
+
 func (n *clusterNodeInst) cleanup() {
 	if n.kvService != nil {
 		n.kvService.Close()
 		n.kvService = nil
 	}
+
+	for _, srv := range n.httpServers() {
+		srv.Close()
+	}
 }
@@ -7,8 +7,15 @@ import (
 
 // NewDefaultCluster returns a new cluster configured with some defaults.
 func NewDefaultCluster() (mock.Cluster, error) {
+	return NewDefaultClusterWithVersion(mock.ServerVersionLatest)
+}
+
+// NewDefaultClusterWithVersion returns a new cluster configured with some
+// defaults which emulates a specific server version.
+func NewDefaultClusterWithVersion(serverVersion mock.ServerVersion) (mock.Cluster, error) {
 	cluster, err := NewCluster(mock.NewClusterOptions{
-		InitialNode: mock.NewNodeOptions{},
+		InitialNode:   mock.NewNodeOptions{},
+		ServerVersion: serverVersion,
 	})
 	if err != nil {
 		return nil, err
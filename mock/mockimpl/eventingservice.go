@@ -0,0 +1,98 @@
+package mockimpl
+
+import (
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/servers"
+)
+
+// eventingService represents an eventing service running somewhere in the cluster.
+type eventingService struct {
+	clusterNode *clusterNodeInst
+	server      *servers.HTTPServer
+	tlsServer   *servers.HTTPServer
+}
+
+type newEventingServiceOptions struct {
+}
+
+func newEventingService(parent *clusterNodeInst, opts newEventingServiceOptions) (*eventingService, error) {
+	svc := &eventingService{
+		clusterNode: parent,
+	}
+
+	srv, err := servers.NewHTTPServer(servers.NewHTTPServiceOptions{
+		Name: "eventing",
+		Handlers: servers.HTTPServerHandlers{
+			NewRequestHandler: svc.handleNewRequest,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.server = srv
+
+	if parent.HasFeature(mock.ClusterNodeFeatureTLS) {
+		tlsSrv, err := servers.NewHTTPServer(servers.NewHTTPServiceOptions{
+			Name: "eventing",
+			Handlers: servers.HTTPServerHandlers{
+				NewRequestHandler: svc.handleNewRequest,
+			},
+			TLSConfig: parent.tlsConfig,
+		})
+		if err != nil {
+			return nil, err
+		}
+		svc.tlsServer = tlsSrv
+	}
+
+	return svc, nil
+}
+
+// Node returns the node which owns this service.
+func (s *eventingService) Node() mock.ClusterNode {
+	return s.clusterNode
+}
+
+// Hostname returns the hostname where this service can be accessed.
+func (s *eventingService) Hostname() string {
+	return s.clusterNode.Hostname()
+}
+
+// ListenPort returns the port this service is listening on.
+func (s *eventingService) ListenPort() int {
+	if s.server == nil {
+		return -1
+	}
+	return s.server.ListenPort()
+}
+
+// ListenPortTLS returns the TLS port this service is listening on.
+func (s *eventingService) ListenPortTLS() int {
+	if s.tlsServer == nil {
+		return -1
+	}
+	return s.tlsServer.ListenPort()
+}
+
+func (s *eventingService) handleNewRequest(req *mock.HTTPRequest) *mock.HTTPResponse {
+	return s.clusterNode.cluster.handleEventingRequest(s, req)
+}
+
+// Close will shut down this service once it is no longer needed.
+func (s *eventingService) Close() error {
+	var errOut error
+	if s.server != nil {
+		errOut = s.server.Close()
+	}
+	if s.tlsServer != nil {
+		errOut = s.tlsServer.Close()
+	}
+	return errOut
+}
+
+// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
+func (s *eventingService) CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string,
+	req *mock.HTTPRequest) bool {
+	return checkHTTPAuthenticated(permission, bucket, scope, collection, req, s.Node().Cluster().Users())
+}
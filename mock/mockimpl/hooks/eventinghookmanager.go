@@ -0,0 +1,46 @@
+package hooks
+
+import (
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// EventingHookManager implements a tree of hooks which can handle an eventing request.
+type EventingHookManager struct {
+	hookManager
+}
+
+// Child returns a child hook manager to this hook manager.
+func (m *EventingHookManager) Child() mock.EventingHookManager {
+	return &EventingHookManager{
+		m.hookManager.Child(),
+	}
+}
+
+// Add adds a new hook at the end of the processing chain.
+func (m *EventingHookManager) Add(fn mock.EventingHookFunc) {
+	m.hookManager.Add(&fn)
+}
+
+// Destroy removes all hooks that were added to this manager.
+func (m *EventingHookManager) Destroy() {
+	m.hookManager.Destroy()
+}
+
+func (m *EventingHookManager) translateHookResult(val interface{}) *mock.HTTPResponse {
+	if val == nil {
+		return nil
+	}
+	return val.(*mock.HTTPResponse)
+}
+
+// Invoke will invoke this hook chain.  It starts at the most recently
+// registered hook and works it's way to the oldest hook.
+func (m *EventingHookManager) Invoke(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	res := m.hookManager.Invoke(func(hook interface{}, next func() interface{}) interface{} {
+		hookFn := *(hook.(*mock.EventingHookFunc))
+		return hookFn(source, req, func() *mock.HTTPResponse {
+			return m.translateHookResult(next())
+		})
+	})
+	return res.(*mock.HTTPResponse)
+}
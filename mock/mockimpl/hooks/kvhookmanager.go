@@ -35,9 +35,13 @@ func (m *KvHookManager) Invoke(source mock.KvClient, pak *memd.Packet) bool {
 	var successMarker struct{}
 	var reachedEndOfChain bool
 
+	// All hooks see the same start time so that server durations cover the
+	// entire time the packet spent being processed.
+	start := time.Now()
+
 	res := m.hookManager.Invoke(func(hook interface{}, next func() interface{}) interface{} {
 		hookFn := *(hook.(*mock.KvHookFunc))
-		hookFn(source, pak, time.Now(), func() {
+		hookFn(source, pak, start, func() {
 			res := next()
 			if res == nil {
 				// This indicates we reached the end of the chain.
@@ -6,18 +6,28 @@ import (
 	"github.com/couchbaselabs/gocaves/mock/mockdb"
 )
 
+// MaxTTLFunc returns the maximum TTL of documents written to a collection, or
+// 0 if documents in that collection may live forever.
+type MaxTTLFunc func(collectionID uint) time.Duration
+
 // Engine represents a specific engine.
 type Engine struct {
 	db          *mockdb.Bucket
 	vbOwnership []int
+	vbMap       [][]int
+	maxTTL      MaxTTLFunc
 }
 
-// New creates a new crudproc engine using a mockdb and a list of what replicas
-// are owned by this particular engine.
-func New(db *mockdb.Bucket, vbOwnership []int) *Engine {
+// New creates a new crudproc engine using a mockdb, a list of what replicas
+// are owned by this particular engine and the vbucket map of the bucket, where
+// -1 indicates that no node is currently available for that copy.  The maxTTL
+// function is used to cap document expiries, and may be nil.
+func New(db *mockdb.Bucket, vbOwnership []int, vbMap [][]int, maxTTL MaxTTLFunc) *Engine {
 	return &Engine{
 		db:          db,
 		vbOwnership: vbOwnership,
+		vbMap:       vbMap,
+		maxTTL:      maxTTL,
 	}
 }
 
@@ -50,18 +60,33 @@ func (e *Engine) docIsLocked(doc *mockdb.Document) bool {
 	return e.db.Chrono().Now().Before(doc.LockExpiry)
 }
 
-func (e *Engine) parseExpiry(expiry uint32) time.Time {
+func (e *Engine) parseExpiry(collectionID uint, expiry uint32) time.Time {
+	var expiryTime time.Time
 	if expiry == 0 {
-		return time.Time{}
+		expiryTime = time.Time{}
+	} else if expiry > 30*24*60*60 {
+		// TODO(brett19): Check if this is the right edge for expiry.
+		expiryTime = time.Unix(int64(expiry), 0).Add(e.db.Chrono().TimeShift())
+	} else {
+		expiryDura := time.Duration(expiry) * time.Second
+		expiryTime = e.db.Chrono().Now().Add(expiryDura)
+	}
+
+	if e.maxTTL == nil {
+		return expiryTime
 	}
 
-	// TODO(brett19): Check if this is the right edge for expiry.
-	if expiry > 30*24*60*60 {
-		return time.Unix(int64(expiry), 0).Add(e.db.Chrono().TimeShift())
+	// Documents which would outlive the max TTL, including those with no expiry
+	// at all, have their expiry capped at the max TTL.
+	maxTTL := e.maxTTL(collectionID)
+	if maxTTL > 0 {
+		maxExpiryTime := e.db.Chrono().Now().Add(maxTTL)
+		if expiryTime.IsZero() || expiryTime.After(maxExpiryTime) {
+			return maxExpiryTime
+		}
 	}
 
-	expiryDura := time.Duration(expiry) * time.Second
-	return e.db.Chrono().Now().Add(expiryDura)
+	return expiryTime
 }
 
 func (e *Engine) HLC() time.Time {
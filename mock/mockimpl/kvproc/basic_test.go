@@ -0,0 +1,68 @@
+package kvproc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxTTL(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	db, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:      chrono,
+		NumReplicas: 0,
+		NumVbuckets: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	e := New(db, []int{0}, [][]int{{0}}, func(collectionID uint) time.Duration {
+		if collectionID == 8 {
+			return 10 * time.Second
+		}
+		return 0
+	})
+
+	setAndGetExpiry := func(collectionID uint, expiry uint32) time.Time {
+		_, err := e.Set(StoreOptions{
+			CollectionID: collectionID,
+			Key:          []byte("test"),
+			Value:        []byte("hello world"),
+			Expiry:       expiry,
+		})
+		if err != nil {
+			t.Fatalf("failed to set document: %v", err)
+		}
+
+		res, err := e.GetMeta(GetMetaOptions{
+			CollectionID: collectionID,
+			Key:          []byte("test"),
+		})
+		if err != nil {
+			t.Fatalf("failed to get document meta: %v", err)
+		}
+
+		return res.ExpTime
+	}
+
+	assert.True(t, setAndGetExpiry(0, 0).IsZero())
+	assert.Equal(t, chrono.Now().Add(20*time.Second).Unix(), setAndGetExpiry(0, 20).Unix())
+
+	// Documents with no expiry, or an expiry past the max TTL, get capped.
+	assert.Equal(t, chrono.Now().Add(10*time.Second).Unix(), setAndGetExpiry(8, 0).Unix())
+	assert.Equal(t, chrono.Now().Add(10*time.Second).Unix(), setAndGetExpiry(8, 20).Unix())
+	assert.Equal(t, chrono.Now().Add(5*time.Second).Unix(), setAndGetExpiry(8, 5).Unix())
+
+	setAndGetExpiry(8, 0)
+	chrono.TimeTravel(11 * time.Second)
+
+	_, err = e.Get(GetOptions{
+		CollectionID: 8,
+		Key:          []byte("test"),
+	})
+	assert.Equal(t, ErrDocNotFound, err)
+}
@@ -0,0 +1,193 @@
+package kvproc
+
+import (
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// DcpRollbackError is returned when a DCP stream cannot be opened at the
+// requested position and the client must first roll back to SeqNo.
+type DcpRollbackError struct {
+	SeqNo uint64
+}
+
+func (e DcpRollbackError) Error() string {
+	return "rollback required"
+}
+
+// DcpFailoverLogOptions specifies options for a DCP_GET_FAILOVER_LOG operation.
+type DcpFailoverLogOptions struct {
+	Vbucket uint
+}
+
+// DcpFailoverLogResult contains the results of a DCP_GET_FAILOVER_LOG operation.
+type DcpFailoverLogResult struct {
+	Entries []mockdb.VbRevData
+}
+
+// DcpFailoverLog performs a DCP_GET_FAILOVER_LOG operation.
+func (e *Engine) DcpFailoverLog(opts DcpFailoverLogOptions) (*DcpFailoverLogResult, error) {
+	if e.findReplicaIdx(opts.Vbucket) == -1 {
+		return nil, ErrNotMyVbucket
+	}
+
+	return &DcpFailoverLogResult{
+		Entries: e.db.GetVbucket(opts.Vbucket).FailoverLog(),
+	}, nil
+}
+
+// DcpStreamRequestOptions specifies options for a DCP_STREAM_REQ operation.
+type DcpStreamRequestOptions struct {
+	Vbucket        uint
+	VbUUID         uint64
+	StartSeqNo     uint64
+	EndSeqNo       uint64
+	SnapStartSeqNo uint64
+	SnapEndSeqNo   uint64
+	ToLatest       bool
+}
+
+// DcpStreamRequestResult contains the results of a DCP_STREAM_REQ operation.
+type DcpStreamRequestResult struct {
+	EndSeqNo    uint64
+	FailoverLog []mockdb.VbRevData
+}
+
+// DcpStreamRequest validates a DCP_STREAM_REQ operation, returning the end
+// seqno that the stream should run to along with the failover log.
+func (e *Engine) DcpStreamRequest(opts DcpStreamRequestOptions) (*DcpStreamRequestResult, error) {
+	if err := e.confirmIsMaster(opts.Vbucket); err != nil {
+		return nil, err
+	}
+
+	vb := e.db.GetVbucket(opts.Vbucket)
+	highSeqNo := vb.HighSeqNo()
+	failoverLog := vb.FailoverLog()
+
+	endSeqNo := opts.EndSeqNo
+	if opts.ToLatest {
+		endSeqNo = highSeqNo
+	}
+
+	if opts.StartSeqNo > endSeqNo {
+		return nil, ErrRange
+	}
+	if opts.SnapStartSeqNo > opts.StartSeqNo || opts.StartSeqNo > opts.SnapEndSeqNo {
+		return nil, ErrRange
+	}
+
+	if opts.StartSeqNo > 0 {
+		rollbackSeqNo, needsRollback := dcpRollbackSeqNo(failoverLog, highSeqNo, opts)
+		if needsRollback {
+			return nil, DcpRollbackError{SeqNo: rollbackSeqNo}
+		}
+	}
+
+	return &DcpStreamRequestResult{
+		EndSeqNo:    endSeqNo,
+		FailoverLog: failoverLog,
+	}, nil
+}
+
+// dcpRollbackSeqNo determines whether a client resuming a stream from the
+// position described by opts must roll back first, and if so, to where.  The
+// failover log is expected to be ordered from newest to oldest.
+func dcpRollbackSeqNo(failoverLog []mockdb.VbRevData, highSeqNo uint64, opts DcpStreamRequestOptions) (uint64, bool) {
+	upperSeqNo := highSeqNo
+	for _, entry := range failoverLog {
+		if entry.VbUUID != opts.VbUUID {
+			// The next entry we look at is older than this one, which means
+			// this entry marks the end of its history branch.
+			upperSeqNo = entry.SeqNo
+			continue
+		}
+
+		if opts.SnapEndSeqNo <= upperSeqNo {
+			return 0, false
+		}
+
+		if opts.SnapStartSeqNo < upperSeqNo {
+			return opts.SnapStartSeqNo, true
+		}
+		return upperSeqNo, true
+	}
+
+	// The vbuuid is not part of our history at all.
+	return 0, true
+}
+
+// DcpSnapshotOptions specifies options for reading a DCP snapshot.
+type DcpSnapshotOptions struct {
+	Vbucket    uint
+	StartSeqNo uint64
+	EndSeqNo   uint64
+}
+
+// DcpSnapshotItem represents a single document change within a DCP snapshot.
+type DcpSnapshotItem struct {
+	Doc       *mockdb.Document
+	IsExpired bool
+}
+
+// DcpSnapshotResult contains a deduplicated snapshot of the documents which
+// were modified within the requested seqno range.
+type DcpSnapshotResult struct {
+	StartSeqNo uint64
+	EndSeqNo   uint64
+	Items      []DcpSnapshotItem
+}
+
+// DcpSnapshot reads the next snapshot for a DCP stream.  The snapshot covers
+// all seqnos after StartSeqNo, up to EndSeqNo or the vbuckets high seqno,
+// whichever comes first.  Returns a nil result if there is nothing new.
+func (e *Engine) DcpSnapshot(opts DcpSnapshotOptions) (*DcpSnapshotResult, error) {
+	if err := e.confirmIsMaster(opts.Vbucket); err != nil {
+		return nil, err
+	}
+
+	vb := e.db.GetVbucket(opts.Vbucket)
+
+	endSeqNo := vb.HighSeqNo()
+	if opts.EndSeqNo < endSeqNo {
+		endSeqNo = opts.EndSeqNo
+	}
+	if opts.StartSeqNo >= endSeqNo {
+		return nil, nil
+	}
+
+	docs, _, err := vb.GetAllWithin(0, opts.StartSeqNo, endSeqNo)
+	if err != nil {
+		return nil, err
+	}
+
+	// DCP only sends the most recent version of each document in a snapshot.
+	type docKey struct {
+		collectionID uint
+		key          string
+	}
+	latestSeqNos := make(map[docKey]uint64)
+	for _, doc := range docs {
+		latestSeqNos[docKey{doc.CollectionID, string(doc.Key)}] = doc.SeqNo
+	}
+
+	var items []DcpSnapshotItem
+	for _, doc := range docs {
+		if latestSeqNos[docKey{doc.CollectionID, string(doc.Key)}] != doc.SeqNo {
+			continue
+		}
+
+		// Documents which have expired since they were written are reported
+		// as expirations rather than mutations.
+		isExpired := !doc.IsDeleted && !doc.Expiry.IsZero() && !e.db.Chrono().Now().Before(doc.Expiry)
+
+		items = append(items, DcpSnapshotItem{
+			Doc:       doc,
+			IsExpired: isExpired,
+		})
+	}
+
+	return &DcpSnapshotResult{
+		StartSeqNo: opts.StartSeqNo + 1,
+		EndSeqNo:   endSeqNo,
+		Items:      items,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package kvproc
+
+import (
+	"testing"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func testOneRollback(t *testing.T, opts DcpStreamRequestOptions, expectedSeqNo uint64, expectedRollback bool) {
+	// History: 0xa started at 0, 0xb at 10, 0xc at 20 and we are now at 30.
+	failoverLog := []mockdb.VbRevData{
+		{VbUUID: 0xc, SeqNo: 20},
+		{VbUUID: 0xb, SeqNo: 10},
+		{VbUUID: 0xa, SeqNo: 0},
+	}
+
+	seqNo, needsRollback := dcpRollbackSeqNo(failoverLog, 30, opts)
+	assert.Equal(t, expectedRollback, needsRollback)
+	assert.Equal(t, expectedSeqNo, seqNo)
+}
+
+func TestDcpRollback(t *testing.T) {
+	// Resuming on the current branch is always fine.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xc, StartSeqNo: 25, SnapStartSeqNo: 25, SnapEndSeqNo: 25,
+	}, 0, false)
+
+	// Resuming beyond what we have on the current branch.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xc, StartSeqNo: 35, SnapStartSeqNo: 35, SnapEndSeqNo: 35,
+	}, 30, true)
+
+	// Resuming on an old branch before it diverged.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xb, StartSeqNo: 15, SnapStartSeqNo: 12, SnapEndSeqNo: 18,
+	}, 0, false)
+
+	// Resuming on an old branch after it diverged.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xb, StartSeqNo: 25, SnapStartSeqNo: 25, SnapEndSeqNo: 25,
+	}, 20, true)
+
+	// Resuming with a snapshot which straddles the divergence point.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xa, StartSeqNo: 8, SnapStartSeqNo: 8, SnapEndSeqNo: 12,
+	}, 8, true)
+
+	// Resuming with a vbuuid we have never seen.
+	testOneRollback(t, DcpStreamRequestOptions{
+		VbUUID: 0xf, StartSeqNo: 5, SnapStartSeqNo: 5, SnapEndSeqNo: 5,
+	}, 0, true)
+}
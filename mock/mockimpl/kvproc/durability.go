@@ -0,0 +1,117 @@
+package kvproc
+
+import (
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// defaultSyncWriteTimeout is the durability timeout which is used when the
+// client does not specify one.
+const defaultSyncWriteTimeout = 30 * time.Second
+
+func translateStoreErr(err error) error {
+	switch err {
+	case mockdb.ErrSyncWriteInProgress:
+		return ErrSyncWriteInProgress
+	case mockdb.ErrSyncWriteRecommitInProgress:
+		return ErrSyncWriteRecommitInProgress
+	}
+	return err
+}
+
+// durabilityMajority returns the number of copies of a vbucket which must
+// acknowledge a durable write, along with the number of copies that are
+// currently available to do so.
+func (e *Engine) durabilityMajority(vbIdx uint) (int, int) {
+	if vbIdx >= uint(len(e.vbMap)) {
+		return 1, 0
+	}
+
+	numAvailable := 0
+	for _, nodeIdx := range e.vbMap[vbIdx] {
+		if nodeIdx >= 0 {
+			numAvailable++
+		}
+	}
+
+	return len(e.vbMap[vbIdx])/2 + 1, numAvailable
+}
+
+// syncWriteDelay calculates how long it takes for a durable write to meet the
+// requested durability level, based on the simulated latencies of the vbucket.
+func (e *Engine) syncWriteDelay(vbIdx uint, level memd.DurabilityLevel) time.Duration {
+	vbucket := e.db.GetVbucket(vbIdx)
+	majority, _ := e.durabilityMajority(vbIdx)
+
+	// The active counts towards the majority, and replica N observes the write
+	// N*ReplicaLatency after the active does.
+	replicateDelay := time.Duration(majority-1) * vbucket.ReplicaLatency()
+
+	switch level {
+	case memd.DurabilityLevelMajorityAndPersistOnMaster:
+		if vbucket.PersistLatency() > replicateDelay {
+			return vbucket.PersistLatency()
+		}
+		return replicateDelay
+	case memd.DurabilityLevelPersistToMajority:
+		return replicateDelay + vbucket.PersistLatency()
+	}
+
+	return replicateDelay
+}
+
+// update performs a non-durable modification of a document.
+func (e *Engine) update(vbIdx, collectionID uint, key []byte, fn mockdb.UpdateFunc) (*mockdb.Document, error) {
+	newDoc, err := e.db.Update(vbIdx, collectionID, key, fn)
+	if err != nil {
+		return nil, translateStoreErr(err)
+	}
+
+	return newDoc, nil
+}
+
+// syncUpdate performs a modification of a document, blocking until the
+// requested durability level has been met or the timeout elapses.
+func (e *Engine) syncUpdate(vbIdx, collectionID uint, key []byte, level memd.DurabilityLevel, timeout time.Duration, fn mockdb.UpdateFunc) (*mockdb.Document, error) {
+	if level == 0 {
+		return e.update(vbIdx, collectionID, key, fn)
+	}
+
+	if level > memd.DurabilityLevelPersistToMajority {
+		return nil, ErrDurabilityInvalidLevel
+	}
+
+	majority, numAvailable := e.durabilityMajority(vbIdx)
+	if numAvailable < majority {
+		return nil, ErrDurabilityImpossible
+	}
+
+	syncWrite, err := e.db.PrepareSyncWrite(vbIdx, collectionID, key, fn)
+	if err != nil {
+		return nil, translateStoreErr(err)
+	}
+
+	if timeout == 0 {
+		timeout = defaultSyncWriteTimeout
+	}
+
+	delay := e.syncWriteDelay(vbIdx, level)
+	if delay > timeout {
+		// The write cannot be made durable in time, so the server aborts it and
+		// the client is left not knowing whether it was successful.
+		<-e.db.Chrono().After(timeout)
+		syncWrite.Abort()
+		return nil, ErrSyncWriteAmbiguous
+	}
+
+	<-e.db.Chrono().After(delay)
+
+	newDoc, err := syncWrite.Commit()
+	if err != nil {
+		return nil, ErrSyncWriteAmbiguous
+	}
+
+	return newDoc, nil
+}
@@ -0,0 +1,89 @@
+package kvproc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDurabilityTestEngine(t *testing.T, vbMap [][]int) *Engine {
+	db, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:         &mocktime.Chrono{},
+		NumReplicas:    1,
+		NumVbuckets:    1,
+		ReplicaLatency: 10 * time.Millisecond,
+		PersistLatency: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	return New(db, []int{0}, vbMap, nil)
+}
+
+func TestDurabilityImpossible(t *testing.T) {
+	e := newDurabilityTestEngine(t, [][]int{{0, -1}})
+
+	_, err := e.Set(StoreOptions{
+		Key:             []byte("test"),
+		Value:           []byte("hello world"),
+		DurabilityLevel: memd.DurabilityLevelMajority,
+	})
+	assert.Equal(t, ErrDurabilityImpossible, err)
+}
+
+func TestDurabilitySyncWrite(t *testing.T) {
+	e := newDurabilityTestEngine(t, [][]int{{0, 1}})
+
+	resultCh := make(chan error)
+	go func() {
+		_, err := e.Set(StoreOptions{
+			Key:             []byte("test"),
+			Value:           []byte("hello world"),
+			DurabilityLevel: memd.DurabilityLevelPersistToMajority,
+		})
+		resultCh <- err
+	}()
+
+	// Wait for the first write to be prepared.
+	time.Sleep(5 * time.Millisecond)
+
+	_, err := e.Set(StoreOptions{
+		Key:   []byte("test"),
+		Value: []byte("hello world"),
+	})
+	assert.Equal(t, ErrSyncWriteInProgress, err)
+
+	_, err = e.Get(GetOptions{
+		Key: []byte("test"),
+	})
+	assert.Equal(t, ErrDocNotFound, err)
+
+	assert.NoError(t, <-resultCh)
+
+	_, err = e.Get(GetOptions{
+		Key: []byte("test"),
+	})
+	assert.NoError(t, err)
+}
+
+func TestDurabilityAmbiguous(t *testing.T) {
+	e := newDurabilityTestEngine(t, [][]int{{0, 1}})
+
+	_, err := e.Set(StoreOptions{
+		Key:               []byte("test"),
+		Value:             []byte("hello world"),
+		DurabilityLevel:   memd.DurabilityLevelPersistToMajority,
+		DurabilityTimeout: 5 * time.Millisecond,
+	})
+	assert.Equal(t, ErrSyncWriteAmbiguous, err)
+
+	_, err = e.Get(GetOptions{
+		Key: []byte("test"),
+	})
+	assert.Equal(t, ErrDocNotFound, err)
+}
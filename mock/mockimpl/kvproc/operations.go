@@ -167,14 +167,16 @@ func (e *Engine) GetReplica(opts GetOptions) (*GetResult, error) {
 
 // StoreOptions specifies options for various store operations.
 type StoreOptions struct {
-	Vbucket      uint
-	CollectionID uint
-	Key          []byte
-	Cas          uint64
-	Datatype     uint8
-	Value        []byte
-	Flags        uint32
-	Expiry       uint32
+	Vbucket           uint
+	CollectionID      uint
+	Key               []byte
+	Cas               uint64
+	Datatype          uint8
+	Value             []byte
+	Flags             uint32
+	Expiry            uint32
+	DurabilityLevel   memd.DurabilityLevel
+	DurabilityTimeout time.Duration
 }
 
 // StoreResult contains the results for various store operations.
@@ -197,19 +199,23 @@ func (e *Engine) Add(opts StoreOptions) (*StoreResult, error) {
 		Value:        opts.Value,
 		Flags:        opts.Flags,
 		Datatype:     opts.Datatype,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Insert(doc)
+	newDoc, err := e.syncUpdate(
+		doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
+		func(idoc *mockdb.Document) (*mockdb.Document, error) {
+			if idoc != nil && !idoc.IsDeleted {
+				return nil, ErrDocExists
+			}
 
-	if err == mockdb.ErrDocExists {
-		return nil, ErrDocExists
-	} else if err == mockdb.ErrValueTooBig {
+			return doc, nil
+		})
+	if err == mockdb.ErrValueTooBig {
 		return nil, ErrValueTooBig
 	} else if err != nil {
-		// TODO(brett19): Correctly handle the various errors which can occur in an ADD.
-		return nil, ErrInternal
+		return nil, err
 	}
 
 	return &StoreResult{
@@ -232,12 +238,12 @@ func (e *Engine) Set(opts StoreOptions) (*StoreResult, error) {
 		Value:        opts.Value,
 		Flags:        opts.Flags,
 		Datatype:     opts.Datatype,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
-		doc.VbID, doc.CollectionID, doc.Key,
+	newDoc, err := e.syncUpdate(
+		doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if opts.Cas != 0 {
 				if idoc == nil || idoc.IsDeleted {
@@ -299,12 +305,12 @@ func (e *Engine) Replace(opts StoreOptions) (*StoreResult, error) {
 		Value:        opts.Value,
 		Flags:        opts.Flags,
 		Datatype:     opts.Datatype,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
-		doc.VbID, doc.CollectionID, doc.Key,
+	newDoc, err := e.syncUpdate(
+		doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
 				return nil, ErrDocNotFound
@@ -342,10 +348,12 @@ func (e *Engine) Replace(opts StoreOptions) (*StoreResult, error) {
 
 // DeleteOptions specifies options for a DELETE operation.
 type DeleteOptions struct {
-	Vbucket      uint
-	CollectionID uint
-	Key          []byte
-	Cas          uint64
+	Vbucket           uint
+	CollectionID      uint
+	Key               []byte
+	Cas               uint64
+	DurabilityLevel   memd.DurabilityLevel
+	DurabilityTimeout time.Duration
 }
 
 // DeleteResult contains the results of a DELETE operation.
@@ -367,8 +375,8 @@ func (e *Engine) Delete(opts DeleteOptions) (*DeleteResult, error) {
 		Key:          opts.Key,
 	}
 
-	newDoc, err := e.db.Update(
-		lkpDoc.VbID, lkpDoc.CollectionID, lkpDoc.Key,
+	newDoc, err := e.syncUpdate(
+		lkpDoc.VbID, lkpDoc.CollectionID, lkpDoc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
 				return nil, ErrDocNotFound
@@ -390,6 +398,7 @@ func (e *Engine) Delete(opts DeleteOptions) (*DeleteResult, error) {
 			idoc.LockExpiry = time.Time{}
 			idoc.Cas = mockdb.GenerateNewCas(e.HLC())
 			idoc.Value = []byte{}
+			idoc.Datatype &^= uint8(memd.DatatypeFlagCompressed)
 			// We need to keep the system xattrs, i.e. those which start with an _.
 			for key := range idoc.Xattrs {
 				if !strings.HasPrefix(key, "_") {
@@ -411,13 +420,15 @@ func (e *Engine) Delete(opts DeleteOptions) (*DeleteResult, error) {
 
 // CounterOptions specifies options for a INCREMENT or DECREMENT operation.
 type CounterOptions struct {
-	Vbucket      uint
-	CollectionID uint
-	Key          []byte
-	Cas          uint64
-	Initial      uint64
-	Delta        uint64
-	Expiry       uint32
+	Vbucket           uint
+	CollectionID      uint
+	Key               []byte
+	Cas               uint64
+	Initial           uint64
+	Delta             uint64
+	Expiry            uint32
+	DurabilityLevel   memd.DurabilityLevel
+	DurabilityTimeout time.Duration
 }
 
 // CounterResult contains the results of a INCREMENT or DECREMENT operation.
@@ -440,12 +451,12 @@ func (e *Engine) counter(opts CounterOptions, isIncr bool) (*CounterResult, erro
 		Value:        []byte(fmt.Sprintf("%d", opts.Initial)),
 		Flags:        0,
 		Datatype:     0,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
-		doc.VbID, doc.CollectionID, doc.Key,
+	newDoc, err := e.syncUpdate(
+		doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
 				if opts.Expiry == 0xffffffff {
@@ -468,6 +479,7 @@ func (e *Engine) counter(opts CounterOptions, isIncr bool) (*CounterResult, erro
 			}
 
 			// Otherwise we simply update the value
+			idoc.Inflate()
 			val, err := strconv.ParseUint(string(idoc.Value), 10, 64)
 			if err != nil {
 				return nil, err
@@ -535,8 +547,8 @@ func (e *Engine) adjoin(opts StoreOptions, isAppend bool) (*StoreResult, error)
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
-		doc.VbID, doc.CollectionID, doc.Key,
+	newDoc, err := e.syncUpdate(
+		doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
 				return nil, ErrDocNotFound
@@ -551,6 +563,7 @@ func (e *Engine) adjoin(opts StoreOptions, isAppend bool) (*StoreResult, error)
 			}
 
 			// Otherwise we simply update the value
+			idoc.Inflate()
 			if isAppend {
 				idoc.Value = append(idoc.Value, doc.Value...)
 			} else {
@@ -604,11 +617,11 @@ func (e *Engine) Touch(opts TouchOptions) (*StoreResult, error) {
 		VbID:         opts.Vbucket,
 		CollectionID: opts.CollectionID,
 		Key:          opts.Key,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
+	newDoc, err := e.update(
 		doc.VbID, doc.CollectionID, doc.Key,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
@@ -655,11 +668,11 @@ func (e *Engine) GetAndTouch(opts GetAndTouchOptions) (*GetResult, error) {
 		VbID:         opts.Vbucket,
 		CollectionID: opts.CollectionID,
 		Key:          opts.Key,
-		Expiry:       e.parseExpiry(opts.Expiry),
+		Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 		Cas:          mockdb.GenerateNewCas(e.HLC()),
 	}
 
-	newDoc, err := e.db.Update(
+	newDoc, err := e.update(
 		doc.VbID, doc.CollectionID, doc.Key,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
@@ -716,7 +729,7 @@ func (e *Engine) GetLocked(opts GetLockedOptions) (*GetResult, error) {
 		CollectionID: opts.CollectionID,
 		Key:          opts.Key,
 	}
-	doc, err := e.db.Update(
+	doc, err := e.update(
 		lkpDoc.VbID, lkpDoc.CollectionID, lkpDoc.Key,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
@@ -764,7 +777,7 @@ func (e *Engine) Unlock(opts UnlockOptions) (*StoreResult, error) {
 		Key:          opts.Key,
 	}
 
-	newDoc, err := e.db.Update(
+	newDoc, err := e.update(
 		doc.VbID, doc.CollectionID, doc.Key,
 		func(idoc *mockdb.Document) (*mockdb.Document, error) {
 			if idoc == nil || idoc.IsDeleted {
@@ -845,6 +858,7 @@ func (e *Engine) MultiLookup(opts MultiLookupOptions) (*MultiLookupResult, error
 		return nil, ErrLocked
 	}
 
+	doc.Inflate()
 	sdRes, err := e.executeSdOps(doc, doc, opts.Ops, true)
 	if err != nil {
 		return nil, err
@@ -859,16 +873,18 @@ func (e *Engine) MultiLookup(opts MultiLookupOptions) (*MultiLookupResult, error
 
 // MultiMutateOptions specifies options for an SD_MULTIMUTATE operation.
 type MultiMutateOptions struct {
-	Vbucket         uint
-	CollectionID    uint
-	Key             []byte
-	Ops             []*SubDocOp
-	AccessDeleted   bool
-	CreateAsDeleted bool
-	CreateIfMissing bool
-	CreateOnly      bool
-	Expiry          uint32
-	Cas             uint64
+	Vbucket           uint
+	CollectionID      uint
+	Key               []byte
+	Ops               []*SubDocOp
+	AccessDeleted     bool
+	CreateAsDeleted   bool
+	CreateIfMissing   bool
+	CreateOnly        bool
+	Expiry            uint32
+	Cas               uint64
+	DurabilityLevel   memd.DurabilityLevel
+	DurabilityTimeout time.Duration
 }
 
 // MultiMutateResult contains the results of a SD_MULTIMUTATE operation.
@@ -900,7 +916,7 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 			Value:        nil,
 			Cas:          0,
 			Xattrs:       make(map[string][]byte),
-			Expiry:       e.parseExpiry(opts.Expiry),
+			Expiry:       e.parseExpiry(opts.CollectionID, opts.Expiry),
 			IsDeleted:    opts.CreateAsDeleted,
 		}
 
@@ -957,10 +973,14 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 				doc = mdoc
 			} else if doc.IsDeleted && !opts.AccessDeleted {
 				doc.IsDeleted = false
-				doc.Expiry = time.Time{} // If a doc is resurrected we also need to reset expiry
+				doc.Expiry = mdoc.Expiry // If a doc is resurrected we also need to reset expiry
 			}
 		}
 
+		if doc != nil && opts.Expiry != 0 {
+			doc.Expiry = mdoc.Expiry
+		}
+
 		if doc == nil {
 			return nil, ErrDocNotFound
 		}
@@ -977,13 +997,14 @@ func (e *Engine) MultiMutate(opts MultiMutateOptions) (*MultiMutateResult, error
 			Cas: mockdb.GenerateNewCas(e.HLC()),
 		}
 
+		doc.Inflate()
 		sdRes, err := e.executeSdOps(doc, newMetaDoc, opts.Ops, false)
 		if err != nil {
 			return nil, err
 		}
 
-		newDoc, err := e.db.Update(
-			doc.VbID, doc.CollectionID, doc.Key,
+		newDoc, err := e.syncUpdate(
+			doc.VbID, doc.CollectionID, doc.Key, opts.DurabilityLevel, opts.DurabilityTimeout,
 			func(idoc *mockdb.Document) (*mockdb.Document, error) {
 				if idoc == nil {
 					// Check if our source document existed or not
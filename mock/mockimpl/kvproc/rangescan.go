@@ -0,0 +1,170 @@
+package kvproc
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// RangeScanSnapshotRequirements specifies the state which a vbucket must have
+// reached before a range scan can be created against it.
+type RangeScanSnapshotRequirements struct {
+	VbUUID      uint64
+	SeqNo       uint64
+	SeqNoExists bool
+	Timeout     time.Duration
+}
+
+// RangeScanCreateOptions specifies options for a RANGE_SCAN_CREATE operation.
+// Either a key range or a number of samples must be specified.  A nil end key
+// indicates that the range extends to the end of the collection.
+type RangeScanCreateOptions struct {
+	Vbucket        uint
+	CollectionID   uint
+	StartKey       []byte
+	EndKey         []byte
+	ExclusiveStart bool
+	ExclusiveEnd   bool
+	IsSampling     bool
+	Samples        uint64
+	Seed           uint64
+	Snapshot       *RangeScanSnapshotRequirements
+}
+
+// RangeScanCreateResult contains the results of a RANGE_SCAN_CREATE operation.
+// The items are a snapshot of the matching documents, ordered by key.
+type RangeScanCreateResult struct {
+	VbUUID uint64
+	SeqNo  uint64
+	Items  []*mockdb.Document
+}
+
+// RangeScanCreate performs a RANGE_SCAN_CREATE operation.
+func (e *Engine) RangeScanCreate(opts RangeScanCreateOptions) (*RangeScanCreateResult, error) {
+	if err := e.confirmIsMaster(opts.Vbucket); err != nil {
+		return nil, err
+	}
+
+	if opts.IsSampling && opts.Samples == 0 {
+		return nil, ErrInvalidArgument
+	}
+
+	vb := e.db.GetVbucket(opts.Vbucket)
+
+	if opts.Snapshot != nil {
+		if err := e.waitForRangeScanSnapshot(vb, *opts.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	metaState := vb.CurrentMetaState(0)
+	highSeqNo := vb.HighSeqNo()
+
+	var docs []*mockdb.Document
+	if highSeqNo > 0 {
+		var err error
+		docs, _, err = vb.GetAllWithin(0, 0, highSeqNo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the most recent version of each living document is visible.
+	latestDocs := make(map[string]*mockdb.Document)
+	for _, doc := range docs {
+		if doc.CollectionID != opts.CollectionID {
+			continue
+		}
+
+		latestDocs[string(doc.Key)] = doc
+	}
+
+	now := e.db.Chrono().Now()
+	var items []*mockdb.Document
+	for _, doc := range latestDocs {
+		if doc.IsDeleted {
+			continue
+		}
+		if !doc.Expiry.IsZero() && !now.Before(doc.Expiry) {
+			continue
+		}
+		if !opts.IsSampling && !rangeScanKeyInRange(doc.Key, opts) {
+			continue
+		}
+
+		items = append(items, doc)
+	}
+
+	if opts.IsSampling && uint64(len(items)) > opts.Samples {
+		random := rand.New(rand.NewSource(int64(opts.Seed)))
+		random.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
+		items = items[:opts.Samples]
+	}
+
+	if len(items) == 0 {
+		return nil, ErrDocNotFound
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+
+	return &RangeScanCreateResult{
+		VbUUID: metaState.VbUUID,
+		SeqNo:  highSeqNo,
+		Items:  items,
+	}, nil
+}
+
+func rangeScanKeyInRange(key []byte, opts RangeScanCreateOptions) bool {
+	startCmp := bytes.Compare(key, opts.StartKey)
+	if startCmp < 0 || (startCmp == 0 && opts.ExclusiveStart) {
+		return false
+	}
+
+	if opts.EndKey != nil {
+		endCmp := bytes.Compare(key, opts.EndKey)
+		if endCmp > 0 || (endCmp == 0 && opts.ExclusiveEnd) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// waitForRangeScanSnapshot blocks until the vbucket has reached the required
+// seqno, failing if the vbucket has a different history or if the seqno is not
+// reached within the timeout.
+func (e *Engine) waitForRangeScanSnapshot(vb *mockdb.Vbucket, reqs RangeScanSnapshotRequirements) error {
+	if vb.CurrentMetaState(0).VbUUID != reqs.VbUUID {
+		return ErrVbUUIDMismatch
+	}
+
+	timeoutCh := e.db.Chrono().After(reqs.Timeout)
+	for {
+		changeCh := vb.WatchChanges()
+		if vb.HighSeqNo() >= reqs.SeqNo {
+			break
+		}
+
+		select {
+		case <-changeCh:
+		case <-timeoutCh:
+			return ErrTmpFail
+		}
+	}
+
+	if reqs.SeqNoExists && reqs.SeqNo > 0 {
+		docs, _, err := vb.GetAllWithin(0, reqs.SeqNo-1, reqs.SeqNo)
+		if err != nil || len(docs) == 0 {
+			return ErrDocNotFound
+		}
+	}
+
+	return nil
+}
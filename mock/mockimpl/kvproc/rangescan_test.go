@@ -0,0 +1,132 @@
+package kvproc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRangeScanTestEngine(t *testing.T) *Engine {
+	db, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:      &mocktime.Chrono{},
+		NumReplicas: 0,
+		NumVbuckets: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	e := New(db, []int{0}, [][]int{{0}}, nil)
+	for i := 0; i < 10; i++ {
+		_, err := e.Set(StoreOptions{
+			Key:   []byte(fmt.Sprintf("key%d", i)),
+			Value: []byte("{}"),
+		})
+		if err != nil {
+			t.Fatalf("failed to set document: %v", err)
+		}
+	}
+
+	return e
+}
+
+func rangeScanKeys(res *RangeScanCreateResult) []string {
+	var keys []string
+	for _, item := range res.Items {
+		keys = append(keys, string(item.Key))
+	}
+	return keys
+}
+
+func TestRangeScanRange(t *testing.T) {
+	e := newRangeScanTestEngine(t)
+
+	_, err := e.Delete(DeleteOptions{
+		Key: []byte("key4"),
+	})
+	if err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	res, err := e.RangeScanCreate(RangeScanCreateOptions{
+		StartKey:     []byte("key2"),
+		EndKey:       []byte("key6"),
+		ExclusiveEnd: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create range scan: %v", err)
+	}
+	assert.Equal(t, []string{"key2", "key3", "key5"}, rangeScanKeys(res))
+
+	_, err = e.RangeScanCreate(RangeScanCreateOptions{
+		StartKey: []byte("z"),
+	})
+	assert.Equal(t, ErrDocNotFound, err)
+}
+
+func TestRangeScanSampling(t *testing.T) {
+	e := newRangeScanTestEngine(t)
+
+	res, err := e.RangeScanCreate(RangeScanCreateOptions{
+		IsSampling: true,
+		Samples:    3,
+		Seed:       42,
+	})
+	if err != nil {
+		t.Fatalf("failed to create range scan: %v", err)
+	}
+	assert.Len(t, res.Items, 3)
+
+	res, err = e.RangeScanCreate(RangeScanCreateOptions{
+		IsSampling: true,
+		Samples:    100,
+	})
+	if err != nil {
+		t.Fatalf("failed to create range scan: %v", err)
+	}
+	assert.Len(t, res.Items, 10)
+}
+
+func TestRangeScanSnapshotRequirements(t *testing.T) {
+	e := newRangeScanTestEngine(t)
+	vbUUID := e.db.GetVbucket(0).CurrentMetaState(0).VbUUID
+
+	_, err := e.RangeScanCreate(RangeScanCreateOptions{
+		IsSampling: true,
+		Samples:    1,
+		Snapshot: &RangeScanSnapshotRequirements{
+			VbUUID: vbUUID + 1,
+			SeqNo:  1,
+		},
+	})
+	assert.Equal(t, ErrVbUUIDMismatch, err)
+
+	_, err = e.RangeScanCreate(RangeScanCreateOptions{
+		IsSampling: true,
+		Samples:    1,
+		Snapshot: &RangeScanSnapshotRequirements{
+			VbUUID:  vbUUID,
+			SeqNo:   20,
+			Timeout: 10 * time.Millisecond,
+		},
+	})
+	assert.Equal(t, ErrTmpFail, err)
+
+	res, err := e.RangeScanCreate(RangeScanCreateOptions{
+		IsSampling: true,
+		Samples:    1,
+		Snapshot: &RangeScanSnapshotRequirements{
+			VbUUID:      vbUUID,
+			SeqNo:       10,
+			SeqNoExists: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create range scan: %v", err)
+	}
+	assert.Equal(t, uint64(10), res.SeqNo)
+}
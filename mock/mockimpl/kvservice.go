@@ -1,7 +1,9 @@
 package mockimpl
 
 import (
+	"errors"
 	"net"
+	"sync"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/contrib/scramserver"
@@ -10,8 +12,11 @@ import (
 	"github.com/couchbaselabs/gocaves/mock/mockimpl/servers"
 )
 
+var errClientDisconnected = errors.New("client is disconnected")
+
 // kvClient represents all the state about a connected kv client.
 type kvClient struct {
+	lock    sync.Mutex
 	client  *servers.MemdClient
 	service *kvService
 	isTLS   bool
@@ -21,18 +26,40 @@ type kvClient struct {
 	features              []memd.HelloFeature
 }
 
+// getClient returns the underlying memd client, or nil if the client has
+// already disconnected.  This may be called from any goroutine.
+func (c *kvClient) getClient() *servers.MemdClient {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.client
+}
+
+func (c *kvClient) setClient(client *servers.MemdClient) {
+	c.lock.Lock()
+	c.client = client
+	c.lock.Unlock()
+}
+
 // LocalAddr returns the local address of this client.
 func (c *kvClient) LocalAddr() net.Addr {
-	return c.client.LocalAddr()
+	client := c.getClient()
+	if client == nil {
+		return nil
+	}
+	return client.LocalAddr()
 }
 
 // RemoteAddr returns the remote address of this client.
 func (c *kvClient) RemoteAddr() net.Addr {
-	return c.client.RemoteAddr()
+	client := c.getClient()
+	if client == nil {
+		return nil
+	}
+	return client.RemoteAddr()
 }
 
 // IsTLS returns whether this client is connected via TLS
-// TODO(brett19): Make this return the TLS config for cert-auth.
 func (c *kvClient) IsTLS() bool {
 	return c.isTLS
 }
@@ -40,7 +67,9 @@ func (c *kvClient) IsTLS() bool {
 // ScramServer returns a SCRAM server object specific to this user.
 func (c *kvClient) ScramServer() *scramserver.ScramServer {
 	var scramServer *scramserver.ScramServer
-	c.client.GetContext(&scramServer)
+	if client := c.getClient(); client != nil {
+		client.GetContext(&scramServer)
+	}
 	return scramServer
 }
 
@@ -51,6 +80,16 @@ func (c *kvClient) SetAuthenticatedUserName(userName string) {
 
 // AuthenticatedUserName gets the name of the user who is authenticated.
 func (c *kvClient) AuthenticatedUserName() string {
+	if c.authenticatedUserName == "" && c.isTLS {
+		// Clients which connected with a client certificate are authenticated
+		// by it, and do not need to go through SASL.
+		client := c.getClient()
+		if client == nil {
+			return ""
+		}
+		return clientCertUserName(client.TLSConnectionState(), c.service.Node().Cluster().Users())
+	}
+
 	return c.authenticatedUserName
 }
 
@@ -109,15 +148,24 @@ func (c *kvClient) Source() mock.KvService {
 
 // WritePacket tries to write data to the underlying connection.
 func (c *kvClient) WritePacket(pak *memd.Packet) error {
+	client := c.getClient()
+	if client == nil {
+		return errClientDisconnected
+	}
+
 	if !c.service.clusterNode.cluster.handleKvPacketOut(c, pak) {
 		return nil
 	}
-	return c.client.WritePacket(pak)
+	return client.WritePacket(pak)
 }
 
 // Close attempts to close the connection.
 func (c *kvClient) Close() error {
-	return c.client.Close()
+	client := c.getClient()
+	if client == nil {
+		return errClientDisconnected
+	}
+	return client.Close()
 }
 
 // kvService represents an instance of the kv service.
@@ -156,7 +204,7 @@ func newKvService(parent *clusterNodeInst, opts newKvServiceOptions) (*kvService
 				LostClientHandler: svc.handleLostMemdClient,
 				PacketHandler:     svc.handleMemdPacket,
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig: parent.tlsConfig,
 		})
 		if err != nil {
 			return nil, err
@@ -174,7 +222,7 @@ func (s *kvService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *kvService) Hostname() string {
-	return "127.0.0.1"
+	return s.clusterNode.Hostname()
 }
 
 // ListenPort returns the port this service is listening on.
@@ -236,26 +284,28 @@ func (s *kvService) getKvClient(cli *servers.MemdClient) *kvClient {
 
 func (s *kvService) handleNewMemdClient(cli *servers.MemdClient) {
 	kvCli := s.getKvClient(cli)
-	kvCli.client = cli
+	kvCli.setClient(cli)
 	kvCli.service = s
 	kvCli.isTLS = false
 }
 
 func (s *kvService) handleNewTLSMemdClient(cli *servers.MemdClient) {
 	kvCli := s.getKvClient(cli)
-	kvCli.client = cli
+	kvCli.setClient(cli)
 	kvCli.service = s
 	kvCli.isTLS = true
 }
 
 func (s *kvService) handleLostMemdClient(cli *servers.MemdClient) {
 	kvCli := s.getKvClient(cli)
-	kvCli.client = nil
+	kvCli.setClient(nil)
+
+	s.clusterNode.cluster.handleKvClientLost(kvCli)
 }
 
 func (s *kvService) handleMemdPacket(cli *servers.MemdClient, pak *memd.Packet) {
 	kvCli := s.getKvClient(cli)
-	if kvCli.client == nil {
+	if kvCli.getClient() == nil {
 		return
 	}
 
@@ -0,0 +1,753 @@
+package mockimpl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/golang/snappy"
+)
+
+func testNewKvCluster(t *testing.T, opts mock.NewBucketOptions) (mock.Cluster, mock.Bucket) {
+	return testNewKvClusterWithVersion(t, mock.ServerVersionLatest, opts)
+}
+
+func testNewKvClusterWithVersion(t *testing.T, version mock.ServerVersion, opts mock.NewBucketOptions) (mock.Cluster, mock.Bucket) {
+	cluster, err := NewCluster(mock.NewClusterOptions{
+		NumVbuckets:   16,
+		ServerVersion: version,
+	})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %v", err)
+	}
+
+	err = cluster.Users().UpsertUser(mockauth.UpsertUserOptions{
+		Username: "Administrator",
+		Password: "password",
+		Roles:    []string{"admin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	opts.Type = mock.BucketTypeCouchbase
+
+	bucket, err := cluster.AddBucket(opts)
+	if err != nil {
+		t.Fatalf("failed to add bucket: %v", err)
+	}
+
+	return cluster, bucket
+}
+
+// testKvConn is a connection to the kv service which has been negotiated,
+// authenticated and has selected a bucket.
+type testKvConn struct {
+	t      *testing.T
+	conn   net.Conn
+	mconn  *memd.Conn
+	opaque uint32
+}
+
+func testDialKv(t *testing.T, cluster mock.Cluster, bucket mock.Bucket, features ...memd.HelloFeature) *testKvConn {
+	kvPort := cluster.Nodes()[0].KvService().ListenPort()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", kvPort))
+	if err != nil {
+		t.Fatalf("failed to connect to kv: %v", err)
+	}
+
+	c := &testKvConn{
+		t:     t,
+		conn:  conn,
+		mconn: memd.NewConn(conn),
+	}
+
+	helloBuf := make([]byte, len(features)*2)
+	for featureIdx, feature := range features {
+		binary.BigEndian.PutUint16(helloBuf[featureIdx*2:], uint16(feature))
+	}
+	resp := c.mustRequest(&memd.Packet{
+		Command: memd.CmdHello,
+		Value:   helloBuf,
+	})
+	for featureIdx := 0; featureIdx < len(resp.Value)/2; featureIdx++ {
+		c.mconn.EnableFeature(memd.HelloFeature(binary.BigEndian.Uint16(resp.Value[featureIdx*2:])))
+	}
+
+	c.mustRequest(&memd.Packet{
+		Command: memd.CmdSASLAuth,
+		Key:     []byte("PLAIN"),
+		Value:   []byte("\x00Administrator\x00password"),
+	})
+	c.mustRequest(&memd.Packet{
+		Command: memd.CmdSelectBucket,
+		Key:     []byte(bucket.Name()),
+	})
+
+	return c
+}
+
+// Request writes a request and returns the response to it, skipping over any
+// server initiated requests which arrive in the meantime.
+func (c *testKvConn) Request(pak *memd.Packet) *memd.Packet {
+	c.opaque++
+	pak.Magic = memd.CmdMagicReq
+	pak.Opaque = c.opaque
+
+	if err := c.mconn.WritePacket(pak); err != nil {
+		c.t.Fatalf("failed to write %s: %v", pak.Command.Name(), err)
+	}
+
+	for {
+		resp := c.Read()
+		if resp.Magic == memd.CmdMagicRes && resp.Opaque == pak.Opaque {
+			return resp
+		}
+	}
+}
+
+func (c *testKvConn) mustRequest(pak *memd.Packet) *memd.Packet {
+	resp := c.Request(pak)
+	if resp.Status != memd.StatusSuccess {
+		c.t.Fatalf("expected %s to succeed, got %v", pak.Command.Name(), resp.Status)
+	}
+	return resp
+}
+
+// Read reads the next packet sent by the server.
+func (c *testKvConn) Read() *memd.Packet {
+	pak := c.TryRead(5 * time.Second)
+	if pak == nil {
+		c.t.Fatalf("timed out waiting for packet")
+	}
+	return pak
+}
+
+// TryRead reads the next packet sent by the server, returning nil if none
+// arrives within the timeout.
+func (c *testKvConn) TryRead(timeout time.Duration) *memd.Packet {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	pak, _, err := c.mconn.ReadPacket()
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil
+	} else if err != nil {
+		c.t.Fatalf("failed to read packet: %v", err)
+	}
+	return pak
+}
+
+func (c *testKvConn) Close() {
+	_ = c.conn.Close()
+}
+
+func (c *testKvConn) openDcp() *memd.Packet {
+	openExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(openExtras[4:], uint32(memd.DcpOpenFlagProducer))
+	return c.mustRequest(&memd.Packet{
+		Command: memd.CmdDcpOpenConnection,
+		Key:     []byte("test"),
+		Extras:  openExtras,
+	})
+}
+
+func TestDcpDisconnectReleasesStreams(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	conn.openDcp()
+
+	numGoroutines := runtime.NumGoroutine()
+
+	numVbuckets := len(bucket.VbucketOwnership(cluster.Nodes()[0]))
+	for vbID := 0; vbID < numVbuckets; vbID++ {
+		streamExtras := make([]byte, 48)
+		binary.BigEndian.PutUint64(streamExtras[16:], 0xffffffffffffffff)
+		conn.mustRequest(&memd.Packet{
+			Command: memd.CmdDcpStreamReq,
+			Vbucket: uint16(vbID),
+			Extras:  streamExtras,
+		})
+	}
+
+	if runtime.NumGoroutine() < numGoroutines+numVbuckets {
+		t.Fatalf("expected a goroutine to be running for each stream")
+	}
+
+	conn.Close()
+
+	// Nothing is ever written to the streams, so they can only stop because
+	// the server noticed that the client went away.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > numGoroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected streams to be released after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDcpNoops(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	conn.openDcp()
+
+	setControl := func(key, value string) {
+		conn.mustRequest(&memd.Packet{
+			Command: memd.CmdDcpControl,
+			Key:     []byte(key),
+			Value:   []byte(value),
+		})
+	}
+	setControl("set_noop_interval", "60")
+	setControl("enable_noop", "true")
+	setControl("enable_noop", "true")
+
+	if pak := conn.TryRead(100 * time.Millisecond); pak != nil {
+		t.Fatalf("expected no noops before the interval passed, got %s", pak.Command.Name())
+	}
+
+	cluster.Chrono().TimeTravel(60 * time.Second)
+
+	pak := conn.Read()
+	if pak.Magic != memd.CmdMagicReq || pak.Command != memd.CmdDcpNoop {
+		t.Fatalf("expected a noop request, got %s", pak.Command.Name())
+	}
+	if pak := conn.TryRead(100 * time.Millisecond); pak != nil {
+		t.Fatalf("expected only a single noop to be sent, got %s", pak.Command.Name())
+	}
+}
+
+func TestDurabilityMinLevelWithoutLevel(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{
+		DurabilityMinLevel: mock.DurabilityLevelMajorityAndPersistActive,
+	})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	// A write that doesn't ask for durability still has to wait for the bucket
+	// minimum level, which means waiting for the active to persist it.
+	writeStart := time.Now()
+	conn.mustRequest(&memd.Packet{
+		Command: memd.CmdSet,
+		Key:     []byte("key"),
+		Extras:  make([]byte, 8),
+		Value:   []byte(`{}`),
+	})
+	if elapsed := time.Since(writeStart); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected write to wait for persistence, took %v", elapsed)
+	}
+}
+
+func TestDurabilityMinLevelUpgradesLevel(t *testing.T) {
+	testSet := func(minLevel mock.DurabilityLevel, level memd.DurabilityLevel) memd.StatusCode {
+		cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{
+			DurabilityMinLevel: minLevel,
+		})
+		conn := testDialKv(t, cluster, bucket, memd.FeatureAltRequests, memd.FeatureSyncReplication)
+		defer conn.Close()
+
+		// Only a majority (which is just the active) can be reached within the
+		// timeout, anything involving persistence cannot.
+		return conn.Request(&memd.Packet{
+			Command: memd.CmdSet,
+			Key:     []byte("key"),
+			Extras:  make([]byte, 8),
+			Value:   []byte(`{}`),
+			DurabilityLevelFrame: &memd.DurabilityLevelFrame{
+				DurabilityLevel: level,
+			},
+			DurabilityTimeoutFrame: &memd.DurabilityTimeoutFrame{
+				DurabilityTimeout: 50 * time.Millisecond,
+			},
+		}).Status
+	}
+
+	if status := testSet(mock.DurabilityLevelNone, memd.DurabilityLevelMajority); status != memd.StatusSuccess {
+		t.Fatalf("expected majority write to succeed, got %v", status)
+	}
+	if status := testSet(mock.DurabilityLevelPersistToMajority, memd.DurabilityLevelMajority); status != memd.StatusSyncWriteAmbiguous {
+		t.Fatalf("expected majority write to be upgraded to persist to majority, got %v", status)
+	}
+	if status := testSet(mock.DurabilityLevelMajority, memd.DurabilityLevelPersistToMajority); status != memd.StatusSyncWriteAmbiguous {
+		t.Fatalf("expected persist to majority write not to be downgraded, got %v", status)
+	}
+}
+
+func TestDurabilityImpossible(t *testing.T) {
+	// Our cluster only has a single node, so none of the replicas exist.
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{
+		NumReplicas:        1,
+		DurabilityMinLevel: mock.DurabilityLevelMajority,
+	})
+	conn := testDialKv(t, cluster, bucket, memd.FeatureAltRequests, memd.FeatureSyncReplication)
+	defer conn.Close()
+
+	resp := conn.Request(&memd.Packet{
+		Command: memd.CmdSet,
+		Key:     []byte("key"),
+		Extras:  make([]byte, 8),
+		Value:   []byte(`{}`),
+	})
+	if resp.Status != memd.StatusDurabilityImpossible {
+		t.Fatalf("expected write without a level to be impossible, got %v", resp.Status)
+	}
+
+	resp = conn.Request(&memd.Packet{
+		Command: memd.CmdDelete,
+		Key:     []byte("key"),
+		DurabilityLevelFrame: &memd.DurabilityLevelFrame{
+			DurabilityLevel: memd.DurabilityLevelMajority,
+		},
+	})
+	if resp.Status != memd.StatusDurabilityImpossible {
+		t.Fatalf("expected durable delete to be impossible, got %v", resp.Status)
+	}
+}
+
+func TestSnappyRejectsInvalidValues(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+
+	compressed := snappy.Encode(nil, []byte(`{"name":"mock"}`))
+	setPacket := func(value []byte) *memd.Packet {
+		return &memd.Packet{
+			Command:  memd.CmdSet,
+			Datatype: uint8(memd.DatatypeFlagCompressed),
+			Key:      []byte("snappy"),
+			Extras:   make([]byte, 8),
+			Value:    value,
+		}
+	}
+
+	plainConn := testDialKv(t, cluster, bucket)
+	defer plainConn.Close()
+
+	resp := plainConn.Request(setPacket(compressed))
+	if resp.Status != memd.StatusInvalidArgs {
+		t.Fatalf("expected compressed value without snappy to be rejected, got %v", resp.Status)
+	}
+
+	snappyConn := testDialKv(t, cluster, bucket, memd.FeatureSnappy)
+	defer snappyConn.Close()
+
+	resp = snappyConn.Request(setPacket([]byte("not snappy at all")))
+	if resp.Status != memd.StatusInvalidArgs {
+		t.Fatalf("expected corrupt snappy value to be rejected, got %v", resp.Status)
+	}
+
+	snappyConn.mustRequest(setPacket(compressed))
+
+	resp = plainConn.mustRequest(&memd.Packet{
+		Command: memd.CmdGet,
+		Key:     []byte("snappy"),
+	})
+	if string(resp.Value) != `{"name":"mock"}` || resp.Datatype&uint8(memd.DatatypeFlagCompressed) != 0 {
+		t.Fatalf("expected the value to be stored inflated, got %q", resp.Value)
+	}
+}
+
+func TestSnappyCompressionModes(t *testing.T) {
+	compressible := []byte(strings.Repeat("compressible", 100))
+	incompressible := make([]byte, 1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(incompressible)
+	if float64(len(incompressible)) >= float64(len(snappy.Encode(nil, incompressible)))*1.2 {
+		t.Fatalf("expected test value to be incompressible")
+	}
+
+	testGet := func(mode mock.CompressionMode, features []memd.HelloFeature, value []byte) bool {
+		cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{
+			CompressionMode: mode,
+		})
+		conn := testDialKv(t, cluster, bucket, features...)
+		defer conn.Close()
+
+		conn.mustRequest(&memd.Packet{
+			Command: memd.CmdSet,
+			Key:     []byte("value"),
+			Extras:  make([]byte, 8),
+			Value:   value,
+		})
+		resp := conn.mustRequest(&memd.Packet{
+			Command: memd.CmdGet,
+			Key:     []byte("value"),
+		})
+
+		if resp.Datatype&uint8(memd.DatatypeFlagCompressed) == 0 {
+			if !bytes.Equal(resp.Value, value) {
+				t.Fatalf("expected inflated value to be returned unchanged")
+			}
+			return false
+		}
+
+		inflated, err := snappy.Decode(nil, resp.Value)
+		if err != nil || !bytes.Equal(inflated, value) {
+			t.Fatalf("expected compressed value to inflate to the original value (%v)", err)
+		}
+		return true
+	}
+
+	withSnappy := []memd.HelloFeature{memd.FeatureSnappy}
+
+	if !testGet(mock.CompressionModeActive, withSnappy, compressible) {
+		t.Fatalf("expected active mode to compress values for snappy clients")
+	}
+	if testGet(mock.CompressionModeActive, nil, compressible) {
+		t.Fatalf("expected active mode not to compress values for other clients")
+	}
+	if testGet(mock.CompressionModeActive, withSnappy, incompressible) {
+		t.Fatalf("expected active mode not to compress values below the minimum ratio")
+	}
+	if testGet(mock.CompressionModePassive, withSnappy, compressible) {
+		t.Fatalf("expected passive mode not to compress values")
+	}
+	if testGet(mock.CompressionModeOff, withSnappy, compressible) {
+		t.Fatalf("expected off mode not to compress values")
+	}
+}
+
+func TestSnappyPassiveMode(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{
+		CompressionMode: mock.CompressionModePassive,
+	})
+
+	snappyConn := testDialKv(t, cluster, bucket, memd.FeatureSnappy)
+	defer snappyConn.Close()
+	plainConn := testDialKv(t, cluster, bucket)
+	defer plainConn.Close()
+
+	value := []byte(strings.Repeat("compressible", 100))
+	compressed := snappy.Encode(nil, value)
+	snappyConn.mustRequest(&memd.Packet{
+		Command:  memd.CmdSet,
+		Datatype: uint8(memd.DatatypeFlagCompressed),
+		Key:      []byte("compressed"),
+		Extras:   make([]byte, 8),
+		Value:    compressed,
+	})
+	snappyConn.mustRequest(&memd.Packet{
+		Command: memd.CmdSet,
+		Key:     []byte("inflated"),
+		Extras:  make([]byte, 8),
+		Value:   value,
+	})
+
+	testGet := func(conn *testKvConn, key string) *memd.Packet {
+		return conn.mustRequest(&memd.Packet{
+			Command: memd.CmdGet,
+			Key:     []byte(key),
+		})
+	}
+
+	resp := testGet(snappyConn, "compressed")
+	if resp.Datatype&uint8(memd.DatatypeFlagCompressed) == 0 || !bytes.Equal(resp.Value, compressed) {
+		t.Fatalf("expected value to be returned as it was sent to snappy clients")
+	}
+	resp = testGet(plainConn, "compressed")
+	if resp.Datatype&uint8(memd.DatatypeFlagCompressed) != 0 || !bytes.Equal(resp.Value, value) {
+		t.Fatalf("expected value to be inflated for other clients")
+	}
+	resp = testGet(snappyConn, "inflated")
+	if resp.Datatype&uint8(memd.DatatypeFlagCompressed) != 0 || !bytes.Equal(resp.Value, value) {
+		t.Fatalf("expected value which was sent inflated not to be compressed")
+	}
+
+	// Modifying the value of a compressed document works on its inflated form.
+	snappyConn.mustRequest(&memd.Packet{
+		Command: memd.CmdAppend,
+		Key:     []byte("compressed"),
+		Value:   []byte("!"),
+	})
+	resp = testGet(plainConn, "compressed")
+	if !bytes.Equal(resp.Value, append(value, '!')) {
+		t.Fatalf("expected append to apply to the inflated value, got %q", resp.Value)
+	}
+}
+
+func TestOpenTracingFrame(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+
+	traceCtx := []byte(`{"uber-trace-id":"1:2:0:1"}`)
+	seenTraceCtx := make(chan []byte, 1)
+	cluster.KvInHooks().Add(func(source mock.KvClient, pak *memd.Packet, start time.Time, next func()) {
+		if pak.Command == memd.CmdGet && pak.OpenTracingFrame != nil {
+			seenTraceCtx <- pak.OpenTracingFrame.TraceContext
+		}
+		next()
+	})
+
+	conn := testDialKv(t, cluster, bucket,
+		memd.FeatureAltRequests, memd.FeatureOpenTracing, memd.FeatureDurations)
+	defer conn.Close()
+
+	resp := conn.Request(&memd.Packet{
+		Command: memd.CmdGet,
+		Key:     []byte("missing"),
+		OpenTracingFrame: &memd.OpenTracingFrame{
+			TraceContext: traceCtx,
+		},
+	})
+	if resp.Status != memd.StatusKeyNotFound {
+		t.Fatalf("expected get to fail with key not found, got %v", resp.Status)
+	}
+	if resp.ServerDurationFrame == nil {
+		t.Fatalf("expected a server duration on the response")
+	}
+
+	select {
+	case seen := <-seenTraceCtx:
+		if !bytes.Equal(seen, traceCtx) {
+			t.Fatalf("expected hook to see trace context %s, got %s", traceCtx, seen)
+		}
+	default:
+		t.Fatalf("expected hook to see the open tracing frame")
+	}
+}
+
+func TestServerDurationOnlyOnResponses(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket, memd.FeatureDurations)
+	defer conn.Close()
+
+	resp := conn.openDcp()
+	if resp.ServerDurationFrame == nil {
+		t.Fatalf("expected a server duration on the response")
+	}
+
+	setControl := func(key, value string) {
+		conn.mustRequest(&memd.Packet{
+			Command: memd.CmdDcpControl,
+			Key:     []byte(key),
+			Value:   []byte(value),
+		})
+	}
+	setControl("set_noop_interval", "1")
+	setControl("enable_noop", "true")
+
+	pak := conn.Read()
+	if pak.Command != memd.CmdDcpNoop {
+		t.Fatalf("expected a noop request, got %s", pak.Command.Name())
+	}
+	if pak.ServerDurationFrame != nil {
+		t.Fatalf("expected no server duration on noop requests")
+	}
+
+	// Our memd client cannot decode cluster map notifications, so we watch for
+	// them as they are sent instead.
+	notifCh := make(chan *memd.Packet, 1)
+	cluster.KvOutHooks().Add(func(source mock.KvClient, pak *memd.Packet, start time.Time, next func()) {
+		if pak.Command == mock.CmdClusterMapChangeNotification {
+			select {
+			case notifCh <- pak:
+			default:
+			}
+		}
+		next()
+	})
+
+	notifConn := testDialKv(t, cluster, bucket,
+		memd.FeatureDurations, memd.FeatureDuplex, memd.FeatureClusterMapNotif)
+	defer notifConn.Close()
+
+	if _, err := cluster.AddNode(mock.NewNodeOptions{}); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+
+	select {
+	case pak := <-notifCh:
+		if pak.ServerDurationFrame != nil {
+			t.Fatalf("expected no server duration on cluster map notifications")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a cluster map notification to be sent")
+	}
+}
+
+func TestClusterMapNotifLostClient(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+
+	numWatchers := func() int {
+		clusterInst := cluster.(*clusterInst)
+		clusterInst.configWatcherLock.Lock()
+		defer clusterInst.configWatcherLock.Unlock()
+		return len(clusterInst.configWatchers)
+	}
+	baseWatchers := numWatchers()
+
+	conn := testDialKv(t, cluster, bucket, memd.FeatureDuplex, memd.FeatureClusterMapNotif)
+	if numWatchers() != baseWatchers+1 {
+		t.Fatalf("expected a config watcher to be added for the client")
+	}
+
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for numWatchers() != baseWatchers {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the config watcher to be removed once the client went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnknownCollection(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket, memd.FeatureCollections)
+	defer conn.Close()
+
+	// Enough collections are added that decimal and hex uids differ.
+	manifest := bucket.CollectionManifest()
+	for i := 0; i < 11; i++ {
+		if _, err := manifest.AddCollection("_default", fmt.Sprintf("col%d", i), 0); err != nil {
+			t.Fatalf("failed to add collection: %v", err)
+		}
+	}
+	_, collectionID, err := manifest.GetByName("_default", "col10")
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+
+	checkUnknown := func(resp *memd.Packet) {
+		if resp.Status != memd.StatusCollectionUnknown {
+			t.Fatalf("expected unknown collection for %s, got %v", resp.Command.Name(), resp.Status)
+		}
+
+		var body struct {
+			ManifestUID string `json:"manifest_uid"`
+		}
+		if err := json.Unmarshal(resp.Value, &body); err != nil {
+			t.Fatalf("failed to unmarshal unknown collection body: %v", err)
+		}
+		if expected := strconv.FormatUint(manifest.UID(), 16); body.ManifestUID != expected {
+			t.Fatalf("expected manifest uid %s, got %s", expected, body.ManifestUID)
+		}
+	}
+
+	checkUnknown(conn.Request(&memd.Packet{
+		Command:      memd.CmdGet,
+		CollectionID: 0xff,
+		Key:          []byte("key"),
+	}))
+
+	// The memd encoder moves the collection ID into the extras for GetRandom.
+	checkUnknown(conn.Request(&memd.Packet{
+		Command:      memd.CmdGetRandom,
+		CollectionID: 0xff,
+	}))
+
+	resp := conn.Request(&memd.Packet{
+		Command:      memd.CmdGet,
+		CollectionID: collectionID,
+		Key:          []byte("key"),
+	})
+	if resp.Status != memd.StatusKeyNotFound {
+		t.Fatalf("expected get from a known collection to reach the document, got %v", resp.Status)
+	}
+}
+
+func (c *testKvConn) createRangeScan() []byte {
+	for _, key := range []string{"a", "b", "c"} {
+		c.mustRequest(&memd.Packet{
+			Command: memd.CmdSet,
+			Key:     []byte(key),
+			Extras:  make([]byte, 8),
+			Value:   []byte(`{}`),
+		})
+	}
+
+	resp := c.mustRequest(&memd.Packet{
+		Command: mock.CmdRangeScanCreate,
+		Value:   []byte(`{"key_only":true,"sampling":{"samples":10}}`),
+	})
+	return resp.Value
+}
+
+func (c *testKvConn) continueRangeScan(scanID []byte) memd.StatusCode {
+	extras := make([]byte, 28)
+	copy(extras, scanID)
+	binary.BigEndian.PutUint32(extras[16:], 1)
+
+	return c.Request(&memd.Packet{
+		Command: mock.CmdRangeScanContinue,
+		Extras:  extras,
+	}).Status
+}
+
+func TestRangeScanExpiresWhenIdle(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	scanID := conn.createRangeScan()
+
+	// Continuing the scan keeps it alive for another idle period.
+	cluster.Chrono().TimeTravel(40 * time.Second)
+	if status := conn.continueRangeScan(scanID); status != mock.StatusRangeScanMore {
+		t.Fatalf("expected scan to still be active, got %v", status)
+	}
+	cluster.Chrono().TimeTravel(40 * time.Second)
+	if status := conn.continueRangeScan(scanID); status != mock.StatusRangeScanMore {
+		t.Fatalf("expected scan to still be active, got %v", status)
+	}
+
+	cluster.Chrono().TimeTravel(60 * time.Second)
+	if status := conn.continueRangeScan(scanID); status != memd.StatusKeyNotFound {
+		t.Fatalf("expected idle scan to have been cancelled, got %v", status)
+	}
+}
+
+func TestRangeScanRemovedOnDisconnect(t *testing.T) {
+	cluster, bucket := testNewKvCluster(t, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	otherConn := testDialKv(t, cluster, bucket)
+	defer otherConn.Close()
+
+	scanID := conn.createRangeScan()
+	conn.Close()
+
+	// Clients are only removed from the service once their disconnection has
+	// been fully handled.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cluster.Nodes()[0].KvService().GetAllClients()) > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the client to disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status := otherConn.continueRangeScan(scanID); status != memd.StatusKeyNotFound {
+		t.Fatalf("expected scan to be removed after its client disconnected, got %v", status)
+	}
+}
+
+func TestRangeScanUnsupportedVersion(t *testing.T) {
+	cluster, bucket := testNewKvClusterWithVersion(t, mock.ServerVersion70, mock.NewBucketOptions{})
+	conn := testDialKv(t, cluster, bucket)
+	defer conn.Close()
+
+	for _, cmd := range []memd.CmdCode{mock.CmdRangeScanCreate, mock.CmdRangeScanContinue, mock.CmdRangeScanCancel} {
+		resp := conn.Request(&memd.Packet{
+			Command: cmd,
+		})
+		if resp.Status != memd.StatusUnknownCommand {
+			t.Fatalf("expected %s to be unknown before 7.2, got %v", cmd.Name(), resp.Status)
+		}
+	}
+}
@@ -38,7 +38,7 @@ func newQueryService(parent *clusterNodeInst, opts newQueryServiceOptions) (*que
 			Handlers: servers.HTTPServerHandlers{
 				NewRequestHandler: svc.handleNewRequest,
 			},
-			TLSConfig: parent.cluster.tlsConfig,
+			TLSConfig: parent.tlsConfig,
 		})
 		if err != nil {
 			return nil, err
@@ -56,7 +56,7 @@ func (s *queryService) Node() mock.ClusterNode {
 
 // Hostname returns the hostname where this service can be accessed.
 func (s *queryService) Hostname() string {
-	return "127.0.0.1"
+	return s.clusterNode.Hostname()
 }
 
 // ListenPort returns the port this service is listening on.
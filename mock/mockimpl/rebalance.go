@@ -0,0 +1,156 @@
+package mockimpl
+
+import (
+	"errors"
+	"log"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// rebalanceState tracks the progress of a rebalance which is moving vbuckets
+// over time.
+type rebalanceState struct {
+	opts     mock.RebalanceOptions
+	buckets  []*bucketInst
+	numMoves int
+	numMoved int
+}
+
+// Rebalance will move the vbuckets of every bucket such that they are laid
+// out over the active nodes.  The vbuckets are moved in batches over time,
+// with this call returning as soon as the rebalance has started.
+func (c *clusterInst) Rebalance(opts mock.RebalanceOptions) error {
+	if opts.BatchSize == 0 {
+		if c.isRebalancing() {
+			return errors.New("a rebalance is already running")
+		}
+
+		c.rebalance()
+		c.updateConfig()
+		return nil
+	}
+
+	c.rebalanceLock.Lock()
+
+	if c.rebalanceState != nil {
+		c.rebalanceLock.Unlock()
+		return errors.New("a rebalance is already running")
+	}
+
+	state := &rebalanceState{
+		opts: opts,
+	}
+
+	nodeUuids := c.nodeUuids()
+	for _, bucket := range c.buckets {
+		numMoves := bucket.startRebalance(bucket.genVbMap(nodeUuids))
+		if numMoves > 0 {
+			state.buckets = append(state.buckets, bucket)
+			state.numMoves += numMoves
+		}
+	}
+
+	if state.numMoves == 0 {
+		c.rebalanceLock.Unlock()
+		return nil
+	}
+
+	c.rebalanceState = state
+	c.rebalanceLock.Unlock()
+
+	// Publish the forward vbmaps before any of the vbuckets start moving.
+	c.updateConfig()
+
+	log.Printf("rebalance started, %d vbuckets to move", state.numMoves)
+
+	c.chrono.AfterFunc(opts.BatchInterval, func() {
+		c.stepRebalance(state)
+	})
+
+	return nil
+}
+
+// stepRebalance moves the next batch of vbuckets for a rebalance, and then
+// schedules the following step if there are still vbuckets left to move.
+func (c *clusterInst) stepRebalance(state *rebalanceState) {
+	c.rebalanceLock.Lock()
+
+	if c.rebalanceState != state {
+		// This rebalance was stopped while the step was pending.
+		c.rebalanceLock.Unlock()
+		return
+	}
+
+	batchMoves := 0
+	for _, bucket := range state.buckets {
+		remaining := int(state.opts.BatchSize) - batchMoves
+		if remaining <= 0 {
+			break
+		}
+		batchMoves += bucket.stepRebalance(remaining)
+	}
+	state.numMoved += batchMoves
+
+	// If nothing moved then something else has already put the vbuckets
+	// where they belong, so there is nothing left for us to do.
+	isDone := batchMoves == 0 || state.numMoved >= state.numMoves
+	if isDone {
+		for _, bucket := range state.buckets {
+			bucket.stopRebalance()
+		}
+		c.rebalanceState = nil
+	}
+
+	c.rebalanceLock.Unlock()
+
+	c.updateConfig()
+
+	if isDone {
+		log.Printf("rebalance completed")
+		return
+	}
+
+	c.chrono.AfterFunc(state.opts.BatchInterval, func() {
+		c.stepRebalance(state)
+	})
+}
+
+// stopRebalance abandons any rebalance which is running, leaving vbuckets
+// which have not been moved yet where they are.
+func (c *clusterInst) stopRebalance() {
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	state := c.rebalanceState
+	if state == nil {
+		return
+	}
+
+	for _, bucket := range state.buckets {
+		bucket.stopRebalance()
+	}
+	c.rebalanceState = nil
+
+	log.Printf("rebalance stopped")
+}
+
+func (c *clusterInst) isRebalancing() bool {
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	return c.rebalanceState != nil
+}
+
+// RebalanceProgress returns whether a rebalance is running, and if so how
+// far through it is, between 0 and 1.
+func (c *clusterInst) RebalanceProgress() (bool, float64) {
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+
+	state := c.rebalanceState
+	if state == nil {
+		return false, 0
+	}
+
+	return true, float64(state.numMoved) / float64(state.numMoves)
+}
@@ -8,6 +8,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/couchbaselabs/gocaves/mock"
 )
@@ -27,6 +29,10 @@ type HTTPServer struct {
 	handlers   HTTPServerHandlers
 	server     *http.Server
 	tlsConfig  *tls.Config
+	offline    uint32
+
+	connsLock sync.Mutex
+	conns     map[net.Conn]struct{}
 }
 
 // NewHTTPServiceOptions enables the specification of default options for a new http server.
@@ -42,6 +48,7 @@ func NewHTTPServer(opts NewHTTPServiceOptions) (*HTTPServer, error) {
 		name:      opts.Name,
 		handlers:  opts.Handlers,
 		tlsConfig: opts.TLSConfig,
+		conns:     make(map[net.Conn]struct{}),
 	}
 
 	err := svc.start()
@@ -92,7 +99,8 @@ func (s *HTTPServer) start() error {
 	s.listener = lsnr
 
 	srv := &http.Server{
-		Handler: http.HandlerFunc(s.handleHTTP),
+		Handler:   http.HandlerFunc(s.handleHTTP),
+		ConnState: s.handleConnState,
 	}
 	s.server = srv
 
@@ -125,7 +133,51 @@ func (s *HTTPServer) Close() error {
 	return nil
 }
 
+// SetOffline marks this server as being offline or back online.  While it is
+// offline, all existing connections are dropped and every request is answered
+// by dropping its connection.
+func (s *HTTPServer) SetOffline(offline bool) {
+	if !offline {
+		atomic.StoreUint32(&s.offline, 0)
+		return
+	}
+
+	atomic.StoreUint32(&s.offline, 1)
+
+	s.connsLock.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.connsLock.Unlock()
+}
+
+func (s *HTTPServer) handleConnState(conn net.Conn, state http.ConnState) {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	switch state {
+	case http.StateNew:
+		s.conns[conn] = struct{}{}
+	case http.StateHijacked, http.StateClosed:
+		delete(s.conns, conn)
+	}
+}
+
 func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadUint32(&s.offline) == 1 {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			w.WriteHeader(503)
+			return
+		}
+
+		conn, _, err := hijacker.Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+		return
+	}
+
 	if err := req.ParseForm(); err != nil {
 		// If the content type isn't form then ParseForm will not error, to get here something
 		// is wrong with the request.
@@ -147,6 +199,7 @@ func (s *HTTPServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
 		Form:    req.Form,
 		Context: req.Context(),
 		Flusher: flusher,
+		TLS:     req.TLS,
 	})
 	if resp == nil {
 		// If nobody decides to answer the request, we write 501 Unsupported.
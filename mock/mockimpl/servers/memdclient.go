@@ -1,11 +1,13 @@
 package servers
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"net"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/contrib/ctxstore"
+	"github.com/couchbaselabs/gocaves/mock"
 )
 
 // MemdClient represents a connected memd client.
@@ -23,14 +25,10 @@ func newMemdClient(parent *MemdServer, conn net.Conn) (*MemdClient, error) {
 	mconn := memd.NewConn(conn)
 
 	cli := &MemdClient{
-		parent: parent,
-		conn:   conn,
-		mconn:  mconn,
-	}
-
-	err := cli.start()
-	if err != nil {
-		return nil, err
+		parent:      parent,
+		conn:        conn,
+		mconn:       mconn,
+		closeWaitCh: make(chan struct{}),
 	}
 
 	return cli, nil
@@ -60,14 +58,43 @@ func (c *MemdClient) WritePacket(pak *memd.Packet) error {
 		}
 	}
 
+	// The memd package has no knowledge of server initiated requests, so we
+	// need to encode those ourselves.
+	if pak.Magic == mock.CmdMagicServerReq {
+		return c.writeServerRequest(pak)
+	}
+
 	// Actually write the packet.  Note that it is critical that the features we enable above
 	// don't actually affect how the HELLO packet is being written.
 	return c.mconn.WritePacket(pak)
 }
 
-func (c *MemdClient) start() error {
-	c.closeWaitCh = make(chan struct{})
+// writeServerRequest encodes and writes a server initiated request packet.
+// These never carry framing extras, so the encoding is simple.
+func (c *MemdClient) writeServerRequest(pak *memd.Packet) error {
+	buffer := make([]byte, 24+len(pak.Extras)+len(pak.Key)+len(pak.Value))
+
+	buffer[0] = uint8(pak.Magic)
+	buffer[1] = uint8(pak.Command)
+	binary.BigEndian.PutUint16(buffer[2:], uint16(len(pak.Key)))
+	buffer[4] = uint8(len(pak.Extras))
+	buffer[5] = pak.Datatype
+	binary.BigEndian.PutUint32(buffer[8:], uint32(len(buffer)-24))
+	binary.BigEndian.PutUint32(buffer[12:], pak.Opaque)
+	binary.BigEndian.PutUint64(buffer[16:], pak.Cas)
+
+	bodyPos := 24
+	bodyPos += copy(buffer[bodyPos:], pak.Extras)
+	bodyPos += copy(buffer[bodyPos:], pak.Key)
+	copy(buffer[bodyPos:], pak.Value)
+
+	_, err := c.conn.Write(buffer)
+	return err
+}
 
+// start begins reading packets from the client.  This must only be called once
+// the client has been fully set up by its handlers.
+func (c *MemdClient) start() error {
 	go func() {
 		for {
 			pak, _, err := c.mconn.ReadPacket()
@@ -98,6 +125,18 @@ func (c *MemdClient) Close() error {
 	return err
 }
 
+// TLSConnectionState returns the state of the TLS connection of this client,
+// or nil if the client did not connect via TLS.
+func (c *MemdClient) TLSConnectionState() *tls.ConnectionState {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := tlsConn.ConnectionState()
+	return &state
+}
+
 // GetContext gets arbitrary context associated with this client
 func (c *MemdClient) GetContext(valuePtr interface{}) {
 	c.ctxStore.Get(valuePtr)
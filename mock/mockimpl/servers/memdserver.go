@@ -25,6 +25,7 @@ type MemdServer struct {
 	listener   net.Listener
 	handlers   MemdServerHandlers
 	tlsConfig  *tls.Config
+	offline    bool
 
 	clients []*MemdClient
 }
@@ -79,6 +80,7 @@ func (s *MemdServer) start() error {
 	tcpAddr := addr.(*net.TCPAddr)
 	s.listenPort = tcpAddr.Port
 	s.localAddr = addr.String()
+	s.listener = lsnr
 
 	if s.tlsConfig != nil {
 		log.Printf("starting listener for kv (memd) TLS server on port %d", s.listenPort)
@@ -100,13 +102,34 @@ func (s *MemdServer) start() error {
 				break
 			}
 
+			// We check whether we are offline under the same lock which adds the
+			// client so that SetOffline cannot miss it when dropping clients.
 			s.lock.Lock()
 
+			if s.offline {
+				s.lock.Unlock()
+
+				// Offline servers immediately drop any new connections.
+				_ = conn.Close()
+				continue
+			}
+
 			s.clients = append(s.clients, client)
 
 			s.lock.Unlock()
 
 			s.handlers.NewClientHandler(client)
+
+			err = client.start()
+			if err != nil {
+				log.Printf("failed to start memd client: %s", err)
+
+				// Only this client is affected, so we drop it and keep accepting
+				// new connections.
+				_ = conn.Close()
+				s.handleClientDisconnect(client)
+				continue
+			}
 		}
 	}()
 
@@ -143,6 +166,19 @@ func (s *MemdServer) handleClientDisconnect(client *MemdClient) {
 	s.lock.Unlock()
 }
 
+// SetOffline marks this server as being offline or back online.  While it is
+// offline, all existing clients are dropped and new connections are closed as
+// soon as they are accepted.
+func (s *MemdServer) SetOffline(offline bool) {
+	s.lock.Lock()
+	s.offline = offline
+	s.lock.Unlock()
+
+	if offline {
+		s.closeAllClients()
+	}
+}
+
 // Close causes this memd server to be forcefully stopped and all clients dropped.
 func (s *MemdServer) Close() error {
 	err := s.listener.Close()
@@ -150,6 +186,12 @@ func (s *MemdServer) Close() error {
 		log.Printf("failed to close memd listener: %s", err)
 	}
 
+	s.closeAllClients()
+
+	return nil
+}
+
+func (s *MemdServer) closeAllClients() {
 	var lastClient *MemdClient
 	for {
 		s.lock.Lock()
@@ -172,6 +214,4 @@ func (s *MemdServer) Close() error {
 			log.Printf("failed to close memd client: %s", err)
 		}
 	}
-
-	return nil
 }
@@ -1,13 +1,16 @@
 package servers
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -101,3 +104,57 @@ func TestMemdBasic(t *testing.T) {
 	assert.Len(packetInvokes, 1)
 	lock.Unlock()
 }
+
+func TestMemdServerRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	clientCh := make(chan *MemdClient, 1)
+	svc, err := NewMemdService(NewMemdServerOptions{
+		Handlers: MemdServerHandlers{
+			NewClientHandler: func(cli *MemdClient) {
+				clientCh <- cli
+			},
+			LostClientHandler: func(cli *MemdClient) {},
+			PacketHandler:     func(cli *MemdClient, pak *memd.Packet) {},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start memd server: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", svc.ListenPort()))
+	if err != nil {
+		t.Fatalf("failed to dial memd server: %v", err)
+	}
+	defer conn.Close()
+
+	cli := <-clientCh
+	err = cli.WritePacket(&memd.Packet{
+		Magic:    mock.CmdMagicServerReq,
+		Command:  mock.CmdClusterMapChangeNotification,
+		Datatype: uint8(memd.DatatypeFlagJSON),
+		Key:      []byte("default"),
+		Extras:   []byte{0, 0, 0, 5},
+		Value:    []byte(`{"rev":5}`),
+	})
+	if err != nil {
+		t.Fatalf("failed to write packet: %v", err)
+	}
+
+	// The memd package can't decode server requests, so we read it by hand.
+	buf := make([]byte, 24+4+7+9)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatalf("failed to read packet: %v", err)
+	}
+
+	assert.Equal(uint8(mock.CmdMagicServerReq), buf[0])
+	assert.Equal(uint8(mock.CmdClusterMapChangeNotification), buf[1])
+	assert.Equal(uint16(7), binary.BigEndian.Uint16(buf[2:]))
+	assert.Equal(uint8(4), buf[4])
+	assert.Equal(uint8(memd.DatatypeFlagJSON), buf[5])
+	assert.Equal(uint32(4+7+9), binary.BigEndian.Uint32(buf[8:]))
+	assert.Equal([]byte{0, 0, 0, 5}, buf[24:28])
+	assert.Equal([]byte("default"), buf[28:35])
+	assert.Equal([]byte(`{"rev":5}`), buf[35:])
+}
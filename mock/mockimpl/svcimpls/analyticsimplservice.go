@@ -0,0 +1,260 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockn1ql"
+	"github.com/google/uuid"
+)
+
+type analyticsImplService struct {
+}
+
+func (x *analyticsImplService) Register(h *hookHelper) {
+	h.RegisterAnalyticsHandler("GET", "/analytics/service", x.handleQuery)
+	h.RegisterAnalyticsHandler("POST", "/analytics/service", x.handleQuery)
+	h.RegisterAnalyticsHandler("GET", "/query/service", x.handleQuery)
+	h.RegisterAnalyticsHandler("POST", "/query/service", x.handleQuery)
+	h.RegisterAnalyticsHandler("GET", "/analytics/node/agg/stats/remaining", x.handlePendingMutations)
+}
+
+type jsonAnalyticsMetrics struct {
+	ElapsedTime      string `json:"elapsedTime"`
+	ExecutionTime    string `json:"executionTime"`
+	ResultCount      int    `json:"resultCount"`
+	ResultSize       int    `json:"resultSize"`
+	ProcessedObjects int    `json:"processedObjects"`
+	ErrorCount       int    `json:"errorCount,omitempty"`
+}
+
+type jsonAnalyticsResponse struct {
+	RequestID       string               `json:"requestID"`
+	ClientContextID string               `json:"clientContextID,omitempty"`
+	Signature       interface{}          `json:"signature,omitempty"`
+	Results         []json.RawMessage    `json:"results"`
+	Errors          []jsonQueryError     `json:"errors,omitempty"`
+	Status          string               `json:"status"`
+	Metrics         jsonAnalyticsMetrics `json:"metrics"`
+}
+
+// analyticsRequest holds the parameters of an analytics request, which can be
+// sent either as a JSON body or as form values.
+type analyticsRequest struct {
+	Statement       string
+	NamedArgs       map[string]interface{}
+	PositionalArgs  []interface{}
+	ClientContextID string
+}
+
+func (x *analyticsImplService) parseRequest(req *mock.HTTPRequest) (*analyticsRequest, error) {
+	params := make(map[string]interface{})
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+	} else {
+		for key, values := range req.Form {
+			if len(values) == 0 {
+				continue
+			}
+
+			if key == "args" || strings.HasPrefix(key, "$") {
+				var val interface{}
+				if err := json.Unmarshal([]byte(values[0]), &val); err != nil {
+					return nil, err
+				}
+				params[key] = val
+			} else {
+				params[key] = values[0]
+			}
+		}
+	}
+
+	areq := &analyticsRequest{
+		NamedArgs: make(map[string]interface{}),
+	}
+	for key, val := range params {
+		switch {
+		case key == "statement":
+			areq.Statement, _ = val.(string)
+		case key == "args":
+			areq.PositionalArgs, _ = val.([]interface{})
+		case key == "client_context_id":
+			areq.ClientContextID, _ = val.(string)
+		case strings.HasPrefix(key, "$"):
+			areq.NamedArgs[key] = val
+		}
+	}
+
+	return areq, nil
+}
+
+// analyticsErrorStatus returns the HTTP status code that the analytics service
+// uses for requests which fail with a particular error code.
+func analyticsErrorStatus(code int) int {
+	switch {
+	case code == mockn1ql.ErrCodeAnalyticsAuth:
+		return 401
+	case code >= 21000 && code < 22000:
+		return 400
+	case code >= 23000 && code < 24000:
+		return 503
+	case code >= 24000 && code < 25000:
+		return 400
+	}
+	return 500
+}
+
+func (x *analyticsImplService) keyspaceResolver(source mock.AnalyticsService, req *mock.HTTPRequest) mockn1ql.KeyspaceResolver {
+	return func(path mockn1ql.KeyspacePath, access mockn1ql.KeyspaceAccess) (*mockn1ql.Keyspace, error) {
+		var permission mockauth.Permission
+		switch access {
+		case mockn1ql.KeyspaceAccessManage:
+			permission = mockauth.PermissionsAnalyticsManage
+		default:
+			permission = mockauth.PermissionAnalyticsRead
+		}
+
+		// Managing dataverses and links is not specific to any one bucket.
+		if path.Bucket == "" {
+			if !source.CheckAuthenticated(permission, "", "", "", req) {
+				return nil, mockn1ql.ErrAccessDenied
+			}
+			return nil, nil
+		}
+
+		bucket := source.Node().Cluster().GetBucket(path.Bucket)
+		if bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			return nil, mockn1ql.ErrKeyspaceNotFound
+		}
+
+		scope, collection := path.Scope, path.Collection
+		if scope == "" {
+			scope, collection = "_default", "_default"
+		}
+
+		_, collectionID, err := bucket.CollectionManifest().GetByName(scope, collection)
+		if err != nil {
+			return nil, mockn1ql.ErrKeyspaceNotFound
+		}
+
+		if !source.CheckAuthenticated(permission, path.Bucket, scope, collection, req) {
+			return nil, mockn1ql.ErrAccessDenied
+		}
+
+		return &mockn1ql.Keyspace{
+			Store:        bucket.Store(),
+			CollectionID: uint(collectionID),
+		}, nil
+	}
+}
+
+func (x *analyticsImplService) handleQuery(source mock.AnalyticsService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	start := time.Now()
+
+	resp := jsonAnalyticsResponse{
+		RequestID: uuid.New().String(),
+		Results:   []json.RawMessage{},
+	}
+
+	statusCode := 200
+	areq, err := x.parseRequest(req)
+	if err != nil {
+		statusCode = 400
+		resp.Status = "fatal"
+		resp.Errors = []jsonQueryError{{
+			Code: mockn1ql.ErrCodeAnalyticsCompilation,
+			Msg:  "Error parsing request: " + err.Error(),
+		}}
+	} else if areq.Statement == "" {
+		statusCode = 400
+		resp.Status = "fatal"
+		resp.Errors = []jsonQueryError{{
+			Code: mockn1ql.ErrCodeAnalyticsCompilation,
+			Msg:  "No statement provided",
+		}}
+	} else {
+		resp.ClientContextID = areq.ClientContextID
+
+		results, err := source.Node().Cluster().AnalyticsEngine().Execute(mockn1ql.AnalyticsExecuteOptions{
+			Statement:      areq.Statement,
+			NamedArgs:      areq.NamedArgs,
+			PositionalArgs: areq.PositionalArgs,
+			Keyspaces:      x.keyspaceResolver(source, req),
+		})
+		if err != nil {
+			analyticsErr, ok := err.(*mockn1ql.Error)
+			if !ok {
+				analyticsErr = &mockn1ql.Error{Code: mockn1ql.ErrCodeAnalyticsInternal, Msg: err.Error()}
+			}
+
+			statusCode = analyticsErrorStatus(analyticsErr.Code)
+			resp.Status = "fatal"
+			resp.Errors = []jsonQueryError{{
+				Code: analyticsErr.Code,
+				Msg:  analyticsErr.Msg,
+			}}
+		} else {
+			resp.Signature = results.Signature
+			resp.Results = results.Rows
+			resp.Status = "success"
+		}
+	}
+
+	for _, row := range resp.Results {
+		resp.Metrics.ResultSize += len(row)
+	}
+	resp.Metrics.ResultCount = len(resp.Results)
+	resp.Metrics.ProcessedObjects = len(resp.Results)
+	resp.Metrics.ErrorCount = len(resp.Errors)
+
+	elapsed := time.Since(start).String()
+	resp.Metrics.ElapsedTime = elapsed
+	resp.Metrics.ExecutionTime = elapsed
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to marshal analytics result: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(b),
+	}
+}
+
+func (x *analyticsImplService) handlePendingMutations(source mock.AnalyticsService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionsAnalyticsManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	pending := source.Node().Cluster().AnalyticsEngine().PendingMutations(x.keyspaceResolver(source, req))
+
+	b, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("Failed to marshal pending mutations: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(b),
+	}
+}
@@ -9,7 +9,7 @@ import (
 
 // GenBucketConfig returns the current config for a bucket.
 func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
-	kvNodes, vbMap, allNodes := b.GetVbServerInfo(reqNode)
+	kvNodes, vbMap, vbMapForward, allNodes := b.GetVbServerInfo(reqNode)
 
 	config := make(map[string]interface{})
 	config["name"] = b.Name()
@@ -18,8 +18,13 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	config["bucketType"] = b.BucketType().Name()
 
 	if b.BucketType() != mock.BucketTypeMemcached {
-		config["collectionsManifestUid"] = fmt.Sprintf("%d", b.CollectionManifest().Rev)
-		config["durabilityMinLevel"] = "none"
+		serverVersion := b.Cluster().ServerVersion()
+		if serverVersion >= mock.ServerVersion70 {
+			config["collectionsManifestUid"] = fmt.Sprintf("%d", b.CollectionManifest().Rev)
+		}
+		if serverVersion >= mock.ServerVersion66 {
+			config["durabilityMinLevel"] = string(b.DurabilityMinLevel())
+		}
 
 		config["ddocs"] = map[string]interface{}{
 			"uri": fmt.Sprintf("/pools/default/%s/default/ddocs", b.Name()),
@@ -36,7 +41,7 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	config["autoCompactionSettings"] = false
 	config["fragmentationPercentage"] = 50
 	config["conflictResolutionType"] = "seqno"
-	config["maxTTL"] = 0
+	config["maxTTL"] = b.MaxTTL()
 
 	config["localRandomKeyUri"] = fmt.Sprintf("/pools/default/buckets/%s/localRandomKey", b.Name())
 	config["uri"] = fmt.Sprintf("/pools/default/buckets/%s?bucket_uuid=%s", b.Name(), b.ID())
@@ -59,19 +64,7 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	}
 
 	config["bucketCapabilitiesVer"] = ""
-	config["bucketCapabilities"] = []string{
-		"collections",
-		"durableWrite",
-		"tombstonedUserXAttrs",
-		"couchapi",
-		"dcp",
-		"cbhello",
-		"touch",
-		"cccp",
-		"xdcrCheckpointing",
-		"nodesExt",
-		"xattr",
-	}
+	config["bucketCapabilities"] = genBucketCapabilities(b)
 
 	controllers := map[string]interface{}{
 		"compactAll":    fmt.Sprintf("/pools/default/buckets/%s/controller/compactBucket", b.Name()),
@@ -105,6 +98,9 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		vbConfig["hashAlgorithm"] = "CRC"
 		vbConfig["numReplicas"] = b.NumReplicas()
 		vbConfig["vBucketMap"] = vbMap
+		if vbMapForward != nil {
+			vbConfig["vBucketMapForward"] = vbMapForward
+		}
 
 		var vbServerList []interface{}
 		for _, node := range kvNodes {
@@ -122,14 +118,18 @@ func GenBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 
 // GenTerseBucketConfig returns the current mini config for a bucket.
 func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
-	kvNodes, vbMap, allNodes := b.GetVbServerInfo(reqNode)
+	// The revision is read before the vbmap, as the vbmap may be moved by a
+	// rebalance in the meantime, and a config must never claim to be newer
+	// than the vbmap within it.
+	configRev := b.ConfigRev()
+	kvNodes, vbMap, vbMapForward, allNodes := b.GetVbServerInfo(reqNode)
 
 	config := make(map[string]interface{})
-	config["rev"] = b.ConfigRev()
+	config["rev"] = configRev
 	config["name"] = b.Name()
 	config["uuid"] = b.ID()
 
-	if b.BucketType() != mock.BucketTypeMemcached {
+	if b.BucketType() != mock.BucketTypeMemcached && b.Cluster().ServerVersion() >= mock.ServerVersion70 {
 		config["collectionsManifestUid"] = fmt.Sprintf("%d", b.CollectionManifest().Rev)
 	}
 
@@ -142,32 +142,13 @@ func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		}
 	}
 
-	config["clusterCapabilitiesVer"] = []int{1, 0}
-
-	config["clusterCapabilities"] = map[string]interface{}{
-		"n1ql": []string{
-			"costBasedOptimizer",
-			"indexAdvisor",
-			"javaScriptFunctions",
-			"inlineFunctions",
-			"enhancedPreparedStatements",
-		},
+	if clusterCaps := genClusterCapabilities(b.Cluster()); clusterCaps != nil {
+		config["clusterCapabilitiesVer"] = []int{1, 0}
+		config["clusterCapabilities"] = clusterCaps
 	}
 
 	config["bucketCapabilitiesVer"] = ""
-	config["bucketCapabilities"] = []string{
-		"collections",
-		"durableWrite",
-		"tombstonedUserXAttrs",
-		"couchapi",
-		"dcp",
-		"cbhello",
-		"touch",
-		"cccp",
-		"xdcrCheckpointing",
-		"nodesExt",
-		"xattr",
-	}
+	config["bucketCapabilities"] = genBucketCapabilities(b)
 
 	nodesConfig := make([]interface{}, 0)
 	nodesExtConfig := make([]interface{}, 0)
@@ -190,6 +171,9 @@ func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 		vbConfig["hashAlgorithm"] = "CRC"
 		vbConfig["numReplicas"] = b.NumReplicas()
 		vbConfig["vBucketMap"] = vbMap
+		if vbMapForward != nil {
+			vbConfig["vBucketMapForward"] = vbMapForward
+		}
 
 		var vbServerList []interface{}
 		for _, node := range kvNodes {
@@ -204,3 +188,31 @@ func GenTerseBucketConfig(b mock.Bucket, reqNode mock.ClusterNode) []byte {
 	configBytes, _ := json.Marshal(config)
 	return configBytes
 }
+
+// genBucketCapabilities returns the list of capabilities which a bucket
+// advertises, based on the version of the server being emulated.
+func genBucketCapabilities(b mock.Bucket) []string {
+	serverVersion := b.Cluster().ServerVersion()
+
+	var capabilities []string
+	if serverVersion >= mock.ServerVersion70 {
+		capabilities = append(capabilities, "collections")
+	}
+	if serverVersion >= mock.ServerVersion65 {
+		capabilities = append(capabilities, "durableWrite")
+	}
+	if serverVersion >= mock.ServerVersion66 {
+		capabilities = append(capabilities, "tombstonedUserXAttrs")
+	}
+
+	return append(capabilities,
+		"couchapi",
+		"dcp",
+		"cbhello",
+		"touch",
+		"cccp",
+		"xdcrCheckpointing",
+		"nodesExt",
+		"xattr",
+	)
+}
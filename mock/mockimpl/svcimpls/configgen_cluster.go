@@ -37,23 +37,50 @@ func GenTerseClusterConfig(c mock.Cluster, reqNode mock.ClusterNode) []byte {
 
 	nodesConfig := make([]interface{}, 0)
 	for _, server := range c.Nodes() {
+		// Nodes which have been failed over are no longer advertised.
+		if server.Membership() != mock.NodeMembershipActive {
+			continue
+		}
+
 		nodeConfig := GenExtClusterNodeConfig(server, reqNode, nil)
 		nodesConfig = append(nodesConfig, json.RawMessage(nodeConfig))
 	}
 	config["nodesExt"] = nodesConfig
 
-	config["clusterCapabilitiesVer"] = []int{1, 0}
+	if clusterCaps := genClusterCapabilities(c); clusterCaps != nil {
+		config["clusterCapabilitiesVer"] = []int{1, 0}
+		config["clusterCapabilities"] = clusterCaps
+	}
+
+	configBytes, _ := json.Marshal(config)
+	return configBytes
+}
+
+// genClusterCapabilities returns the capabilities which a cluster advertises,
+// based on the version of the server being emulated.  Servers prior to 6.5 do
+// not advertise cluster capabilities at all, in which case nil is returned.
+func genClusterCapabilities(c mock.Cluster) map[string]interface{} {
+	serverVersion := c.ServerVersion()
+	if serverVersion < mock.ServerVersion65 {
+		return nil
+	}
 
-	config["clusterCapabilities"] = map[string]interface{}{
-		"n1ql": []string{
+	n1qlCaps := []string{}
+	if serverVersion >= mock.ServerVersion66 {
+		n1qlCaps = append(n1qlCaps,
 			"costBasedOptimizer",
 			"indexAdvisor",
+		)
+	}
+	if serverVersion >= mock.ServerVersion70 {
+		n1qlCaps = append(n1qlCaps,
 			"javaScriptFunctions",
 			"inlineFunctions",
-			"enhancedPreparedStatements",
-		},
+		)
 	}
+	n1qlCaps = append(n1qlCaps, "enhancedPreparedStatements")
 
-	configBytes, _ := json.Marshal(config)
-	return configBytes
+	return map[string]interface{}{
+		"n1ql": n1qlCaps,
+	}
 }
@@ -7,6 +7,34 @@ import (
 	"github.com/couchbaselabs/gocaves/mock"
 )
 
+// genOtpNode returns the erlang node name of a cluster node.  As every node
+// shares a hostname, they are told apart by their management port in the same
+// way as nodes started by cluster_run are.
+func genOtpNode(n mock.ClusterNode) string {
+	return fmt.Sprintf("n_%d@%s", n.MgmtService().ListenPort(), n.MgmtService().Hostname())
+}
+
+// genAlternateAddresses returns the alternateAddresses section for a cluster
+// node, or nil if the node has no alternate addresses.
+func genAlternateAddresses(n mock.ClusterNode) map[string]interface{} {
+	altAddrs := n.AlternateAddresses()
+	if len(altAddrs) == 0 {
+		return nil
+	}
+
+	config := make(map[string]interface{})
+	for network, altAddr := range altAddrs {
+		altConfig := map[string]interface{}{
+			"hostname": altAddr.Hostname,
+		}
+		if len(altAddr.Ports) > 0 {
+			altConfig["ports"] = altAddr.Ports
+		}
+		config[network] = altConfig
+	}
+	return config
+}
+
 // GenClusterNodeConfig returns the config data for a cluster node.
 func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucket mock.Bucket) []byte {
 	config := make(map[string]interface{})
@@ -32,14 +60,17 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 		}
 	}
 
-	// TODO(brett19): Generate something reasonable for the otpNode field
-	config["otpNode"] = "ns_NOPE@cb.local"
+	config["otpNode"] = genOtpNode(n)
 	config["thisNode"] = n == reqNode
 	config["hostname"] = fmt.Sprintf("%s:%d", n.MgmtService().Hostname(), n.MgmtService().ListenPort())
 	config["configuredHostname"] = fmt.Sprintf("%s:%d", n.MgmtService().Hostname(), n.MgmtService().ListenPort())
 	config["nodeUUID"] = n.ID()
 	config["recoveryType"] = "none"
 
+	if altAddrs := genAlternateAddresses(n); altAddrs != nil {
+		config["alternateAddresses"] = altAddrs
+	}
+
 	if forBucket != nil {
 		config["replication"] = 0
 	}
@@ -90,13 +121,17 @@ func GenClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBucke
 		},
 	}
 
-	config["clusterCompatibility"] = 458752
-	config["version"] = "7.0.0-3016-enterprise"
+	config["clusterCompatibility"] = n.Cluster().ServerVersion().ClusterCompatibility()
+	config["version"] = n.Cluster().ServerVersion().BuildString()
 	config["os"] = "x86_64-unknown-linux-gnu"
 	config["cpuCount"] = 24
 
-	config["clusterMembership"] = "active"
-	config["status"] = "healthy"
+	config["clusterMembership"] = n.Membership().Name()
+	if n.Membership() == mock.NodeMembershipActive {
+		config["status"] = "healthy"
+	} else {
+		config["status"] = "unhealthy"
+	}
 	config["uptime"] = "383443"
 	config["memoryTotal"] = 49093763072
 	config["memoryFree"] = 44611338240
@@ -164,6 +199,10 @@ func GenTerseClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, for
 
 	config["ports"] = servicePorts
 
+	if altAddrs := genAlternateAddresses(n); altAddrs != nil {
+		config["alternateAddresses"] = altAddrs
+	}
+
 	configBytes, _ := json.Marshal(config)
 	return configBytes
 }
@@ -226,9 +265,25 @@ func GenExtClusterNodeConfig(n mock.ClusterNode, reqNode mock.ClusterNode, forBu
 		servicePorts["ftsSSL"] = n.SearchService().ListenPortTLS()
 	}
 
+	if n.EventingService() != nil && n.EventingService().ListenPort() > 0 {
+		servicePorts["eventingAdminPort"] = n.EventingService().ListenPort()
+	}
+	if n.EventingService() != nil && n.EventingService().ListenPortTLS() > 0 {
+		servicePorts["eventingSSL"] = n.EventingService().ListenPortTLS()
+	}
+
 	config["services"] = servicePorts
 	config["thisNode"] = n == reqNode
 
+	if altAddrs := genAlternateAddresses(n); altAddrs != nil {
+		config["alternateAddresses"] = altAddrs
+
+		// SDKs pick which network to use by comparing the host they bootstrapped
+		// against with the hostnames of each network, so we need to include the
+		// default hostname too whenever there is another network to choose.
+		config["hostname"] = n.Hostname()
+	}
+
 	configBytes, _ := json.Marshal(config)
 	return configBytes
 }
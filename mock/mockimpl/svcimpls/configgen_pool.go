@@ -39,9 +39,9 @@ func GenPoolsConfig(c mock.Cluster) []byte {
 		"maxParallelIndexers": "/settings/maxParallelIndexers?uuid=" + uuid,
 		"viewUpdateDaemon":    "/settings/viewUpdateDaemon?uuid=" + uuid,
 	}
-	config["implementationVersion"] = "7.0.0-3016-enterprise"
+	config["implementationVersion"] = c.ServerVersion().BuildString()
 	config["componentsVersion"] = map[string]string{
-		"ns_server":  "7.0.0-3016-enterprise",
+		"ns_server":  c.ServerVersion().BuildString(),
 		"inets":      "7.1.3.3",
 		"os_mon":     "2.5.1.1",
 		"ale":        "0.0.0",
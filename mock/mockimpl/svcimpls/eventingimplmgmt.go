@@ -0,0 +1,331 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockeventing"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+type eventingImplMgmt struct {
+}
+
+func (x *eventingImplMgmt) Register(h *hookHelper) {
+	h.RegisterEventingHandler("GET", "/api/v1/functions", x.handleGetAllFunctions)
+	h.RegisterEventingHandler("GET", "/api/v1/functions/*", x.handleGetFunction)
+	h.RegisterEventingHandler("POST", "/api/v1/functions/*", x.handleUpsertFunction)
+	h.RegisterEventingHandler("DELETE", "/api/v1/functions/*", x.handleDropFunction)
+	h.RegisterEventingHandler("POST", "/api/v1/functions/*/deploy", x.handleDeployFunction)
+	h.RegisterEventingHandler("POST", "/api/v1/functions/*/undeploy", x.handleUndeployFunction)
+	h.RegisterEventingHandler("POST", "/api/v1/functions/*/pause", x.handlePauseFunction)
+	h.RegisterEventingHandler("POST", "/api/v1/functions/*/resume", x.handleResumeFunction)
+	h.RegisterEventingHandler("GET", "/api/v1/status", x.handleFunctionsStatus)
+	h.RegisterEventingHandler("GET", "/getAppLog", x.handleGetAppLog)
+}
+
+// eventingKeyspaceResolver returns a resolver which looks up the keyspaces
+// used by eventing functions.  Handlers write to the active copy of each
+// vbucket, wherever it lives, so the returned kvproc engines own them all.
+func eventingKeyspaceResolver(source mock.EventingService) mockeventing.KeyspaceResolver {
+	return func(bucketName, scope, collection string) (*mockeventing.Keyspace, error) {
+		bucket := source.Node().Cluster().GetBucket(bucketName)
+		if bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			return nil, mockeventing.ErrKeyspaceBucketNotFound
+		}
+
+		_, collectionID, err := bucket.CollectionManifest().GetByName(scope, collection)
+		if err != nil {
+			return nil, mockeventing.ErrKeyspaceCollectionNotFound
+		}
+
+		_, vbMap, _, _ := bucket.GetVbServerInfo(source.Node())
+		vbOwnership := make([]int, len(vbMap))
+
+		return &mockeventing.Keyspace{
+			Store:        bucket.Store(),
+			CollectionID: uint(collectionID),
+			Proc: kvproc.New(bucket.Store(), vbOwnership, vbMap, func(collectionID uint) time.Duration {
+				maxTTL, err := bucket.CollectionManifest().GetMaxTTL(uint32(collectionID))
+				if err != nil || maxTTL == 0 {
+					maxTTL = bucket.MaxTTL()
+				}
+				return time.Duration(maxTTL) * time.Second
+			}),
+		}, nil
+	}
+}
+
+// eventingFunctionScope returns the function scope which a request refers to.
+func eventingFunctionScope(req *mock.HTTPRequest) mockeventing.FunctionScope {
+	bucket := req.URL.Query().Get("bucket")
+	scope := req.URL.Query().Get("scope")
+	if bucket == "" {
+		bucket, scope = "*", "*"
+	}
+
+	return mockeventing.FunctionScope{
+		Bucket: bucket,
+		Scope:  scope,
+	}
+}
+
+// checkEventingAuthenticated verifies that the request may manage the
+// functions of a scope.  Functions in the "*" scope are managed at the
+// cluster level.
+func checkEventingAuthenticated(source mock.EventingService, scope mockeventing.FunctionScope, req *mock.HTTPRequest) bool {
+	if scope.Bucket == "*" {
+		return source.CheckAuthenticated(mockauth.PermissionEventingManage, "", "", "", req)
+	}
+
+	return source.CheckAuthenticated(mockauth.PermissionEventingManage, scope.Bucket, scope.Scope, "", req)
+}
+
+// eventingErrorStatus returns the HTTP status code which the eventing service
+// uses for requests which fail with a particular error.
+func eventingErrorStatus(err *mockeventing.Error) int {
+	switch {
+	case errors.Is(err, mockeventing.ErrFunctionNotFound):
+		return 404
+	case errors.Is(err, mockeventing.ErrFunctionNotDeployed),
+		errors.Is(err, mockeventing.ErrFunctionDeployed),
+		errors.Is(err, mockeventing.ErrFunctionNotUndeployed),
+		errors.Is(err, mockeventing.ErrFunctionPaused):
+		return 406
+	}
+	return 400
+}
+
+func eventingUnauthorizedResponse() *mock.HTTPResponse {
+	return &mock.HTTPResponse{
+		StatusCode: 401,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
+
+func eventingJSONResponse(statusCode int, value interface{}) *mock.HTTPResponse {
+	b, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to marshal eventing response: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(b),
+	}
+}
+
+func eventingErrorResponse(err error) *mock.HTTPResponse {
+	var eventingErr *mockeventing.Error
+	if !errors.As(err, &eventingErr) {
+		eventingErr = &mockeventing.Error{Name: "ERR_INTERNAL", Code: 1, Description: err.Error()}
+	}
+
+	return eventingJSONResponse(eventingErrorStatus(eventingErr), map[string]interface{}{
+		"name":        eventingErr.Name,
+		"code":        eventingErr.Code,
+		"description": eventingErr.Description,
+		"attributes":  nil,
+		"runtime_info": map[string]interface{}{
+			"code": eventingErr.Code,
+			"info": eventingErr.Description,
+		},
+	})
+}
+
+func eventingOkResponse(info string) *mock.HTTPResponse {
+	return eventingJSONResponse(200, map[string]interface{}{
+		"code": 0,
+		"info": map[string]interface{}{
+			"status": info,
+		},
+	})
+}
+
+func (x *eventingImplMgmt) handleGetAllFunctions(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	// Without a scope, all of the functions the user may manage are listed.
+	query := req.URL.Query()
+	var scope *mockeventing.FunctionScope
+	if query.Get("bucket") != "" {
+		s := eventingFunctionScope(req)
+		scope = &s
+	}
+
+	fns := []*mockeventing.Function{}
+	for _, fn := range source.Node().Cluster().EventingEngine().GetAllFunctions() {
+		if scope != nil && *fn.FunctionScope != *scope {
+			continue
+		}
+		if !checkEventingAuthenticated(source, *fn.FunctionScope, req) {
+			continue
+		}
+		fns = append(fns, fn)
+	}
+
+	if len(fns) == 0 && !checkEventingAuthenticated(source, eventingFunctionScope(req), req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	return eventingJSONResponse(200, fns)
+}
+
+func (x *eventingImplMgmt) handleGetFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/v1/functions/*")
+	scope := eventingFunctionScope(req)
+
+	if !checkEventingAuthenticated(source, scope, req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	fn, err := source.Node().Cluster().EventingEngine().GetFunction(scope, pathParts[0])
+	if err != nil {
+		return eventingErrorResponse(err)
+	}
+
+	return eventingJSONResponse(200, fn)
+}
+
+func (x *eventingImplMgmt) handleUpsertFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/v1/functions/*")
+	name := pathParts[0]
+
+	var fn mockeventing.Function
+	if err := json.Unmarshal(req.PeekBody(), &fn); err != nil {
+		return eventingErrorResponse(&mockeventing.Error{
+			Name:        mockeventing.ErrInvalidConfig.Name,
+			Code:        mockeventing.ErrInvalidConfig.Code,
+			Description: "failed to parse function definition: " + err.Error(),
+		})
+	}
+	if fn.Name == "" {
+		fn.Name = name
+	} else if fn.Name != name {
+		return eventingErrorResponse(&mockeventing.Error{
+			Name:        mockeventing.ErrInvalidConfig.Name,
+			Code:        mockeventing.ErrInvalidConfig.Code,
+			Description: "function name in the request body does not match the function name in the URL",
+		})
+	}
+	if fn.FunctionScope == nil {
+		scope := eventingFunctionScope(req)
+		fn.FunctionScope = &scope
+	}
+
+	if !checkEventingAuthenticated(source, *fn.FunctionScope, req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	err := source.Node().Cluster().EventingEngine().UpsertFunction(&fn, eventingKeyspaceResolver(source))
+	if err != nil {
+		return eventingErrorResponse(err)
+	}
+
+	return eventingOkResponse("Stored function: '" + name + "' in metakv")
+}
+
+func (x *eventingImplMgmt) handleDropFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/v1/functions/*")
+	scope := eventingFunctionScope(req)
+
+	if !checkEventingAuthenticated(source, scope, req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	err := source.Node().Cluster().EventingEngine().DropFunction(scope, pathParts[0])
+	if err != nil {
+		return eventingErrorResponse(err)
+	}
+
+	return eventingOkResponse("Deleting function: '" + pathParts[0] + "'")
+}
+
+// handleLifecycle implements the endpoints which move a function between its
+// deployment states.
+func (x *eventingImplMgmt) handleLifecycle(source mock.EventingService, req *mock.HTTPRequest, op string,
+	fn func(engine mock.EventingEngine, scope mockeventing.FunctionScope, name string) error) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/v1/functions/*/"+op)
+	scope := eventingFunctionScope(req)
+
+	if !checkEventingAuthenticated(source, scope, req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	if err := fn(source.Node().Cluster().EventingEngine(), scope, pathParts[0]); err != nil {
+		return eventingErrorResponse(err)
+	}
+
+	return eventingOkResponse(strings.Title(op) + " request for function: '" + pathParts[0] + "' accepted")
+}
+
+func (x *eventingImplMgmt) handleDeployFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleLifecycle(source, req, "deploy",
+		func(engine mock.EventingEngine, scope mockeventing.FunctionScope, name string) error {
+			return engine.DeployFunction(scope, name, eventingKeyspaceResolver(source))
+		})
+}
+
+func (x *eventingImplMgmt) handleUndeployFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleLifecycle(source, req, "undeploy", mock.EventingEngine.UndeployFunction)
+}
+
+func (x *eventingImplMgmt) handlePauseFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleLifecycle(source, req, "pause", mock.EventingEngine.PauseFunction)
+}
+
+func (x *eventingImplMgmt) handleResumeFunction(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleLifecycle(source, req, "resume", mock.EventingEngine.ResumeFunction)
+}
+
+func (x *eventingImplMgmt) handleFunctionsStatus(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionEventingManage, "", "", "", req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	var numNodes int
+	for _, node := range source.Node().Cluster().Nodes() {
+		if node.EventingService() != nil {
+			numNodes++
+		}
+	}
+
+	statuses := source.Node().Cluster().EventingEngine().FunctionsStatus()
+	for _, status := range statuses {
+		if status.DeploymentStatus {
+			status.NumDeployedNodes = numNodes
+		}
+	}
+
+	return eventingJSONResponse(200, map[string]interface{}{
+		"apps":               statuses,
+		"num_eventing_nodes": numNodes,
+	})
+}
+
+func (x *eventingImplMgmt) handleGetAppLog(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	scope := eventingFunctionScope(req)
+
+	if !checkEventingAuthenticated(source, scope, req) {
+		return eventingUnauthorizedResponse()
+	}
+
+	lines, err := source.Node().Cluster().EventingEngine().FunctionLog(scope, req.URL.Query().Get("name"))
+	if err != nil {
+		return eventingErrorResponse(err)
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte(strings.Join(lines, "\n"))),
+	}
+}
@@ -10,12 +10,15 @@ import (
 // hookHelper is simply a wrapper to simplify the setup of hooks.
 type hookHelper struct {
 	AnalyticsHooks mock.AnalyticsHookManager
+	EventingHooks  mock.EventingHookManager
 	KvInHooks      mock.KvHookManager
 	KvOutHooks     mock.KvHookManager
 	MgmtHooks      mock.MgmtHookManager
 	QueryHooks     mock.QueryHookManager
 	SearchHooks    mock.SearchHookManager
 	ViewHooks      mock.ViewHookManager
+
+	AddKvLostClientHandler func(handler func(source mock.KvClient))
 }
 
 // RegisterKvReq registers a hook for a kv command request.
@@ -29,6 +32,14 @@ func (h *hookHelper) RegisterKvHandler(cmd memd.CmdCode, handler func(source moc
 	})
 }
 
+// RegisterKvLostClientHandler registers a handler which is invoked when a kv
+// client disconnects, allowing any per-client state to be cleaned up.
+func (h *hookHelper) RegisterKvLostClientHandler(handler func(source mock.KvClient)) {
+	if h.AddKvLostClientHandler != nil {
+		h.AddKvLostClientHandler(handler)
+	}
+}
+
 // RegisterMgmtReq registers a hook for a mgmt request.
 func (h *hookHelper) RegisterMgmtHandler(method, path string, handler func(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse) {
 	parser := pathparse.NewParser(path)
@@ -62,6 +73,17 @@ func (h *hookHelper) RegisterAnalyticsHandler(method, path string, handler func(
 	})
 }
 
+// RegisterEventingHandler registers a hook for an eventing request.
+func (h *hookHelper) RegisterEventingHandler(method, path string, handler func(source mock.EventingService, req *mock.HTTPRequest) *mock.HTTPResponse) {
+	parser := pathparse.NewParser(path)
+	h.EventingHooks.Add(func(source mock.EventingService, req *mock.HTTPRequest, next func() *mock.HTTPResponse) *mock.HTTPResponse {
+		if req.Method == method && parser.Match(req.URL.Path) {
+			return handler(source, req)
+		}
+		return next()
+	})
+}
+
 // RegisterSearchHandler registers a hook for a search request.
 func (h *hookHelper) RegisterSearchHandler(method, path string, handler func(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse) {
 	parser := pathparse.NewParser(path)
@@ -0,0 +1,158 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
+// kvImplClusterMap pushes cluster map change notifications to the kv clients
+// which have negotiated them.
+type kvImplClusterMap struct {
+	lock     sync.Mutex
+	watchers map[mock.KvClient]*clusterMapWatcher
+}
+
+// clusterMapWatcher watches for cluster configuration changes on behalf of a
+// single kv client.  Notifications are pushed from a goroutine of its own so
+// that a slow client cannot hold up configuration changes for everybody else.
+type clusterMapWatcher struct {
+	parent  *kvImplClusterMap
+	source  mock.KvClient
+	pushCh  chan struct{}
+	closeCh chan struct{}
+}
+
+func newClusterMapWatcher(parent *kvImplClusterMap, source mock.KvClient) *clusterMapWatcher {
+	watcher := &clusterMapWatcher{
+		parent:  parent,
+		source:  source,
+		pushCh:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+	go watcher.pushLoop()
+	return watcher
+}
+
+func (w *clusterMapWatcher) OnNewConfig(cfg uint) {
+	// Any pending push will send the latest config anyway, so there is no
+	// need to queue up another one behind it.
+	select {
+	case w.pushCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *clusterMapWatcher) pushLoop() {
+	for {
+		select {
+		case <-w.pushCh:
+			w.parent.pushClusterMap(w)
+		case <-w.closeCh:
+			return
+		}
+	}
+}
+
+func (w *clusterMapWatcher) stop() {
+	close(w.closeCh)
+}
+
+// Register removes the watcher of any client which goes away.
+func (x *kvImplClusterMap) Register(h *hookHelper) {
+	h.RegisterKvLostClientHandler(func(source mock.KvClient) {
+		x.lock.Lock()
+		watcher := x.watchers[source]
+		x.lock.Unlock()
+
+		if watcher != nil {
+			x.removeWatcher(watcher)
+		}
+	})
+}
+
+// updateClient starts or stops watching for cluster map changes on behalf of
+// a client, depending on whether it has negotiated notifications.
+func (x *kvImplClusterMap) updateClient(source mock.KvClient) {
+	cluster := source.Source().Node().Cluster()
+	wantsNotifs := source.HasFeature(memd.FeatureClusterMapNotif)
+
+	x.lock.Lock()
+	if x.watchers == nil {
+		x.watchers = make(map[mock.KvClient]*clusterMapWatcher)
+	}
+	watcher := x.watchers[source]
+	if wantsNotifs && watcher == nil {
+		watcher = newClusterMapWatcher(x, source)
+		x.watchers[source] = watcher
+		x.lock.Unlock()
+
+		cluster.AddConfigWatcher(watcher)
+		return
+	} else if !wantsNotifs && watcher != nil {
+		delete(x.watchers, source)
+		x.lock.Unlock()
+
+		cluster.RemoveConfigWatcher(watcher)
+		watcher.stop()
+		return
+	}
+	x.lock.Unlock()
+}
+
+// removeWatcher stops a watcher, this is used once its client has gone away.
+func (x *kvImplClusterMap) removeWatcher(watcher *clusterMapWatcher) {
+	x.lock.Lock()
+	if x.watchers[watcher.source] != watcher {
+		x.lock.Unlock()
+		return
+	}
+	delete(x.watchers, watcher.source)
+	x.lock.Unlock()
+
+	watcher.source.Source().Node().Cluster().RemoveConfigWatcher(watcher)
+	watcher.stop()
+}
+
+func (x *kvImplClusterMap) pushClusterMap(watcher *clusterMapWatcher) {
+	source := watcher.source
+	node := source.Source().Node()
+
+	var bucketName []byte
+	var configRev uint
+	var configBytes []byte
+	if selectedBucket := source.SelectedBucket(); selectedBucket != nil {
+		if selectedBucket.BucketType() == mock.BucketTypeMemcached {
+			// Memcached buckets have no cluster map to push.
+			return
+		}
+
+		bucketName = []byte(selectedBucket.Name())
+		configRev = selectedBucket.ConfigRev()
+		configBytes = GenTerseBucketConfig(selectedBucket, node)
+	} else {
+		configRev = node.Cluster().ConfigRev()
+		configBytes = GenTerseClusterConfig(node.Cluster(), node)
+	}
+
+	extrasBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(extrasBuf[0:], uint32(configRev))
+
+	// Server pushed requests never carry framing extras, so we write these
+	// directly rather than using writePacketToSource.
+	err := source.WritePacket(&memd.Packet{
+		Magic:    mock.CmdMagicServerReq,
+		Command:  mock.CmdClusterMapChangeNotification,
+		Datatype: uint8(memd.DatatypeFlagJSON),
+		Key:      bucketName,
+		Extras:   extrasBuf,
+		Value:    configBytes,
+	})
+	if err != nil {
+		log.Printf("failed to push cluster map to %+v: %s", source, err)
+		x.removeWatcher(watcher)
+	}
+}
@@ -3,12 +3,15 @@ package svcimpls
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
 )
 
 func (x *kvImplCrud) handleManifestRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
@@ -45,6 +48,7 @@ func (x *kvImplCrud) handleGetCollectionIDRequest(source mock.KvClient, pak *mem
 		uid, cid, err := manifest.GetByName(keyParts[0], keyParts[1])
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
+			return
 		}
 
 		extrasBuf := make([]byte, 12)
@@ -60,3 +64,105 @@ func (x *kvImplCrud) handleGetCollectionIDRequest(source mock.KvClient, pak *mem
 		}, start)
 	}
 }
+
+// getCollectionStats generates the "collections" stat group.  The optional
+// argument restricts the stats to a single collection, identified either by
+// its scope.collection path or, for the byid variant, its hex ID.
+func (x *kvImplCrud) getCollectionStats(source mock.KvClient, byID bool, arg string) (map[string]string, error) {
+	manifest := source.SelectedBucket().CollectionManifest()
+	uid, scopes := manifest.GetManifest()
+
+	filterID := uint32(0)
+	hasFilter := arg != ""
+	if hasFilter {
+		if byID {
+			collectionID, err := strconv.ParseUint(strings.TrimPrefix(arg, "0x"), 16, 32)
+			if err != nil {
+				return nil, kvproc.ErrInvalidArgument
+			}
+			if err := manifest.CheckCollectionID(uint32(collectionID)); err != nil {
+				return nil, err
+			}
+			filterID = uint32(collectionID)
+		} else {
+			pathParts := strings.Split(arg, ".")
+			if len(pathParts) != 2 {
+				return nil, kvproc.ErrInvalidArgument
+			}
+			_, collectionID, err := manifest.GetByName(pathParts[0], pathParts[1])
+			if err != nil {
+				return nil, err
+			}
+			filterID = collectionID
+		}
+	}
+
+	stats := map[string]string{
+		"manifest_uid": strconv.FormatUint(uid, 10),
+	}
+	for _, scope := range scopes {
+		for _, collection := range scope.Collections {
+			if hasFilter && collection.UID != filterID {
+				continue
+			}
+
+			prefix := fmt.Sprintf("0x%x:0x%x:", scope.UID, collection.UID)
+			stats[prefix+"name"] = collection.Name
+			stats[prefix+"scope_name"] = scope.Name
+			if collection.MaxTTL > 0 {
+				stats[prefix+"maxTTL"] = strconv.FormatUint(uint64(collection.MaxTTL), 10)
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// getScopeStats generates the "scopes" stat group.  The optional argument
+// restricts the stats to a single scope, identified either by its name or,
+// for the byid variant, its hex ID.
+func (x *kvImplCrud) getScopeStats(source mock.KvClient, byID bool, arg string) (map[string]string, error) {
+	manifest := source.SelectedBucket().CollectionManifest()
+	uid, scopes := manifest.GetManifest()
+
+	filterID := uint32(0)
+	hasFilter := arg != ""
+	if hasFilter {
+		if byID {
+			scopeID, err := strconv.ParseUint(strings.TrimPrefix(arg, "0x"), 16, 32)
+			if err != nil {
+				return nil, kvproc.ErrInvalidArgument
+			}
+			if err := manifest.CheckScopeID(uint32(scopeID)); err != nil {
+				return nil, err
+			}
+			filterID = uint32(scopeID)
+		} else {
+			foundScope := false
+			for _, scope := range scopes {
+				if scope.Name == arg {
+					filterID = scope.UID
+					foundScope = true
+				}
+			}
+			if !foundScope {
+				return nil, mock.ErrScopeNotFound
+			}
+		}
+	}
+
+	stats := map[string]string{
+		"manifest_uid": strconv.FormatUint(uid, 10),
+	}
+	for _, scope := range scopes {
+		if hasFilter && scope.UID != filterID {
+			continue
+		}
+
+		prefix := fmt.Sprintf("0x%x:", scope.UID)
+		stats[prefix+"name"] = scope.Name
+		stats[prefix+"collections"] = strconv.Itoa(len(scope.Collections))
+	}
+
+	return stats, nil
+}
@@ -3,46 +3,83 @@ package svcimpls
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"log"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/couchbase/gocbcore/v9/memd"
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
 	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+	"github.com/golang/snappy"
 )
 
+// minCompressionRatio is the ratio of inflated to compressed size which a value
+// must achieve before it is sent to clients compressed.
+const minCompressionRatio = 1.2
+
 type kvImplCrud struct {
 }
 
 func (x *kvImplCrud) Register(h *hookHelper) {
-	h.RegisterKvHandler(memd.CmdAdd, x.handleAddRequest)
-	h.RegisterKvHandler(memd.CmdSet, x.handleSetRequest)
-	h.RegisterKvHandler(memd.CmdReplace, x.handleReplaceRequest)
+	h.RegisterKvHandler(memd.CmdAdd, x.durableHandler(x.handleAddRequest))
+	h.RegisterKvHandler(memd.CmdSet, x.durableHandler(x.handleSetRequest))
+	h.RegisterKvHandler(memd.CmdReplace, x.durableHandler(x.handleReplaceRequest))
 	h.RegisterKvHandler(memd.CmdGet, x.handleGetRequest)
 	h.RegisterKvHandler(memd.CmdGetMeta, x.handleGetMetaRequest)
 	h.RegisterKvHandler(memd.CmdGetRandom, x.handleGetRandomRequest)
 	h.RegisterKvHandler(memd.CmdGetReplica, x.handleGetReplicaRequest)
-	h.RegisterKvHandler(memd.CmdDelete, x.handleDeleteRequest)
-	h.RegisterKvHandler(memd.CmdIncrement, x.handleIncrementRequest)
-	h.RegisterKvHandler(memd.CmdDecrement, x.handleDecrementRequest)
-	h.RegisterKvHandler(memd.CmdAppend, x.handleAppendRequest)
-	h.RegisterKvHandler(memd.CmdPrepend, x.handlePrependRequest)
+	h.RegisterKvHandler(memd.CmdDelete, x.durableHandler(x.handleDeleteRequest))
+	h.RegisterKvHandler(memd.CmdIncrement, x.durableHandler(x.handleIncrementRequest))
+	h.RegisterKvHandler(memd.CmdDecrement, x.durableHandler(x.handleDecrementRequest))
+	h.RegisterKvHandler(memd.CmdAppend, x.durableHandler(x.handleAppendRequest))
+	h.RegisterKvHandler(memd.CmdPrepend, x.durableHandler(x.handlePrependRequest))
 	h.RegisterKvHandler(memd.CmdTouch, x.handleTouchRequest)
 	h.RegisterKvHandler(memd.CmdGAT, x.handleGATRequest)
 	h.RegisterKvHandler(memd.CmdGetLocked, x.handleGetLockedRequest)
 	h.RegisterKvHandler(memd.CmdUnlockKey, x.handleUnlockRequest)
 	h.RegisterKvHandler(memd.CmdSubDocMultiLookup, x.handleMultiLookupRequest)
-	h.RegisterKvHandler(memd.CmdSubDocMultiMutation, x.handleMultiMutateRequest)
+	h.RegisterKvHandler(memd.CmdSubDocMultiMutation, x.durableHandler(x.handleMultiMutateRequest))
 	h.RegisterKvHandler(memd.CmdObserveSeqNo, x.handleObserveSeqNo)
-	h.RegisterKvHandler(memd.CmdCollectionsGetManifest, x.handleManifestRequest)
-	h.RegisterKvHandler(memd.CmdCollectionsGetID, x.handleGetCollectionIDRequest)
+	h.RegisterKvHandler(memd.CmdCollectionsGetManifest, x.versionedHandler(mock.ServerVersion70, x.handleManifestRequest))
+	h.RegisterKvHandler(memd.CmdCollectionsGetID, x.versionedHandler(mock.ServerVersion70, x.handleGetCollectionIDRequest))
 	h.RegisterKvHandler(memd.CmdStat, x.handleStatsRequest)
 }
 
+// durableHandler wraps a mutation handler such that requests with synchronous
+// durability requirements are processed in the background, as they block until
+// those requirements have been met.  Everything else is processed inline so that
+// replies are written in the order the requests arrived.
+func (x *kvImplCrud) durableHandler(handler func(source mock.KvClient, pak *memd.Packet, start time.Time)) func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	return func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+		level, _, status := x.durabilityRequirements(source, pak)
+		if status == memd.StatusSuccess && level != 0 && level <= memd.DurabilityLevelPersistToMajority {
+			go handler(source, pak, start)
+			return
+		}
+
+		handler(source, pak, start)
+	}
+}
+
+// versionedHandler wraps the handler of a command which only exists on servers
+// of at least the specified version.
+func (x *kvImplCrud) versionedHandler(minVersion mock.ServerVersion, handler func(source mock.KvClient, pak *memd.Packet, start time.Time)) func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	return func(source mock.KvClient, pak *memd.Packet, start time.Time) {
+		if source.Source().Node().Cluster().ServerVersion() < minVersion {
+			x.writeStatusReply(source, pak, memd.StatusUnknownCommand, start)
+			return
+		}
+
+		handler(source, pak, start)
+	}
+}
+
 func (x *kvImplCrud) writeStatusReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
 	writePacketToSource(source, &memd.Packet{
 		Magic:   memd.CmdMagicRes,
@@ -62,6 +99,15 @@ func (x *kvImplCrud) makeProc(source mock.KvClient, pak *memd.Packet, permission
 
 	sourceNode := source.Source().Node()
 	vbOwnership := selectedBucket.VbucketOwnership(sourceNode)
+	_, vbMap, _, _ := selectedBucket.GetVbServerInfo(sourceNode)
+
+	// Commands which carry a collection ID in their key must refer to a
+	// collection which exists in the current manifest.
+	if memd.IsCommandCollectionEncoded(pak.Command) {
+		if !x.checkCollectionID(source, pak, pak.CollectionID, start) {
+			return nil
+		}
+	}
 
 	if !source.CheckAuthenticated(permission, pak.CollectionID) {
 		// TODO(chvck): CheckAuthenticated needs to change, this could be actually be auth or access error depending on the user
@@ -70,11 +116,167 @@ func (x *kvImplCrud) makeProc(source mock.KvClient, pak *memd.Packet, permission
 		return nil
 	}
 
-	return kvproc.New(selectedBucket.Store(), vbOwnership)
+	return kvproc.New(selectedBucket.Store(), vbOwnership, vbMap, func(collectionID uint) time.Duration {
+		return x.collectionMaxTTL(selectedBucket, collectionID)
+	})
+}
+
+// collectionMaxTTL returns the maximum TTL applied to documents written into a
+// collection.  A collection's own max TTL takes precedence over the bucket's.
+func (x *kvImplCrud) collectionMaxTTL(bucket mock.Bucket, collectionID uint) time.Duration {
+	maxTTL, err := bucket.CollectionManifest().GetMaxTTL(uint32(collectionID))
+	if err != nil || maxTTL == 0 {
+		maxTTL = bucket.MaxTTL()
+	}
+
+	return time.Duration(maxTTL) * time.Second
 }
 
-func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
-	// TODO(brett19): Implement special handling for various errors on specific versions.
+// parseDurability either writes a reply to the network, or returns the durability
+// requirements of the request.
+func (x *kvImplCrud) parseDurability(source mock.KvClient, pak *memd.Packet, start time.Time) (memd.DurabilityLevel, time.Duration, bool) {
+	level, timeout, status := x.durabilityRequirements(source, pak)
+	if status != memd.StatusSuccess {
+		x.writeStatusReply(source, pak, status, start)
+		return 0, 0, false
+	}
+
+	return level, timeout, true
+}
+
+// durabilityRequirements returns the durability requirements of a request once
+// the minimum level of the bucket has been applied, or the status which the
+// request must be failed with.
+func (x *kvImplCrud) durabilityRequirements(source mock.KvClient, pak *memd.Packet) (memd.DurabilityLevel, time.Duration, memd.StatusCode) {
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		return 0, 0, memd.StatusNoBucket
+	}
+
+	minLevel := x.bucketDurabilityMinLevel(source)
+	if pak.DurabilityLevelFrame == nil {
+		// The bucket minimum applies even if the client didn't ask for durability.
+		return minLevel, 0, memd.StatusSuccess
+	}
+
+	// Synchronous replication was only introduced in 6.5.
+	if source.Source().Node().Cluster().ServerVersion() < mock.ServerVersion65 {
+		return 0, 0, memd.StatusInvalidArgs
+	}
+
+	level := pak.DurabilityLevelFrame.DurabilityLevel
+	if level < minLevel && level <= memd.DurabilityLevelPersistToMajority {
+		level = minLevel
+	}
+
+	var timeout time.Duration
+	if pak.DurabilityTimeoutFrame != nil {
+		timeout = pak.DurabilityTimeoutFrame.DurabilityTimeout
+	}
+
+	switch selectedBucket.BucketType() {
+	case mock.BucketTypeMemcached:
+		return 0, 0, memd.StatusNotSupported
+	case mock.BucketTypeEphemeral:
+		// Ephemeral buckets have nothing to persist to.
+		if level == memd.DurabilityLevelMajorityAndPersistOnMaster || level == memd.DurabilityLevelPersistToMajority {
+			return 0, 0, memd.StatusDurabilityInvalidLevel
+		}
+	}
+
+	return level, timeout, memd.StatusSuccess
+}
+
+// parseValue either writes a reply to the network, or returns the datatype and
+// value of a request with any snappy compression removed.
+func (x *kvImplCrud) parseValue(source mock.KvClient, pak *memd.Packet, start time.Time) (uint8, []byte, bool) {
+	if pak.Datatype&uint8(memd.DatatypeFlagCompressed) == 0 {
+		return pak.Datatype, pak.Value, true
+	}
+
+	// Clients may only send compressed values once they have negotiated it.
+	if !source.HasFeature(memd.FeatureSnappy) {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return 0, nil, false
+	}
+
+	value, err := snappy.Decode(nil, pak.Value)
+	if err != nil {
+		x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return 0, nil, false
+	}
+
+	return pak.Datatype &^ uint8(memd.DatatypeFlagCompressed), value, true
+}
+
+// parseDocumentValue is like parseValue, but is used for requests which replace
+// the whole value of a document.  Buckets in passive compression mode keep the
+// values which clients send compressed in that form, everything else is stored
+// inflated.
+func (x *kvImplCrud) parseDocumentValue(source mock.KvClient, pak *memd.Packet, start time.Time) (uint8, []byte, bool) {
+	datatype, value, ok := x.parseValue(source, pak, start)
+	if !ok {
+		return 0, nil, false
+	}
+
+	if pak.Datatype&uint8(memd.DatatypeFlagCompressed) != 0 &&
+		source.SelectedBucket().CompressionMode() == mock.CompressionModePassive {
+		return pak.Datatype, pak.Value, true
+	}
+
+	return datatype, value, true
+}
+
+// encodeValue returns the datatype and value which should be sent to a client
+// for a document.  Values held compressed are only sent that way to clients
+// which have negotiated snappy, and in active compression mode we additionally
+// compress any other values which shrink enough for those clients.
+func (x *kvImplCrud) encodeValue(source mock.KvClient, datatype uint8, value []byte) (uint8, []byte) {
+	if !source.HasFeature(memd.FeatureSnappy) {
+		return datatype &^ uint8(memd.DatatypeFlagCompressed), mockdb.InflateValue(datatype, value)
+	}
+
+	if datatype&uint8(memd.DatatypeFlagCompressed) != 0 {
+		return datatype, value
+	}
+
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil || selectedBucket.CompressionMode() != mock.CompressionModeActive {
+		return datatype, value
+	}
+
+	// Like the server, we only bother compressing values which shrink enough
+	// to make it worthwhile.
+	compressed := snappy.Encode(nil, value)
+	if float64(len(value)) < float64(len(compressed))*minCompressionRatio {
+		return datatype, value
+	}
+
+	return datatype | uint8(memd.DatatypeFlagCompressed), compressed
+}
+
+// bucketDurabilityMinLevel returns the minimum durability level which must be
+// applied to mutations against the selected bucket of a client.
+func (x *kvImplCrud) bucketDurabilityMinLevel(source mock.KvClient) memd.DurabilityLevel {
+	selectedBucket := source.SelectedBucket()
+	if selectedBucket == nil {
+		return 0
+	}
+
+	switch selectedBucket.DurabilityMinLevel() {
+	case mock.DurabilityLevelMajority:
+		return memd.DurabilityLevelMajority
+	case mock.DurabilityLevelMajorityAndPersistActive:
+		return memd.DurabilityLevelMajorityAndPersistOnMaster
+	case mock.DurabilityLevelPersistToMajority:
+		return memd.DurabilityLevelPersistToMajority
+	}
+
+	return 0
+}
+
+func (x *kvImplCrud) translateProcErr(source mock.KvClient, err error) memd.StatusCode {
+	serverVersion := source.Source().Node().Cluster().ServerVersion()
 
 	switch err {
 	case nil:
@@ -94,6 +296,11 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 	case kvproc.ErrCasMismatch:
 		return memd.StatusKeyExists
 	case kvproc.ErrLocked:
+		// Prior to 6.5 the server reported locked documents as a temporary
+		// failure, and it still does for clients which don't support xerror.
+		if serverVersion < mock.ServerVersion65 || !source.HasFeature(memd.FeatureXerror) {
+			return memd.StatusTmpFail
+		}
 		return memd.StatusLocked
 	case kvproc.ErrNotLocked:
 		return memd.StatusTmpFail
@@ -130,6 +337,22 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 		return memd.StatusCollectionUnknown
 	case kvproc.ErrSdXattrInvalidKeyCombo:
 		return memd.StatusSubDocXattrInvalidKeyCombo
+	case kvproc.ErrRange:
+		return memd.StatusRangeError
+	case kvproc.ErrDurabilityInvalidLevel:
+		return memd.StatusDurabilityInvalidLevel
+	case kvproc.ErrDurabilityImpossible:
+		return memd.StatusDurabilityImpossible
+	case kvproc.ErrSyncWriteInProgress:
+		return memd.StatusSyncWriteInProgress
+	case kvproc.ErrSyncWriteAmbiguous:
+		return memd.StatusSyncWriteAmbiguous
+	case kvproc.ErrSyncWriteRecommitInProgress:
+		return memd.StatusSyncWriteReCommitInProgress
+	case kvproc.ErrTmpFail:
+		return memd.StatusTmpFail
+	case kvproc.ErrVbUUIDMismatch:
+		return mock.StatusVbUUIDNotEqual
 	}
 
 	log.Printf("Recieved unexpected crud proc error: %s", err)
@@ -137,7 +360,69 @@ func (x *kvImplCrud) translateProcErr(err error) memd.StatusCode {
 }
 
 func (x *kvImplCrud) writeProcErr(source mock.KvClient, pak *memd.Packet, err error, start time.Time) {
-	x.writeStatusReply(source, pak, x.translateProcErr(err), start)
+	status := x.translateProcErr(source, err)
+	if status == memd.StatusCollectionUnknown || status == memd.StatusScopeUnknown {
+		x.writeUnknownCollectionReply(source, pak, status, start)
+		return
+	}
+	if status == memd.StatusNotMyVBucket {
+		x.writeNotMyVbucketReply(source, pak, start)
+		return
+	}
+
+	x.writeStatusReply(source, pak, status, start)
+}
+
+// checkCollectionID either writes an unknown collection reply to the network, or
+// returns true to indicate that the collection exists in the bucket's manifest.
+func (x *kvImplCrud) checkCollectionID(source mock.KvClient, pak *memd.Packet, collectionID uint32, start time.Time) bool {
+	if err := source.SelectedBucket().CollectionManifest().CheckCollectionID(collectionID); err != nil {
+		x.writeProcErr(source, pak, err, start)
+		return false
+	}
+
+	return true
+}
+
+// writeUnknownCollectionReply writes an unknown scope or collection error.  Like
+// the real server, the body holds the uid of the manifest that the request was
+// checked against so that clients can tell if their own manifest is outdated.
+func (x *kvImplCrud) writeUnknownCollectionReply(source mock.KvClient, pak *memd.Packet, status memd.StatusCode, start time.Time) {
+	manifestUID := source.SelectedBucket().CollectionManifest().UID()
+	value, err := json.Marshal(struct {
+		ManifestUID string `json:"manifest_uid"`
+	}{
+		ManifestUID: strconv.FormatUint(manifestUID, 16),
+	})
+	if err != nil {
+		x.writeStatusReply(source, pak, memd.StatusInternalError, start)
+		return
+	}
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:    memd.CmdMagicRes,
+		Command:  pak.Command,
+		Opaque:   pak.Opaque,
+		Status:   status,
+		Datatype: uint8(memd.DatatypeFlagJSON),
+		Value:    value,
+	}, start)
+}
+
+// writeNotMyVbucketReply writes a not my vbucket error.  Like the real server,
+// the body holds the current config of the bucket so that clients which are
+// routing with an outdated config can pick up the new one straight away.
+func (x *kvImplCrud) writeNotMyVbucketReply(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	configBytes := GenTerseBucketConfig(source.SelectedBucket(), source.Source().Node())
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:    memd.CmdMagicRes,
+		Command:  pak.Command,
+		Opaque:   pak.Opaque,
+		Status:   memd.StatusNotMyVBucket,
+		Datatype: uint8(memd.DatatypeFlagJSON),
+		Value:    configBytes,
+	}, start)
 }
 
 func (x *kvImplCrud) handleGetRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
@@ -160,14 +445,16 @@ func (x *kvImplCrud) handleGetRequest(source mock.KvClient, pak *memd.Packet, st
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
 
+		datatype, value := x.encodeValue(source, resp.Datatype, resp.Value)
+
 		writePacketToSource(source, &memd.Packet{
 			Magic:    memd.CmdMagicRes,
 			Command:  pak.Command,
 			Opaque:   pak.Opaque,
 			Status:   memd.StatusSuccess,
 			Cas:      resp.Cas,
-			Datatype: resp.Datatype,
-			Value:    resp.Value,
+			Datatype: datatype,
+			Value:    value,
 			Extras:   extrasBuf,
 		}, start)
 	}
@@ -203,7 +490,7 @@ func (x *kvImplCrud) handleGetMetaRequest(source mock.KvClient, pak *memd.Packet
 			binary.BigEndian.PutUint32(extrasBuf[0:], 0)
 		}
 		binary.BigEndian.PutUint32(extrasBuf[4:], resp.Flags)
-		binary.BigEndian.PutUint32(extrasBuf[8:], uint32(resp.ExpTime.Unix()))
+		binary.BigEndian.PutUint32(extrasBuf[8:], x.encodeExpiry(source, resp.ExpTime))
 		binary.BigEndian.PutUint64(extrasBuf[12:], resp.SeqNo)
 		extrasBuf[20] = resp.Datatype
 
@@ -220,6 +507,16 @@ func (x *kvImplCrud) handleGetMetaRequest(source mock.KvClient, pak *memd.Packet
 	}
 }
 
+// encodeExpiry converts a document expiry into the unix timestamp reported to
+// clients, taking into account any time travel which has been performed.
+func (x *kvImplCrud) encodeExpiry(source mock.KvClient, expiry time.Time) uint32 {
+	if expiry.IsZero() {
+		return 0
+	}
+
+	return uint32(expiry.Add(-source.SelectedBucket().Store().Chrono().TimeShift()).Unix())
+}
+
 func (x *kvImplCrud) handleGetRandomRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
 		var collectionID uint32
@@ -230,6 +527,11 @@ func (x *kvImplCrud) handleGetRandomRequest(source mock.KvClient, pak *memd.Pack
 			return
 		}
 
+		// GetRandom carries its collection ID in the extras rather than the key.
+		if !x.checkCollectionID(source, pak, collectionID, start) {
+			return
+		}
+
 		resp, err := proc.GetRandom(kvproc.GetRandomOptions{
 			CollectionID: uint(collectionID),
 		})
@@ -241,14 +543,16 @@ func (x *kvImplCrud) handleGetRandomRequest(source mock.KvClient, pak *memd.Pack
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
 
+		datatype, value := x.encodeValue(source, resp.Datatype, resp.Value)
+
 		writePacketToSource(source, &memd.Packet{
 			Magic:    memd.CmdMagicRes,
 			Command:  pak.Command,
 			Opaque:   pak.Opaque,
 			Status:   memd.StatusSuccess,
 			Cas:      resp.Cas,
-			Datatype: resp.Datatype,
-			Value:    resp.Value,
+			Datatype: datatype,
+			Value:    value,
 			Extras:   extrasBuf,
 			Key:      resp.Key,
 		}, start)
@@ -275,14 +579,16 @@ func (x *kvImplCrud) handleGetReplicaRequest(source mock.KvClient, pak *memd.Pac
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
 
+		datatype, value := x.encodeValue(source, resp.Datatype, resp.Value)
+
 		writePacketToSource(source, &memd.Packet{
 			Magic:    memd.CmdMagicRes,
 			Command:  pak.Command,
 			Opaque:   pak.Opaque,
 			Status:   memd.StatusSuccess,
 			Cas:      resp.Cas,
-			Datatype: resp.Datatype,
-			Value:    resp.Value,
+			Datatype: datatype,
+			Value:    value,
 			Extras:   extrasBuf,
 		}, start)
 	}
@@ -290,6 +596,16 @@ func (x *kvImplCrud) handleGetReplicaRequest(source mock.KvClient, pak *memd.Pac
 
 func (x *kvImplCrud) handleAddRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
+		datatype, value, ok := x.parseDocumentValue(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 8 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
@@ -304,13 +620,15 @@ func (x *kvImplCrud) handleAddRequest(source mock.KvClient, pak *memd.Packet, st
 		expiry := binary.BigEndian.Uint32(pak.Extras[4:])
 
 		resp, err := proc.Add(kvproc.StoreOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Datatype:     pak.Datatype,
-			Value:        pak.Value,
-			Flags:        flags,
-			Expiry:       expiry,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Datatype:          datatype,
+			Value:             value,
+			Flags:             flags,
+			Expiry:            expiry,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -339,6 +657,16 @@ func (x *kvImplCrud) handleAddRequest(source mock.KvClient, pak *memd.Packet, st
 
 func (x *kvImplCrud) handleSetRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
+		datatype, value, ok := x.parseDocumentValue(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 8 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
@@ -348,14 +676,16 @@ func (x *kvImplCrud) handleSetRequest(source mock.KvClient, pak *memd.Packet, st
 		expiry := binary.BigEndian.Uint32(pak.Extras[4:])
 
 		resp, err := proc.Set(kvproc.StoreOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Datatype:     pak.Datatype,
-			Value:        pak.Value,
-			Flags:        flags,
-			Expiry:       expiry,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Datatype:          datatype,
+			Value:             value,
+			Flags:             flags,
+			Expiry:            expiry,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -384,6 +714,16 @@ func (x *kvImplCrud) handleSetRequest(source mock.KvClient, pak *memd.Packet, st
 
 func (x *kvImplCrud) handleReplaceRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
+		datatype, value, ok := x.parseDocumentValue(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 8 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
@@ -393,14 +733,16 @@ func (x *kvImplCrud) handleReplaceRequest(source mock.KvClient, pak *memd.Packet
 		expiry := binary.BigEndian.Uint32(pak.Extras[4:])
 
 		resp, err := proc.Replace(kvproc.StoreOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Datatype:     pak.Datatype,
-			Value:        pak.Value,
-			Flags:        flags,
-			Expiry:       expiry,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Datatype:          datatype,
+			Value:             value,
+			Flags:             flags,
+			Expiry:            expiry,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -429,16 +771,23 @@ func (x *kvImplCrud) handleReplaceRequest(source mock.KvClient, pak *memd.Packet
 
 func (x *kvImplCrud) handleDeleteRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 0 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
 		}
 
 		resp, err := proc.Delete(kvproc.DeleteOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -467,6 +816,11 @@ func (x *kvImplCrud) handleDeleteRequest(source mock.KvClient, pak *memd.Packet,
 
 func (x *kvImplCrud) handleIncrementRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 20 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
@@ -477,13 +831,15 @@ func (x *kvImplCrud) handleIncrementRequest(source mock.KvClient, pak *memd.Pack
 		expiry := binary.BigEndian.Uint32(pak.Extras[16:])
 
 		resp, err := proc.Increment(kvproc.CounterOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Initial:      initial,
-			Delta:        delta,
-			Expiry:       expiry,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Initial:           initial,
+			Delta:             delta,
+			Expiry:            expiry,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -516,6 +872,11 @@ func (x *kvImplCrud) handleIncrementRequest(source mock.KvClient, pak *memd.Pack
 
 func (x *kvImplCrud) handleDecrementRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 20 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
@@ -526,13 +887,15 @@ func (x *kvImplCrud) handleDecrementRequest(source mock.KvClient, pak *memd.Pack
 		expiry := binary.BigEndian.Uint32(pak.Extras[16:])
 
 		resp, err := proc.Decrement(kvproc.CounterOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Initial:      initial,
-			Delta:        delta,
-			Expiry:       expiry,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Initial:           initial,
+			Delta:             delta,
+			Expiry:            expiry,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -565,18 +928,30 @@ func (x *kvImplCrud) handleDecrementRequest(source mock.KvClient, pak *memd.Pack
 
 func (x *kvImplCrud) handleAppendRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
+		_, value, ok := x.parseValue(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 0 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
 		}
 
 		resp, err := proc.Append(kvproc.StoreOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Expiry:       0,
-			Value:        pak.Value,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Expiry:            0,
+			Value:             value,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -605,18 +980,30 @@ func (x *kvImplCrud) handleAppendRequest(source mock.KvClient, pak *memd.Packet,
 
 func (x *kvImplCrud) handlePrependRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
+		_, value, ok := x.parseValue(source, pak, start)
+		if !ok {
+			return
+		}
+
 		if len(pak.Extras) != 0 {
 			x.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
 			return
 		}
 
 		resp, err := proc.Prepend(kvproc.StoreOptions{
-			Vbucket:      uint(pak.Vbucket),
-			CollectionID: uint(pak.CollectionID),
-			Key:          pak.Key,
-			Cas:          pak.Cas,
-			Expiry:       0,
-			Value:        pak.Value,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			Cas:               pak.Cas,
+			Expiry:            0,
+			Value:             value,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			x.writeProcErr(source, pak, err, start)
@@ -706,14 +1093,16 @@ func (x *kvImplCrud) handleGATRequest(source mock.KvClient, pak *memd.Packet, st
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
 
+		datatype, value := x.encodeValue(source, resp.Datatype, resp.Value)
+
 		writePacketToSource(source, &memd.Packet{
 			Magic:    memd.CmdMagicRes,
 			Command:  pak.Command,
 			Opaque:   pak.Opaque,
 			Status:   memd.StatusSuccess,
 			Cas:      resp.Cas,
-			Datatype: resp.Datatype,
-			Value:    resp.Value,
+			Datatype: datatype,
+			Value:    value,
 			Extras:   extrasBuf,
 		}, start)
 	}
@@ -742,14 +1131,16 @@ func (x *kvImplCrud) handleGetLockedRequest(source mock.KvClient, pak *memd.Pack
 		extrasBuf := make([]byte, 4)
 		binary.BigEndian.PutUint32(extrasBuf[0:], resp.Flags)
 
+		datatype, value := x.encodeValue(source, resp.Datatype, resp.Value)
+
 		writePacketToSource(source, &memd.Packet{
 			Magic:    memd.CmdMagicRes,
 			Command:  pak.Command,
 			Opaque:   pak.Opaque,
 			Status:   memd.StatusSuccess,
 			Cas:      resp.Cas,
-			Datatype: resp.Datatype,
-			Value:    resp.Value,
+			Datatype: datatype,
+			Value:    value,
 			Extras:   extrasBuf,
 		}, start)
 	}
@@ -861,7 +1252,7 @@ func (x *kvImplCrud) handleMultiLookupRequest(source mock.KvClient, pak *memd.Pa
 		anOperationFailed := false
 		for _, opRes := range resp.Ops {
 			opBytes := make([]byte, 6)
-			resStatus := x.translateProcErr(opRes.Err)
+			resStatus := x.translateProcErr(source, opRes.Err)
 
 			binary.BigEndian.PutUint16(opBytes[0:], uint16(resStatus))
 			binary.BigEndian.PutUint32(opBytes[2:], uint32(len(opRes.Value)))
@@ -900,6 +1291,11 @@ func (x *kvImplCrud) handleMultiLookupRequest(source mock.KvClient, pak *memd.Pa
 
 func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
 	if proc := x.makeProc(source, pak, mockauth.PermissionDataWrite, start); proc != nil {
+		durabilityLevel, durabilityTimeout, ok := x.parseDurability(source, pak, start)
+		if !ok {
+			return
+		}
+
 		var docFlags memd.SubdocDocFlag
 		var expiry uint32
 		if len(pak.Extras) > 0 {
@@ -1042,16 +1438,18 @@ func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Pa
 		}
 
 		resp, err := proc.MultiMutate(kvproc.MultiMutateOptions{
-			Vbucket:         uint(pak.Vbucket),
-			CollectionID:    uint(pak.CollectionID),
-			Key:             pak.Key,
-			AccessDeleted:   docFlags&memd.SubdocDocFlagAccessDeleted != 0,
-			CreateAsDeleted: docFlags&memd.SubdocDocFlagCreateAsDeleted != 0,
-			CreateIfMissing: docFlags&memd.SubdocDocFlagMkDoc != 0,
-			CreateOnly:      docFlags&memd.SubdocDocFlagAddDoc != 0,
-			Ops:             ops,
-			Expiry:          expiry,
-			Cas:             pak.Cas,
+			Vbucket:           uint(pak.Vbucket),
+			CollectionID:      uint(pak.CollectionID),
+			Key:               pak.Key,
+			AccessDeleted:     docFlags&memd.SubdocDocFlagAccessDeleted != 0,
+			CreateAsDeleted:   docFlags&memd.SubdocDocFlagCreateAsDeleted != 0,
+			CreateIfMissing:   docFlags&memd.SubdocDocFlagMkDoc != 0,
+			CreateOnly:        docFlags&memd.SubdocDocFlagAddDoc != 0,
+			Ops:               ops,
+			Expiry:            expiry,
+			Cas:               pak.Cas,
+			DurabilityLevel:   durabilityLevel,
+			DurabilityTimeout: durabilityTimeout,
 		})
 		if err != nil {
 			if e, ok := err.(kvproc.SubdocMutateError); ok {
@@ -1078,7 +1476,7 @@ func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Pa
 		for opIdx, opRes := range resp.Ops {
 			if opRes.Err == nil && len(opRes.Value) > 0 {
 				opBytes := make([]byte, 7)
-				resStatus := x.translateProcErr(opRes.Err)
+				resStatus := x.translateProcErr(source, opRes.Err)
 
 				opBytes[0] = uint8(opIdx)
 				binary.BigEndian.PutUint16(opBytes[1:], uint16(resStatus))
@@ -1111,7 +1509,7 @@ func (x *kvImplCrud) handleMultiMutateRequest(source mock.KvClient, pak *memd.Pa
 }
 
 func (x *kvImplCrud) writeSubdocMutateErr(source mock.KvClient, pak *memd.Packet, start time.Time, errIdx int, err error) {
-	resStatus := x.translateProcErr(err)
+	resStatus := x.translateProcErr(source, err)
 
 	valueBytes := make([]byte, 3)
 	valueBytes[0] = uint8(0)
@@ -1177,7 +1575,7 @@ func (x *kvImplCrud) handleStatsRequest(source mock.KvClient, pak *memd.Packet,
 				Value:   []byte(source.SelectedBucket().ID()),
 			}, start)
 		} else {
-			stats, err := x.getStats(string(pak.Key))
+			stats, err := x.getStats(source, string(pak.Key))
 			if err != nil {
 				x.writeProcErr(source, pak, err, start)
 				return
@@ -1205,7 +1603,21 @@ func (x *kvImplCrud) handleStatsRequest(source mock.KvClient, pak *memd.Packet,
 	}
 }
 
-func (x *kvImplCrud) getStats(key string) (map[string]string, error) {
+func (x *kvImplCrud) getStats(source mock.KvClient, key string) (map[string]string, error) {
+	statGroup := key
+	statArg := ""
+	if spaceIdx := strings.IndexByte(key, ' '); spaceIdx >= 0 {
+		statGroup = key[:spaceIdx]
+		statArg = key[spaceIdx+1:]
+	}
+
+	switch statGroup {
+	case "collections", "collections-byid":
+		return x.getCollectionStats(source, statGroup == "collections-byid", statArg)
+	case "scopes", "scopes-byid":
+		return x.getScopeStats(source, statGroup == "scopes-byid", statArg)
+	}
+
 	if key == "" {
 		return x.defaultStats(), nil
 	} else if key == "memory" {
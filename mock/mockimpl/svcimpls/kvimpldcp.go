@@ -0,0 +1,808 @@
+package svcimpls
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+)
+
+const (
+	dcpSnapshotFlagMemory = uint32(0x01)
+	dcpSnapshotFlagDisk   = uint32(0x02)
+)
+
+type kvImplDcp struct {
+	crud kvImplCrud
+
+	lock  sync.Mutex
+	conns map[mock.KvClient]*dcpConn
+}
+
+// dcpConn represents the DCP state of a single client connection.
+type dcpConn struct {
+	lock        sync.Mutex
+	name        string
+	flags       memd.DcpOpenFlag
+	controls    map[string]string
+	streams     map[dcpStreamKey]*dcpStream
+	closeCh     chan struct{}
+	sendingNoop bool
+}
+
+type dcpStreamKey struct {
+	vbID     uint16
+	streamID uint16
+}
+
+// dcpStream represents a single open stream on a DCP connection.
+type dcpStream struct {
+	key         dcpStreamKey
+	opaque      uint32
+	hasStreamID bool
+	proc        *kvproc.Engine
+	vbucket     *mockdb.Vbucket
+	vbUUID      uint64
+	lastSeqNo   uint64
+	endSeqNo    uint64
+	collections map[uint]bool
+	closeCh     chan struct{}
+}
+
+func (x *kvImplDcp) Register(h *hookHelper) {
+	x.conns = make(map[mock.KvClient]*dcpConn)
+
+	h.RegisterKvHandler(memd.CmdDcpOpenConnection, x.handleOpenConnectionRequest)
+	h.RegisterKvHandler(memd.CmdDcpControl, x.handleControlRequest)
+	h.RegisterKvHandler(memd.CmdDcpStreamReq, x.handleStreamRequest)
+	h.RegisterKvHandler(memd.CmdDcpCloseStream, x.handleCloseStreamRequest)
+	h.RegisterKvHandler(memd.CmdDcpGetFailoverLog, x.handleGetFailoverLogRequest)
+	h.RegisterKvHandler(memd.CmdDcpBufferAck, x.handleBufferAckRequest)
+	h.RegisterKvHandler(memd.CmdGetAllVBSeqnos, x.handleGetAllVbSeqnosRequest)
+
+	// Clients reply to the noops we send them, we just need to swallow these.
+	h.KvInHooks.Add(func(source mock.KvClient, pak *memd.Packet, start time.Time, next func()) {
+		if pak.Magic == memd.CmdMagicRes && pak.Command == memd.CmdDcpNoop {
+			return
+		}
+		next()
+	})
+
+	h.RegisterKvLostClientHandler(func(source mock.KvClient) {
+		if conn := x.getConn(source); conn != nil {
+			x.closeConn(source, conn)
+		}
+	})
+}
+
+func (x *kvImplDcp) getConn(source mock.KvClient) *dcpConn {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	return x.conns[source]
+}
+
+// closeConn tears down all the DCP state for a client, this is invoked when
+// the client disconnects or when we fail to write to it.
+func (x *kvImplDcp) closeConn(source mock.KvClient, conn *dcpConn) {
+	x.lock.Lock()
+	if x.conns[source] == conn {
+		delete(x.conns, source)
+	}
+	x.lock.Unlock()
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	select {
+	case <-conn.closeCh:
+	default:
+		close(conn.closeCh)
+	}
+	for key, stream := range conn.streams {
+		close(stream.closeCh)
+		delete(conn.streams, key)
+	}
+}
+
+func (x *kvImplDcp) handleOpenConnectionRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if source.SelectedBucket() == nil {
+		x.crud.writeStatusReply(source, pak, memd.StatusNoBucket, start)
+		return
+	}
+	if !source.CheckAuthenticated(mockauth.PermissionDCPRead, 0) {
+		x.crud.writeStatusReply(source, pak, memd.StatusAccessError, start)
+		return
+	}
+
+	if len(pak.Extras) != 8 || len(pak.Key) == 0 {
+		x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	flags := memd.DcpOpenFlag(binary.BigEndian.Uint32(pak.Extras[4:]))
+	if flags&memd.DcpOpenFlagProducer == 0 {
+		// We only support acting as a producer, consumers and notifiers are
+		// not something that SDKs ever need.
+		x.crud.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+		return
+	}
+
+	if oldConn := x.getConn(source); oldConn != nil {
+		x.closeConn(source, oldConn)
+	}
+
+	conn := &dcpConn{
+		name:     string(pak.Key),
+		flags:    flags,
+		controls: make(map[string]string),
+		streams:  make(map[dcpStreamKey]*dcpStream),
+		closeCh:  make(chan struct{}),
+	}
+
+	x.lock.Lock()
+	x.conns[source] = conn
+	x.lock.Unlock()
+
+	x.crud.writeStatusReply(source, pak, memd.StatusSuccess, start)
+}
+
+func (x *kvImplDcp) handleControlRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	conn := x.getConn(source)
+	if conn == nil {
+		x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	key := string(pak.Key)
+	value := string(pak.Value)
+
+	switch key {
+	case "set_noop_interval":
+		interval, err := strconv.Atoi(value)
+		if err != nil || interval <= 0 {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+	case "enable_noop", "enable_expiry_opcode", "enable_stream_id",
+		"send_stream_end_on_client_close_stream":
+		if value != "true" && value != "false" {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+	}
+
+	// Only a single noop loop is ever started per connection, it picks up
+	// any later changes to the interval by itself.
+	startNoops := false
+
+	conn.lock.Lock()
+	conn.controls[key] = value
+	if key == "enable_noop" && value == "true" && !conn.sendingNoop {
+		conn.sendingNoop = true
+		startNoops = true
+	}
+	conn.lock.Unlock()
+
+	x.crud.writeStatusReply(source, pak, memd.StatusSuccess, start)
+
+	if startNoops {
+		go x.runNoops(source, conn)
+	}
+}
+
+func (x *kvImplDcp) controlEnabled(conn *dcpConn, key string) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return conn.controls[key] == "true"
+}
+
+// runNoops periodically sends noops to the client when they are enabled, this
+// also allows us to discover when the client has gone away.
+func (x *kvImplDcp) runNoops(source mock.KvClient, conn *dcpConn) {
+	chrono := source.Source().Node().Cluster().Chrono()
+
+	for {
+		conn.lock.Lock()
+		interval, err := strconv.Atoi(conn.controls["set_noop_interval"])
+		if err != nil || interval <= 0 {
+			interval = 180
+		}
+		conn.lock.Unlock()
+
+		select {
+		case <-conn.closeCh:
+			return
+		case <-chrono.After(time.Duration(interval) * time.Second):
+		}
+
+		// Noops stop being sent once they are disabled again.
+		conn.lock.Lock()
+		if conn.controls["enable_noop"] != "true" {
+			conn.sendingNoop = false
+			conn.lock.Unlock()
+			return
+		}
+		conn.lock.Unlock()
+
+		err = source.WritePacket(&memd.Packet{
+			Magic:   memd.CmdMagicReq,
+			Command: memd.CmdDcpNoop,
+		})
+		if err != nil {
+			x.closeConn(source, conn)
+			return
+		}
+	}
+}
+
+// parseStreamFilter parses the optional collection filter which can be sent
+// with a stream request.  A nil map indicates that all collections are wanted.
+func (x *kvImplDcp) parseStreamFilter(source mock.KvClient, value []byte) (map[uint]bool, memd.StatusCode) {
+	if !source.HasFeature(memd.FeatureCollections) {
+		if len(value) > 0 {
+			return nil, memd.StatusInvalidArgs
+		}
+
+		// Clients which are not collection-aware only see the default collection.
+		return map[uint]bool{0: true}, memd.StatusSuccess
+	}
+
+	if len(value) == 0 {
+		return nil, memd.StatusSuccess
+	}
+
+	var filter struct {
+		Collections []string `json:"collections"`
+		Scope       *string  `json:"scope"`
+	}
+	if err := json.Unmarshal(value, &filter); err != nil {
+		return nil, memd.StatusInvalidArgs
+	}
+
+	if filter.Scope == nil && filter.Collections == nil {
+		return nil, memd.StatusSuccess
+	}
+	if filter.Scope != nil && filter.Collections != nil {
+		return nil, memd.StatusInvalidArgs
+	}
+
+	_, scopes := source.SelectedBucket().CollectionManifest().GetManifest()
+
+	collections := make(map[uint]bool)
+	if filter.Scope != nil {
+		scopeID, err := strconv.ParseUint(*filter.Scope, 16, 32)
+		if err != nil {
+			return nil, memd.StatusInvalidArgs
+		}
+
+		foundScope := false
+		for _, scope := range scopes {
+			if scope.UID != uint32(scopeID) {
+				continue
+			}
+
+			foundScope = true
+			for _, collection := range scope.Collections {
+				collections[uint(collection.UID)] = true
+			}
+		}
+		if !foundScope {
+			return nil, memd.StatusScopeUnknown
+		}
+
+		return collections, memd.StatusSuccess
+	}
+
+	knownCollections := make(map[uint]bool)
+	for _, scope := range scopes {
+		for _, collection := range scope.Collections {
+			knownCollections[uint(collection.UID)] = true
+		}
+	}
+
+	for _, collectionStr := range filter.Collections {
+		collectionID, err := strconv.ParseUint(collectionStr, 16, 32)
+		if err != nil {
+			return nil, memd.StatusInvalidArgs
+		}
+		if !knownCollections[uint(collectionID)] {
+			return nil, memd.StatusCollectionUnknown
+		}
+
+		collections[uint(collectionID)] = true
+	}
+
+	return collections, memd.StatusSuccess
+}
+
+func (x *kvImplDcp) writeFailoverLogReply(source mock.KvClient, pak *memd.Packet, entries []mockdb.VbRevData, start time.Time) {
+	valueBuf := make([]byte, len(entries)*16)
+	for entryIdx, entry := range entries {
+		binary.BigEndian.PutUint64(valueBuf[entryIdx*16+0:], entry.VbUUID)
+		binary.BigEndian.PutUint64(valueBuf[entryIdx*16+8:], entry.SeqNo)
+	}
+
+	writePacketToSource(source, &memd.Packet{
+		Magic:         memd.CmdMagicRes,
+		Command:       pak.Command,
+		Opaque:        pak.Opaque,
+		Status:        memd.StatusSuccess,
+		Value:         valueBuf,
+		StreamIDFrame: pak.StreamIDFrame,
+	}, start)
+}
+
+func (x *kvImplDcp) handleStreamRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	conn := x.getConn(source)
+	if conn == nil {
+		x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDCPRead, start); proc != nil {
+		if len(pak.Extras) != 48 {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		flags := memd.DcpStreamAddFlag(binary.BigEndian.Uint32(pak.Extras[0:]))
+		startSeqNo := binary.BigEndian.Uint64(pak.Extras[8:])
+		endSeqNo := binary.BigEndian.Uint64(pak.Extras[16:])
+		vbUUID := binary.BigEndian.Uint64(pak.Extras[24:])
+		snapStartSeqNo := binary.BigEndian.Uint64(pak.Extras[32:])
+		snapEndSeqNo := binary.BigEndian.Uint64(pak.Extras[40:])
+
+		collections, status := x.parseStreamFilter(source, pak.Value)
+		if status == memd.StatusCollectionUnknown || status == memd.StatusScopeUnknown {
+			x.crud.writeUnknownCollectionReply(source, pak, status, start)
+			return
+		} else if status != memd.StatusSuccess {
+			x.crud.writeStatusReply(source, pak, status, start)
+			return
+		}
+
+		key := dcpStreamKey{vbID: pak.Vbucket}
+		if pak.StreamIDFrame != nil {
+			key.streamID = pak.StreamIDFrame.StreamID
+		}
+
+		conn.lock.Lock()
+		_, streamExists := conn.streams[key]
+		conn.lock.Unlock()
+		if streamExists {
+			x.crud.writeStatusReply(source, pak, memd.StatusKeyExists, start)
+			return
+		}
+
+		resp, err := proc.DcpStreamRequest(kvproc.DcpStreamRequestOptions{
+			Vbucket:        uint(pak.Vbucket),
+			VbUUID:         vbUUID,
+			StartSeqNo:     startSeqNo,
+			EndSeqNo:       endSeqNo,
+			SnapStartSeqNo: snapStartSeqNo,
+			SnapEndSeqNo:   snapEndSeqNo,
+			ToLatest:       flags&(memd.DcpStreamAddFlagLatest|memd.DcpStreamAddFlagDiskOnly) != 0,
+		})
+		if rollbackErr, ok := err.(kvproc.DcpRollbackError); ok {
+			valueBuf := make([]byte, 8)
+			binary.BigEndian.PutUint64(valueBuf[0:], rollbackErr.SeqNo)
+
+			writePacketToSource(source, &memd.Packet{
+				Magic:         memd.CmdMagicRes,
+				Command:       pak.Command,
+				Opaque:        pak.Opaque,
+				Status:        memd.StatusRollback,
+				Value:         valueBuf,
+				StreamIDFrame: pak.StreamIDFrame,
+			}, start)
+			return
+		} else if err == kvproc.ErrRange {
+			x.crud.writeStatusReply(source, pak, memd.StatusRangeError, start)
+			return
+		} else if err != nil {
+			x.crud.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		stream := &dcpStream{
+			key:         key,
+			opaque:      pak.Opaque,
+			hasStreamID: pak.StreamIDFrame != nil,
+			proc:        proc,
+			vbucket:     source.SelectedBucket().Store().GetVbucket(uint(pak.Vbucket)),
+			vbUUID:      resp.FailoverLog[0].VbUUID,
+			lastSeqNo:   startSeqNo,
+			endSeqNo:    resp.EndSeqNo,
+			collections: collections,
+			closeCh:     make(chan struct{}),
+		}
+
+		// Another request for the same stream may have raced with us, or the
+		// connection may have been closed, while we were not holding the lock.
+		conn.lock.Lock()
+		status = memd.StatusSuccess
+		if _, streamExists := conn.streams[key]; streamExists {
+			status = memd.StatusKeyExists
+		}
+		select {
+		case <-conn.closeCh:
+			status = memd.StatusInvalidArgs
+		default:
+		}
+		if status == memd.StatusSuccess {
+			conn.streams[key] = stream
+		}
+		conn.lock.Unlock()
+		if status != memd.StatusSuccess {
+			x.crud.writeStatusReply(source, pak, status, start)
+			return
+		}
+
+		x.writeFailoverLogReply(source, pak, resp.FailoverLog, start)
+
+		go x.runStream(source, conn, stream)
+	}
+}
+
+func (x *kvImplDcp) handleCloseStreamRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	conn := x.getConn(source)
+	if conn == nil {
+		x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+		return
+	}
+
+	key := dcpStreamKey{vbID: pak.Vbucket}
+	if pak.StreamIDFrame != nil {
+		key.streamID = pak.StreamIDFrame.StreamID
+	}
+
+	conn.lock.Lock()
+	stream, ok := conn.streams[key]
+	if ok {
+		delete(conn.streams, key)
+		close(stream.closeCh)
+	}
+	conn.lock.Unlock()
+
+	if !ok {
+		x.crud.writeStatusReply(source, pak, memd.StatusKeyNotFound, start)
+		return
+	}
+
+	x.crud.writeStatusReply(source, pak, memd.StatusSuccess, start)
+
+	if x.controlEnabled(conn, "send_stream_end_on_client_close_stream") {
+		x.writeStreamEnd(source, stream, memd.StreamEndClosed)
+	}
+}
+
+func (x *kvImplDcp) handleGetFailoverLogRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDCPRead, start); proc != nil {
+		resp, err := proc.DcpFailoverLog(kvproc.DcpFailoverLogOptions{
+			Vbucket: uint(pak.Vbucket),
+		})
+		if err != nil {
+			x.crud.writeProcErr(source, pak, err, start)
+			return
+		}
+
+		x.writeFailoverLogReply(source, pak, resp.Entries, start)
+	}
+}
+
+func (x *kvImplDcp) handleBufferAckRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	// We do not implement flow control, so buffer acknowledgements are simply
+	// ignored.  The server never replies to these.
+}
+
+func (x *kvImplDcp) handleGetAllVbSeqnosRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		var state memd.VbucketState
+		if len(pak.Extras) >= 4 {
+			state = memd.VbucketState(binary.BigEndian.Uint32(pak.Extras[0:]))
+		}
+		if len(pak.Extras) != 0 && len(pak.Extras) != 4 && len(pak.Extras) != 8 {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+		if len(pak.Extras) == 8 {
+			if !x.crud.checkCollectionID(source, pak, binary.BigEndian.Uint32(pak.Extras[4:]), start) {
+				return
+			}
+
+			// Per-collection high seqnos are not tracked by our storage, so
+			// we only support this for the default collection.
+			if binary.BigEndian.Uint32(pak.Extras[4:]) != 0 {
+				x.crud.writeStatusReply(source, pak, memd.StatusNotSupported, start)
+				return
+			}
+		}
+
+		selectedBucket := source.SelectedBucket()
+		vbOwnership := selectedBucket.VbucketOwnership(source.Source().Node())
+
+		var valueBuf []byte
+		for vbIdx, repIdx := range vbOwnership {
+			if repIdx == -1 {
+				continue
+			}
+			if state == memd.VbucketStateActive && repIdx != 0 {
+				continue
+			}
+			if state == memd.VbucketStateReplica && repIdx == 0 {
+				continue
+			}
+			if state == memd.VbucketStatePending || state == memd.VbucketStateDead {
+				continue
+			}
+
+			resp, err := proc.ObserveSeqNo(kvproc.ObserveSeqNoOptions{
+				Vbucket: uint(vbIdx),
+			})
+			if err != nil {
+				x.crud.writeProcErr(source, pak, err, start)
+				return
+			}
+
+			entryBuf := make([]byte, 10)
+			binary.BigEndian.PutUint16(entryBuf[0:], uint16(vbIdx))
+			binary.BigEndian.PutUint64(entryBuf[2:], resp.CurrentSeqNo)
+			valueBuf = append(valueBuf, entryBuf...)
+		}
+
+		writePacketToSource(source, &memd.Packet{
+			Magic:   memd.CmdMagicRes,
+			Command: pak.Command,
+			Opaque:  pak.Opaque,
+			Status:  memd.StatusSuccess,
+			Value:   valueBuf,
+		}, start)
+	}
+}
+
+// removeStream removes a stream from its connection, returning false if it
+// had already been removed (such as by a close stream request).
+func (x *kvImplDcp) removeStream(conn *dcpConn, stream *dcpStream) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.streams[stream.key] != stream {
+		return false
+	}
+
+	delete(conn.streams, stream.key)
+	close(stream.closeCh)
+	return true
+}
+
+// runStream sends snapshots to the client until the stream reaches its end
+// seqno, is closed or the client disconnects.
+func (x *kvImplDcp) runStream(source mock.KvClient, conn *dcpConn, stream *dcpStream) {
+	isFirstSnapshot := true
+
+	for {
+		// We grab the change channel before we read so we cannot miss any
+		// mutations which occur while we are sending this snapshot.
+		changeCh := stream.vbucket.WatchChanges()
+
+		if stream.lastSeqNo >= stream.endSeqNo {
+			if x.removeStream(conn, stream) {
+				x.writeStreamEnd(source, stream, memd.StreamEndOK)
+			}
+			return
+		}
+
+		failoverLog := stream.vbucket.FailoverLog()
+		if failoverLog[0].VbUUID != stream.vbUUID {
+			// The vbucket was rolled back or flushed underneath us.
+			if x.removeStream(conn, stream) {
+				x.writeStreamEnd(source, stream, memd.StreamEndStateChanged)
+			}
+			return
+		}
+
+		snapshot, err := stream.proc.DcpSnapshot(kvproc.DcpSnapshotOptions{
+			Vbucket:    uint(stream.key.vbID),
+			StartSeqNo: stream.lastSeqNo,
+			EndSeqNo:   stream.endSeqNo,
+		})
+		if err != nil {
+			if x.removeStream(conn, stream) {
+				x.writeStreamEnd(source, stream, memd.StreamEndStateChanged)
+			}
+			return
+		}
+
+		if snapshot == nil {
+			select {
+			case <-changeCh:
+			case <-stream.closeCh:
+				return
+			}
+			continue
+		}
+
+		if err := x.writeSnapshot(source, conn, stream, snapshot, isFirstSnapshot); err != nil {
+			x.closeConn(source, conn)
+			return
+		}
+
+		isFirstSnapshot = false
+		stream.lastSeqNo = snapshot.EndSeqNo
+	}
+}
+
+func (x *kvImplDcp) writeSnapshot(source mock.KvClient, conn *dcpConn, stream *dcpStream, snapshot *kvproc.DcpSnapshotResult, isFirst bool) error {
+	var items []kvproc.DcpSnapshotItem
+	for _, item := range snapshot.Items {
+		if stream.collections != nil && !stream.collections[item.Doc.CollectionID] {
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	snapStartSeqNo := snapshot.StartSeqNo
+	snapFlags := dcpSnapshotFlagMemory
+	if isFirst {
+		snapStartSeqNo = stream.lastSeqNo
+		snapFlags = dcpSnapshotFlagDisk
+	}
+
+	extrasBuf := make([]byte, 20)
+	binary.BigEndian.PutUint64(extrasBuf[0:], snapStartSeqNo)
+	binary.BigEndian.PutUint64(extrasBuf[8:], snapshot.EndSeqNo)
+	binary.BigEndian.PutUint32(extrasBuf[16:], snapFlags)
+
+	err := x.writeStreamPacket(source, stream, &memd.Packet{
+		Command: memd.CmdDcpSnapshotMarker,
+		Extras:  extrasBuf,
+	})
+	if err != nil {
+		return err
+	}
+
+	useExpiryOpcode := x.controlEnabled(conn, "enable_expiry_opcode")
+
+	for _, item := range items {
+		var pak *memd.Packet
+		if item.IsExpired && useExpiryOpcode {
+			pak = x.makeExpirationPacket(item.Doc)
+		} else if item.Doc.IsDeleted || item.IsExpired {
+			pak = x.makeDeletionPacket(conn, item.Doc)
+		} else {
+			pak = x.makeMutationPacket(conn, item.Doc)
+		}
+
+		if err := x.writeStreamPacket(source, stream, pak); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *kvImplDcp) makeMutationPacket(conn *dcpConn, doc *mockdb.Document) *memd.Packet {
+	var expiry uint32
+	if !doc.Expiry.IsZero() {
+		expiry = uint32(doc.Expiry.Unix())
+	}
+
+	extrasBuf := make([]byte, 31)
+	binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+	binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+	binary.BigEndian.PutUint32(extrasBuf[16:], doc.Flags)
+	binary.BigEndian.PutUint32(extrasBuf[20:], expiry)
+
+	// Values which are held compressed are always streamed inflated.
+	datatype := doc.Datatype &^ uint8(memd.DatatypeFlagCompressed)
+	value := doc.InflatedValue()
+	if conn.flags&memd.DcpOpenFlagIncludeXattrs != 0 && len(doc.Xattrs) > 0 {
+		value = append(encodeXattrs(doc.Xattrs), value...)
+		datatype |= uint8(memd.DatatypeFlagXattrs)
+	}
+	if conn.flags&memd.DcpOpenFlagNoValue != 0 {
+		value = nil
+		datatype = 0
+	}
+
+	return &memd.Packet{
+		Command:      memd.CmdDcpMutation,
+		Datatype:     datatype,
+		Cas:          doc.Cas,
+		CollectionID: uint32(doc.CollectionID),
+		Key:          doc.Key,
+		Extras:       extrasBuf,
+		Value:        value,
+	}
+}
+
+func (x *kvImplDcp) makeDeletionPacket(conn *dcpConn, doc *mockdb.Document) *memd.Packet {
+	var extrasBuf []byte
+	if conn.flags&memd.DcpOpenFlagIncludeDeleteTimes != 0 {
+		extrasBuf = make([]byte, 21)
+		binary.BigEndian.PutUint32(extrasBuf[16:], uint32(doc.ModifiedTime.Unix()))
+	} else {
+		extrasBuf = make([]byte, 18)
+	}
+	binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+	binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+
+	return &memd.Packet{
+		Command:      memd.CmdDcpDeletion,
+		Cas:          doc.Cas,
+		CollectionID: uint32(doc.CollectionID),
+		Key:          doc.Key,
+		Extras:       extrasBuf,
+	}
+}
+
+func (x *kvImplDcp) makeExpirationPacket(doc *mockdb.Document) *memd.Packet {
+	extrasBuf := make([]byte, 20)
+	binary.BigEndian.PutUint64(extrasBuf[0:], doc.SeqNo)
+	binary.BigEndian.PutUint64(extrasBuf[8:], doc.RevID)
+	binary.BigEndian.PutUint32(extrasBuf[16:], uint32(doc.Expiry.Unix()))
+
+	return &memd.Packet{
+		Command:      memd.CmdDcpExpiration,
+		Cas:          doc.Cas,
+		CollectionID: uint32(doc.CollectionID),
+		Key:          doc.Key,
+		Extras:       extrasBuf,
+	}
+}
+
+func (x *kvImplDcp) writeStreamEnd(source mock.KvClient, stream *dcpStream, status memd.StreamEndStatus) {
+	extrasBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(extrasBuf[0:], uint32(status))
+
+	// There is nothing useful we can do if this fails.
+	_ = x.writeStreamPacket(source, stream, &memd.Packet{
+		Command: memd.CmdDcpStreamEnd,
+		Extras:  extrasBuf,
+	})
+}
+
+func (x *kvImplDcp) writeStreamPacket(source mock.KvClient, stream *dcpStream, pak *memd.Packet) error {
+	pak.Magic = memd.CmdMagicReq
+	pak.Vbucket = stream.key.vbID
+	pak.Opaque = stream.opaque
+	if stream.hasStreamID {
+		pak.StreamIDFrame = &memd.StreamIDFrame{
+			StreamID: stream.key.streamID,
+		}
+	}
+
+	return source.WritePacket(pak)
+}
+
+// encodeXattrs encodes a set of extended attributes into the format which is
+// prefixed to a document value when the xattr datatype flag is set.
+func encodeXattrs(xattrs map[string][]byte) []byte {
+	var xattrsBuf []byte
+	for key, value := range xattrs {
+		pairLen := len(key) + 1 + len(value) + 1
+		pairBuf := make([]byte, 4, 4+pairLen)
+		binary.BigEndian.PutUint32(pairBuf[0:], uint32(pairLen))
+		pairBuf = append(pairBuf, key...)
+		pairBuf = append(pairBuf, 0)
+		pairBuf = append(pairBuf, value...)
+		pairBuf = append(pairBuf, 0)
+		xattrsBuf = append(xattrsBuf, pairBuf...)
+	}
+
+	lenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBuf[0:], uint32(len(xattrsBuf)))
+	return append(lenBuf, xattrsBuf...)
+}
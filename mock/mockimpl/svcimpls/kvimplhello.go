@@ -9,10 +9,13 @@ import (
 )
 
 type kvImplHello struct {
+	clusterMap kvImplClusterMap
 }
 
 func (x *kvImplHello) Register(h *hookHelper) {
 	h.RegisterKvHandler(memd.CmdHello, x.handleHelloRequest)
+
+	x.clusterMap.Register(h)
 }
 
 func (x *kvImplHello) handleHelloRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
@@ -25,28 +28,7 @@ func (x *kvImplHello) handleHelloRequest(source mock.KvClient, pak *memd.Packet,
 		return false
 	}
 
-	// TODO(brett19): This list should actually be moderated by the server
-	// version/features configured at the top level for the cluster.
-	availableFeatures := []memd.HelloFeature{
-		memd.FeatureDatatype,
-		memd.FeatureTCPNoDelay,
-		memd.FeatureSeqNo,
-		memd.FeatureTCPDelay,
-		memd.FeatureXattr,
-		memd.FeatureXerror,
-		memd.FeatureSelectBucket,
-		memd.FeatureSnappy,
-		memd.FeatureJSON,
-		memd.FeatureDuplex,
-		//memd.FeatureClusterMapNotif,
-		memd.FeatureUnorderedExec,
-		memd.FeatureDurations,
-		memd.FeatureAltRequests,
-		memd.FeatureSyncReplication,
-		memd.FeatureCollections,
-		//memd.FeatureOpenTracing,
-		memd.FeatureCreateAsDeleted,
-	}
+	availableFeatures := x.availableFeatures(source.Source().Node().Cluster().ServerVersion())
 	enabledFeatures := make([]memd.HelloFeature, 0)
 
 	numFeatures := len(pak.Value) / 2
@@ -61,7 +43,20 @@ func (x *kvImplHello) handleHelloRequest(source mock.KvClient, pak *memd.Packet,
 		}
 	}
 
+	// Cluster map notifications are pushed over the duplex channel, so they
+	// cannot be enabled without it.
+	if isInFeatureList(enabledFeatures, memd.FeatureClusterMapNotif) &&
+		!isInFeatureList(enabledFeatures, memd.FeatureDuplex) {
+		for featureIdx, featureCode := range enabledFeatures {
+			if featureCode == memd.FeatureClusterMapNotif {
+				enabledFeatures = append(enabledFeatures[:featureIdx], enabledFeatures[featureIdx+1:]...)
+				break
+			}
+		}
+	}
+
 	source.SetFeatures(enabledFeatures)
+	x.clusterMap.updateClient(source)
 
 	enabledBytes := make([]byte, len(enabledFeatures)*2)
 	for featureIdx, featureCode := range enabledFeatures {
@@ -76,3 +71,45 @@ func (x *kvImplHello) handleHelloRequest(source mock.KvClient, pak *memd.Packet,
 		Status:  memd.StatusSuccess,
 	}, start)
 }
+
+// availableFeatures returns the list of HELLO features which are supported by
+// the specified version of the server.
+func (x *kvImplHello) availableFeatures(serverVersion mock.ServerVersion) []memd.HelloFeature {
+	features := []memd.HelloFeature{
+		memd.FeatureDatatype,
+		memd.FeatureTCPNoDelay,
+		memd.FeatureSeqNo,
+		memd.FeatureTCPDelay,
+		memd.FeatureXattr,
+		memd.FeatureXerror,
+		memd.FeatureSelectBucket,
+		memd.FeatureSnappy,
+		memd.FeatureJSON,
+		memd.FeatureDuplex,
+		memd.FeatureClusterMapNotif,
+		memd.FeatureUnorderedExec,
+		memd.FeatureDurations,
+	}
+
+	if serverVersion >= mock.ServerVersion65 {
+		features = append(features,
+			memd.FeatureAltRequests,
+			memd.FeatureSyncReplication,
+			memd.FeatureOpenTracing,
+		)
+	}
+
+	if serverVersion >= mock.ServerVersion66 {
+		features = append(features,
+			memd.FeatureCreateAsDeleted,
+		)
+	}
+
+	if serverVersion >= mock.ServerVersion70 {
+		features = append(features,
+			memd.FeatureCollections,
+		)
+	}
+
+	return features
+}
@@ -0,0 +1,434 @@
+package svcimpls
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mockimpl/kvproc"
+	"github.com/google/uuid"
+)
+
+const (
+	rangeScanFormatKeys      = uint32(0x00)
+	rangeScanFormatDocuments = uint32(0x01)
+
+	// rangeScanBatchSize is the approximate size of the value of each of the
+	// responses sent during a range scan continue.
+	rangeScanBatchSize = 16 * 1024
+
+	// rangeScanIdleTimeout is how long a scan can go without being continued
+	// before it is cancelled, the same as the real server's default.
+	rangeScanIdleTimeout = 60 * time.Second
+)
+
+type kvImplRangeScan struct {
+	crud kvImplCrud
+
+	lock  sync.Mutex
+	scans map[uuid.UUID]*rangeScan
+}
+
+// rangeScan represents the state of a single range scan which was created
+// against a vbucket.
+type rangeScan struct {
+	lock       sync.Mutex
+	source     mock.KvClient
+	bucket     mock.Bucket
+	vbID       uint16
+	keyOnly    bool
+	items      []*mockdb.Document
+	lastActive time.Time
+	cancelled  uint32
+}
+
+type rangeScanCreateRequest struct {
+	Collection string `json:"collection"`
+	KeyOnly    bool   `json:"key_only"`
+	Range      *struct {
+		Start     *string `json:"start"`
+		End       *string `json:"end"`
+		ExclStart *string `json:"excl_start"`
+		ExclEnd   *string `json:"excl_end"`
+	} `json:"range"`
+	Sampling *struct {
+		Samples uint64 `json:"samples"`
+		Seed    uint64 `json:"seed"`
+	} `json:"sampling"`
+	Snapshot *struct {
+		VbUUID      string `json:"vb_uuid"`
+		SeqNo       uint64 `json:"seqno"`
+		SeqNoExists bool   `json:"seqno_exists"`
+		TimeoutMs   uint64 `json:"timeout_ms"`
+	} `json:"snapshot_requirements"`
+}
+
+func (x *kvImplRangeScan) Register(h *hookHelper) {
+	x.scans = make(map[uuid.UUID]*rangeScan)
+
+	h.RegisterKvHandler(mock.CmdRangeScanCreate, x.crud.versionedHandler(mock.ServerVersion72, x.handleCreateRequest))
+	h.RegisterKvHandler(mock.CmdRangeScanContinue, x.crud.versionedHandler(mock.ServerVersion72, x.handleContinueRequest))
+	h.RegisterKvHandler(mock.CmdRangeScanCancel, x.crud.versionedHandler(mock.ServerVersion72, x.handleCancelRequest))
+
+	h.RegisterKvLostClientHandler(x.removeClientScans)
+}
+
+// parseCreateRequest parses the JSON body of a range scan create request into
+// the options used by the engine.
+func (x *kvImplRangeScan) parseCreateRequest(value []byte) (*rangeScanCreateRequest, kvproc.RangeScanCreateOptions, bool) {
+	var req rangeScanCreateRequest
+	if err := json.Unmarshal(value, &req); err != nil {
+		return nil, kvproc.RangeScanCreateOptions{}, false
+	}
+
+	var opts kvproc.RangeScanCreateOptions
+
+	if req.Collection != "" {
+		collectionID, err := strconv.ParseUint(req.Collection, 16, 32)
+		if err != nil {
+			return nil, opts, false
+		}
+		opts.CollectionID = uint(collectionID)
+	}
+
+	decodeKey := func(key *string) ([]byte, bool) {
+		decoded, err := base64.StdEncoding.DecodeString(*key)
+		if err != nil {
+			return nil, false
+		}
+		return decoded, true
+	}
+
+	if (req.Range == nil) == (req.Sampling == nil) {
+		return nil, opts, false
+	}
+
+	if req.Range != nil {
+		if (req.Range.Start == nil) == (req.Range.ExclStart == nil) ||
+			(req.Range.End == nil) == (req.Range.ExclEnd == nil) {
+			return nil, opts, false
+		}
+
+		var ok bool
+		if req.Range.Start != nil {
+			opts.StartKey, ok = decodeKey(req.Range.Start)
+		} else {
+			opts.StartKey, ok = decodeKey(req.Range.ExclStart)
+			opts.ExclusiveStart = true
+		}
+		if !ok {
+			return nil, opts, false
+		}
+
+		if req.Range.End != nil {
+			opts.EndKey, ok = decodeKey(req.Range.End)
+		} else {
+			opts.EndKey, ok = decodeKey(req.Range.ExclEnd)
+			opts.ExclusiveEnd = true
+		}
+		if !ok {
+			return nil, opts, false
+		}
+	}
+
+	if req.Sampling != nil {
+		if req.Sampling.Samples == 0 {
+			return nil, opts, false
+		}
+
+		opts.IsSampling = true
+		opts.Samples = req.Sampling.Samples
+		opts.Seed = req.Sampling.Seed
+	}
+
+	if req.Snapshot != nil {
+		vbUUID, err := strconv.ParseUint(req.Snapshot.VbUUID, 10, 64)
+		if err != nil {
+			return nil, opts, false
+		}
+
+		opts.Snapshot = &kvproc.RangeScanSnapshotRequirements{
+			VbUUID:      vbUUID,
+			SeqNo:       req.Snapshot.SeqNo,
+			SeqNoExists: req.Snapshot.SeqNoExists,
+			Timeout:     time.Duration(req.Snapshot.TimeoutMs) * time.Millisecond,
+		}
+	}
+
+	return &req, opts, true
+}
+
+func (x *kvImplRangeScan) handleCreateRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		req, opts, ok := x.parseCreateRequest(pak.Value)
+		if !ok {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		if !x.crud.checkCollectionID(source, pak, uint32(opts.CollectionID), start) {
+			return
+		}
+
+		opts.Vbucket = uint(pak.Vbucket)
+
+		// Waiting for the snapshot requirements can block for some time, so we
+		// do the rest of the work in the background.
+		go func() {
+			resp, err := proc.RangeScanCreate(opts)
+			if err != nil {
+				x.crud.writeProcErr(source, pak, err, start)
+				return
+			}
+
+			scanID := uuid.New()
+			chrono := source.SelectedBucket().Store().Chrono()
+			scan := &rangeScan{
+				source:     source,
+				bucket:     source.SelectedBucket(),
+				vbID:       pak.Vbucket,
+				keyOnly:    req.KeyOnly,
+				items:      resp.Items,
+				lastActive: chrono.Now(),
+			}
+
+			x.lock.Lock()
+			x.scans[scanID] = scan
+			x.lock.Unlock()
+
+			chrono.AfterFunc(rangeScanIdleTimeout, func() {
+				x.expireScan(scanID, scan)
+			})
+
+			writePacketToSource(source, &memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+				Status:  memd.StatusSuccess,
+				Value:   scanID[:],
+			}, start)
+		}()
+	}
+}
+
+// findScan returns the scan with the specified uuid, provided that it was
+// created against the bucket and vbucket of the request.
+func (x *kvImplRangeScan) findScan(source mock.KvClient, pak *memd.Packet, scanIDBytes []byte) (uuid.UUID, *rangeScan) {
+	scanID, err := uuid.FromBytes(scanIDBytes)
+	if err != nil {
+		return scanID, nil
+	}
+
+	x.lock.Lock()
+	scan := x.scans[scanID]
+	x.lock.Unlock()
+
+	if scan == nil || scan.bucket != source.SelectedBucket() || scan.vbID != pak.Vbucket {
+		return scanID, nil
+	}
+
+	return scanID, scan
+}
+
+func (x *kvImplRangeScan) removeScan(scanID uuid.UUID) {
+	x.lock.Lock()
+	delete(x.scans, scanID)
+	x.lock.Unlock()
+}
+
+// cancelScan marks a scan as cancelled and removes it.  A continue which is in
+// progress will notice the cancellation before it sends its next item.
+func (x *kvImplRangeScan) cancelScan(scanID uuid.UUID, scan *rangeScan) {
+	atomic.StoreUint32(&scan.cancelled, 1)
+
+	x.lock.Lock()
+	if x.scans[scanID] == scan {
+		delete(x.scans, scanID)
+	}
+	x.lock.Unlock()
+}
+
+// expireScan cancels a scan if it has not been continued for long enough,
+// otherwise it checks again once the scan could next have become idle.
+func (x *kvImplRangeScan) expireScan(scanID uuid.UUID, scan *rangeScan) {
+	x.lock.Lock()
+	isActive := x.scans[scanID] == scan
+	x.lock.Unlock()
+
+	// Scans which completed or were cancelled have nothing left to expire.
+	if !isActive {
+		return
+	}
+
+	chrono := scan.bucket.Store().Chrono()
+
+	scan.lock.Lock()
+	idleTime := chrono.Now().Sub(scan.lastActive)
+	scan.lock.Unlock()
+
+	if idleTime < rangeScanIdleTimeout {
+		chrono.AfterFunc(rangeScanIdleTimeout-idleTime, func() {
+			x.expireScan(scanID, scan)
+		})
+		return
+	}
+
+	x.cancelScan(scanID, scan)
+}
+
+// removeClientScans cancels all of the scans which were created by a client
+// which has since disconnected.
+func (x *kvImplRangeScan) removeClientScans(source mock.KvClient) {
+	x.lock.Lock()
+	var scanIDs []uuid.UUID
+	var scans []*rangeScan
+	for scanID, scan := range x.scans {
+		if scan.source == source {
+			scanIDs = append(scanIDs, scanID)
+			scans = append(scans, scan)
+		}
+	}
+	x.lock.Unlock()
+
+	for scanIdx, scanID := range scanIDs {
+		x.cancelScan(scanID, scans[scanIdx])
+	}
+}
+
+func (x *kvImplRangeScan) handleContinueRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		if len(pak.Extras) != 28 {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		scanID, scan := x.findScan(source, pak, pak.Extras[0:16])
+		if scan == nil {
+			x.crud.writeStatusReply(source, pak, memd.StatusKeyNotFound, start)
+			return
+		}
+
+		itemLimit := binary.BigEndian.Uint32(pak.Extras[16:])
+		timeLimit := time.Duration(binary.BigEndian.Uint32(pak.Extras[20:])) * time.Millisecond
+		byteLimit := binary.BigEndian.Uint32(pak.Extras[24:])
+
+		chrono := source.SelectedBucket().Store().Chrono()
+		deadline := chrono.Now().Add(timeLimit)
+
+		scan.lock.Lock()
+		defer scan.lock.Unlock()
+
+		// The scan only becomes idle again once this continue has finished.
+		defer func() {
+			scan.lastActive = chrono.Now()
+		}()
+		scan.lastActive = chrono.Now()
+
+		format := rangeScanFormatDocuments
+		if scan.keyOnly {
+			format = rangeScanFormatKeys
+		}
+
+		writeBatch := func(status memd.StatusCode, value []byte) {
+			var extrasBuf []byte
+			if len(value) > 0 {
+				extrasBuf = make([]byte, 4)
+				binary.BigEndian.PutUint32(extrasBuf[0:], format)
+			}
+
+			writePacketToSource(source, &memd.Packet{
+				Magic:   memd.CmdMagicRes,
+				Command: pak.Command,
+				Opaque:  pak.Opaque,
+				Status:  status,
+				Extras:  extrasBuf,
+				Value:   value,
+			}, start)
+		}
+
+		var batch []byte
+		numItems := uint32(0)
+		numBytes := uint32(0)
+		for len(scan.items) > 0 {
+			if atomic.LoadUint32(&scan.cancelled) != 0 {
+				writeBatch(mock.StatusRangeScanCancelled, nil)
+				return
+			}
+
+			item := scan.items[0]
+			scan.items = scan.items[1:]
+
+			itemBytes := x.encodeItem(source, scan.keyOnly, item)
+			batch = append(batch, itemBytes...)
+			numItems++
+			numBytes += uint32(len(itemBytes))
+
+			if len(batch) >= rangeScanBatchSize {
+				writeBatch(memd.StatusSuccess, batch)
+				batch = nil
+			}
+
+			if (itemLimit > 0 && numItems >= itemLimit) ||
+				(byteLimit > 0 && numBytes >= byteLimit) ||
+				(timeLimit > 0 && !chrono.Now().Before(deadline)) {
+				break
+			}
+		}
+
+		if len(scan.items) > 0 {
+			writeBatch(mock.StatusRangeScanMore, batch)
+			return
+		}
+
+		x.removeScan(scanID)
+		writeBatch(mock.StatusRangeScanComplete, batch)
+	}
+}
+
+// encodeItem encodes a single item of a range scan.  Key scans send just the
+// key, while document scans also send the document's metadata and value.
+func (x *kvImplRangeScan) encodeItem(source mock.KvClient, keyOnly bool, doc *mockdb.Document) []byte {
+	if keyOnly {
+		itemBytes := memd.AppendULEB128_32(nil, uint32(len(doc.Key)))
+		return append(itemBytes, doc.Key...)
+	}
+
+	itemBytes := make([]byte, 25)
+	binary.BigEndian.PutUint32(itemBytes[0:], doc.Flags)
+	binary.BigEndian.PutUint32(itemBytes[4:], x.crud.encodeExpiry(source, doc.Expiry))
+	binary.BigEndian.PutUint64(itemBytes[8:], doc.SeqNo)
+	binary.BigEndian.PutUint64(itemBytes[16:], doc.Cas)
+	itemBytes[24] = doc.Datatype &^ uint8(memd.DatatypeFlagCompressed)
+	itemBytes = memd.AppendULEB128_32(itemBytes, uint32(len(doc.Key)))
+	itemBytes = append(itemBytes, doc.Key...)
+	value := doc.InflatedValue()
+	itemBytes = memd.AppendULEB128_32(itemBytes, uint32(len(value)))
+	return append(itemBytes, value...)
+}
+
+func (x *kvImplRangeScan) handleCancelRequest(source mock.KvClient, pak *memd.Packet, start time.Time) {
+	if proc := x.crud.makeProc(source, pak, mockauth.PermissionDataRead, start); proc != nil {
+		if len(pak.Extras) != 16 {
+			x.crud.writeStatusReply(source, pak, memd.StatusInvalidArgs, start)
+			return
+		}
+
+		scanID, scan := x.findScan(source, pak, pak.Extras)
+		if scan == nil {
+			x.crud.writeStatusReply(source, pak, memd.StatusKeyNotFound, start)
+			return
+		}
+
+		x.cancelScan(scanID, scan)
+
+		x.crud.writeStatusReply(source, pak, memd.StatusSuccess, start)
+	}
+}
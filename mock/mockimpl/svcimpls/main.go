@@ -9,35 +9,50 @@ import (
 // RegisterOptions specifies options used for impl registration
 type RegisterOptions struct {
 	AnalyticsHooks mock.AnalyticsHookManager
+	EventingHooks  mock.EventingHookManager
 	KvInHooks      mock.KvHookManager
 	KvOutHooks     mock.KvHookManager
 	MgmtHooks      mock.MgmtHookManager
 	QueryHooks     mock.QueryHookManager
 	SearchHooks    mock.SearchHookManager
 	ViewHooks      mock.ViewHookManager
+
+	// AddKvLostClientHandler registers a function to be called whenever a kv
+	// client disconnects.
+	AddKvLostClientHandler func(handler func(source mock.KvClient))
 }
 
 // Register registers all known hooks.
 func Register(opts RegisterOptions) {
 	h := &hookHelper{
 		AnalyticsHooks: opts.AnalyticsHooks,
+		EventingHooks:  opts.EventingHooks,
 		KvInHooks:      opts.KvInHooks,
 		KvOutHooks:     opts.KvOutHooks,
 		MgmtHooks:      opts.MgmtHooks,
 		QueryHooks:     opts.QueryHooks,
 		SearchHooks:    opts.SearchHooks,
 		ViewHooks:      opts.ViewHooks,
+
+		AddKvLostClientHandler: opts.AddKvLostClientHandler,
 	}
 
 	(&analyticsImplPing{}).Register(h)
+	(&analyticsImplService{}).Register(h)
+	(&eventingImplMgmt{}).Register(h)
 	(&kvImplAuth{}).Register(h)
 	(&kvImplCccp{}).Register(h)
 	(&kvImplCrud{}).Register(h)
+	(&kvImplDcp{}).Register(h)
 	(&kvImplErrMap{}).Register(h)
 	(&kvImplHello{}).Register(h)
 	(&kvImplPing{}).Register(h)
+	(&kvImplRangeScan{}).Register(h)
 	(&queryImplPing{}).Register(h)
+	(&queryImplService{}).Register(h)
 	(&searchImplPing{}).Register(h)
+	(&searchImplMgmt{}).Register(h)
+	(&searchImplQuery{}).Register(h)
 	(&viewImplPing{}).Register(h)
 	(&viewImplMgmt{}).Register(h)
 	(&viewImplQuery{}).Register(h)
@@ -1,5 +1,11 @@
 package svcimpls
 
+import (
+	"bytes"
+
+	"github.com/couchbaselabs/gocaves/mock"
+)
+
 type mgmtImpl struct {
 }
 
@@ -14,18 +20,39 @@ func (x *mgmtImpl) Register(h *hookHelper) {
 	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*", x.handleUpdateBucketConfig)
 	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*", x.handleDropBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/nodeServices", x.handleGetNodeServices)
+	h.RegisterMgmtHandler("GET", "/pools/default/rebalanceProgress", x.handleGetRebalanceProgress)
+	h.RegisterMgmtHandler("GET", "/pools/default/certificate", x.handleGetCertificate)
+	h.RegisterMgmtHandler("POST", "/controller/regenerateCertificate", x.handleRegenerateCertificate)
+	h.RegisterMgmtHandler("GET", "/settings/clientCertAuth", x.handleGetClientCertAuth)
+	h.RegisterMgmtHandler("POST", "/settings/clientCertAuth", x.handleSetClientCertAuth)
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*", x.handleGetBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/b/*", x.handleGetTerseBucketConfig)
 	h.RegisterMgmtHandler("GET", "/pools/default/bs/*", x.handleGetTerseBucketStreamingConfig)
-	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/scopes", x.handleCreateScope)
-	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/scopes/*/collections", x.handleCreateCollection)
-	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*", x.handleDropScope)
-	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*/collections/*", x.handleDropCollection)
-	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/scopes", x.handleGetAllScopes)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/scopes", x.versionedHandler(mock.ServerVersion70, x.handleCreateScope))
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/scopes/*/collections", x.versionedHandler(mock.ServerVersion70, x.handleCreateCollection))
+	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*", x.versionedHandler(mock.ServerVersion70, x.handleDropScope))
+	h.RegisterMgmtHandler("DELETE", "/pools/default/buckets/*/scopes/*/collections/*", x.versionedHandler(mock.ServerVersion70, x.handleDropCollection))
+	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/scopes", x.versionedHandler(mock.ServerVersion70, x.handleGetAllScopes))
 	h.RegisterMgmtHandler("GET", "/pools/default/buckets/*/ddocs", x.handleGetAllDesignDocuments)
+	h.RegisterMgmtHandler("POST", "/pools/default/buckets/*/ddocs/_design/*/controller/publish", x.handlePublishDesignDocument)
 	h.RegisterMgmtHandler("PUT", "/settings/rbac/users/*/*", x.handleUpsertUser)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/users/*", x.handleGetAllUsers)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/users/*/*", x.handleGetUser)
 	h.RegisterMgmtHandler("DELETE", "/settings/rbac/users/*/*", x.handleDropUser)
 	h.RegisterMgmtHandler("GET", "/settings/rbac/roles", x.handleGetRoles)
 }
+
+// versionedHandler wraps the handler of an endpoint which only exists on servers
+// of at least the specified version.
+func (x *mgmtImpl) versionedHandler(minVersion mock.ServerVersion, handler func(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse) func(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return func(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+		if source.Node().Cluster().ServerVersion() < minVersion {
+			return &mock.HTTPResponse{
+				StatusCode: 404,
+				Body:       bytes.NewReader([]byte(`"Not found."`)),
+			}
+		}
+
+		return handler(source, req)
+	}
+}
@@ -0,0 +1,166 @@
+package svcimpls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+)
+
+type jsonCertificate struct {
+	Type    string `json:"type"`
+	Pem     string `json:"pem"`
+	Subject string `json:"subject"`
+	Expires string `json:"expires"`
+}
+
+func certificateResponse(certPem []byte) *mock.HTTPResponse {
+	headers := http.Header{}
+	headers.Set("Content-Type", "text/plain")
+	return &mock.HTTPResponse{
+		Header:     headers,
+		StatusCode: 200,
+		Body:       bytes.NewReader(certPem),
+	}
+}
+
+func (x *mgmtImpl) handleGetCertificate(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	certPem := source.Node().Cluster().CACertificate()
+	if req.URL.Query().Get("extended") != "true" {
+		return certificateResponse(certPem)
+	}
+
+	block, _ := pem.Decode(certPem)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	certBytes, _ := json.Marshal(map[string]interface{}{
+		"cert": jsonCertificate{
+			Type:    "generated",
+			Pem:     string(certPem),
+			Subject: cert.Subject.String(),
+			Expires: cert.NotAfter.UTC().Format(time.RFC3339),
+		},
+		"warnings": []interface{}{},
+	})
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(certBytes),
+	}
+}
+
+func (x *mgmtImpl) handleRegenerateCertificate(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	cluster := source.Node().Cluster()
+	if err := cluster.RotateCertificates(); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return certificateResponse(cluster.CACertificate())
+}
+
+type jsonClientCertPrefix struct {
+	Path      string `json:"path"`
+	Prefix    string `json:"prefix"`
+	Delimiter string `json:"delimiter"`
+}
+
+type jsonClientCertAuth struct {
+	State    string                 `json:"state"`
+	Prefixes []jsonClientCertPrefix `json:"prefixes"`
+}
+
+func (x *mgmtImpl) handleGetClientCertAuth(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	settings := source.Node().Cluster().Users().ClientCertAuth()
+
+	jsonSettings := jsonClientCertAuth{
+		State:    string(settings.State),
+		Prefixes: []jsonClientCertPrefix{},
+	}
+	for _, prefix := range settings.Prefixes {
+		jsonSettings.Prefixes = append(jsonSettings.Prefixes, jsonClientCertPrefix{
+			Path:      prefix.Path,
+			Prefix:    prefix.Prefix,
+			Delimiter: prefix.Delimiter,
+		})
+	}
+
+	settingsBytes, _ := json.Marshal(jsonSettings)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(settingsBytes),
+	}
+}
+
+func (x *mgmtImpl) handleSetClientCertAuth(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	var jsonSettings jsonClientCertAuth
+	if err := json.NewDecoder(req.Body).Decode(&jsonSettings); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("invalid json")),
+		}
+	}
+
+	settings := mockauth.ClientCertAuthSettings{
+		State: mockauth.ClientCertAuthState(jsonSettings.State),
+	}
+	for _, prefix := range jsonSettings.Prefixes {
+		settings.Prefixes = append(settings.Prefixes, mockauth.ClientCertPrefix{
+			Path:      prefix.Path,
+			Prefix:    prefix.Prefix,
+			Delimiter: prefix.Delimiter,
+		})
+	}
+
+	if err := source.Node().Cluster().Users().SetClientCertAuth(settings); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 202,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
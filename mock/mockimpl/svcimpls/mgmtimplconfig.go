@@ -2,6 +2,7 @@ package svcimpls
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/url"
@@ -83,7 +84,12 @@ type configHandler struct {
 }
 
 func (c *configHandler) OnNewConfig(cfg uint) {
-	c.configChan <- cfg
+	// We only need to know that the config changed, so if there is already a
+	// pending notification we don't need to queue another.
+	select {
+	case c.configChan <- cfg:
+	default:
+	}
 }
 
 func (x *mgmtImpl) handleGetTerseBucketStreamingConfig(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
@@ -104,15 +110,16 @@ func (x *mgmtImpl) handleGetTerseBucketStreamingConfig(source mock.MgmtService,
 		}
 	}
 
-	bucketConfig := GenTerseBucketConfig(bucket, source.Node())
-
 	reader, writer := io.Pipe()
-	watcher := &configHandler{}
+	watcher := &configHandler{
+		configChan: make(chan uint, 1),
+	}
 	source.Node().Cluster().AddConfigWatcher(watcher)
 	req.Header.Set("Transfer-Encoding", "chunked")
 
 	go func() {
 		for {
+			bucketConfig := GenTerseBucketConfig(bucket, source.Node())
 			_, err := writer.Write(bucketConfig)
 			if err != nil {
 				return
@@ -208,6 +215,8 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 	replicaIndexStr := values.Get("replicaIndex")
 	replicaNumberStr := values.Get("replicaNumber")
 	compressionModeStr := values.Get("compressionMode")
+	durabilityMinLevelStr := values.Get("durabilityMinLevel")
+	maxTTLStr := values.Get("maxTTL")
 
 	var replicaNumber int
 	if replicaNumberStr != "" {
@@ -244,9 +253,28 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 		}
 	}
 
-	// TODO: validate compression mode
-	if compressionModeStr == "" {
-		compressionModeStr = "passive"
+	compressionMode := mock.CompressionMode(compressionModeStr)
+	switch compressionMode {
+	case "", mock.CompressionModeOff, mock.CompressionModePassive, mock.CompressionModeActive:
+	default:
+		return mock.NewBucketOptions{}, errors.New(`{"errors":{"compressionMode":"compressionMode can be set to 'off', 'passive' or 'active'"}}`)
+	}
+
+	durabilityMinLevel := mock.DurabilityLevel(durabilityMinLevelStr)
+	switch durabilityMinLevel {
+	case "", mock.DurabilityLevelNone, mock.DurabilityLevelMajority,
+		mock.DurabilityLevelMajorityAndPersistActive, mock.DurabilityLevelPersistToMajority:
+	default:
+		return mock.NewBucketOptions{}, errors.New(`{"errors":{"durability_min_level":"Durability minimum level must be one of none, majority, majorityAndPersistActive, or persistToMajority"}}`)
+	}
+
+	var maxTTL uint64
+	if maxTTLStr != "" {
+		var err error
+		maxTTL, err = strconv.ParseUint(maxTTLStr, 10, 31)
+		if err != nil {
+			return mock.NewBucketOptions{}, errors.New(`{"errors":{"maxTTL":"Max TTL must be an integer between 0 and 2147483647"}}`)
+		}
 	}
 
 	return mock.NewBucketOptions{
@@ -254,10 +282,37 @@ func (x *mgmtImpl) parseBucketSettings(values url.Values) (mock.NewBucketOptions
 		FlushEnabled:        flushEnabled,
 		RamQuota:            ramQuotaMB * 1024 * 1024,
 		ReplicaIndexEnabled: replicaIndexEnabled,
-		CompressionMode:     mock.CompressionMode(compressionModeStr),
+		CompressionMode:     compressionMode,
+		DurabilityMinLevel:  durabilityMinLevel,
+		MaxTTL:              uint32(maxTTL),
 	}, nil
 }
 
+func (x *mgmtImpl) checkBucketDurabilityMinLevel(serverVersion mock.ServerVersion, bucketType mock.BucketType, level mock.DurabilityLevel) error {
+	if level == "" {
+		return nil
+	}
+
+	if serverVersion < mock.ServerVersion66 {
+		return errors.New(`{"errors":{"durability_min_level":"Durability minimum level cannot be set until the cluster is fully 6.6"}}`)
+	}
+
+	if level == mock.DurabilityLevelNone {
+		return nil
+	}
+
+	switch bucketType {
+	case mock.BucketTypeMemcached:
+		return errors.New(`{"errors":{"durability_min_level":"Durability minimum level cannot be specified for memcached buckets"}}`)
+	case mock.BucketTypeEphemeral:
+		if level != mock.DurabilityLevelMajority {
+			return errors.New(`{"errors":{"durability_min_level":"Durability minimum level must be either none or majority for ephemeral buckets"}}`)
+		}
+	}
+
+	return nil
+}
+
 func (x *mgmtImpl) handleAddBucketConfig(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
 	if !source.CheckAuthenticated(mockauth.PermissionClusterManage, "", "", "", req) {
 		return &mock.HTTPResponse{
@@ -279,6 +334,13 @@ func (x *mgmtImpl) handleAddBucketConfig(source mock.MgmtService, req *mock.HTTP
 	settings.Name = name
 	settings.Type = mock.BucketTypeFromString(bucketType)
 
+	if err := x.checkBucketDurabilityMinLevel(source.Node().Cluster().ServerVersion(), settings.Type, settings.DurabilityMinLevel); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
 	_, err = source.Node().Cluster().AddBucket(settings)
 	if err != nil {
 		return &mock.HTTPResponse{
@@ -321,12 +383,26 @@ func (x *mgmtImpl) handleUpdateBucketConfig(source mock.MgmtService, req *mock.H
 		}
 	}
 
+	if err := x.checkBucketDurabilityMinLevel(source.Node().Cluster().ServerVersion(), bucket.BucketType(), settings.DurabilityMinLevel); err != nil {
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	var maxTTL *uint32
+	if req.Form.Get("maxTTL") != "" {
+		maxTTL = &settings.MaxTTL
+	}
+
 	if err := bucket.Update(mock.UpdateBucketOptions{
 		NumReplicas:         settings.NumReplicas,
 		FlushEnabled:        settings.FlushEnabled,
 		RamQuota:            settings.RamQuota,
 		ReplicaIndexEnabled: settings.ReplicaIndexEnabled,
 		CompressionMode:     settings.CompressionMode,
+		DurabilityMinLevel:  settings.DurabilityMinLevel,
+		MaxTTL:              maxTTL,
 	}); err != nil {
 		return &mock.HTTPResponse{
 			StatusCode: 400,
@@ -380,6 +456,41 @@ func (x *mgmtImpl) handleGetNodeServices(source mock.MgmtService, req *mock.HTTP
 	}
 }
 
+func (x *mgmtImpl) handleGetRebalanceProgress(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionClusterRead, "", "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+	cluster := source.Node().Cluster()
+
+	progress := map[string]interface{}{
+		"status": "none",
+	}
+
+	// Every node moves its vbuckets as part of the same rebalance, so they all
+	// report the overall progress of it.
+	if isRunning, fraction := cluster.RebalanceProgress(); isRunning {
+		progress["status"] = "running"
+		for _, node := range cluster.Nodes() {
+			if node.Membership() != mock.NodeMembershipActive {
+				continue
+			}
+
+			progress[genOtpNode(node)] = map[string]interface{}{
+				"progress": fraction,
+			}
+		}
+	}
+
+	progressBytes, _ := json.Marshal(progress)
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(progressBytes),
+	}
+}
+
 func (x *mgmtImpl) handleGetAllPoolsConfig(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
 	if !source.CheckAuthenticated(mockauth.PermissionSettings, "", "", "", req) {
 		return &mock.HTTPResponse{
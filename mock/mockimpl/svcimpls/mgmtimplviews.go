@@ -3,7 +3,10 @@ package svcimpls
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/couchbaselabs/gocaves/contrib/pathparse"
 	"github.com/couchbaselabs/gocaves/mock"
 	"github.com/couchbaselabs/gocaves/mock/mockauth"
@@ -29,9 +32,29 @@ func (x *mgmtImpl) handleGetAllDesignDocuments(source mock.MgmtService, req *moc
 		}
 	}
 
+	// The namespace can optionally be used to only list the development or
+	// the production design documents.
+	var filterDevelopment, filterProduction bool
+	switch strings.ToLower(req.URL.Query().Get("namespace")) {
+	case "":
+	case "development":
+		filterProduction = true
+	case "production":
+		filterDevelopment = true
+	default:
+		return &mock.HTTPResponse{
+			StatusCode: 400,
+			Body:       bytes.NewReader([]byte("invalid design document namespace")),
+		}
+	}
+
 	ddocs := bucket.ViewIndexManager().GetAllDesignDocuments()
 	var jsonsRows []jsonGetAllDesignDocsRow
 	for _, ddoc := range ddocs {
+		if (filterDevelopment && ddoc.IsDevelopment()) || (filterProduction && !ddoc.IsDevelopment()) {
+			continue
+		}
+
 		doc := jsonGetAllDesignDocsDoc{
 			Meta: jsonGetAllDesignDocsMeta{
 				ID:  "_design/" + ddoc.Name,
@@ -64,6 +87,47 @@ func (x *mgmtImpl) handleGetAllDesignDocuments(source mock.MgmtService, req *moc
 	}
 }
 
+// handlePublishDesignDocument copies a development design document into the
+// production namespace.  The real server leaves publishing to its clients, but
+// this allows the behaviour to be driven from the mock directly.
+func (x *mgmtImpl) handlePublishDesignDocument(source mock.MgmtService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/pools/default/buckets/*/ddocs/_design/*/controller/publish")
+	bucketName := pathParts[0]
+	ddocName := pathParts[1]
+
+	if !source.CheckAuthenticated(mockauth.PermissionViewsManage, bucketName, "", "", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte(mockmr.ErrNotFound.Error())),
+		}
+	}
+
+	err := bucket.ViewIndexManager().PublishDesignDocument(ddocName)
+	if err != nil {
+		statusCode := 400
+		if errors.Is(err, mockmr.ErrNotFound) {
+			statusCode = 404
+		}
+		return &mock.HTTPResponse{
+			StatusCode: statusCode,
+			Body:       bytes.NewReader([]byte(err.Error())),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
+
 type jsonGetAllDesignDocsMeta struct {
 	ID  string `json:"id"`
 	Rev string `json:"rev"`
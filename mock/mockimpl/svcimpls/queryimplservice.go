@@ -0,0 +1,404 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockn1ql"
+	"github.com/google/uuid"
+)
+
+type queryImplService struct {
+}
+
+func (x *queryImplService) Register(h *hookHelper) {
+	h.RegisterQueryHandler("GET", "/query/service", x.handleQuery)
+	h.RegisterQueryHandler("POST", "/query/service", x.handleQuery)
+}
+
+type jsonQueryError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+type jsonQueryMetrics struct {
+	ElapsedTime   string `json:"elapsedTime"`
+	ExecutionTime string `json:"executionTime"`
+	ResultCount   int    `json:"resultCount"`
+	ResultSize    int    `json:"resultSize"`
+	MutationCount int    `json:"mutationCount,omitempty"`
+	ErrorCount    int    `json:"errorCount,omitempty"`
+}
+
+type jsonQueryResponse struct {
+	RequestID       string            `json:"requestID"`
+	ClientContextID string            `json:"clientContextID,omitempty"`
+	Prepared        string            `json:"prepared,omitempty"`
+	Signature       interface{}       `json:"signature,omitempty"`
+	Results         []json.RawMessage `json:"results"`
+	Errors          []jsonQueryError  `json:"errors,omitempty"`
+	Status          string            `json:"status"`
+	Metrics         jsonQueryMetrics  `json:"metrics"`
+}
+
+// queryRequest holds the parameters of a query request, which can be sent
+// either as a JSON body or as form values.
+type queryRequest struct {
+	Statement       string
+	NamedArgs       map[string]interface{}
+	PositionalArgs  []interface{}
+	QueryContext    string
+	ClientContextID string
+	ScanConsistency string
+	ScanVectors     map[string]mockn1ql.ScanVector
+	ScanWait        time.Duration
+	Prepared        string
+	EncodedPlan     string
+	AutoExecute     bool
+	TxID            string
+	TxImplicit      bool
+	TxTimeout       time.Duration
+}
+
+// parseScanVectorEntry parses a single [seqno, "vbuuid"] scan vector entry.
+func parseScanVectorEntry(val interface{}) (mockn1ql.ScanVectorEntry, error) {
+	parts, ok := val.([]interface{})
+	if !ok || len(parts) != 2 {
+		return mockn1ql.ScanVectorEntry{}, errors.New("invalid scan vector entry")
+	}
+
+	seqNo, ok := parts[0].(float64)
+	if !ok {
+		return mockn1ql.ScanVectorEntry{}, errors.New("invalid scan vector seqno")
+	}
+
+	var vbUUID string
+	switch uuidVal := parts[1].(type) {
+	case string:
+		vbUUID = uuidVal
+	case float64:
+		vbUUID = strconv.FormatUint(uint64(uuidVal), 10)
+	default:
+		return mockn1ql.ScanVectorEntry{}, errors.New("invalid scan vector vbuuid")
+	}
+
+	return mockn1ql.ScanVectorEntry{
+		SeqNo:  uint64(seqNo),
+		VbUUID: vbUUID,
+	}, nil
+}
+
+// parseScanVectors parses the scan_vectors parameter, which maps each keyspace
+// to either a full vector with an entry for every vbucket, or a sparse vector
+// keyed by vbucket id.
+func parseScanVectors(val interface{}) (map[string]mockn1ql.ScanVector, error) {
+	keyspaces, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("scan_vectors must be an object")
+	}
+
+	vectors := make(map[string]mockn1ql.ScanVector)
+	for keyspace, vectorVal := range keyspaces {
+		vector := make(mockn1ql.ScanVector)
+
+		switch entries := vectorVal.(type) {
+		case []interface{}:
+			for vbID, entryVal := range entries {
+				entry, err := parseScanVectorEntry(entryVal)
+				if err != nil {
+					return nil, err
+				}
+				vector[uint(vbID)] = entry
+			}
+		case map[string]interface{}:
+			for vbIDStr, entryVal := range entries {
+				vbID, err := strconv.ParseUint(vbIDStr, 10, 16)
+				if err != nil {
+					return nil, errors.New("invalid scan vector vbucket id")
+				}
+				entry, err := parseScanVectorEntry(entryVal)
+				if err != nil {
+					return nil, err
+				}
+				vector[uint(vbID)] = entry
+			}
+		default:
+			return nil, errors.New("invalid scan vector")
+		}
+
+		// Keyspaces may be qualified with their namespace.
+		vectors[strings.TrimPrefix(keyspace, "default:")] = vector
+	}
+
+	return vectors, nil
+}
+
+func (x *queryImplService) parseRequest(req *mock.HTTPRequest) (*queryRequest, error) {
+	params := make(map[string]interface{})
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(req.Body).Decode(&params); err != nil {
+			return nil, err
+		}
+	} else {
+		for key, values := range req.Form {
+			if len(values) == 0 {
+				continue
+			}
+
+			// Arguments are JSON encoded within form values, everything else is
+			// just a plain string.
+			if key == "args" || key == "scan_vectors" || strings.HasPrefix(key, "$") {
+				var val interface{}
+				if err := json.Unmarshal([]byte(values[0]), &val); err != nil {
+					return nil, err
+				}
+				params[key] = val
+			} else {
+				params[key] = values[0]
+			}
+		}
+	}
+
+	qreq := &queryRequest{
+		NamedArgs: make(map[string]interface{}),
+	}
+	for key, val := range params {
+		switch {
+		case key == "statement":
+			qreq.Statement, _ = val.(string)
+		case key == "args":
+			qreq.PositionalArgs, _ = val.([]interface{})
+		case key == "query_context":
+			qreq.QueryContext, _ = val.(string)
+		case key == "client_context_id":
+			qreq.ClientContextID, _ = val.(string)
+		case key == "prepared":
+			prepared, ok := val.(string)
+			if !ok {
+				return nil, &mockn1ql.Error{
+					Code: mockn1ql.ErrCodeUnrecognizedPrepared,
+					Msg:  "Unrecognizable prepared statement",
+				}
+			}
+			qreq.Prepared = prepared
+		case key == "encoded_plan":
+			qreq.EncodedPlan, _ = val.(string)
+		case key == "auto_execute":
+			switch autoExecute := val.(type) {
+			case bool:
+				qreq.AutoExecute = autoExecute
+			case string:
+				qreq.AutoExecute = autoExecute == "true"
+			}
+		case key == "txid":
+			qreq.TxID, _ = val.(string)
+		case key == "tximplicit":
+			switch txImplicit := val.(type) {
+			case bool:
+				qreq.TxImplicit = txImplicit
+			case string:
+				qreq.TxImplicit = txImplicit == "true"
+			}
+		case key == "txtimeout":
+			timeoutStr, _ := val.(string)
+			txTimeout, err := time.ParseDuration(timeoutStr)
+			if err != nil {
+				return nil, err
+			}
+			qreq.TxTimeout = txTimeout
+		case key == "scan_consistency":
+			qreq.ScanConsistency, _ = val.(string)
+		case key == "scan_vectors":
+			vectors, err := parseScanVectors(val)
+			if err != nil {
+				return nil, err
+			}
+			qreq.ScanVectors = vectors
+		case key == "scan_wait":
+			waitStr, _ := val.(string)
+			scanWait, err := time.ParseDuration(waitStr)
+			if err != nil {
+				return nil, err
+			}
+			qreq.ScanWait = scanWait
+		case strings.HasPrefix(key, "$"):
+			qreq.NamedArgs[key] = val
+		}
+	}
+
+	return qreq, nil
+}
+
+// queryErrorStatus returns the HTTP status code that the query service uses
+// for requests which fail with a particular error code.
+func queryErrorStatus(code int) int {
+	switch {
+	case code >= 1000 && code < 2000:
+		return 400
+	case code >= 3000 && code < 4000:
+		return 400
+	case code >= 4040 && code <= 4090:
+		return 404
+	case code == 10000 || code == mockn1ql.ErrCodeAccessDenied:
+		return 401
+	}
+	return 500
+}
+
+func (x *queryImplService) keyspaceResolver(source mock.QueryService, req *mock.HTTPRequest) mockn1ql.KeyspaceResolver {
+	return func(path mockn1ql.KeyspacePath, access mockn1ql.KeyspaceAccess) (*mockn1ql.Keyspace, error) {
+		bucket := source.Node().Cluster().GetBucket(path.Bucket)
+		if bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			return nil, mockn1ql.ErrKeyspaceNotFound
+		}
+
+		scope, collection := path.Scope, path.Collection
+		if scope == "" {
+			scope, collection = "_default", "_default"
+		}
+
+		_, collectionID, err := bucket.CollectionManifest().GetByName(scope, collection)
+		if err != nil {
+			return nil, mockn1ql.ErrKeyspaceNotFound
+		}
+
+		var permission mockauth.Permission
+		switch access {
+		case mockn1ql.KeyspaceAccessRead:
+			permission = mockauth.PermissionQueryRead
+		case mockn1ql.KeyspaceAccessInsert, mockn1ql.KeyspaceAccessUpdate:
+			permission = mockauth.PermissionQueryWrite
+		case mockn1ql.KeyspaceAccessDelete:
+			permission = mockauth.PermissionQueryDelete
+		case mockn1ql.KeyspaceAccessManage:
+			permission = mockauth.PermissionQueryManage
+		}
+
+		if !source.CheckAuthenticated(permission, path.Bucket, scope, collection, req) {
+			return nil, mockn1ql.ErrAccessDenied
+		}
+
+		return &mockn1ql.Keyspace{
+			Store:        bucket.Store(),
+			CollectionID: uint(collectionID),
+		}, nil
+	}
+}
+
+func (x *queryImplService) handleQuery(source mock.QueryService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	start := time.Now()
+
+	resp := jsonQueryResponse{
+		RequestID: uuid.New().String(),
+		Results:   []json.RawMessage{},
+	}
+
+	statusCode := 200
+	qreq, err := x.parseRequest(req)
+	if queryErr, ok := err.(*mockn1ql.Error); ok {
+		statusCode = queryErrorStatus(queryErr.Code)
+		resp.Status = "fatal"
+		resp.Errors = []jsonQueryError{{
+			Code: queryErr.Code,
+			Msg:  queryErr.Msg,
+		}}
+	} else if err != nil {
+		statusCode = 400
+		resp.Status = "fatal"
+		resp.Errors = []jsonQueryError{{
+			Code: mockn1ql.ErrCodeInvalidParameters,
+			Msg:  "Error parsing request: " + err.Error(),
+		}}
+	} else if qreq.Statement == "" && qreq.Prepared == "" {
+		statusCode = 400
+		resp.Status = "fatal"
+		resp.Errors = []jsonQueryError{{
+			Code: mockn1ql.ErrCodeInvalidParameters,
+			Msg:  "No statement or prepared value",
+		}}
+	} else {
+		resp.ClientContextID = qreq.ClientContextID
+
+		// The enhanced prepared statement protocol is only supported from 6.5.
+		autoExecute := qreq.AutoExecute && source.Node().Cluster().ServerVersion() >= mock.ServerVersion65
+
+		results, err := source.Node().Cluster().QueryEngine().Execute(mockn1ql.ExecuteOptions{
+			Statement:      qreq.Statement,
+			NamedArgs:      qreq.NamedArgs,
+			PositionalArgs: qreq.PositionalArgs,
+			QueryContext:   qreq.QueryContext,
+			Keyspaces:      x.keyspaceResolver(source, req),
+
+			ScanConsistency: qreq.ScanConsistency,
+			ScanVectors:     qreq.ScanVectors,
+			ScanWait:        qreq.ScanWait,
+
+			Prepared:    qreq.Prepared,
+			EncodedPlan: qreq.EncodedPlan,
+			AutoExecute: autoExecute,
+
+			TxID:       qreq.TxID,
+			TxImplicit: qreq.TxImplicit,
+			TxTimeout:  qreq.TxTimeout,
+		})
+		if err != nil {
+			queryErr, ok := err.(*mockn1ql.Error)
+			if !ok {
+				queryErr = &mockn1ql.Error{Code: mockn1ql.ErrCodeInternal, Msg: err.Error()}
+			}
+
+			statusCode = queryErrorStatus(queryErr.Code)
+			resp.Status = "fatal"
+			resp.Errors = []jsonQueryError{{
+				Code: queryErr.Code,
+				Msg:  queryErr.Msg,
+			}}
+		} else {
+			resp.Prepared = results.Prepared
+			resp.Signature = results.Signature
+			resp.Results = results.Rows
+			resp.Metrics.MutationCount = results.MutationCount
+			resp.Status = "success"
+
+			for _, queryErr := range results.Errors {
+				resp.Errors = append(resp.Errors, jsonQueryError{
+					Code: queryErr.Code,
+					Msg:  queryErr.Msg,
+				})
+				resp.Status = "errors"
+			}
+		}
+	}
+
+	for _, row := range resp.Results {
+		resp.Metrics.ResultSize += len(row)
+	}
+	resp.Metrics.ResultCount = len(resp.Results)
+	resp.Metrics.ErrorCount = len(resp.Errors)
+
+	elapsed := time.Since(start).String()
+	resp.Metrics.ElapsedTime = elapsed
+	resp.Metrics.ExecutionTime = elapsed
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Failed to marshal query result: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(b),
+	}
+}
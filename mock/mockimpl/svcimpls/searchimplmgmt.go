@@ -0,0 +1,255 @@
+package svcimpls
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockfts"
+)
+
+type searchImplMgmt struct {
+}
+
+func (x *searchImplMgmt) Register(h *hookHelper) {
+	h.RegisterSearchHandler("GET", "/api/index", x.handleGetAllIndexes)
+	h.RegisterSearchHandler("PUT", "/api/index/*", x.handleUpsertIndex)
+	h.RegisterSearchHandler("GET", "/api/index/*", x.handleGetIndex)
+	h.RegisterSearchHandler("DELETE", "/api/index/*", x.handleDeleteIndex)
+	h.RegisterSearchHandler("GET", "/api/index/*/count", x.handleDocCount)
+	h.RegisterSearchHandler("POST", "/api/index/*/ingestControl/*", x.handleIngestControl)
+	h.RegisterSearchHandler("POST", "/api/index/*/queryControl/*", x.handleQueryControl)
+	h.RegisterSearchHandler("POST", "/api/index/*/planFreezeControl/*", x.handlePlanFreezeControl)
+}
+
+// searchSourceResolver returns a resolver which looks up the collections that
+// search indexes source their documents from.
+func searchSourceResolver(source mock.SearchService, req *mock.HTTPRequest) mockfts.SourceResolver {
+	return func(bucketName, scope, collection string) (*mockfts.Source, error) {
+		bucket := source.Node().Cluster().GetBucket(bucketName)
+		if bucket == nil || bucket.BucketType() == mock.BucketTypeMemcached {
+			return nil, mockfts.ErrSourceNotFound
+		}
+
+		_, collectionID, err := bucket.CollectionManifest().GetByName(scope, collection)
+		if err != nil {
+			return nil, mockfts.ErrSourceNotFound
+		}
+
+		if !source.CheckAuthenticated(mockauth.PermissionSearchRead, bucketName, scope, collection, req) {
+			return nil, mockfts.ErrAccessDenied
+		}
+
+		return &mockfts.Source{
+			Store:        bucket.Store(),
+			CollectionID: uint(collectionID),
+		}, nil
+	}
+}
+
+// checkSearchIndexAuthenticated verifies that the request has a permission
+// on the bucket which an index is sourced from.  Indexes which do not exist
+// fall back to checking the permission against the cluster.
+func checkSearchIndexAuthenticated(source mock.SearchService, permission mockauth.Permission, indexName string, req *mock.HTTPRequest) bool {
+	bucketName := ""
+	if def, err := source.Node().Cluster().SearchEngine().GetIndex(indexName); err == nil {
+		bucketName = def.SourceName
+	}
+
+	return source.CheckAuthenticated(permission, bucketName, "", "", req)
+}
+
+func searchUnauthorizedResponse() *mock.HTTPResponse {
+	return &mock.HTTPResponse{
+		StatusCode: 401,
+		Body:       bytes.NewReader([]byte{}),
+	}
+}
+
+func searchJSONResponse(statusCode int, value interface{}) *mock.HTTPResponse {
+	b, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to marshal search response: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: statusCode,
+		Body:       bytes.NewReader(b),
+	}
+}
+
+func searchErrorResponse(err error) *mock.HTTPResponse {
+	if errors.Is(err, mockfts.ErrAccessDenied) {
+		return searchUnauthorizedResponse()
+	}
+
+	return searchJSONResponse(400, map[string]interface{}{
+		"error":  err.Error(),
+		"status": "fail",
+	})
+}
+
+func searchOkResponse(fields map[string]interface{}) *mock.HTTPResponse {
+	resp := map[string]interface{}{
+		"status": "ok",
+	}
+	for key, val := range fields {
+		resp[key] = val
+	}
+
+	return searchJSONResponse(200, resp)
+}
+
+func (x *searchImplMgmt) handleGetAllIndexes(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	if !source.CheckAuthenticated(mockauth.PermissionSearchManage, "", "", "", req) {
+		return searchUnauthorizedResponse()
+	}
+
+	defs := make(map[string]*mockfts.IndexDefinition)
+	for _, def := range source.Node().Cluster().SearchEngine().GetAllIndexes() {
+		defs[def.Name] = def
+	}
+
+	return searchOkResponse(map[string]interface{}{
+		"indexDefs": map[string]interface{}{
+			"uuid":        source.Node().Cluster().ID(),
+			"indexDefs":   defs,
+			"implVersion": "5.5.0",
+		},
+	})
+}
+
+func (x *searchImplMgmt) handleUpsertIndex(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/index/*")
+	indexName := pathParts[0]
+
+	var def mockfts.IndexDefinition
+	if err := json.Unmarshal(req.PeekBody(), &def); err != nil {
+		return searchErrorResponse(errors.New("rest_create_index: failed to parse index definition: " + err.Error()))
+	}
+	if def.Name == "" {
+		def.Name = indexName
+	} else if def.Name != indexName {
+		return searchErrorResponse(errors.New("rest_create_index: index name in the request body does not match the index name in the URL"))
+	}
+
+	if !source.CheckAuthenticated(mockauth.PermissionSearchManage, def.SourceName, "", "", req) {
+		return searchUnauthorizedResponse()
+	}
+
+	newDef, err := source.Node().Cluster().SearchEngine().UpsertIndex(&def, searchSourceResolver(source, req))
+	if err != nil {
+		return searchErrorResponse(err)
+	}
+
+	return searchOkResponse(map[string]interface{}{
+		"name": newDef.Name,
+		"uuid": newDef.UUID,
+	})
+}
+
+func (x *searchImplMgmt) handleGetIndex(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/index/*")
+	indexName := pathParts[0]
+
+	if !checkSearchIndexAuthenticated(source, mockauth.PermissionSearchManage, indexName, req) {
+		return searchUnauthorizedResponse()
+	}
+
+	def, err := source.Node().Cluster().SearchEngine().GetIndex(indexName)
+	if err != nil {
+		return searchErrorResponse(err)
+	}
+
+	return searchOkResponse(map[string]interface{}{
+		"indexDef": def,
+	})
+}
+
+func (x *searchImplMgmt) handleDeleteIndex(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/index/*")
+	indexName := pathParts[0]
+
+	if !checkSearchIndexAuthenticated(source, mockauth.PermissionSearchManage, indexName, req) {
+		return searchUnauthorizedResponse()
+	}
+
+	def, err := source.Node().Cluster().SearchEngine().DeleteIndex(indexName)
+	if err != nil {
+		return searchErrorResponse(err)
+	}
+
+	return searchOkResponse(map[string]interface{}{
+		"uuid": def.UUID,
+	})
+}
+
+func (x *searchImplMgmt) handleDocCount(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/index/*/count")
+	indexName := pathParts[0]
+
+	if !checkSearchIndexAuthenticated(source, mockauth.PermissionSearchRead, indexName, req) {
+		return searchUnauthorizedResponse()
+	}
+
+	count, err := source.Node().Cluster().SearchEngine().DocCount(indexName, searchSourceResolver(source, req))
+	if err != nil {
+		return searchErrorResponse(err)
+	}
+
+	return searchOkResponse(map[string]interface{}{
+		"count": count,
+	})
+}
+
+// handleControl implements the control endpoints, which each switch some
+// state of an index between one of two named operations.
+func (x *searchImplMgmt) handleControl(source mock.SearchService, req *mock.HTTPRequest, path, onOp, offOp string,
+	fn func(engine mock.SearchEngine, indexName string, on bool) error) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, path)
+	indexName := pathParts[0]
+	op := pathParts[1]
+
+	if !checkSearchIndexAuthenticated(source, mockauth.PermissionSearchManage, indexName, req) {
+		return searchUnauthorizedResponse()
+	}
+
+	if op != onOp && op != offOp {
+		return searchErrorResponse(errors.New("rest_index: unsupported op: " + op))
+	}
+
+	if err := fn(source.Node().Cluster().SearchEngine(), indexName, op == onOp); err != nil {
+		return searchErrorResponse(err)
+	}
+
+	return searchOkResponse(nil)
+}
+
+func (x *searchImplMgmt) handleIngestControl(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleControl(source, req, "/api/index/*/ingestControl/*", "pause", "resume",
+		func(engine mock.SearchEngine, indexName string, on bool) error {
+			return engine.SetIngestPaused(indexName, on)
+		})
+}
+
+func (x *searchImplMgmt) handleQueryControl(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleControl(source, req, "/api/index/*/queryControl/*", "allow", "disallow",
+		func(engine mock.SearchEngine, indexName string, on bool) error {
+			return engine.SetQueryAllowed(indexName, on)
+		})
+}
+
+func (x *searchImplMgmt) handlePlanFreezeControl(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	return x.handleControl(source, req, "/api/index/*/planFreezeControl/*", "freeze", "unfreeze",
+		func(engine mock.SearchEngine, indexName string, on bool) error {
+			return engine.SetPlanFrozen(indexName, on)
+		})
+}
@@ -0,0 +1,51 @@
+package svcimpls
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/couchbaselabs/gocaves/contrib/pathparse"
+	"github.com/couchbaselabs/gocaves/mock"
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockfts"
+)
+
+type searchImplQuery struct {
+}
+
+func (x *searchImplQuery) Register(h *hookHelper) {
+	h.RegisterSearchHandler("POST", "/api/index/*/query", x.handleQuery)
+}
+
+func (x *searchImplQuery) handleQuery(source mock.SearchService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/api/index/*/query")
+	indexName := pathParts[0]
+
+	if !checkSearchIndexAuthenticated(source, mockauth.PermissionSearchRead, indexName, req) {
+		return searchUnauthorizedResponse()
+	}
+
+	failResponse := func(err error) *mock.HTTPResponse {
+		if errors.Is(err, mockfts.ErrAccessDenied) {
+			return searchUnauthorizedResponse()
+		}
+
+		return searchJSONResponse(400, map[string]interface{}{
+			"error":   "rest_index: Query, indexName: " + indexName + ", err: " + err.Error(),
+			"request": json.RawMessage(req.PeekBody()),
+			"status":  "fail",
+		})
+	}
+
+	var searchReq mockfts.SearchRequest
+	if err := json.Unmarshal(req.PeekBody(), &searchReq); err != nil {
+		return searchErrorResponse(errors.New("rest_index: Query, could not unmarshal req bytes: " + err.Error()))
+	}
+
+	results, err := source.Node().Cluster().SearchEngine().Search(indexName, &searchReq, searchSourceResolver(source, req))
+	if err != nil {
+		return failResponse(err)
+	}
+
+	return searchJSONResponse(200, results)
+}
@@ -10,7 +10,8 @@ import (
 )
 
 func writePacketToSource(source mock.KvClient, pak *memd.Packet, start time.Time) {
-	if source.HasFeature(memd.FeatureDurations) {
+	// Server durations are only ever attached to responses.
+	if pak.Magic == memd.CmdMagicRes && source.HasFeature(memd.FeatureDurations) {
 		// TODO (chvck): revisit this, for some reason Windows reports a server duration of 0.
 		// Golang time accuracy in Windows is good enough that this shouldn't be the case and it seems pretty unlikely
 		// that we've actually done the operation in no time.
@@ -26,7 +26,8 @@ type jsonView struct {
 }
 
 type jsonDesignDocument struct {
-	Views map[string]jsonView `json:"views,omitempty"`
+	Views   map[string]jsonView `json:"views,omitempty"`
+	Spatial map[string]string   `json:"spatial,omitempty"`
 }
 
 func ddocToJsonDesignDocument(ddoc *mockmr.DesignDocument) jsonDesignDocument {
@@ -41,6 +42,13 @@ func ddocToJsonDesignDocument(ddoc *mockmr.DesignDocument) jsonDesignDocument {
 		}
 	}
 
+	if len(ddoc.SpatialIndexes) > 0 {
+		jsonDdoc.Spatial = make(map[string]string)
+		for _, view := range ddoc.SpatialIndexes {
+			jsonDdoc.Spatial[view.Name] = view.MapFunc
+		}
+	}
+
 	return jsonDdoc
 }
 
@@ -84,8 +92,17 @@ func (x *viewImplMgmt) handleUpsertDesignDocument(source mock.ViewService, req *
 		i++
 	}
 
+	spatialViews := make([]*mockmr.Index, 0, len(ddoc.Spatial))
+	for name, view := range ddoc.Spatial {
+		spatialViews = append(spatialViews, &mockmr.Index{
+			Name:    name,
+			MapFunc: view,
+		})
+	}
+
 	err = bucket.ViewIndexManager().UpsertDesignDocument(ddocName, mockmr.UpsertDesignDocumentOptions{
-		Indexes: views,
+		Indexes:        views,
+		SpatialIndexes: spatialViews,
 	})
 	if err != nil {
 		return &mock.HTTPResponse{
@@ -19,6 +19,7 @@ type viewImplQuery struct {
 
 func (x *viewImplQuery) Register(h *hookHelper) {
 	h.RegisterViewHandler("GET", "/*/_design/*/_view/*", x.handleQuery)
+	h.RegisterViewHandler("GET", "/*/_design/*/_spatial/*", x.handleSpatialQuery)
 }
 
 type jsonViewResult struct {
@@ -41,6 +42,17 @@ type jsonViewRow struct {
 	Value interface{} `json:"value"`
 }
 
+type jsonSpatialResult struct {
+	Rows []jsonSpatialRow `json:"rows"`
+}
+
+type jsonSpatialRow struct {
+	ID       string      `json:"id"`
+	Key      interface{} `json:"key"`
+	Value    interface{} `json:"value"`
+	Geometry interface{} `json:"geometry,omitempty"`
+}
+
 func (x *viewImplQuery) handleQuery(source mock.ViewService, req *mock.HTTPRequest) *mock.HTTPResponse {
 	pathParts := pathparse.ParseParts(req.URL.Path, "/*/_design/*/_view/*")
 	bucketName := pathParts[0]
@@ -65,13 +77,9 @@ func (x *viewImplQuery) handleQuery(source mock.ViewService, req *mock.HTTPReque
 		}
 	}
 
-	docs, err := bucket.Store().GetAll(0, 0)
-	if err != nil {
-		log.Printf("Failed to get view query docs: %v", err)
-		return &mock.HTTPResponse{
-			StatusCode: 500,
-			Body:       bytes.NewReader([]byte("internal server error")),
-		}
+	stale, ok := x.parseStale(options.Get("stale"))
+	if !ok {
+		return x.queryParseError("Invalid value for parameter `stale`.")
 	}
 
 	keysOpt := options.Get("keys")
@@ -86,9 +94,9 @@ func (x *viewImplQuery) handleQuery(source mock.ViewService, req *mock.HTTPReque
 	}
 
 	totalResults, results, err := bucket.ViewIndexManager().Execute(mockmr.ExecuteOptions{
-		Data:      docs,
 		DesignDoc: ddocName,
 		View:      viewName,
+		Stale:     stale,
 
 		Skip:          x.stringToInt(options.Get("skip")),
 		StartKey:      options.Get("startkey"),
@@ -162,6 +170,146 @@ func (x *viewImplQuery) handleQuery(source mock.ViewService, req *mock.HTTPReque
 
 }
 
+func (x *viewImplQuery) handleSpatialQuery(source mock.ViewService, req *mock.HTTPRequest) *mock.HTTPResponse {
+	pathParts := pathparse.ParseParts(req.URL.Path, "/*/_design/*/_spatial/*")
+	bucketName := pathParts[0]
+	ddocName := pathParts[1]
+	viewName := pathParts[2]
+
+	options := req.URL.Query()
+
+	if !source.CheckAuthenticated(mockauth.PermissionViewsManage, bucketName, "_default", "_default", req) {
+		return &mock.HTTPResponse{
+			StatusCode: 401,
+			Body:       bytes.NewReader([]byte{}),
+		}
+	}
+
+	bucket := source.Node().Cluster().GetBucket(bucketName)
+	if bucket == nil {
+		return &mock.HTTPResponse{
+			StatusCode: 404,
+			Body:       bytes.NewReader([]byte(mockmr.ErrNotFound.Error())),
+		}
+	}
+
+	stale, ok := x.parseStale(options.Get("stale"))
+	if !ok {
+		return x.queryParseError("Invalid value for parameter `stale`.")
+	}
+
+	var startRange, endRange []*float64
+	if bboxOpt := options.Get("bbox"); bboxOpt != "" {
+		bboxParts := strings.Split(bboxOpt, ",")
+		if len(bboxParts) != 4 {
+			return x.queryParseError("Invalid value for parameter `bbox`.")
+		}
+
+		bbox := make([]*float64, 4)
+		for partIdx, part := range bboxParts {
+			val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return x.queryParseError("Invalid value for parameter `bbox`.")
+			}
+			bbox[partIdx] = &val
+		}
+
+		startRange = bbox[:2]
+		endRange = bbox[2:]
+	} else {
+		if rangeOpt := options.Get("start_range"); rangeOpt != "" {
+			if err := json.Unmarshal([]byte(rangeOpt), &startRange); err != nil {
+				return x.queryParseError("Invalid value for parameter `start_range`.")
+			}
+		}
+		if rangeOpt := options.Get("end_range"); rangeOpt != "" {
+			if err := json.Unmarshal([]byte(rangeOpt), &endRange); err != nil {
+				return x.queryParseError("Invalid value for parameter `end_range`.")
+			}
+		}
+	}
+
+	results, err := bucket.ViewIndexManager().ExecuteSpatial(mockmr.ExecuteSpatialOptions{
+		DesignDoc:  ddocName,
+		View:       viewName,
+		Stale:      stale,
+		StartRange: startRange,
+		EndRange:   endRange,
+		Skip:       x.stringToInt(options.Get("skip")),
+		Limit:      x.stringToInt(options.Get("limit")),
+	})
+	if err != nil {
+		log.Printf("Failed to execute spatial view query: %v", err)
+		if errors.Is(err, mockmr.ErrNotFound) {
+			return &mock.HTTPResponse{
+				StatusCode: 404,
+				Body:       bytes.NewReader([]byte(err.Error())),
+			}
+		} else if errors.Is(err, mockmr.ErrInvalidParameters) {
+			return &mock.HTTPResponse{
+				StatusCode: 400,
+				Body:       bytes.NewReader([]byte(err.Error())),
+			}
+		}
+
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	rows := []jsonSpatialRow{} // Make sure this isn't sent as null.
+	for _, res := range results.Rows {
+		rows = append(rows, jsonSpatialRow{
+			ID:       res.ID,
+			Key:      res.Key,
+			Value:    res.Value,
+			Geometry: res.Geometry,
+		})
+	}
+
+	b, err := json.Marshal(jsonSpatialResult{
+		Rows: rows,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal spatial view query result: %v", err)
+		return &mock.HTTPResponse{
+			StatusCode: 500,
+			Body:       bytes.NewReader([]byte("internal server error")),
+		}
+	}
+
+	return &mock.HTTPResponse{
+		StatusCode: 200,
+		Body:       bytes.NewReader(b),
+	}
+}
+
+func (x *viewImplQuery) parseStale(val string) (mockmr.StaleMode, bool) {
+	if val == "" {
+		return mockmr.StaleUpdateAfter, true
+	}
+
+	stale := mockmr.StaleMode(val)
+	if stale != mockmr.StaleOk && stale != mockmr.StaleFalse && stale != mockmr.StaleUpdateAfter {
+		return "", false
+	}
+
+	return stale, true
+}
+
+func (x *viewImplQuery) queryParseError(reason string) *mock.HTTPResponse {
+	b, _ := json.Marshal(map[string]string{
+		"error":  "query_parse_error",
+		"reason": reason,
+	})
+
+	return &mock.HTTPResponse{
+		StatusCode: 400,
+		Body:       bytes.NewReader(b),
+	}
+}
+
 func (x *viewImplQuery) stringToInt(num string) int {
 	i, err := strconv.Atoi(num)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/couchbaselabs/gocaves/mock"
@@ -189,3 +190,190 @@ func TestBucketTerseConfig70(t *testing.T) {
 
 	testCompareLayout(t, actualConfig, testConfig)
 }
+
+func TestBucketConfigDurabilityMinLevel(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 1024,
+	})
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:               "default",
+		Type:               mock.BucketTypeCouchbase,
+		NumReplicas:        1,
+		DurabilityMinLevel: mock.DurabilityLevelMajority,
+	})
+
+	readMinLevel := func() interface{} {
+		var actualConfig map[string]interface{}
+		if err := json.Unmarshal(svcimpls.GenBucketConfig(bucket, nil), &actualConfig); err != nil {
+			t.Fatalf("failed to marshal configuration: %s", err)
+		}
+		return actualConfig["durabilityMinLevel"]
+	}
+
+	if level := readMinLevel(); level != "majority" {
+		t.Fatalf("expected durabilityMinLevel of majority, got %v", level)
+	}
+
+	bucket.Update(mock.UpdateBucketOptions{
+		NumReplicas:        1,
+		DurabilityMinLevel: mock.DurabilityLevelPersistToMajority,
+	})
+	if level := readMinLevel(); level != "persistToMajority" {
+		t.Fatalf("expected durabilityMinLevel of persistToMajority, got %v", level)
+	}
+
+	bucket.Update(mock.UpdateBucketOptions{
+		NumReplicas: 1,
+	})
+	if level := readMinLevel(); level != "persistToMajority" {
+		t.Fatalf("expected durabilityMinLevel to be unchanged, got %v", level)
+	}
+}
+
+func TestBucketConfigMaxTTL(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 1024,
+	})
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+		MaxTTL:      100,
+	})
+
+	readMaxTTL := func() interface{} {
+		var actualConfig map[string]interface{}
+		if err := json.Unmarshal(svcimpls.GenBucketConfig(bucket, nil), &actualConfig); err != nil {
+			t.Fatalf("failed to marshal configuration: %s", err)
+		}
+		return actualConfig["maxTTL"]
+	}
+
+	if maxTTL := readMaxTTL(); maxTTL != float64(100) {
+		t.Fatalf("expected maxTTL of 100, got %v", maxTTL)
+	}
+
+	bucket.Update(mock.UpdateBucketOptions{
+		NumReplicas:  1,
+		FlushEnabled: true,
+	})
+	if maxTTL := readMaxTTL(); maxTTL != float64(100) {
+		t.Fatalf("expected maxTTL to be unchanged, got %v", maxTTL)
+	}
+
+	noMaxTTL := uint32(0)
+	bucket.Update(mock.UpdateBucketOptions{
+		NumReplicas: 1,
+		MaxTTL:      &noMaxTTL,
+	})
+	if maxTTL := readMaxTTL(); maxTTL != float64(0) {
+		t.Fatalf("expected maxTTL of 0, got %v", maxTTL)
+	}
+}
+
+func TestBucketConfigServerVersion65(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets:   1024,
+		ServerVersion: mock.ServerVersion65,
+	})
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	var actualConfig map[string]interface{}
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, nil), &actualConfig); err != nil {
+		t.Fatalf("failed to marshal configuration: %s", err)
+	}
+
+	if _, ok := actualConfig["collectionsManifestUid"]; ok {
+		t.Fatalf("expected no collections manifest uid for 6.5")
+	}
+
+	capabilities := fmt.Sprintf("%v", actualConfig["bucketCapabilities"])
+	if !strings.Contains(capabilities, "durableWrite") {
+		t.Fatalf("expected durableWrite capability for 6.5, got %s", capabilities)
+	}
+	if strings.Contains(capabilities, "collections") || strings.Contains(capabilities, "tombstonedUserXAttrs") {
+		t.Fatalf("expected no collections or tombstonedUserXAttrs capabilities for 6.5, got %s", capabilities)
+	}
+}
+
+func TestBucketConfigAlternateAddresses(t *testing.T) {
+	cluster, _ := NewCluster(mock.NewClusterOptions{
+		NumVbuckets: 1024,
+		InitialNode: mock.NewNodeOptions{
+			Hostname: "localhost",
+			AlternateAddresses: map[string]mock.AlternateAddress{
+				"external": {
+					Hostname: "couchbase.example.com",
+					Ports: map[string]int{
+						"kv":   32000,
+						"mgmt": 32001,
+					},
+				},
+			},
+		},
+	})
+
+	bucket, _ := cluster.AddBucket(mock.NewBucketOptions{
+		Name:        "default",
+		Type:        mock.BucketTypeCouchbase,
+		NumReplicas: 1,
+	})
+
+	type altAddrJSON struct {
+		Hostname string         `json:"hostname"`
+		Ports    map[string]int `json:"ports"`
+	}
+	type nodeJSON struct {
+		Hostname           string                 `json:"hostname"`
+		AlternateAddresses map[string]altAddrJSON `json:"alternateAddresses"`
+	}
+	type configJSON struct {
+		Nodes    []nodeJSON `json:"nodes"`
+		NodesExt []nodeJSON `json:"nodesExt"`
+	}
+
+	checkAltAddrs := func(path string, node nodeJSON) {
+		external, ok := node.AlternateAddresses["external"]
+		if !ok {
+			t.Fatalf("expected external alternate address in %s, got %v", path, node.AlternateAddresses)
+		}
+		if external.Hostname != "couchbase.example.com" {
+			t.Fatalf("expected external hostname in %s, got %s", path, external.Hostname)
+		}
+		if external.Ports["kv"] != 32000 || external.Ports["mgmt"] != 32001 {
+			t.Fatalf("expected external ports in %s, got %v", path, external.Ports)
+		}
+	}
+
+	var terseConfig configJSON
+	if err := json.Unmarshal(svcimpls.GenTerseBucketConfig(bucket, nil), &terseConfig); err != nil {
+		t.Fatalf("failed to marshal configuration: %s", err)
+	}
+	checkAltAddrs("terse nodes", terseConfig.Nodes[0])
+	checkAltAddrs("terse nodesExt", terseConfig.NodesExt[0])
+	if terseConfig.NodesExt[0].Hostname != "localhost" {
+		t.Fatalf("expected default hostname in nodesExt, got %s", terseConfig.NodesExt[0].Hostname)
+	}
+
+	var fullConfig configJSON
+	if err := json.Unmarshal(svcimpls.GenBucketConfig(bucket, nil), &fullConfig); err != nil {
+		t.Fatalf("failed to marshal configuration: %s", err)
+	}
+	checkAltAddrs("full nodes", fullConfig.Nodes[0])
+	if !strings.HasPrefix(fullConfig.Nodes[0].Hostname, "localhost:") {
+		t.Fatalf("expected default hostname in nodes, got %s", fullConfig.Nodes[0].Hostname)
+	}
+
+	var clusterConfig configJSON
+	if err := json.Unmarshal(svcimpls.GenClusterConfig(cluster, nil), &clusterConfig); err != nil {
+		t.Fatalf("failed to marshal configuration: %s", err)
+	}
+	checkAltAddrs("cluster nodes", clusterConfig.Nodes[0])
+}
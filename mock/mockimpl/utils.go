@@ -1,6 +1,7 @@
 package mockimpl
 
 import (
+	"crypto/tls"
 	"encoding/base64"
 	"strings"
 
@@ -42,7 +43,19 @@ func checkHTTPAuthenticated(permission mockauth.Permission, bucket, scope, colle
 	req *mock.HTTPRequest, users mock.UserManager) bool {
 	authHeader := req.Header.Get("Authorization")
 	if authHeader == "" {
-		return false
+		// Requests without credentials may still have been authenticated by
+		// the client certificate they connected with.
+		username := clientCertUserName(req.TLS, users)
+		if username == "" {
+			return false
+		}
+
+		user := users.GetUser(username)
+		if user == nil {
+			return false
+		}
+
+		return user.HasPermission(permission, bucket, scope, collection)
 	}
 
 	split := strings.SplitN(authHeader, " ", 2)
@@ -71,3 +84,14 @@ func checkHTTPAuthenticated(permission mockauth.Permission, bucket, scope, colle
 
 	return user.HasPermission(permission, bucket, scope, collection)
 }
+
+// clientCertUserName returns the name of the user which the client certificate
+// of a TLS connection identifies, or an empty string if there is none.
+func clientCertUserName(state *tls.ConnectionState, users mock.UserManager) string {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+
+	username, _ := users.ClientCertAuth().UsernameForCertificate(state.VerifiedChains[0][0])
+	return username
+}
@@ -0,0 +1,290 @@
+package mockmr
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/dop251/goja"
+)
+
+// vbIndexState records how far through a vbucket a design document index has
+// processed mutations.
+type vbIndexState struct {
+	VbUUID uint64
+	SeqNo  uint64
+}
+
+// viewKey identifies a single view within a design document.  Regular and
+// spatial views live in separate namespaces.
+type viewKey struct {
+	Spatial bool
+	Name    string
+}
+
+// indexedDocument holds the rows which the views of a design document emitted
+// for a single document.
+type indexedDocument struct {
+	VbID uint
+	Rows map[viewKey][]indexedItem
+}
+
+// viewMapper holds the compiled map function for a single view.
+type viewMapper struct {
+	key     viewKey
+	vm      *goja.Runtime
+	fn      goja.Callable
+	emitted []indexedItem
+	docID   string
+}
+
+// designDocIndex holds the persisted index state for all of the views within
+// a design document.  The index is updated incrementally from the mutations
+// in the bucket, so it only reflects the bucket data as of the last update.
+type designDocIndex struct {
+	ddoc *DesignDocument
+
+	lock     sync.Mutex
+	mappers  []*viewMapper
+	vbStates []vbIndexState
+	docs     map[string]*indexedDocument
+	sorted   map[viewKey][]indexedItem
+}
+
+func newDesignDocIndex(ddoc *DesignDocument) *designDocIndex {
+	return &designDocIndex{
+		ddoc:   ddoc,
+		docs:   make(map[string]*indexedDocument),
+		sorted: make(map[viewKey][]indexedItem),
+	}
+}
+
+func newViewMapper(index *Index, spatial bool) (*viewMapper, error) {
+	mapper := &viewMapper{
+		key: viewKey{Spatial: spatial, Name: index.Name},
+		vm:  goja.New(),
+	}
+	mapper.vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	emit := func(key interface{}, value interface{}) {
+		var geometry interface{}
+		if spatial {
+			ranges, geom, err := spatialKeyRanges(key)
+			if err != nil {
+				panic(mapper.vm.NewGoError(err))
+			}
+			key = ranges
+			geometry = geom
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			panic(err)
+		}
+		mapper.emitted = append(mapper.emitted, indexedItem{
+			Key:      string(k),
+			ID:       mapper.docID,
+			Value:    value,
+			Geometry: geometry,
+		})
+	}
+
+	err := mapper.vm.Set("emit", emit)
+	if err != nil {
+		return nil, err
+	}
+
+	fnStr := "callme = " + index.MapFunc
+	_, err = mapper.vm.RunString(fnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := goja.AssertFunction(mapper.vm.Get("callme"))
+	if !ok {
+		return nil, errors.New("cannot parse function")
+	}
+	mapper.fn = fn
+
+	return mapper, nil
+}
+
+// mapDocument runs the map function against a document, returning the rows
+// which it emitted.
+func (m *viewMapper) mapDocument(doc *mockdb.Document, docValue map[string]interface{}) []indexedItem {
+	m.docID = string(doc.Key)
+	m.emitted = nil
+
+	meta := indexInputMeta{
+		ID:         string(doc.Key),
+		Rev:        0,
+		Type:       doc.Datatype,
+		Flags:      doc.Flags,
+		Expiration: doc.Expiry.Second(),
+	}
+
+	_, err := m.fn(goja.Undefined(), m.vm.ToValue(docValue), m.vm.ToValue(meta))
+	if err != nil {
+		// Much like the real server, a document which the map function fails
+		// on simply does not appear in the index.
+		return nil
+	}
+
+	return m.emitted
+}
+
+// update brings the index up to date with the current contents of the store.
+func (idx *designDocIndex) update(store *mockdb.Bucket) error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if idx.mappers == nil {
+		mappers := make([]*viewMapper, 0, len(idx.ddoc.Indexes)+len(idx.ddoc.SpatialIndexes))
+		for _, index := range idx.ddoc.Indexes {
+			mapper, err := newViewMapper(index, false)
+			if err != nil {
+				return err
+			}
+			mappers = append(mappers, mapper)
+		}
+		for _, index := range idx.ddoc.SpatialIndexes {
+			mapper, err := newViewMapper(index, true)
+			if err != nil {
+				return err
+			}
+			mappers = append(mappers, mapper)
+		}
+		idx.mappers = mappers
+	}
+
+	if idx.vbStates == nil {
+		idx.vbStates = make([]vbIndexState, store.NumVbuckets())
+	}
+
+	for vbIdx := range idx.vbStates {
+		vbState := &idx.vbStates[vbIdx]
+		vbucket := store.GetVbucket(uint(vbIdx))
+		metaState := vbucket.CurrentMetaState(0)
+
+		if metaState.VbUUID != vbState.VbUUID {
+			// The history of this vbucket has changed underneath us, due to a
+			// flush or a rollback, so we need to rebuild it from scratch.
+			if vbState.SeqNo > 0 {
+				idx.dropVbucketLocked(uint(vbIdx))
+			}
+			vbState.VbUUID = metaState.VbUUID
+			vbState.SeqNo = 0
+		}
+
+		if metaState.CurrentSeqNo <= vbState.SeqNo {
+			continue
+		}
+
+		docs, _, err := vbucket.GetAllWithin(0, vbState.SeqNo, metaState.CurrentSeqNo)
+		if err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			idx.indexDocumentLocked(doc)
+		}
+
+		vbState.SeqNo = metaState.CurrentSeqNo
+	}
+
+	return nil
+}
+
+func (idx *designDocIndex) dropVbucketLocked(vbID uint) {
+	for docID, indexed := range idx.docs {
+		if indexed.VbID == vbID {
+			delete(idx.docs, docID)
+		}
+	}
+	idx.sorted = make(map[viewKey][]indexedItem)
+}
+
+func (idx *designDocIndex) indexDocumentLocked(doc *mockdb.Document) {
+	// Views only operate on the default collection.
+	if doc.CollectionID != 0 {
+		return
+	}
+
+	docID := string(doc.Key)
+	delete(idx.docs, docID)
+	idx.sorted = make(map[viewKey][]indexedItem)
+
+	if doc.IsDeleted {
+		return
+	}
+
+	var docValue map[string]interface{}
+	err := json.Unmarshal(doc.InflatedValue(), &docValue)
+	if err != nil || docValue == nil {
+		// TODO: this should probably do something else, non json docs are supported by views.
+		return
+	}
+
+	indexed := &indexedDocument{
+		VbID: doc.VbID,
+		Rows: make(map[viewKey][]indexedItem),
+	}
+	for _, mapper := range idx.mappers {
+		rows := mapper.mapDocument(doc, docValue)
+		if len(rows) > 0 {
+			indexed.Rows[mapper.key] = rows
+		}
+	}
+
+	idx.docs[docID] = indexed
+}
+
+// rows returns all of the rows in the index for a particular view, sorted by
+// key and then by document id.  The returned slice must not be modified.
+func (idx *designDocIndex) rows(view viewKey) []indexedItem {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if rows, ok := idx.sorted[view]; ok {
+		return rows
+	}
+
+	var rows []indexedItem
+	for _, indexed := range idx.docs {
+		rows = append(rows, indexed.Rows[view]...)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Key != rows[j].Key {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].ID < rows[j].ID
+	})
+
+	idx.sorted[view] = rows
+	return rows
+}
+
+// query returns the rows of a view, updating the index before or after the
+// rows are read as specified by the stale mode.
+func (idx *designDocIndex) query(store *mockdb.Bucket, view viewKey, stale StaleMode) ([]indexedItem, error) {
+	if stale == StaleFalse {
+		err := idx.update(store)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows := idx.rows(view)
+
+	if stale == StaleUpdateAfter {
+		err := idx.update(store)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,231 @@
+package mockmr
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ExecuteSpatialOptions provides options when executing a spatial query.
+type ExecuteSpatialOptions struct {
+	DesignDoc string
+	View      string
+	Stale     StaleMode
+
+	// StartRange and EndRange bound each dimension of the emitted keys, a nil
+	// entry leaves that side of the dimension unbounded.
+	StartRange []*float64
+	EndRange   []*float64
+
+	Skip  int
+	Limit int
+}
+
+func spatialNumber(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// geometryBounds extends a set of x and y bounds to include all of the
+// positions within some GeoJSON coordinates.
+func geometryBounds(coords interface{}, bounds *[2][2]float64) error {
+	items, ok := coords.([]interface{})
+	if !ok {
+		return errors.New("geometry coordinates must be an array")
+	}
+
+	if len(items) >= 2 {
+		x, xOk := spatialNumber(items[0])
+		y, yOk := spatialNumber(items[1])
+		if xOk && yOk {
+			bounds[0][0] = math.Min(bounds[0][0], x)
+			bounds[0][1] = math.Max(bounds[0][1], x)
+			bounds[1][0] = math.Min(bounds[1][0], y)
+			bounds[1][1] = math.Max(bounds[1][1], y)
+			return nil
+		}
+	}
+
+	for _, item := range items {
+		if err := geometryBounds(item, bounds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// geometryRanges returns the bounding box of a GeoJSON geometry as a pair of
+// ranges, or false if the value is not a geometry.
+func geometryRanges(val interface{}) ([][]float64, bool, error) {
+	geom, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+	if _, ok := geom["type"].(string); !ok {
+		return nil, false, nil
+	}
+
+	bounds := [2][2]float64{
+		{math.Inf(1), math.Inf(-1)},
+		{math.Inf(1), math.Inf(-1)},
+	}
+	if err := geometryBounds(geom["coordinates"], &bounds); err != nil {
+		return nil, true, err
+	}
+	if math.IsInf(bounds[0][0], 0) {
+		return nil, true, errors.New("geometry must contain at least one position")
+	}
+
+	return [][]float64{bounds[0][:], bounds[1][:]}, true, nil
+}
+
+// spatialKeyRanges converts the key emitted by a spatial view into the range
+// which it covers in each dimension.  Keys are either a GeoJSON geometry, or
+// an array whose entries are single values or [min, max] ranges, and where the
+// first entry may also be a GeoJSON geometry.
+func spatialKeyRanges(key interface{}) ([][]float64, interface{}, error) {
+	if ranges, isGeom, err := geometryRanges(key); isGeom {
+		return ranges, key, err
+	}
+
+	items, ok := key.([]interface{})
+	if !ok {
+		return nil, nil, errors.New("spatial keys must be a geometry or an array of ranges")
+	}
+
+	var ranges [][]float64
+	var geometry interface{}
+	for itemIdx, item := range items {
+		if itemIdx == 0 {
+			if geomRanges, isGeom, err := geometryRanges(item); isGeom {
+				if err != nil {
+					return nil, nil, err
+				}
+				ranges = append(ranges, geomRanges...)
+				geometry = item
+				continue
+			}
+		}
+
+		if val, ok := spatialNumber(item); ok {
+			ranges = append(ranges, []float64{val, val})
+			continue
+		}
+
+		bounds, ok := item.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, nil, errors.New("spatial key ranges must be a number or a [min, max] pair")
+		}
+		min, minOk := spatialNumber(bounds[0])
+		max, maxOk := spatialNumber(bounds[1])
+		if !minOk || !maxOk || min > max {
+			return nil, nil, errors.New("spatial key ranges must be a number or a [min, max] pair")
+		}
+		ranges = append(ranges, []float64{min, max})
+	}
+
+	return ranges, geometry, nil
+}
+
+// ExecuteSpatial executes a spatial query.
+func (e *Engine) ExecuteSpatial(opts ExecuteSpatialOptions) (*ExecuteResults, error) {
+	ddoc, idx, err := e.getDesignDocIndex(opts.DesignDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	var view *Index
+	for _, v := range ddoc.SpatialIndexes {
+		if v.Name == opts.View {
+			view = v
+			break
+		}
+	}
+	if view == nil {
+		return nil, ErrNotFound
+	}
+
+	if len(opts.StartRange) != len(opts.EndRange) {
+		return nil, &InvalidParametersError{
+			Message: "{\"error\":\"query_parse_error\",\"reason\":\"start_range and end_range must have the same number of dimensions.\"}",
+		}
+	}
+
+	indexed, err := idx.query(e.store, viewKey{Spatial: true, Name: view.Name}, opts.Stale)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []indexedItem
+	var rowRanges [][][]float64
+	for _, item := range indexed {
+		var ranges [][]float64
+		if err := json.Unmarshal([]byte(item.Key), &ranges); err != nil {
+			return nil, err
+		}
+
+		if len(ranges) < len(opts.StartRange) {
+			continue
+		}
+
+		matched := true
+		for dimIdx := range opts.StartRange {
+			start := opts.StartRange[dimIdx]
+			end := opts.EndRange[dimIdx]
+			if start != nil && ranges[dimIdx][1] < *start {
+				matched = false
+				break
+			}
+			if end != nil && ranges[dimIdx][0] > *end {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		results = append(results, item)
+		rowRanges = append(rowRanges, ranges)
+	}
+
+	// Spatial results have no meaningful order, but we sort them by document
+	// id so that paging through them is stable.
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return results[order[i]].ID < results[order[j]].ID
+	})
+
+	output := []outputItem{}
+	for _, resIdx := range order {
+		item := results[resIdx]
+		output = append(output, outputItem{
+			ID:       item.ID,
+			Key:      rowRanges[resIdx],
+			Value:    item.Value,
+			Geometry: item.Geometry,
+		})
+	}
+
+	if opts.Skip > len(output) {
+		opts.Skip = len(output)
+	}
+	output = output[opts.Skip:]
+	if opts.Limit > 0 && opts.Limit < len(output) {
+		output = output[:opts.Limit]
+	}
+
+	return &ExecuteResults{Rows: output}, nil
+}
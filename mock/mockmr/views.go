@@ -18,22 +18,57 @@ type Index struct {
 	ReduceFunc string
 }
 
+// DevelopmentPrefix is the prefix which design documents in the development
+// namespace have in their names.
+const DevelopmentPrefix = "dev_"
+
 type DesignDocument struct {
-	Name    string
-	Indexes []*Index
+	Name           string
+	Indexes        []*Index
+	SpatialIndexes []*Index
+}
+
+// IsDevelopment returns whether this design document is in the development
+// namespace.
+func (ddoc *DesignDocument) IsDevelopment() bool {
+	return strings.HasPrefix(ddoc.Name, DevelopmentPrefix)
 }
 
+// StaleMode specifies whether a query may be served from a view index which
+// is not up to date with the data it indexes.
+type StaleMode string
+
+// The stale modes which can be used when executing a query.
+const (
+	// StaleOk serves the query from the index as it currently is.
+	StaleOk = StaleMode("ok")
+
+	// StaleFalse brings the index up to date before serving the query.
+	StaleFalse = StaleMode("false")
+
+	// StaleUpdateAfter serves the query from the index as it currently is and
+	// brings the index up to date afterwards.
+	StaleUpdateAfter = StaleMode("update_after")
+)
+
 // Engine represents the mock map reduce engine.
 type Engine struct {
+	store           *mockdb.Bucket
 	designDocuments map[string]*DesignDocument
+	indexes         map[string]*designDocIndex
 	lock            sync.Mutex
 }
 
+// NewEngineOptions specifies options for creating a new Engine.
+type NewEngineOptions struct {
+	Store *mockdb.Bucket
+}
+
 // ExecuteOptions provides options when executing an query.
 type ExecuteOptions struct {
-	Data      []*mockdb.Document
 	DesignDoc string
 	View      string
+	Stale     StaleMode
 
 	Skip          int
 	StartKey      string
@@ -64,15 +99,17 @@ type indexInputMeta struct {
 }
 
 type outputItem struct {
-	ID    string
-	Key   interface{}
-	Value interface{}
+	ID       string
+	Key      interface{}
+	Value    interface{}
+	Geometry interface{}
 }
 
 type indexedItem struct {
-	ID    string
-	Key   string
-	Value interface{}
+	ID       string
+	Key      string
+	Value    interface{}
+	Geometry interface{}
 }
 
 type resultContainer struct {
@@ -84,7 +121,10 @@ func (rc resultContainer) Len() int {
 }
 
 func (rc resultContainer) Less(i, j int) bool {
-	return rc.results[i].Key < rc.results[j].Key
+	if rc.results[i].Key != rc.results[j].Key {
+		return rc.results[i].Key < rc.results[j].Key
+	}
+	return rc.results[i].ID < rc.results[j].ID
 }
 
 func (rc resultContainer) Swap(i, j int) {
@@ -93,16 +133,31 @@ func (rc resultContainer) Swap(i, j int) {
 	rc.results[i] = item
 }
 
+// getDesignDocIndex returns a design document along with its index.
+func (e *Engine) getDesignDocIndex(name string) (*DesignDocument, *designDocIndex, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ddoc, ok := e.designDocuments[name]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+
+	return ddoc, e.indexes[name], nil
+}
+
 // NewEngine creates a new Engine
-func NewEngine() *Engine {
+func NewEngine(opts NewEngineOptions) *Engine {
 	return &Engine{
+		store:           opts.Store,
 		designDocuments: make(map[string]*DesignDocument),
+		indexes:         make(map[string]*designDocIndex),
 	}
 }
 
 // Execute executes a query.
 func (e *Engine) Execute(opts ExecuteOptions) (int, *ExecuteResults, error) {
-	ddoc, err := e.GetDesignDocument(opts.DesignDoc)
+	ddoc, idx, err := e.getDesignDocIndex(opts.DesignDoc)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -124,7 +179,7 @@ func (e *Engine) Execute(opts ExecuteOptions) (int, *ExecuteResults, error) {
 		}
 	}
 
-	indexed, err := e.index(view, opts.Data)
+	indexed, err := idx.query(e.store, viewKey{Name: view.Name}, opts.Stale)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -318,79 +373,21 @@ func (e *Engine) reduce(index *Index, groupLevel int, docs []indexedItem) ([]out
 	return results, nil
 }
 
-func (e *Engine) index(index *Index, docs []*mockdb.Document) ([]indexedItem, error) {
-	vm := goja.New()
-	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
-	var data []indexedItem
-
-	var currentDoc *mockdb.Document
-	emit := func(key interface{}, value interface{}) {
-		k, err := json.Marshal(key)
-		if err != nil {
-			panic(err)
-		}
-		data = append(data, indexedItem{
-			Key:   string(k),
-			ID:    string(currentDoc.Key),
-			Value: value,
-		})
-	}
-
-	err := vm.Set("emit", emit)
-	if err != nil {
-		return nil, err
-	}
-
-	fnStr := "callme = " + index.MapFunc
-	_, err = vm.RunString(fnStr)
-	if err != nil {
-		return nil, err
-	}
-
-	fn, ok := goja.AssertFunction(vm.Get("callme"))
-	if !ok {
-		return nil, errors.New("cannot parse function")
-	}
-
-	for _, doc := range docs {
-		currentDoc = doc
-
-		var docValue map[string]interface{}
-		err := json.Unmarshal(doc.Value, &docValue)
-		if err != nil || docValue == nil {
-			// TODO: this should probably do something else, non json docs are supported by views.
-			continue
-		}
-
-		meta := indexInputMeta{
-			ID:         string(doc.Key),
-			Rev:        0,
-			Type:       doc.Datatype,
-			Flags:      doc.Flags,
-			Expiration: doc.Expiry.Second(),
-		}
-
-		_, err = fn(goja.Undefined(), vm.ToValue(docValue), vm.ToValue(meta))
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return data, nil
-}
-
 type UpsertDesignDocumentOptions struct {
-	Indexes []*Index
+	Indexes        []*Index
+	SpatialIndexes []*Index
 }
 
 // UpsertDesignDocument creates or updates a design document.
 func (e *Engine) UpsertDesignDocument(name string, opts UpsertDesignDocumentOptions) error {
 	ddoc := &DesignDocument{
-		Name:    name,
-		Indexes: opts.Indexes,
+		Name:           name,
+		Indexes:        opts.Indexes,
+		SpatialIndexes: opts.SpatialIndexes,
 	}
 	e.lock.Lock()
 	e.designDocuments[ddoc.Name] = ddoc
+	e.indexes[ddoc.Name] = newDesignDocIndex(ddoc)
 	e.lock.Unlock()
 
 	return nil
@@ -404,6 +401,36 @@ func (e *Engine) DropDesignDocument(name string) error {
 		return ErrNotFound
 	}
 	delete(e.designDocuments, name)
+	delete(e.indexes, name)
+	return nil
+}
+
+// PublishDesignDocument copies a design document from the development
+// namespace into the production namespace, replacing any production design
+// document of the same name.
+func (e *Engine) PublishDesignDocument(name string) error {
+	if !strings.HasPrefix(name, DevelopmentPrefix) {
+		return &InvalidParametersError{
+			Message: "{\"error\":\"invalid_design_document\",\"reason\":\"Only development design documents can be published.\"}",
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	devDdoc, ok := e.designDocuments[name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	ddoc := &DesignDocument{
+		Name:           strings.TrimPrefix(name, DevelopmentPrefix),
+		Indexes:        devDdoc.Indexes,
+		SpatialIndexes: devDdoc.SpatialIndexes,
+	}
+	e.designDocuments[ddoc.Name] = ddoc
+	e.indexes[ddoc.Name] = newDesignDocIndex(ddoc)
+
 	return nil
 }
 
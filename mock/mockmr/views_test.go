@@ -1,15 +1,39 @@
 package mockmr
 
 import (
-	"fmt"
-	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
 )
 
-func TestExecute(t *testing.T) {
-	e := NewEngine()
+func testInsertDoc(t *testing.T, store *mockdb.Bucket, key, value string) {
+	_, err := store.Insert(&mockdb.Document{
+		VbID:  store.VbucketForKey([]byte(key)),
+		Key:   []byte(key),
+		Value: []byte(value),
+		Cas:   mockdb.GenerateNewCas(time.Now()),
+	})
+	if err != nil {
+		t.Fatalf("failed to insert document: %v", err)
+	}
+}
+
+func testNewEngine(t *testing.T) (*Engine, *mockdb.Bucket) {
+	store, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:      &mocktime.Chrono{},
+		NumReplicas: 1,
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
 
-	err := e.UpsertDesignDocument("ddoc", UpsertDesignDocumentOptions{
+	e := NewEngine(NewEngineOptions{Store: store})
+
+	err = e.UpsertDesignDocument("ddoc", UpsertDesignDocumentOptions{
 		Indexes: []*Index{
 			{
 				Name: "test",
@@ -25,28 +49,186 @@ func TestExecute(t *testing.T) {
 		t.Fatalf(err.Error())
 	}
 
-	_, res, err := e.Execute(ExecuteOptions{
-		Data: []*mockdb.Document{
-			{
-				Key:   []byte("test"),
-				Value: []byte(`{"key":"me","test1":22,"test2":"23"}`),
-			},
+	return e, store
+}
+
+func TestExecute(t *testing.T) {
+	e, store := testNewEngine(t)
+
+	testInsertDoc(t, store, "test", `{"key":"me","test1":22,"test2":"23"}`)
+	testInsertDoc(t, store, "test2", `{"key":"me","test1":22,"test2":"23"}`)
+	testInsertDoc(t, store, "test3", `{"key":22,"test1":22,"test2":"23"}`)
+
+	total, res, err := e.Execute(ExecuteOptions{
+		DesignDoc: "ddoc",
+		View:      "test",
+		Stale:     StaleFalse,
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if total != 3 || len(res.Rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d (total %d)", len(res.Rows), total)
+	}
+	if res.Rows[0].ID != "test" || res.Rows[1].ID != "test2" {
+		t.Fatalf("expected rows with equal keys to be sorted by id, got %s, %s", res.Rows[0].ID, res.Rows[1].ID)
+	}
+}
+
+func TestExecuteStale(t *testing.T) {
+	e, store := testNewEngine(t)
+
+	testInsertDoc(t, store, "test", `{"key":"me"}`)
+
+	execute := func(stale StaleMode) int {
+		total, _, err := e.Execute(ExecuteOptions{
+			DesignDoc: "ddoc",
+			View:      "test",
+			Stale:     stale,
+		})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return total
+	}
+
+	if total := execute(StaleOk); total != 0 {
+		t.Fatalf("expected stale=ok to see an unbuilt index, got %d rows", total)
+	}
+	if total := execute(StaleUpdateAfter); total != 0 {
+		t.Fatalf("expected stale=update_after to see the old index, got %d rows", total)
+	}
+	if total := execute(StaleOk); total != 1 {
+		t.Fatalf("expected stale=update_after to have updated the index, got %d rows", total)
+	}
+
+	testInsertDoc(t, store, "test2", `{"key":"you"}`)
+	_, err := store.Update(store.VbucketForKey([]byte("test")), 0, []byte("test"),
+		func(doc *mockdb.Document) (*mockdb.Document, error) {
+			doc.IsDeleted = true
+			doc.Value = nil
+			return doc, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to delete document: %v", err)
+	}
+
+	if total := execute(StaleOk); total != 1 {
+		t.Fatalf("expected stale=ok to see the old index, got %d rows", total)
+	}
+	if total := execute(StaleFalse); total != 1 {
+		t.Fatalf("expected stale=false to see the deletion and insertion, got %d rows", total)
+	}
+
+	store.Flush()
+
+	if total := execute(StaleFalse); total != 0 {
+		t.Fatalf("expected index to be rebuilt after a flush, got %d rows", total)
+	}
+}
+
+func TestExecuteSpatial(t *testing.T) {
+	e, store := testNewEngine(t)
+
+	err := e.UpsertDesignDocument("spatial", UpsertDesignDocumentOptions{
+		SpatialIndexes: []*Index{
 			{
-				Key:   []byte("test2"),
-				Value: []byte(`{"key":"me","test1":22,"test2":"23"}`),
+				Name: "points",
+				MapFunc: `
+				function (doc, meta) {
+					emit([{type: "Point", coordinates: [doc.x, doc.y]}, doc.year], doc.name);
+				}`,
 			},
+		},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	testInsertDoc(t, store, "a", `{"x":1,"y":1,"year":2000,"name":"a"}`)
+	testInsertDoc(t, store, "b", `{"x":5,"y":5,"year":2010,"name":"b"}`)
+	testInsertDoc(t, store, "c", `{"x":-3,"y":2,"year":2020,"name":"c"}`)
+
+	float := func(val float64) *float64 {
+		return &val
+	}
+
+	res, err := e.ExecuteSpatial(ExecuteSpatialOptions{
+		DesignDoc:  "spatial",
+		View:       "points",
+		Stale:      StaleFalse,
+		StartRange: []*float64{float(0), float(0)},
+		EndRange:   []*float64{float(10), float(10)},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(res.Rows) != 2 || res.Rows[0].ID != "a" || res.Rows[1].ID != "b" {
+		t.Fatalf("unexpected bounding box results: %+v", res.Rows)
+	}
+
+	res, err = e.ExecuteSpatial(ExecuteSpatialOptions{
+		DesignDoc:  "spatial",
+		View:       "points",
+		StartRange: []*float64{nil, nil, float(2005)},
+		EndRange:   []*float64{nil, nil, nil},
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(res.Rows) != 2 || res.Rows[0].ID != "b" || res.Rows[1].ID != "c" {
+		t.Fatalf("unexpected range results: %+v", res.Rows)
+	}
+
+	_, err = e.ExecuteSpatial(ExecuteSpatialOptions{
+		DesignDoc: "spatial",
+		View:      "missing",
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected missing spatial view to fail with not found, got %v", err)
+	}
+}
+
+func TestPublishDesignDocument(t *testing.T) {
+	e, _ := testNewEngine(t)
+
+	err := e.PublishDesignDocument("ddoc")
+	if !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("expected publishing a production design document to fail, got %v", err)
+	}
+
+	err = e.PublishDesignDocument("dev_missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected publishing a missing design document to fail, got %v", err)
+	}
+
+	err = e.UpsertDesignDocument("dev_test", UpsertDesignDocumentOptions{
+		Indexes: []*Index{
 			{
-				Key:   []byte("test3"),
-				Value: []byte(`{"key":22,"test1":22,"test2":"23"}`),
+				Name:    "test",
+				MapFunc: `function (doc, meta) { emit(meta.id, null); }`,
 			},
 		},
-		DesignDoc: "ddoc",
-		View:      "test",
-		// Reduce:    true,
 	})
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
 
-	fmt.Printf("%#v", res)
+	err = e.PublishDesignDocument("dev_test")
+	if err != nil {
+		t.Fatalf("failed to publish design document: %v", err)
+	}
+
+	ddoc, err := e.GetDesignDocument("test")
+	if err != nil {
+		t.Fatalf("failed to get published design document: %v", err)
+	}
+	if ddoc.IsDevelopment() || len(ddoc.Indexes) != 1 || ddoc.Indexes[0].Name != "test" {
+		t.Fatalf("unexpected published design document: %+v", ddoc)
+	}
+
+	if _, err := e.GetDesignDocument("dev_test"); err != nil {
+		t.Fatalf("expected development design document to remain after publishing: %v", err)
+	}
 }
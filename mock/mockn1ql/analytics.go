@@ -0,0 +1,699 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
+
+const (
+	defaultDataverseName  = "Default"
+	metadataDataverseName = "Metadata"
+	localLinkName         = "Local"
+)
+
+// Dataverse represents an analytics dataverse.  Every dataverse has a single
+// Local link which shadows the data of the buckets in the cluster.
+type Dataverse struct {
+	Name          string
+	LinkConnected bool
+}
+
+// Dataset represents an analytics dataset which shadows the documents of a
+// bucket or collection that match its filter.
+type Dataset struct {
+	Dataverse      string
+	Name           string
+	LinkName       string
+	BucketName     string
+	ScopeName      string
+	CollectionName string
+	Filter         string
+
+	filter expr
+
+	// shadowedUntil is the point in time up to which the data has been
+	// ingested while the link of the dataset is disconnected.
+	shadowedUntil time.Time
+}
+
+// AnalyticsIndex represents a secondary index on an analytics dataset.
+type AnalyticsIndex struct {
+	Dataverse string
+	Dataset   string
+	Name      string
+	Fields    []string
+}
+
+// AnalyticsEngine represents the mock analytics engine.  It shares its SQL++
+// evaluation with the query engine, but queries run over datasets rather
+// than directly over buckets.
+type AnalyticsEngine struct {
+	chrono *mocktime.Chrono
+
+	lock       sync.Mutex
+	dataverses []*Dataverse
+	datasets   []*Dataset
+	indexes    []*AnalyticsIndex
+}
+
+// NewAnalyticsEngineOptions provides options when creating a new analytics
+// engine.
+type NewAnalyticsEngineOptions struct {
+	Chrono *mocktime.Chrono
+}
+
+// NewAnalyticsEngine creates a new analytics engine.
+func NewAnalyticsEngine(opts NewAnalyticsEngineOptions) *AnalyticsEngine {
+	if opts.Chrono == nil {
+		opts.Chrono = &mocktime.Chrono{}
+	}
+
+	return &AnalyticsEngine{
+		chrono: opts.Chrono,
+		dataverses: []*Dataverse{
+			{Name: defaultDataverseName},
+		},
+	}
+}
+
+// AnalyticsExecuteOptions provides options when executing an analytics query.
+// Keyspaces is also invoked with an empty bucket and KeyspaceAccessManage to
+// check whether the user is permitted to manage dataverses.
+type AnalyticsExecuteOptions struct {
+	Statement      string
+	NamedArgs      map[string]interface{}
+	PositionalArgs []interface{}
+	Keyspaces      KeyspaceResolver
+}
+
+// GetAllDatasets returns all of the datasets which have been created.
+func (e *AnalyticsEngine) GetAllDatasets() []*Dataset {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	datasets := make([]*Dataset, len(e.datasets))
+	copy(datasets, e.datasets)
+	return datasets
+}
+
+// translateAnalyticsError converts an error from the shared SQL++ evaluation
+// into the equivalent analytics error.
+func translateAnalyticsError(err error) error {
+	queryErr, ok := err.(*Error)
+	if !ok {
+		return newError(ErrCodeAnalyticsInternal, "Internal error: %s", err)
+	}
+	if queryErr.Code >= 20000 && queryErr.Code < 30000 {
+		return queryErr
+	}
+
+	switch queryErr.Code {
+	case ErrCodeParse, ErrCodeSemantic, ErrCodeInvalidParameters:
+		return newError(ErrCodeAnalyticsCompilation, "%s", queryErr.Msg)
+	}
+	return newError(ErrCodeAnalyticsInternal, "Internal error: %s", queryErr.Msg)
+}
+
+// Execute executes an analytics query.
+func (e *AnalyticsEngine) Execute(opts AnalyticsExecuteOptions) (*ExecuteResults, error) {
+	x := &execution{
+		analytics: e,
+		opts: ExecuteOptions{
+			Statement:      opts.Statement,
+			NamedArgs:      opts.NamedArgs,
+			PositionalArgs: opts.PositionalArgs,
+			Keyspaces:      opts.Keyspaces,
+		},
+		queryContext: &queryContext{
+			namespace: "default",
+		},
+		results: &ExecuteResults{},
+	}
+	x.params = newQueryParams(x.opts)
+
+	stmt, err := parseAnalyticsStatement(opts.Statement)
+	if err == nil {
+		err = x.executeAnalyticsStatement(stmt)
+	}
+	if err != nil {
+		return nil, translateAnalyticsError(err)
+	}
+
+	if x.results.Rows == nil {
+		x.results.Rows = []json.RawMessage{}
+	}
+
+	return x.results, nil
+}
+
+func (x *execution) executeAnalyticsStatement(stmt statement) error {
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		if err := x.executeSelect(stmt); err != nil {
+			return err
+		}
+		// Analytics does not describe the fields of its results.
+		x.results.Signature = map[string]interface{}{"*": "*"}
+		return nil
+	case *createDataverseStmt:
+		return x.analytics.createDataverse(x, stmt)
+	case *dropDataverseStmt:
+		return x.analytics.dropDataverse(x, stmt)
+	case *createDatasetStmt:
+		return x.analytics.createDataset(x, stmt)
+	case *dropDatasetStmt:
+		return x.analytics.dropDataset(x, stmt)
+	case *createAnalyticsIndexStmt:
+		return x.analytics.createIndex(x, stmt)
+	case *dropAnalyticsIndexStmt:
+		return x.analytics.dropIndex(x, stmt)
+	case *linkStmt:
+		return x.analytics.changeLink(x, stmt)
+	}
+
+	return newError(ErrCodeAnalyticsInternal, "unsupported statement")
+}
+
+// checkManage checks that the user is permitted to manage analytics, either
+// for a specific bucket or for the cluster as a whole.
+func (x *execution) checkManage(bucket string) error {
+	if x.opts.Keyspaces == nil {
+		return nil
+	}
+
+	_, err := x.opts.Keyspaces(KeyspacePath{Namespace: "default", Bucket: bucket}, KeyspaceAccessManage)
+	if errors.Is(err, ErrAccessDenied) {
+		return newError(ErrCodeAnalyticsAuth, "User must have permission (cluster.analytics!manage)")
+	} else if errors.Is(err, ErrKeyspaceNotFound) {
+		return newError(ErrCodeAnalyticsBucketNotFound, "Bucket (%s) does not exist", bucket)
+	}
+	return err
+}
+
+// splitDatasetName splits a dataset or link name into its dataverse and name.
+func splitDatasetName(parts []string) (string, string) {
+	if len(parts) == 1 {
+		return defaultDataverseName, parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+func (e *AnalyticsEngine) findDataverseLocked(name string) *Dataverse {
+	for _, dataverse := range e.dataverses {
+		if dataverse.Name == name {
+			return dataverse
+		}
+	}
+	return nil
+}
+
+func (e *AnalyticsEngine) findDatasetLocked(dataverse, name string) (int, *Dataset) {
+	for i, dataset := range e.datasets {
+		if dataset.Dataverse == dataverse && dataset.Name == name {
+			return i, dataset
+		}
+	}
+	return -1, nil
+}
+
+func (e *AnalyticsEngine) createDataverse(x *execution, stmt *createDataverseStmt) error {
+	if err := x.checkManage(""); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if stmt.name == metadataDataverseName || e.findDataverseLocked(stmt.name) != nil {
+		if stmt.ifNotExists {
+			return nil
+		}
+		return newError(ErrCodeAnalyticsDataverseExists, "A dataverse with this name %s already exists.", stmt.name)
+	}
+
+	e.dataverses = append(e.dataverses, &Dataverse{
+		Name: stmt.name,
+	})
+	return nil
+}
+
+func (e *AnalyticsEngine) dropDataverse(x *execution, stmt *dropDataverseStmt) error {
+	if err := x.checkManage(""); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if stmt.name == metadataDataverseName {
+		return newError(ErrCodeAnalyticsCompilation, "Compilation error: Cannot drop dataverse: %s", stmt.name)
+	}
+
+	dataverse := e.findDataverseLocked(stmt.name)
+	if dataverse == nil {
+		if stmt.ifExists {
+			return nil
+		}
+		return newError(ErrCodeAnalyticsDataverseNotFound, "Cannot find dataverse with name %s", stmt.name)
+	}
+
+	var dataverses []*Dataverse
+	for _, existing := range e.dataverses {
+		if existing != dataverse {
+			dataverses = append(dataverses, existing)
+		}
+	}
+	e.dataverses = dataverses
+
+	var datasets []*Dataset
+	for _, dataset := range e.datasets {
+		if dataset.Dataverse != stmt.name {
+			datasets = append(datasets, dataset)
+		}
+	}
+	e.datasets = datasets
+
+	var indexes []*AnalyticsIndex
+	for _, index := range e.indexes {
+		if index.Dataverse != stmt.name {
+			indexes = append(indexes, index)
+		}
+	}
+	e.indexes = indexes
+
+	return nil
+}
+
+func (e *AnalyticsEngine) createDataset(x *execution, stmt *createDatasetStmt) error {
+	dataverseName, name := splitDatasetName(stmt.dataset)
+
+	dataset := &Dataset{
+		Dataverse:  dataverseName,
+		Name:       name,
+		LinkName:   localLinkName,
+		BucketName: stmt.source.parts[0],
+		filter:     stmt.where,
+	}
+	if len(stmt.source.parts) == 3 {
+		dataset.ScopeName = stmt.source.parts[1]
+		dataset.CollectionName = stmt.source.parts[2]
+	}
+	if stmt.where != nil {
+		dataset.Filter = formatExpr(stmt.where)
+	}
+
+	if err := x.checkManage(dataset.BucketName); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.findDataverseLocked(dataverseName) == nil {
+		return newError(ErrCodeAnalyticsDataverseNotFound, "Cannot find dataverse with name %s", dataverseName)
+	}
+
+	if _, existing := e.findDatasetLocked(dataverseName, name); existing != nil {
+		if stmt.ifNotExists {
+			return nil
+		}
+		return newError(ErrCodeAnalyticsDatasetExists,
+			"A dataset with name %s already exists in dataverse %s", name, dataverseName)
+	}
+
+	e.datasets = append(e.datasets, dataset)
+	return nil
+}
+
+func (e *AnalyticsEngine) dropDataset(x *execution, stmt *dropDatasetStmt) error {
+	dataverseName, name := splitDatasetName(stmt.dataset)
+
+	e.lock.Lock()
+	datasetIdx, dataset := e.findDatasetLocked(dataverseName, name)
+	e.lock.Unlock()
+
+	if dataset == nil {
+		if stmt.ifExists {
+			return nil
+		}
+		return newError(ErrCodeAnalyticsDatasetNotFound,
+			"Cannot find dataset with name %s in dataverse %s", name, dataverseName)
+	}
+
+	if err := x.checkManage(dataset.BucketName); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	datasetIdx, dataset = e.findDatasetLocked(dataverseName, name)
+	if dataset == nil {
+		return nil
+	}
+	e.datasets = append(e.datasets[:datasetIdx:datasetIdx], e.datasets[datasetIdx+1:]...)
+
+	var indexes []*AnalyticsIndex
+	for _, index := range e.indexes {
+		if index.Dataverse != dataverseName || index.Dataset != name {
+			indexes = append(indexes, index)
+		}
+	}
+	e.indexes = indexes
+
+	return nil
+}
+
+func (e *AnalyticsEngine) createIndex(x *execution, stmt *createAnalyticsIndexStmt) error {
+	dataverseName, datasetName := splitDatasetName(stmt.dataset)
+
+	e.lock.Lock()
+	_, dataset := e.findDatasetLocked(dataverseName, datasetName)
+	e.lock.Unlock()
+
+	if dataset == nil {
+		return newError(ErrCodeAnalyticsDatasetNotFound,
+			"Cannot find dataset with name %s in dataverse %s", datasetName, dataverseName)
+	}
+
+	if err := x.checkManage(dataset.BucketName); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, index := range e.indexes {
+		if index.Dataverse == dataverseName && index.Dataset == datasetName && index.Name == stmt.name {
+			if stmt.ifNotExists {
+				return nil
+			}
+			return newError(ErrCodeAnalyticsIndexExists, "An index with this name %s already exists", stmt.name)
+		}
+	}
+
+	e.indexes = append(e.indexes, &AnalyticsIndex{
+		Dataverse: dataverseName,
+		Dataset:   datasetName,
+		Name:      stmt.name,
+		Fields:    stmt.fields,
+	})
+	return nil
+}
+
+func (e *AnalyticsEngine) dropIndex(x *execution, stmt *dropAnalyticsIndexStmt) error {
+	dataverseName, datasetName := splitDatasetName(stmt.dataset)
+
+	e.lock.Lock()
+	_, dataset := e.findDatasetLocked(dataverseName, datasetName)
+	e.lock.Unlock()
+
+	if dataset == nil {
+		return newError(ErrCodeAnalyticsDatasetNotFound,
+			"Cannot find dataset with name %s in dataverse %s", datasetName, dataverseName)
+	}
+
+	if err := x.checkManage(dataset.BucketName); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for i, index := range e.indexes {
+		if index.Dataverse == dataverseName && index.Dataset == datasetName && index.Name == stmt.name {
+			e.indexes = append(e.indexes[:i:i], e.indexes[i+1:]...)
+			return nil
+		}
+	}
+
+	if stmt.ifExists {
+		return nil
+	}
+	return newError(ErrCodeAnalyticsIndexNotFound, "Cannot find index with name %s", stmt.name)
+}
+
+// changeLink connects or disconnects the Local link of a dataverse.  While the
+// link is disconnected its datasets stop ingesting new data.
+func (e *AnalyticsEngine) changeLink(x *execution, stmt *linkStmt) error {
+	if err := x.checkManage(""); err != nil {
+		return err
+	}
+
+	dataverseName, linkName := splitDatasetName(stmt.link)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	dataverse := e.findDataverseLocked(dataverseName)
+	if dataverse == nil {
+		return newError(ErrCodeAnalyticsDataverseNotFound, "Cannot find dataverse with name %s", dataverseName)
+	}
+	if linkName != localLinkName {
+		return newError(ErrCodeAnalyticsLinkNotFound, "Link %s.%s does not exist", dataverseName, linkName)
+	}
+
+	if !stmt.connect && dataverse.LinkConnected {
+		now := e.chrono.Now()
+		for _, dataset := range e.datasets {
+			if dataset.Dataverse == dataverseName {
+				dataset.shadowedUntil = now
+			}
+		}
+	}
+	dataverse.LinkConnected = stmt.connect
+
+	return nil
+}
+
+// readDataset returns the rows of a dataset, or of one of the metadata
+// datasets which describe the analytics catalog.
+func (e *AnalyticsEngine) readDataset(x *execution, ref *keyspaceRef) ([]*queryRow, error) {
+	if len(ref.parts) > 2 || ref.namespace != "" {
+		return nil, newError(ErrCodeAnalyticsCompilation, "Invalid dataset name %s", strings.Join(ref.parts, "."))
+	}
+
+	dataverseName, name := splitDatasetName(ref.parts)
+	if dataverseName == metadataDataverseName {
+		return e.readMetadata(name)
+	}
+
+	e.lock.Lock()
+	_, dataset := e.findDatasetLocked(dataverseName, name)
+	var snapshotTime time.Time
+	if dataset != nil {
+		snapshotTime = dataset.shadowedUntil
+		if dataverse := e.findDataverseLocked(dataverseName); dataverse != nil && dataverse.LinkConnected {
+			snapshotTime = e.chrono.Now()
+		}
+	}
+	e.lock.Unlock()
+
+	if dataset == nil {
+		return nil, newError(ErrCodeAnalyticsDatasetNotFound,
+			"Cannot find dataset with name %s in dataverse %s", name, dataverseName)
+	}
+
+	if x.opts.Keyspaces == nil {
+		return nil, nil
+	}
+	ks, err := x.opts.Keyspaces(KeyspacePath{
+		Namespace:  "default",
+		Bucket:     dataset.BucketName,
+		Scope:      dataset.ScopeName,
+		Collection: dataset.CollectionName,
+	}, KeyspaceAccessRead)
+	if errors.Is(err, ErrKeyspaceNotFound) {
+		// The source of the dataset no longer exists, so there is nothing to
+		// shadow.
+		return nil, nil
+	} else if errors.Is(err, ErrAccessDenied) {
+		return nil, newError(ErrCodeAnalyticsAuth, "User must have permission (cluster.bucket[%s].analytics!select)",
+			dataset.BucketName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	docs, err := ks.Store.GetAll(0, ks.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the most recent version of each document which has been ingested
+	// is visible.
+	latest := make(map[string]*mockdb.Document)
+	var keys []string
+	for _, doc := range docs {
+		if doc.ModifiedTime.After(snapshotTime) {
+			continue
+		}
+
+		key := string(doc.Key)
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = doc
+	}
+
+	var rows []*queryRow
+	for _, key := range keys {
+		doc := latest[key]
+		if doc.IsDeleted {
+			continue
+		}
+
+		row := newQueryRow(doc)
+		if isMissing(row.value) {
+			// Only JSON documents are ingested.
+			continue
+		}
+
+		if dataset.filter != nil {
+			val, err := eval(x.baseContext().withRow(row), dataset.filter)
+			if err != nil {
+				return nil, err
+			}
+			if toTriState(val) != true {
+				continue
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func (e *AnalyticsEngine) readMetadata(name string) ([]*queryRow, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var entries []map[string]interface{}
+	switch name {
+	case "Dataverse":
+		for _, dataverse := range e.dataverses {
+			entries = append(entries, map[string]interface{}{
+				"DataverseName": dataverse.Name,
+				"DataFormat":    "org.apache.asterix.runtime.formats.NonTaggedDataFormat",
+				"PendingOp":     0,
+			})
+		}
+	case "Dataset":
+		for _, dataset := range e.datasets {
+			entry := map[string]interface{}{
+				"DataverseName":         dataset.Dataverse,
+				"DatasetName":           dataset.Name,
+				"DatatypeDataverseName": metadataDataverseName,
+				"DatatypeName":          "AnyObject",
+				"DatasetType":           "INTERNAL",
+				"LinkName":              dataset.LinkName,
+				"BucketName":            dataset.BucketName,
+				"PendingOp":             0,
+			}
+			if dataset.ScopeName != "" {
+				entry["ScopeName"] = dataset.ScopeName
+				entry["CollectionName"] = dataset.CollectionName
+			}
+			if dataset.Filter != "" {
+				entry["Filter"] = dataset.Filter
+			}
+			entries = append(entries, entry)
+		}
+	case "Index":
+		for _, dataset := range e.datasets {
+			// Every dataset has an implicit primary index.
+			entries = append(entries, map[string]interface{}{
+				"DataverseName":  dataset.Dataverse,
+				"DatasetName":    dataset.Name,
+				"IndexName":      dataset.Name,
+				"IndexStructure": "BTREE",
+				"IsPrimary":      true,
+				"PendingOp":      0,
+			})
+		}
+		for _, index := range e.indexes {
+			searchKey := make([]interface{}, len(index.Fields))
+			for i, field := range index.Fields {
+				searchKey[i] = []interface{}{strings.SplitN(field, ":", 2)[0]}
+			}
+			entries = append(entries, map[string]interface{}{
+				"DataverseName":  index.Dataverse,
+				"DatasetName":    index.Dataset,
+				"IndexName":      index.Name,
+				"IndexStructure": "BTREE",
+				"SearchKey":      searchKey,
+				"IsPrimary":      false,
+				"PendingOp":      0,
+			})
+		}
+	case "Link":
+		for _, dataverse := range e.dataverses {
+			entries = append(entries, map[string]interface{}{
+				"DataverseName": dataverse.Name,
+				"Name":          localLinkName,
+				"IsActive":      dataverse.LinkConnected,
+			})
+		}
+	default:
+		return nil, newError(ErrCodeAnalyticsDatasetNotFound,
+			"Cannot find dataset with name %s in dataverse %s", name, metadataDataverseName)
+	}
+
+	rows := make([]*queryRow, len(entries))
+	for i, entry := range entries {
+		rows[i] = &queryRow{
+			value: normalizeValue(entry),
+		}
+	}
+
+	return rows, nil
+}
+
+// PendingMutations returns the number of mutations which each dataset has yet
+// to ingest, keyed by dataverse and then dataset.  Datasets only fall behind
+// while their link is disconnected.
+func (e *AnalyticsEngine) PendingMutations(keyspaces KeyspaceResolver) map[string]map[string]int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	pending := make(map[string]map[string]int)
+	for _, dataset := range e.datasets {
+		if pending[dataset.Dataverse] == nil {
+			pending[dataset.Dataverse] = make(map[string]int)
+		}
+		pending[dataset.Dataverse][dataset.Name] = 0
+
+		dataverse := e.findDataverseLocked(dataset.Dataverse)
+		if dataverse == nil || dataverse.LinkConnected || keyspaces == nil {
+			continue
+		}
+
+		ks, err := keyspaces(KeyspacePath{
+			Namespace:  "default",
+			Bucket:     dataset.BucketName,
+			Scope:      dataset.ScopeName,
+			Collection: dataset.CollectionName,
+		}, KeyspaceAccessRead)
+		if err != nil {
+			continue
+		}
+
+		docs, err := ks.Store.GetAll(0, ks.CollectionID)
+		if err != nil {
+			continue
+		}
+		for _, doc := range docs {
+			if doc.ModifiedTime.After(dataset.shadowedUntil) {
+				pending[dataset.Dataverse][dataset.Name]++
+			}
+		}
+	}
+
+	return pending
+}
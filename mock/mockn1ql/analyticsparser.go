@@ -0,0 +1,330 @@
+package mockn1ql
+
+import "strings"
+
+type createDataverseStmt struct {
+	name        string
+	ifNotExists bool
+}
+
+type dropDataverseStmt struct {
+	name     string
+	ifExists bool
+}
+
+type createDatasetStmt struct {
+	ifNotExists bool
+	dataset     []string
+	source      keyspaceRef
+	where       expr
+}
+
+type dropDatasetStmt struct {
+	dataset  []string
+	ifExists bool
+}
+
+type createAnalyticsIndexStmt struct {
+	name        string
+	ifNotExists bool
+	dataset     []string
+	fields      []string
+}
+
+type dropAnalyticsIndexStmt struct {
+	dataset  []string
+	name     string
+	ifExists bool
+}
+
+type linkStmt struct {
+	connect bool
+	link    []string
+}
+
+// parseAnalyticsStatement parses a single SQL++ statement for the analytics
+// service.  Queries share their grammar with N1QL, but the DDL statements
+// manage dataverses, datasets and links rather than GSI indexes.
+func parseAnalyticsStatement(text string) (statement, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{text: text, tokens: tokens}
+	stmt, err := p.parseAnalyticsStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptOp(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.peek().text)
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseAnalyticsStatement() (statement, error) {
+	switch {
+	case p.isKeyword("SELECT"):
+		return p.parseSelect()
+	case p.isKeyword("CREATE"):
+		switch {
+		case isKeyword(p.peekAt(1), "DATAVERSE"):
+			return p.parseCreateDataverse()
+		case isKeyword(p.peekAt(1), "DATASET"):
+			return p.parseCreateDataset()
+		case isKeyword(p.peekAt(1), "INDEX"):
+			return p.parseCreateAnalyticsIndex()
+		}
+	case p.isKeyword("DROP"):
+		switch {
+		case isKeyword(p.peekAt(1), "DATAVERSE"):
+			return p.parseDropDataverse()
+		case isKeyword(p.peekAt(1), "DATASET"):
+			return p.parseDropDataset()
+		case isKeyword(p.peekAt(1), "INDEX"):
+			return p.parseDropAnalyticsIndex()
+		}
+	case p.isKeyword("CONNECT"), p.isKeyword("DISCONNECT"):
+		return p.parseLinkStatement()
+	}
+
+	return nil, p.errorf("unsupported statement")
+}
+
+// parseQualifiedName parses a name made up of one or more dot separated parts.
+func (p *parser) parseQualifiedName() ([]string, error) {
+	var parts []string
+	for {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, name)
+
+		if !p.acceptOp(".") {
+			return parts, nil
+		}
+	}
+}
+
+func (p *parser) parseCreateDataverse() (*createDataverseStmt, error) {
+	p.next()
+	p.next()
+
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &createDataverseStmt{
+		name: name,
+	}
+	stmt.ifNotExists, err = p.parseIfExists(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseDropDataverse() (*dropDataverseStmt, error) {
+	p.next()
+	p.next()
+
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &dropDataverseStmt{
+		name: name,
+	}
+	stmt.ifExists, err = p.parseIfExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseCreateDataset() (*createDatasetStmt, error) {
+	p.next()
+	p.next()
+
+	stmt := &createDatasetStmt{}
+
+	ifNotExists, err := p.parseIfExists(true)
+	if err != nil {
+		return nil, err
+	}
+	stmt.ifNotExists = ifNotExists
+
+	stmt.dataset, err = p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(stmt.dataset) > 2 {
+		return nil, p.errorf("invalid dataset name %s", strings.Join(stmt.dataset, "."))
+	}
+
+	if !stmt.ifNotExists {
+		stmt.ifNotExists, err = p.parseIfExists(true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	source, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(source) != 1 && len(source) != 3 {
+		return nil, p.errorf("invalid dataset source %s", strings.Join(source, "."))
+	}
+	stmt.source = keyspaceRef{
+		parts: source,
+	}
+
+	if p.acceptKeyword("WHERE") {
+		stmt.where, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseDropDataset() (*dropDatasetStmt, error) {
+	p.next()
+	p.next()
+
+	dataset, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(dataset) > 2 {
+		return nil, p.errorf("invalid dataset name %s", strings.Join(dataset, "."))
+	}
+
+	stmt := &dropDatasetStmt{
+		dataset: dataset,
+	}
+	stmt.ifExists, err = p.parseIfExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseCreateAnalyticsIndex() (*createAnalyticsIndexStmt, error) {
+	p.next()
+	p.next()
+
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &createAnalyticsIndexStmt{
+		name: name,
+	}
+	stmt.ifNotExists, err = p.parseIfExists(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	stmt.dataset, err = p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(stmt.dataset) > 2 {
+		return nil, p.errorf("invalid dataset name %s", strings.Join(stmt.dataset, "."))
+	}
+
+	// Each indexed field is a path followed by the type of the field.
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		path, err := p.parseQualifiedName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(":"); err != nil {
+			return nil, err
+		}
+		fieldType, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.fields = append(stmt.fields, strings.Join(path, ".")+":"+strings.ToLower(fieldType))
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseDropAnalyticsIndex() (*dropAnalyticsIndexStmt, error) {
+	p.next()
+	p.next()
+
+	parts, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, p.errorf("invalid index name %s", strings.Join(parts, "."))
+	}
+
+	stmt := &dropAnalyticsIndexStmt{
+		dataset: parts[:len(parts)-1],
+		name:    parts[len(parts)-1],
+	}
+	stmt.ifExists, err = p.parseIfExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseLinkStatement() (*linkStmt, error) {
+	stmt := &linkStmt{
+		connect: p.isKeyword("CONNECT"),
+	}
+	p.next()
+
+	if err := p.expectKeyword("LINK"); err != nil {
+		return nil, err
+	}
+
+	link, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if len(link) > 2 {
+		return nil, p.errorf("invalid link name %s", strings.Join(link, "."))
+	}
+	stmt.link = link
+
+	return stmt, nil
+}
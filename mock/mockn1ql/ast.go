@@ -0,0 +1,213 @@
+package mockn1ql
+
+// expr represents any expression which can be evaluated.
+type expr interface{}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type identExpr struct {
+	name string
+}
+
+type fieldExpr struct {
+	target expr
+	name   string
+}
+
+type indexExpr struct {
+	target expr
+	index  expr
+}
+
+// paramExpr refers to a named parameter when name is set, otherwise it refers
+// to the positional parameter at position (which is 1-based).
+type paramExpr struct {
+	name     string
+	position int
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+type binaryExpr struct {
+	op    string
+	left  expr
+	right expr
+}
+
+type betweenExpr struct {
+	operand expr
+	low     expr
+	high    expr
+	not     bool
+}
+
+// isExpr represents IS [NOT] NULL, IS [NOT] MISSING and IS [NOT] VALUED.
+type isExpr struct {
+	operand expr
+	what    string
+	not     bool
+}
+
+type funcExpr struct {
+	name     string
+	args     []expr
+	star     bool
+	distinct bool
+}
+
+type arrayExpr struct {
+	elems []expr
+}
+
+type objectExpr struct {
+	keys   []string
+	values []expr
+}
+
+type caseWhen struct {
+	cond   expr
+	result expr
+}
+
+type caseExpr struct {
+	operand  expr
+	whens    []caseWhen
+	elseExpr expr
+}
+
+// KeyspacePath identifies a keyspace referenced by a statement.  Scope and
+// Collection are blank when the default collection of a bucket is referenced.
+type KeyspacePath struct {
+	Namespace  string
+	Bucket     string
+	Scope      string
+	Collection string
+}
+
+// keyspaceRef is a keyspace as written in a statement, which is resolved to a
+// KeyspacePath once the query context is known.
+type keyspaceRef struct {
+	namespace string
+	parts     []string
+	alias     string
+}
+
+// resultTerm represents a single projection.  A term with star set and no
+// expression is a bare *, with an expression it is expr.*.
+type resultTerm struct {
+	expr  expr
+	alias string
+	star  bool
+}
+
+type projection struct {
+	raw      bool
+	distinct bool
+	terms    []resultTerm
+}
+
+type orderTerm struct {
+	expr expr
+	desc bool
+}
+
+type statement interface{}
+
+type selectStmt struct {
+	projection projection
+	from       *keyspaceRef
+	useKeys    expr
+	where      expr
+	groupBy    []expr
+	having     expr
+	orderBy    []orderTerm
+	limit      expr
+	offset     expr
+}
+
+type insertValue struct {
+	key   expr
+	value expr
+}
+
+type insertStmt struct {
+	upsert    bool
+	keyspace  keyspaceRef
+	values    []insertValue
+	returning *projection
+}
+
+type setTerm struct {
+	path  expr
+	value expr
+}
+
+type updateStmt struct {
+	keyspace  keyspaceRef
+	useKeys   expr
+	sets      []setTerm
+	unsets    []expr
+	where     expr
+	limit     expr
+	returning *projection
+}
+
+type deleteStmt struct {
+	keyspace  keyspaceRef
+	useKeys   expr
+	where     expr
+	limit     expr
+	returning *projection
+}
+
+type indexKey struct {
+	expr expr
+	desc bool
+}
+
+type createIndexStmt struct {
+	primary     bool
+	ifNotExists bool
+	name        string
+	keyspace    keyspaceRef
+	keys        []indexKey
+	where       expr
+	with        expr
+}
+
+type dropIndexStmt struct {
+	primary  bool
+	ifExists bool
+	name     string
+	keyspace keyspaceRef
+}
+
+type buildIndexStmt struct {
+	keyspace keyspaceRef
+	names    []expr
+}
+
+// prepareStmt holds both the parsed form of the statement being prepared and
+// its original text, which is what is stored in the prepared statement.
+type prepareStmt struct {
+	force bool
+	name  string
+	text  string
+	inner statement
+}
+
+type beginStmt struct{}
+
+type commitStmt struct{}
+
+type rollbackStmt struct{}
+
+type executeStmt struct {
+	name  string
+	using expr
+}
@@ -0,0 +1,109 @@
+package mockn1ql
+
+import (
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// The following lists the scan consistencies which a query can request.
+const (
+	ScanConsistencyNotBounded  = "not_bounded"
+	ScanConsistencyRequestPlus = "request_plus"
+	ScanConsistencyAtPlus      = "at_plus"
+)
+
+// ScanVectorEntry specifies the sequence number which an index must have
+// processed within a single vbucket before an at_plus scan can proceed.
+type ScanVectorEntry struct {
+	SeqNo  uint64
+	VbUUID string
+}
+
+// ScanVector holds the scan vector entries of a bucket, keyed by vbucket id.
+type ScanVector map[uint]ScanVectorEntry
+
+func validateScanConsistency(opts ExecuteOptions) error {
+	switch opts.ScanConsistency {
+	case "", ScanConsistencyNotBounded, ScanConsistencyRequestPlus:
+		return nil
+	case ScanConsistencyAtPlus:
+		if len(opts.ScanVectors) == 0 {
+			return newError(ErrCodeInvalidParameters, "scan_vectors parameter is required for scan_consistency at_plus")
+		}
+		return nil
+	}
+
+	return newError(ErrCodeInvalidParameters, "Unknown scan_consistency value: %s", opts.ScanConsistency)
+}
+
+// seqNoTime returns the time at which a sequence number was written to a
+// vbucket, or the zero time if the vbucket has never reached it.
+func seqNoTime(vbucket *mockdb.Vbucket, seqNo uint64) time.Time {
+	if highSeqNo := vbucket.HighSeqNo(); seqNo > highSeqNo {
+		seqNo = highSeqNo
+	}
+	if seqNo == 0 {
+		return time.Time{}
+	}
+
+	docs, _, err := vbucket.GetAllWithin(0, seqNo-1, seqNo)
+	if err != nil || len(docs) == 0 {
+		return time.Time{}
+	}
+
+	return docs[0].ModifiedTime
+}
+
+// requiredIndexTime returns the point in time which the indexes of a bucket
+// must have caught up to in order to satisfy the scan consistency of the
+// query.  Note that we do not validate the vbuuids of scan vectors.
+func (x *execution) requiredIndexTime(ks *Keyspace, bucket string) time.Time {
+	var required time.Time
+
+	switch x.opts.ScanConsistency {
+	case ScanConsistencyRequestPlus:
+		for vbIdx := uint(0); vbIdx < ks.Store.NumVbuckets(); vbIdx++ {
+			vbucket := ks.Store.GetVbucket(vbIdx)
+			if writeTime := seqNoTime(vbucket, vbucket.HighSeqNo()); writeTime.After(required) {
+				required = writeTime
+			}
+		}
+	case ScanConsistencyAtPlus:
+		for vbIdx, entry := range x.opts.ScanVectors[bucket] {
+			vbucket := ks.Store.GetVbucket(vbIdx)
+			if vbucket == nil {
+				continue
+			}
+			if writeTime := seqNoTime(vbucket, entry.SeqNo); writeTime.After(required) {
+				required = writeTime
+			}
+		}
+	}
+
+	return required
+}
+
+// indexSnapshotTime returns the point in time which an index scan of a
+// keyspace reflects.  Indexes trail the data by the index latency of the
+// engine, so this waits for them to catch up where the scan consistency
+// of the query requires it.
+func (x *execution) indexSnapshotTime(ks *Keyspace, path KeyspacePath) (time.Time, error) {
+	chrono := x.engine.chrono
+	latency := x.engine.indexLatency
+
+	required := x.requiredIndexTime(ks, path.Bucket)
+	if !required.IsZero() {
+		caughtUpTime := required.Add(latency)
+		if waitTime := caughtUpTime.Sub(chrono.Now()); waitTime > 0 {
+			if x.opts.ScanWait > 0 && waitTime > x.opts.ScanWait {
+				<-chrono.After(x.opts.ScanWait)
+				return time.Time{}, newError(ErrCodeIndexScanTimeout, "Index scan timed out")
+			}
+
+			<-chrono.After(waitTime)
+		}
+	}
+
+	return chrono.Now().Add(-latency), nil
+}
@@ -0,0 +1,73 @@
+package mockn1ql
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The following error codes are those reported by the query service.
+const (
+	ErrCodeInternal                 = 5000
+	ErrCodeDML                      = 5070
+	ErrCodeParse                    = 3000
+	ErrCodeSemantic                 = 3100
+	ErrCodePlan                     = 4000
+	ErrCodeNoSuchPrepared           = 4040
+	ErrCodeUnrecognizedPrepared     = 4050
+	ErrCodePreparedName             = 4060
+	ErrCodePreparedDecoding         = 4070
+	ErrCodePreparedEncodingMismatch = 4080
+	ErrCodeIndexExists              = 4300
+	ErrCodeKeyspaceNotFound         = 12003
+	ErrCodeIndexNotFound            = 12004
+	ErrCodeDuplicateKey             = 12009
+	ErrCodeIndexScanTimeout         = 12015
+	ErrCodeAccessDenied             = 13014
+	ErrCodeTransactionContext       = 17004
+	ErrCodeTransactionCommit        = 17007
+	ErrCodeTransactionExpired       = 17010
+	ErrCodeInvalidParameters        = 1050
+)
+
+// The following error codes are those reported by the analytics service.
+const (
+	ErrCodeAnalyticsAuth              = 20001
+	ErrCodeAnalyticsCompilation       = 24000
+	ErrCodeAnalyticsLinkNotFound      = 24006
+	ErrCodeAnalyticsDataverseNotFound = 24034
+	ErrCodeAnalyticsBucketNotFound    = 24035
+	ErrCodeAnalyticsDataverseExists   = 24039
+	ErrCodeAnalyticsDatasetExists     = 24040
+	ErrCodeAnalyticsDatasetNotFound   = 24045
+	ErrCodeAnalyticsIndexNotFound     = 24047
+	ErrCodeAnalyticsIndexExists       = 24048
+	ErrCodeAnalyticsInternal          = 25000
+)
+
+// Error represents an error reported by the query engine.
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Msg)
+}
+
+func newError(code int, format string, args ...interface{}) *Error {
+	return &Error{
+		Code: code,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+func newParseError(format string, args ...interface{}) *Error {
+	return newError(ErrCodeParse, "syntax error - "+format, args...)
+}
+
+// These errors are returned by a KeyspaceResolver to indicate why it was
+// unable to resolve a keyspace.
+var (
+	ErrKeyspaceNotFound = errors.New("keyspace not found")
+	ErrAccessDenied     = errors.New("access denied")
+)
@@ -0,0 +1,452 @@
+package mockn1ql
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// queryRow represents a single document flowing through a query.  doc is nil
+// for rows which are not backed by a document, such as SELECT without FROM.
+type queryRow struct {
+	key   string
+	doc   *mockdb.Document
+	value interface{}
+}
+
+// evalContext holds everything needed to evaluate an expression against a
+// single row, or a group of rows when aggregates are involved.
+type evalContext struct {
+	params    *queryParams
+	alias     string
+	row       *queryRow
+	group     []*queryRow
+	projected map[string]interface{}
+}
+
+type queryParams struct {
+	named      map[string]interface{}
+	positional []interface{}
+}
+
+func (c *evalContext) withRow(row *queryRow) *evalContext {
+	return &evalContext{
+		params: c.params,
+		alias:  c.alias,
+		row:    row,
+	}
+}
+
+func (c *evalContext) lookupIdent(name string) interface{} {
+	if c.projected != nil {
+		if val, ok := c.projected[name]; ok {
+			return val
+		}
+	}
+
+	if c.row == nil {
+		return missing
+	}
+
+	if name == c.alias {
+		return c.row.value
+	}
+
+	// Unqualified identifiers refer to fields of the keyspace being queried.
+	if obj, ok := c.row.value.(map[string]interface{}); ok {
+		if val, ok := obj[name]; ok {
+			return val
+		}
+	}
+
+	return missing
+}
+
+// eval evaluates an expression in the given context.
+func eval(c *evalContext, e expr) (interface{}, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value, nil
+
+	case *identExpr:
+		return c.lookupIdent(e.name), nil
+
+	case *paramExpr:
+		return c.evalParam(e)
+
+	case *fieldExpr:
+		target, err := eval(c, e.target)
+		if err != nil {
+			return nil, err
+		}
+		if obj, ok := target.(map[string]interface{}); ok {
+			if val, ok := obj[e.name]; ok {
+				return val, nil
+			}
+			return missing, nil
+		}
+		if isMissing(target) {
+			return missing, nil
+		}
+		if target == nil {
+			return nil, nil
+		}
+		return missing, nil
+
+	case *indexExpr:
+		target, err := eval(c, e.target)
+		if err != nil {
+			return nil, err
+		}
+		index, err := eval(c, e.index)
+		if err != nil {
+			return nil, err
+		}
+		return evalIndex(target, index), nil
+
+	case *unaryExpr:
+		operand, err := eval(c, e.operand)
+		if err != nil {
+			return nil, err
+		}
+		if e.op == "NOT" {
+			return triNot(operand), nil
+		}
+		if num, ok := operand.(float64); ok {
+			return -num, nil
+		}
+		if isMissing(operand) {
+			return missing, nil
+		}
+		return nil, nil
+
+	case *binaryExpr:
+		return c.evalBinary(e)
+
+	case *betweenExpr:
+		operand, err := eval(c, e.operand)
+		if err != nil {
+			return nil, err
+		}
+		low, err := eval(c, e.low)
+		if err != nil {
+			return nil, err
+		}
+		high, err := eval(c, e.high)
+		if err != nil {
+			return nil, err
+		}
+		res := triAnd(compareValues(">=", operand, low), compareValues("<=", operand, high))
+		if e.not {
+			return triNot(res), nil
+		}
+		return res, nil
+
+	case *isExpr:
+		operand, err := eval(c, e.operand)
+		if err != nil {
+			return nil, err
+		}
+		var res bool
+		switch e.what {
+		case "NULL":
+			if isMissing(operand) {
+				return missing, nil
+			}
+			res = operand == nil
+		case "MISSING":
+			res = isMissing(operand)
+		case "VALUED":
+			res = operand != nil && !isMissing(operand)
+		}
+		if e.not {
+			return !res, nil
+		}
+		return res, nil
+
+	case *arrayExpr:
+		out := make([]interface{}, 0, len(e.elems))
+		for _, elem := range e.elems {
+			val, err := eval(c, elem)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, val)
+		}
+		return out, nil
+
+	case *objectExpr:
+		out := make(map[string]interface{}, len(e.keys))
+		for i, key := range e.keys {
+			val, err := eval(c, e.values[i])
+			if err != nil {
+				return nil, err
+			}
+			if !isMissing(val) {
+				out[key] = val
+			}
+		}
+		return out, nil
+
+	case *caseExpr:
+		var operand interface{}
+		if e.operand != nil {
+			var err error
+			operand, err = eval(c, e.operand)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, when := range e.whens {
+			cond, err := eval(c, when.cond)
+			if err != nil {
+				return nil, err
+			}
+			if e.operand != nil {
+				cond = compareValues("=", operand, cond)
+			}
+			if cond == true {
+				return eval(c, when.result)
+			}
+		}
+		if e.elseExpr != nil {
+			return eval(c, e.elseExpr)
+		}
+		return nil, nil
+
+	case *funcExpr:
+		return c.evalFunction(e)
+	}
+
+	return nil, newError(ErrCodeInternal, "unexpected expression type %T", e)
+}
+
+func (c *evalContext) evalParam(e *paramExpr) (interface{}, error) {
+	if e.name != "" {
+		val, ok := c.params.named[e.name]
+		if !ok {
+			return nil, newError(ErrCodeInvalidParameters, "No value for named parameter $%s.", e.name)
+		}
+		return val, nil
+	}
+
+	if e.position > len(c.params.positional) {
+		return nil, newError(ErrCodeInvalidParameters, "No value for positional parameter $%d.", e.position)
+	}
+	return c.params.positional[e.position-1], nil
+}
+
+func evalIndex(target, index interface{}) interface{} {
+	switch t := target.(type) {
+	case []interface{}:
+		pos, ok := index.(float64)
+		if !ok {
+			return missing
+		}
+		idx := int(pos)
+		if idx < 0 {
+			idx += len(t)
+		}
+		if idx < 0 || idx >= len(t) {
+			return missing
+		}
+		return t[idx]
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return missing
+		}
+		if val, ok := t[key]; ok {
+			return val
+		}
+		return missing
+	case nil:
+		return nil
+	}
+	return missing
+}
+
+// toTriState converts a value into the three-valued logic used by AND, OR and
+// NOT, where MISSING and null are preserved.
+func toTriState(val interface{}) interface{} {
+	if isMissing(val) || val == nil {
+		return val
+	}
+	return isTruthy(val)
+}
+
+func triNot(val interface{}) interface{} {
+	val = toTriState(val)
+	if b, ok := val.(bool); ok {
+		return !b
+	}
+	return val
+}
+
+func triAnd(a, b interface{}) interface{} {
+	a, b = toTriState(a), toTriState(b)
+	if a == false || b == false {
+		return false
+	}
+	if isMissing(a) || isMissing(b) {
+		return missing
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return true
+}
+
+func triOr(a, b interface{}) interface{} {
+	a, b = toTriState(a), toTriState(b)
+	if a == true || b == true {
+		return true
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	if isMissing(a) || isMissing(b) {
+		return missing
+	}
+	return false
+}
+
+func compareValues(op string, a, b interface{}) interface{} {
+	if isMissing(a) || isMissing(b) {
+		return missing
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+
+	cmp := collate(a, b)
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return nil
+}
+
+func (c *evalContext) evalBinary(e *binaryExpr) (interface{}, error) {
+	left, err := eval(c, e.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := eval(c, e.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "AND":
+		return triAnd(left, right), nil
+	case "OR":
+		return triOr(left, right), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareValues(e.op, left, right), nil
+	}
+
+	if isMissing(left) || isMissing(right) {
+		return missing, nil
+	}
+
+	switch e.op {
+	case "||":
+		lStr, lOk := left.(string)
+		rStr, rOk := right.(string)
+		if !lOk || !rOk {
+			return nil, nil
+		}
+		return lStr + rStr, nil
+
+	case "LIKE":
+		str, strOk := left.(string)
+		pattern, patternOk := right.(string)
+		if !strOk || !patternOk {
+			return nil, nil
+		}
+		re, err := likeToRegexp(pattern)
+		if err != nil {
+			return nil, nil
+		}
+		return re.MatchString(str), nil
+
+	case "IN":
+		arr, ok := right.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		for _, item := range arr {
+			if collate(left, item) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	lNum, lOk := left.(float64)
+	rNum, rOk := right.(float64)
+	if !lOk || !rOk {
+		return nil, nil
+	}
+
+	switch e.op {
+	case "+":
+		return lNum + rNum, nil
+	case "-":
+		return lNum - rNum, nil
+	case "*":
+		return lNum * rNum, nil
+	case "/":
+		if rNum == 0 {
+			return nil, nil
+		}
+		return lNum / rNum, nil
+	case "%":
+		if rNum == 0 {
+			return nil, nil
+		}
+		return math.Mod(lNum, rNum), nil
+	}
+
+	return nil, newError(ErrCodeInternal, "unexpected operator %s", e.op)
+}
+
+// likeToRegexp converts a LIKE pattern, where % matches any number of
+// characters and _ matches exactly one, into a regular expression.
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
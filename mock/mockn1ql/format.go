@@ -0,0 +1,202 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// formatExpr formats an expression in the same way as the query service does
+// when reporting index keys and conditions.
+func formatExpr(e expr) string {
+	switch e := e.(type) {
+	case *literalExpr:
+		if isMissing(e.value) {
+			return "missing"
+		}
+		if num, ok := e.value.(float64); ok {
+			return strconv.FormatFloat(num, 'f', -1, 64)
+		}
+		bytes, err := json.Marshal(e.value)
+		if err != nil {
+			return ""
+		}
+		return string(bytes)
+
+	case *identExpr:
+		return "`" + e.name + "`"
+
+	case *fieldExpr:
+		return "(" + formatPath(e) + ")"
+
+	case *indexExpr:
+		return "(" + formatPath(e) + ")"
+
+	case *paramExpr:
+		if e.name != "" {
+			return "$" + e.name
+		}
+		return "$" + strconv.Itoa(e.position)
+
+	case *unaryExpr:
+		if e.op == "NOT" {
+			return "(not " + formatExpr(e.operand) + ")"
+		}
+		return "(-" + formatExpr(e.operand) + ")"
+
+	case *binaryExpr:
+		return "(" + formatExpr(e.left) + " " + strings.ToLower(e.op) + " " + formatExpr(e.right) + ")"
+
+	case *betweenExpr:
+		op := " between "
+		if e.not {
+			op = " not between "
+		}
+		return "(" + formatExpr(e.operand) + op + formatExpr(e.low) + " and " + formatExpr(e.high) + ")"
+
+	case *isExpr:
+		op := " is "
+		if e.not {
+			op = " is not "
+		}
+		return "(" + formatExpr(e.operand) + op + strings.ToLower(e.what) + ")"
+
+	case *funcExpr:
+		var args []string
+		if e.star {
+			args = append(args, "*")
+		}
+		for _, arg := range e.args {
+			args = append(args, formatExpr(arg))
+		}
+		prefix := ""
+		if e.distinct {
+			prefix = "distinct "
+		}
+		return strings.ToLower(e.name) + "(" + prefix + strings.Join(args, ", ") + ")"
+
+	case *arrayExpr:
+		elems := make([]string, len(e.elems))
+		for i, elem := range e.elems {
+			elems[i] = formatExpr(elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+
+	case *objectExpr:
+		fields := make([]string, len(e.keys))
+		for i, key := range e.keys {
+			keyBytes, _ := json.Marshal(key)
+			fields[i] = string(keyBytes) + ": " + formatExpr(e.values[i])
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+
+	case *caseExpr:
+		var sb strings.Builder
+		sb.WriteString("case")
+		if e.operand != nil {
+			sb.WriteString(" " + formatExpr(e.operand))
+		}
+		for _, when := range e.whens {
+			sb.WriteString(" when " + formatExpr(when.cond) + " then " + formatExpr(when.result))
+		}
+		if e.elseExpr != nil {
+			sb.WriteString(" else " + formatExpr(e.elseExpr))
+		}
+		sb.WriteString(" end")
+		return sb.String()
+	}
+
+	return ""
+}
+
+// formatPath formats a field or array access without any surrounding brackets.
+func formatPath(e expr) string {
+	switch e := e.(type) {
+	case *fieldExpr:
+		return formatPath(e.target) + ".`" + e.name + "`"
+	case *indexExpr:
+		return formatPath(e.target) + "[" + formatExpr(e.index) + "]"
+	}
+	return formatExpr(e)
+}
+
+// stripAlias rewrites an expression so that any paths which are qualified by
+// the keyspace alias are made relative to the document instead.
+func stripAlias(e expr, alias string) expr {
+	switch e := e.(type) {
+	case *fieldExpr:
+		if ident, ok := e.target.(*identExpr); ok && ident.name == alias {
+			return &identExpr{name: e.name}
+		}
+		return &fieldExpr{target: stripAlias(e.target, alias), name: e.name}
+	case *indexExpr:
+		return &indexExpr{target: stripAlias(e.target, alias), index: stripAlias(e.index, alias)}
+	case *unaryExpr:
+		return &unaryExpr{op: e.op, operand: stripAlias(e.operand, alias)}
+	case *binaryExpr:
+		return &binaryExpr{op: e.op, left: stripAlias(e.left, alias), right: stripAlias(e.right, alias)}
+	case *betweenExpr:
+		return &betweenExpr{
+			operand: stripAlias(e.operand, alias),
+			low:     stripAlias(e.low, alias),
+			high:    stripAlias(e.high, alias),
+			not:     e.not,
+		}
+	case *isExpr:
+		return &isExpr{operand: stripAlias(e.operand, alias), what: e.what, not: e.not}
+	case *funcExpr:
+		args := make([]expr, len(e.args))
+		for i, arg := range e.args {
+			args[i] = stripAlias(arg, alias)
+		}
+		return &funcExpr{name: e.name, args: args, star: e.star, distinct: e.distinct}
+	}
+	return e
+}
+
+// collectSubExprs returns the formatted form of an expression and every
+// expression nested within it.
+func collectSubExprs(e expr, out map[string]bool) {
+	if e == nil {
+		return
+	}
+	out[formatExpr(e)] = true
+
+	switch e := e.(type) {
+	case *fieldExpr:
+		collectSubExprs(e.target, out)
+	case *indexExpr:
+		collectSubExprs(e.target, out)
+		collectSubExprs(e.index, out)
+	case *unaryExpr:
+		collectSubExprs(e.operand, out)
+	case *binaryExpr:
+		collectSubExprs(e.left, out)
+		collectSubExprs(e.right, out)
+	case *betweenExpr:
+		collectSubExprs(e.operand, out)
+		collectSubExprs(e.low, out)
+		collectSubExprs(e.high, out)
+	case *isExpr:
+		collectSubExprs(e.operand, out)
+	case *funcExpr:
+		for _, arg := range e.args {
+			collectSubExprs(arg, out)
+		}
+	case *arrayExpr:
+		for _, elem := range e.elems {
+			collectSubExprs(elem, out)
+		}
+	case *objectExpr:
+		for _, value := range e.values {
+			collectSubExprs(value, out)
+		}
+	case *caseExpr:
+		collectSubExprs(e.operand, out)
+		for _, when := range e.whens {
+			collectSubExprs(when.cond, out)
+			collectSubExprs(when.result, out)
+		}
+		collectSubExprs(e.elseExpr, out)
+	}
+}
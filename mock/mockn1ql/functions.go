@@ -0,0 +1,574 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// aggregateFunctions lists the functions which operate over a group of rows.
+var aggregateFunctions = map[string]bool{
+	"COUNT":     true,
+	"SUM":       true,
+	"AVG":       true,
+	"MIN":       true,
+	"MAX":       true,
+	"ARRAY_AGG": true,
+}
+
+type scalarFunction struct {
+	minArgs int
+	maxArgs int
+	fn      func(args []interface{}) interface{}
+}
+
+// scalarFunctions lists the supported non-aggregate functions.  Unless noted
+// otherwise, these return MISSING if any argument is MISSING and null if any
+// argument is null or of the wrong type.
+var scalarFunctions = map[string]scalarFunction{
+	"UPPER":           {1, 1, stringFunc(strings.ToUpper)},
+	"LOWER":           {1, 1, stringFunc(strings.ToLower)},
+	"TRIM":            {1, 1, stringFunc(strings.TrimSpace)},
+	"LTRIM":           {1, 1, stringFunc(func(s string) string { return strings.TrimLeft(s, " \t\n\r") })},
+	"RTRIM":           {1, 1, stringFunc(func(s string) string { return strings.TrimRight(s, " \t\n\r") })},
+	"LENGTH":          {1, 1, fnLength},
+	"SUBSTR":          {2, 3, fnSubstr},
+	"CONTAINS":        {2, 2, fnContains},
+	"REPLACE":         {3, 3, fnReplace},
+	"SPLIT":           {1, 2, fnSplit},
+	"CONCAT":          {2, -1, fnConcat},
+	"ABS":             {1, 1, numberFunc(math.Abs)},
+	"CEIL":            {1, 1, numberFunc(math.Ceil)},
+	"FLOOR":           {1, 1, numberFunc(math.Floor)},
+	"SQRT":            {1, 1, numberFunc(math.Sqrt)},
+	"ROUND":           {1, 2, fnRound},
+	"POWER":           {2, 2, fnPower},
+	"TOSTRING":        {1, 1, fnToString},
+	"TONUMBER":        {1, 1, fnToNumber},
+	"TYPE":            {1, 1, fnType},
+	"TYPENAME":        {1, 1, fnType},
+	"IFMISSING":       {2, -1, fnIfMissing},
+	"IFNULL":          {2, -1, fnIfNull},
+	"IFMISSINGORNULL": {2, -1, fnIfMissingOrNull},
+	"ARRAY_LENGTH":    {1, 1, fnArrayLength},
+	"ARRAY_CONTAINS":  {2, 2, fnArrayContains},
+	"OBJECT_NAMES":    {1, 1, fnObjectNames},
+	"UUID":            {0, 0, fnUUID},
+}
+
+// checkArgs returns the value that a function should return based on its
+// arguments being MISSING or null, along with whether that value applies.
+func checkArgs(args []interface{}) (interface{}, bool) {
+	for _, arg := range args {
+		if isMissing(arg) {
+			return missing, true
+		}
+	}
+	for _, arg := range args {
+		if arg == nil {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func stringFunc(fn func(string) string) func(args []interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if val, ok := checkArgs(args); ok {
+			return val
+		}
+		str, ok := args[0].(string)
+		if !ok {
+			return nil
+		}
+		return fn(str)
+	}
+}
+
+func numberFunc(fn func(float64) float64) func(args []interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		if val, ok := checkArgs(args); ok {
+			return val
+		}
+		num, ok := args[0].(float64)
+		if !ok {
+			return nil
+		}
+		return fn(num)
+	}
+}
+
+func fnLength(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return nil
+	}
+	return float64(len(str))
+}
+
+func fnSubstr(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	str, ok := args[0].(string)
+	pos, posOk := args[1].(float64)
+	if !ok || !posOk {
+		return nil
+	}
+
+	start := int(pos)
+	if start < 0 {
+		start += len(str)
+	}
+	if start < 0 || start > len(str) {
+		return nil
+	}
+
+	end := len(str)
+	if len(args) > 2 {
+		length, ok := args[2].(float64)
+		if !ok || length < 0 {
+			return nil
+		}
+		if start+int(length) < end {
+			end = start + int(length)
+		}
+	}
+
+	return str[start:end]
+}
+
+func fnContains(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	str, ok := args[0].(string)
+	substr, subOk := args[1].(string)
+	if !ok || !subOk {
+		return nil
+	}
+	return strings.Contains(str, substr)
+}
+
+func fnReplace(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	str, ok1 := args[0].(string)
+	old, ok2 := args[1].(string)
+	replacement, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil
+	}
+	return strings.Replace(str, old, replacement, -1)
+}
+
+func fnSplit(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	str, ok := args[0].(string)
+	if !ok {
+		return nil
+	}
+
+	var parts []string
+	if len(args) > 1 {
+		sep, ok := args[1].(string)
+		if !ok {
+			return nil
+		}
+		parts = strings.Split(str, sep)
+	} else {
+		parts = strings.Fields(str)
+	}
+
+	out := make([]interface{}, len(parts))
+	for i, part := range parts {
+		out[i] = part
+	}
+	return out
+}
+
+func fnConcat(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	var sb strings.Builder
+	for _, arg := range args {
+		str, ok := arg.(string)
+		if !ok {
+			return nil
+		}
+		sb.WriteString(str)
+	}
+	return sb.String()
+}
+
+func fnRound(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	num, ok := args[0].(float64)
+	if !ok {
+		return nil
+	}
+
+	digits := 0.0
+	if len(args) > 1 {
+		digits, ok = args[1].(float64)
+		if !ok {
+			return nil
+		}
+	}
+
+	scale := math.Pow(10, math.Trunc(digits))
+	return math.Round(num*scale) / scale
+}
+
+func fnPower(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	base, ok1 := args[0].(float64)
+	exp, ok2 := args[1].(float64)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	return math.Pow(base, exp)
+}
+
+func fnToString(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	switch v := args[0].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	bytes, err := json.Marshal(stripMissing(args[0]))
+	if err != nil {
+		return nil
+	}
+	return string(bytes)
+}
+
+func fnToNumber(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	switch v := args[0].(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1.0
+		}
+		return 0.0
+	case string:
+		num, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil
+		}
+		return num
+	}
+	return nil
+}
+
+func fnType(args []interface{}) interface{} {
+	return typeName(args[0])
+}
+
+func fnIfMissing(args []interface{}) interface{} {
+	for _, arg := range args {
+		if !isMissing(arg) {
+			return arg
+		}
+	}
+	return nil
+}
+
+func fnIfNull(args []interface{}) interface{} {
+	for _, arg := range args {
+		if isMissing(arg) {
+			return missing
+		}
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+func fnIfMissingOrNull(args []interface{}) interface{} {
+	for _, arg := range args {
+		if arg != nil && !isMissing(arg) {
+			return arg
+		}
+	}
+	return nil
+}
+
+func fnArrayLength(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	arr, ok := args[0].([]interface{})
+	if !ok {
+		return nil
+	}
+	return float64(len(arr))
+}
+
+func fnArrayContains(args []interface{}) interface{} {
+	if val, ok := checkArgs(args[:1]); ok {
+		return val
+	}
+	arr, ok := args[0].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, item := range arr {
+		if collate(item, args[1]) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func fnObjectNames(args []interface{}) interface{} {
+	if val, ok := checkArgs(args); ok {
+		return val
+	}
+	obj, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	keys := sortedKeys(obj)
+	out := make([]interface{}, len(keys))
+	for i, key := range keys {
+		out[i] = key
+	}
+	return out
+}
+
+func fnUUID(args []interface{}) interface{} {
+	return uuid.New().String()
+}
+
+func (c *evalContext) evalFunction(e *funcExpr) (interface{}, error) {
+	if aggregateFunctions[e.name] {
+		return c.evalAggregate(e)
+	}
+
+	if e.name == "META" {
+		return c.evalMeta(e)
+	}
+
+	fn, ok := scalarFunctions[e.name]
+	if !ok {
+		return nil, newError(ErrCodeParse, "Invalid function %s", strings.ToLower(e.name))
+	}
+	if e.star || e.distinct || len(e.args) < fn.minArgs || (fn.maxArgs >= 0 && len(e.args) > fn.maxArgs) {
+		return nil, newError(ErrCodeParse, "Number of arguments to function %s must be %s.",
+			strings.ToLower(e.name), argCountDescription(fn))
+	}
+
+	args := make([]interface{}, len(e.args))
+	for i, argExpr := range e.args {
+		arg, err := eval(c, argExpr)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	return fn.fn(args), nil
+}
+
+func argCountDescription(fn scalarFunction) string {
+	if fn.maxArgs < 0 {
+		return "at least " + strconv.Itoa(fn.minArgs)
+	}
+	if fn.minArgs == fn.maxArgs {
+		return strconv.Itoa(fn.minArgs)
+	}
+	return "between " + strconv.Itoa(fn.minArgs) + " and " + strconv.Itoa(fn.maxArgs)
+}
+
+func (c *evalContext) evalMeta(e *funcExpr) (interface{}, error) {
+	if len(e.args) > 1 {
+		return nil, newError(ErrCodeParse, "Number of arguments to function meta must be between 0 and 1.")
+	}
+
+	row := c.row
+	if row == nil && len(c.group) > 0 {
+		row = c.group[0]
+	}
+	if row == nil || row.doc == nil {
+		return missing, nil
+	}
+
+	expiration := 0.0
+	if !row.doc.Expiry.IsZero() {
+		expiration = float64(row.doc.Expiry.Unix())
+	}
+
+	return map[string]interface{}{
+		"id":         row.key,
+		"cas":        float64(row.doc.Cas),
+		"expiration": expiration,
+		"flags":      float64(row.doc.Flags),
+		"type":       "json",
+	}, nil
+}
+
+func (c *evalContext) evalAggregate(e *funcExpr) (interface{}, error) {
+	if c.group == nil {
+		return nil, newError(ErrCodeSemantic, "Invalid use of aggregate function %s.", strings.ToLower(e.name))
+	}
+	if !e.star && len(e.args) != 1 {
+		return nil, newError(ErrCodeParse, "Number of arguments to function %s must be 1.", strings.ToLower(e.name))
+	}
+	if e.star && e.name != "COUNT" {
+		return nil, newError(ErrCodeParse, "Invalid use of * in function %s.", strings.ToLower(e.name))
+	}
+
+	if e.star {
+		return float64(len(c.group)), nil
+	}
+
+	var values []interface{}
+	for _, row := range c.group {
+		val, err := eval(c.withRow(row), e.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if isMissing(val) || (val == nil && e.name != "ARRAY_AGG") {
+			continue
+		}
+
+		if e.distinct {
+			isDuplicate := false
+			for _, existing := range values {
+				if collate(existing, val) == 0 {
+					isDuplicate = true
+					break
+				}
+			}
+			if isDuplicate {
+				continue
+			}
+		}
+
+		values = append(values, val)
+	}
+
+	switch e.name {
+	case "COUNT":
+		return float64(len(values)), nil
+
+	case "SUM", "AVG":
+		sum := 0.0
+		count := 0
+		for _, val := range values {
+			if num, ok := val.(float64); ok {
+				sum += num
+				count++
+			}
+		}
+		if count == 0 {
+			return nil, nil
+		}
+		if e.name == "AVG" {
+			return sum / float64(count), nil
+		}
+		return sum, nil
+
+	case "MIN", "MAX":
+		var result interface{}
+		for i, val := range values {
+			if i == 0 {
+				result = val
+				continue
+			}
+			cmp := collate(val, result)
+			if (e.name == "MIN" && cmp < 0) || (e.name == "MAX" && cmp > 0) {
+				result = val
+			}
+		}
+		return result, nil
+
+	case "ARRAY_AGG":
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values, nil
+	}
+
+	return nil, newError(ErrCodeInternal, "unexpected aggregate %s", e.name)
+}
+
+// containsAggregate reports whether an expression makes use of any aggregate
+// functions.
+func containsAggregate(e expr) bool {
+	switch e := e.(type) {
+	case *funcExpr:
+		if aggregateFunctions[e.name] {
+			return true
+		}
+		for _, arg := range e.args {
+			if containsAggregate(arg) {
+				return true
+			}
+		}
+	case *fieldExpr:
+		return containsAggregate(e.target)
+	case *indexExpr:
+		return containsAggregate(e.target) || containsAggregate(e.index)
+	case *unaryExpr:
+		return containsAggregate(e.operand)
+	case *binaryExpr:
+		return containsAggregate(e.left) || containsAggregate(e.right)
+	case *betweenExpr:
+		return containsAggregate(e.operand) || containsAggregate(e.low) || containsAggregate(e.high)
+	case *isExpr:
+		return containsAggregate(e.operand)
+	case *arrayExpr:
+		for _, elem := range e.elems {
+			if containsAggregate(elem) {
+				return true
+			}
+		}
+	case *objectExpr:
+		for _, value := range e.values {
+			if containsAggregate(value) {
+				return true
+			}
+		}
+	case *caseExpr:
+		if e.operand != nil && containsAggregate(e.operand) {
+			return true
+		}
+		for _, when := range e.whens {
+			if containsAggregate(when.cond) || containsAggregate(when.result) {
+				return true
+			}
+		}
+		if e.elseExpr != nil {
+			return containsAggregate(e.elseExpr)
+		}
+	}
+	return false
+}
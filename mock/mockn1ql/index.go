@@ -0,0 +1,353 @@
+package mockn1ql
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// The following lists the states which an index can be in.
+const (
+	IndexStateDeferred = "deferred"
+	IndexStateBuilding = "building"
+	IndexStateOnline   = "online"
+)
+
+// Index represents a single GSI index.
+type Index struct {
+	ID         string
+	Name       string
+	Keyspace   KeyspacePath
+	IsPrimary  bool
+	IndexKey   []string
+	Condition  string
+	NumReplica int
+
+	// leadingKey is the first index key with any DESC qualifier removed, this
+	// is what decides whether the index can be used for a query.
+	leadingKey string
+
+	// onlineTime is when the index finishes building, it is zero while the
+	// build of the index is deferred.
+	onlineTime time.Time
+}
+
+// normalizeIndexKeyspace makes sure that indexes on the default collection
+// are always recorded against the bucket itself.
+func normalizeIndexKeyspace(path KeyspacePath) KeyspacePath {
+	if path.Scope == "_default" && path.Collection == "_default" {
+		path.Scope = ""
+		path.Collection = ""
+	}
+	path.Namespace = "default"
+	return path
+}
+
+func (e *Engine) indexState(idx *Index) string {
+	if idx.onlineTime.IsZero() {
+		return IndexStateDeferred
+	}
+	if e.chrono.Now().Before(idx.onlineTime) {
+		return IndexStateBuilding
+	}
+	return IndexStateOnline
+}
+
+func (e *Engine) findIndexLocked(keyspace KeyspacePath, name string) (int, *Index) {
+	for i, idx := range e.indexes {
+		if idx.Keyspace == keyspace && idx.Name == name {
+			return i, idx
+		}
+	}
+	return -1, nil
+}
+
+// GetAllIndexes returns all of the indexes which have been created.
+func (e *Engine) GetAllIndexes() []*Index {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	indexes := make([]*Index, len(e.indexes))
+	copy(indexes, e.indexes)
+	return indexes
+}
+
+// DropBucketIndexes removes all of the indexes which belong to a bucket, this
+// is used when a bucket is deleted.
+func (e *Engine) DropBucketIndexes(bucket string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var indexes []*Index
+	for _, idx := range e.indexes {
+		if idx.Keyspace.Bucket != bucket {
+			indexes = append(indexes, idx)
+		}
+	}
+	e.indexes = indexes
+	e.bumpIndexVersionLocked(bucket)
+}
+
+// hasUsableIndex checks whether there is an online index which can be used to
+// scan a keyspace with the specified WHERE clause.  The primary index can
+// always be used.  Secondary indexes can be used when their leading key is
+// referenced by the WHERE clause, the index condition is not considered.
+func (e *Engine) hasUsableIndex(keyspace KeyspacePath, alias string, where expr) bool {
+	keyspace = normalizeIndexKeyspace(keyspace)
+
+	var referenced map[string]bool
+	if where != nil {
+		referenced = make(map[string]bool)
+		collectSubExprs(stripAlias(where, alias), referenced)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, idx := range e.indexes {
+		if idx.Keyspace != keyspace || e.indexState(idx) != IndexStateOnline {
+			continue
+		}
+		if idx.IsPrimary || referenced[idx.leadingKey] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatKeyspaceForError(path KeyspacePath) string {
+	keyspace := "`default`:`" + path.Bucket + "`"
+	if path.Scope != "" {
+		keyspace += ".`" + path.Scope + "`.`" + path.Collection + "`"
+	}
+	return keyspace
+}
+
+// checkIndexAvailable returns an error if a keyspace cannot be scanned.
+func (x *execution) checkIndexAvailable(path KeyspacePath, alias string, where expr) error {
+	if x.engine.hasUsableIndex(path, alias, where) {
+		return nil
+	}
+
+	keyspace := formatKeyspaceForError(normalizeIndexKeyspace(path))
+	return newError(ErrCodePlan,
+		"No index available on keyspace %s that matches your query. Use CREATE PRIMARY INDEX ON %s to create a primary index, or check that your expected index is online.",
+		keyspace, keyspace)
+}
+
+func (x *execution) executeCreateIndex(stmt *createIndexStmt) error {
+	_, path, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessManage)
+	if err != nil {
+		return err
+	}
+	path = normalizeIndexKeyspace(path)
+
+	idx := &Index{
+		ID:        fmt.Sprintf("%016x", rand.Uint64()),
+		Name:      stmt.name,
+		Keyspace:  path,
+		IsPrimary: stmt.primary,
+		IndexKey:  []string{},
+	}
+	if idx.Name == "" {
+		idx.Name = "#primary"
+	}
+
+	for i, key := range stmt.keys {
+		formatted := formatExpr(stripAlias(key.expr, stmt.keyspace.alias))
+		if i == 0 {
+			idx.leadingKey = formatted
+		}
+		if key.desc {
+			formatted += " DESC"
+		}
+		idx.IndexKey = append(idx.IndexKey, formatted)
+	}
+	if stmt.where != nil {
+		idx.Condition = formatExpr(stripAlias(stmt.where, stmt.keyspace.alias))
+	}
+
+	deferBuild := false
+	if stmt.with != nil {
+		withVal, err := eval(x.baseContext(), stmt.with)
+		if err != nil {
+			return err
+		}
+		with, ok := withVal.(map[string]interface{})
+		if !ok {
+			return newError(ErrCodeSemantic, "WITH clause of CREATE INDEX must be an object.")
+		}
+		for key, val := range with {
+			switch key {
+			case "defer_build":
+				deferBuild, ok = val.(bool)
+				if !ok {
+					return newError(ErrCodeSemantic, "defer_build must be a boolean.")
+				}
+			case "num_replica":
+				numReplica, ok := val.(float64)
+				if !ok || numReplica < 0 {
+					return newError(ErrCodeSemantic, "num_replica must be a positive integer.")
+				}
+				idx.NumReplica = int(numReplica)
+			}
+		}
+	}
+
+	if !deferBuild {
+		idx.onlineTime = x.engine.chrono.Now()
+	}
+
+	x.engine.lock.Lock()
+	defer x.engine.lock.Unlock()
+
+	if _, existing := x.engine.findIndexLocked(path, idx.Name); existing != nil {
+		if stmt.ifNotExists {
+			return nil
+		}
+		return newError(ErrCodeIndexExists, "The index %s already exists.", idx.Name)
+	}
+
+	x.engine.indexes = append(x.engine.indexes, idx)
+	x.engine.bumpIndexVersionLocked(path.Bucket)
+	return nil
+}
+
+func (x *execution) executeDropIndex(stmt *dropIndexStmt) error {
+	_, path, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessManage)
+	if err != nil {
+		return err
+	}
+	path = normalizeIndexKeyspace(path)
+
+	name := stmt.name
+	if name == "" {
+		name = "#primary"
+	}
+
+	x.engine.lock.Lock()
+	defer x.engine.lock.Unlock()
+
+	idxPos, idx := x.engine.findIndexLocked(path, name)
+	if idx == nil || (stmt.primary && !idx.IsPrimary) {
+		if stmt.ifExists {
+			return nil
+		}
+		return newError(ErrCodeIndexNotFound, "GSI index %s not found.", name)
+	}
+
+	x.engine.indexes = append(x.engine.indexes[:idxPos:idxPos], x.engine.indexes[idxPos+1:]...)
+	x.engine.bumpIndexVersionLocked(path.Bucket)
+	return nil
+}
+
+func (x *execution) executeBuildIndex(stmt *buildIndexStmt) error {
+	_, path, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessManage)
+	if err != nil {
+		return err
+	}
+	path = normalizeIndexKeyspace(path)
+
+	var names []string
+	for _, nameExpr := range stmt.names {
+		// Plain identifiers here refer to index names rather than fields.
+		if ident, ok := nameExpr.(*identExpr); ok {
+			names = append(names, ident.name)
+			continue
+		}
+
+		val, err := eval(x.baseContext(), nameExpr)
+		if err != nil {
+			return err
+		}
+		switch v := val.(type) {
+		case string:
+			names = append(names, v)
+		case []interface{}:
+			for _, item := range v {
+				if name, ok := item.(string); ok {
+					names = append(names, name)
+				}
+			}
+		default:
+			return newError(ErrCodeSemantic, "BUILD INDEX requires index names, not %s.", typeName(val))
+		}
+	}
+
+	x.engine.lock.Lock()
+	defer x.engine.lock.Unlock()
+
+	var toBuild []*Index
+	for _, name := range names {
+		_, idx := x.engine.findIndexLocked(path, name)
+		if idx == nil {
+			return newError(ErrCodeIndexNotFound, "GSI index %s not found.", name)
+		}
+		toBuild = append(toBuild, idx)
+	}
+
+	onlineTime := x.engine.chrono.Now().Add(x.engine.indexBuildLatency)
+	for _, idx := range toBuild {
+		if idx.onlineTime.IsZero() {
+			idx.onlineTime = onlineTime
+		}
+	}
+	x.engine.bumpIndexVersionLocked(path.Bucket)
+
+	return nil
+}
+
+// readSystemKeyspace returns the rows of one of the system keyspaces.  Only
+// system:indexes is currently supported, which lists every index that the
+// user is able to see.
+func (x *execution) readSystemKeyspace(ref *keyspaceRef) ([]*queryRow, error) {
+	if len(ref.parts) != 1 || ref.parts[0] != "indexes" {
+		return nil, newError(ErrCodeKeyspaceNotFound, "Keyspace not found in CB datastore: system:%s",
+			strings.Join(ref.parts, "."))
+	}
+
+	var rows []*queryRow
+	for _, idx := range x.engine.GetAllIndexes() {
+		if x.opts.Keyspaces == nil {
+			break
+		}
+		if _, err := x.opts.Keyspaces(idx.Keyspace, KeyspaceAccessRead); err != nil {
+			continue
+		}
+
+		indexKey := make([]interface{}, len(idx.IndexKey))
+		for i, key := range idx.IndexKey {
+			indexKey[i] = key
+		}
+
+		entry := map[string]interface{}{
+			"id":           idx.ID,
+			"name":         idx.Name,
+			"namespace_id": "default",
+			"keyspace_id":  idx.Keyspace.Bucket,
+			"index_key":    indexKey,
+			"state":        x.engine.indexState(idx),
+			"using":        "gsi",
+		}
+		if idx.Keyspace.Scope != "" {
+			entry["bucket_id"] = idx.Keyspace.Bucket
+			entry["scope_id"] = idx.Keyspace.Scope
+			entry["keyspace_id"] = idx.Keyspace.Collection
+		}
+		if idx.IsPrimary {
+			entry["is_primary"] = true
+		}
+		if idx.Condition != "" {
+			entry["condition"] = idx.Condition
+		}
+
+		rows = append(rows, &queryRow{
+			key:   idx.ID,
+			value: entry,
+		})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,413 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+)
+
+// jsonFlags are the common flags which the query service writes documents with.
+const jsonFlags = 0x02000006
+
+// KeyspaceAccess describes how a statement intends to use a keyspace.
+type KeyspaceAccess int
+
+// The following lists the ways in which a keyspace can be accessed.
+const (
+	KeyspaceAccessRead = KeyspaceAccess(iota)
+	KeyspaceAccessInsert
+	KeyspaceAccessUpdate
+	KeyspaceAccessDelete
+	KeyspaceAccessManage
+)
+
+// Keyspace represents a resolved keyspace which documents can be read from
+// and written to.
+type Keyspace struct {
+	Store        *mockdb.Bucket
+	CollectionID uint
+}
+
+// KeyspaceResolver resolves a keyspace referenced by a statement, checking
+// that the requesting user is permitted the specified access.  It returns
+// ErrKeyspaceNotFound or ErrAccessDenied if the keyspace cannot be used.
+type KeyspaceResolver func(path KeyspacePath, access KeyspaceAccess) (*Keyspace, error)
+
+// String returns the keyspace path formatted as it is by the query service.
+func (p KeyspacePath) String() string {
+	namespace := p.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	if p.Scope == "" && p.Collection == "" {
+		return namespace + ":" + p.Bucket
+	}
+	return namespace + ":" + p.Bucket + "." + p.Scope + "." + p.Collection
+}
+
+// queryContext represents the query_context of a request, which is used to
+// resolve relative keyspace paths.
+type queryContext struct {
+	namespace string
+	bucket    string
+	scope     string
+}
+
+func parseQueryContext(text string) (*queryContext, error) {
+	qc := &queryContext{
+		namespace: "default",
+	}
+	if text == "" {
+		return qc, nil
+	}
+
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptOp(":") {
+		qc.namespace = name
+		if p.peek().kind == tokEOF {
+			return qc, nil
+		}
+		name, err = p.parseName()
+		if err != nil {
+			return nil, err
+		}
+	}
+	qc.bucket = name
+
+	if p.acceptOp(".") {
+		qc.scope, err = p.parseName()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek().kind != tokEOF {
+		return nil, newError(ErrCodeInvalidParameters, "Invalid query_context %s", text)
+	}
+
+	return qc, nil
+}
+
+func (qc *queryContext) resolvePath(ref *keyspaceRef) (KeyspacePath, error) {
+	path := KeyspacePath{
+		Namespace: qc.namespace,
+	}
+	if ref.namespace != "" {
+		path.Namespace = ref.namespace
+	}
+
+	switch len(ref.parts) {
+	case 1:
+		if ref.namespace == "" && qc.scope != "" {
+			path.Bucket = qc.bucket
+			path.Scope = qc.scope
+			path.Collection = ref.parts[0]
+		} else {
+			path.Bucket = ref.parts[0]
+		}
+	case 2:
+		if ref.namespace != "" || qc.bucket == "" {
+			return path, newParseError("invalid keyspace path %s", strings.Join(ref.parts, "."))
+		}
+		path.Bucket = qc.bucket
+		path.Scope = ref.parts[0]
+		path.Collection = ref.parts[1]
+	case 3:
+		path.Bucket = ref.parts[0]
+		path.Scope = ref.parts[1]
+		path.Collection = ref.parts[2]
+	}
+
+	return path, nil
+}
+
+var accessStatementNames = map[KeyspaceAccess]string{
+	KeyspaceAccessRead:   "SELECT",
+	KeyspaceAccessInsert: "INSERT",
+	KeyspaceAccessUpdate: "UPDATE",
+	KeyspaceAccessDelete: "DELETE",
+	KeyspaceAccessManage: "index",
+}
+
+var accessRoleNames = map[KeyspaceAccess]string{
+	KeyspaceAccessRead:   "query_select",
+	KeyspaceAccessInsert: "query_insert",
+	KeyspaceAccessUpdate: "query_update",
+	KeyspaceAccessDelete: "query_delete",
+	KeyspaceAccessManage: "query_manage_index",
+}
+
+func (x *execution) resolveKeyspace(ref *keyspaceRef, access KeyspaceAccess) (*Keyspace, KeyspacePath, error) {
+	path, err := x.queryContext.resolvePath(ref)
+	if err != nil {
+		return nil, path, err
+	}
+
+	if path.Namespace != "default" || x.opts.Keyspaces == nil {
+		return nil, path, newError(ErrCodeKeyspaceNotFound, "Keyspace not found in CB datastore: %s", path)
+	}
+
+	ks, err := x.opts.Keyspaces(path, access)
+	if errors.Is(err, ErrKeyspaceNotFound) {
+		return nil, path, newError(ErrCodeKeyspaceNotFound, "Keyspace not found in CB datastore: %s", path)
+	} else if errors.Is(err, ErrAccessDenied) {
+		return nil, path, newError(ErrCodeAccessDenied,
+			"User does not have credentials to run %s queries on %s. Add role %s on %s to allow the statement to run.",
+			accessStatementNames[access], path, accessRoleNames[access], path)
+	} else if err != nil {
+		return nil, path, newError(ErrCodeInternal, "%s", err)
+	}
+
+	return ks, path, nil
+}
+
+// newQueryRow builds a row from a document, decoding its value.
+func newQueryRow(doc *mockdb.Document) *queryRow {
+	row := &queryRow{
+		key:   string(doc.Key),
+		doc:   doc,
+		value: missing,
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(doc.InflatedValue(), &value); err == nil {
+		row.value = value
+	}
+
+	return row
+}
+
+// scanKeyspace returns the current version of every live document within a
+// keyspace which is visible to an index scan.  The keys are taken from the
+// index, which may be stale, while the documents themselves are fetched from
+// the data service as they are by the real query service.
+func (x *execution) scanKeyspace(ks *Keyspace, path KeyspacePath) ([]*queryRow, error) {
+	snapshotTime, err := x.indexSnapshotTime(ks, path)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := ks.Store.GetAll(0, ks.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The store contains the full history of each document, so we need to pick
+	// out only the most recent version of each which the index has seen.
+	latest := make(map[string]*mockdb.Document)
+	var keys []string
+	for _, doc := range docs {
+		if doc.ModifiedTime.After(snapshotTime) {
+			continue
+		}
+
+		key := string(doc.Key)
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = doc
+	}
+
+	var indexedKeys []string
+	for _, key := range keys {
+		if !latest[key].IsDeleted {
+			indexedKeys = append(indexedKeys, key)
+		}
+	}
+
+	return x.fetchKeys(ks, indexedKeys)
+}
+
+// fetchDocument returns the current version of a document, or nil if it does
+// not exist.
+func fetchDocument(ks *Keyspace, key string) (*mockdb.Document, error) {
+	vbID := ks.Store.VbucketForKey([]byte(key))
+	doc, err := ks.Store.Get(0, vbID, ks.CollectionID, []byte(key))
+	if err == mockdb.ErrDocNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if doc.IsDeleted {
+		return nil, nil
+	}
+
+	return doc, nil
+}
+
+// fetchKeys returns the current version of the specified documents, skipping
+// any which do not exist.
+func (x *execution) fetchKeys(ks *Keyspace, keys []string) ([]*queryRow, error) {
+	var rows []*queryRow
+	for _, key := range keys {
+		doc, err := fetchDocument(ks, key)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+
+		rows = append(rows, newQueryRow(doc))
+	}
+
+	return rows, nil
+}
+
+// readKeyspace reads the documents of a keyspace, either directly by key when
+// USE KEYS is specified or by scanning all of them.  Within a transaction the
+// documents reflect the writes which the transaction has made.
+func (x *execution) readKeyspace(c *evalContext, ks *Keyspace, path KeyspacePath, useKeys expr) ([]*queryRow, error) {
+	if useKeys == nil {
+		rows, err := x.scanKeyspace(ks, path)
+		if err != nil {
+			return nil, err
+		}
+		if x.tx != nil {
+			rows = x.tx.overlay(ks, rows, nil)
+		}
+		return rows, nil
+	}
+
+	keysVal, err := eval(c, useKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	switch v := keysVal.(type) {
+	case string:
+		keys = append(keys, v)
+	case []interface{}:
+		for _, item := range v {
+			if key, ok := item.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	rows, err := x.fetchKeys(ks, keys)
+	if err != nil {
+		return nil, err
+	}
+	if x.tx != nil {
+		rows = x.tx.overlay(ks, rows, keys)
+	}
+	return rows, nil
+}
+
+var errDuplicateKey = errors.New("duplicate key")
+
+// writeDocument stores a new value for a document, staging it instead when
+// executing within a transaction.  If upsert is false then errDuplicateKey is
+// returned if the document already exists.
+func (x *execution) writeDocument(ks *Keyspace, key string, value interface{}, upsert bool) (*mockdb.Document, error) {
+	if x.tx != nil {
+		return x.tx.writeDocument(ks, key, value, upsert)
+	}
+	return writeDocument(ks, key, value, upsert)
+}
+
+// replaceDocument updates the value of an existing document, staging it
+// instead when executing within a transaction.
+func (x *execution) replaceDocument(ks *Keyspace, key string, value interface{}) (*mockdb.Document, error) {
+	if x.tx != nil {
+		return x.tx.replaceDocument(ks, key, value)
+	}
+	return replaceDocument(ks, key, value)
+}
+
+// removeDocument deletes a document, staging the removal instead when
+// executing within a transaction.
+func (x *execution) removeDocument(ks *Keyspace, key string) (*mockdb.Document, error) {
+	if x.tx != nil {
+		return x.tx.removeDocument(ks, key)
+	}
+	return removeDocument(ks, key)
+}
+
+// writeDocument stores a new value for a document directly in the bucket.
+func writeDocument(ks *Keyspace, key string, value interface{}, upsert bool) (*mockdb.Document, error) {
+	bytes, err := json.Marshal(stripMissing(value))
+	if err != nil {
+		return nil, err
+	}
+
+	vbID := ks.Store.VbucketForKey([]byte(key))
+	return ks.Store.Update(vbID, ks.CollectionID, []byte(key), func(idoc *mockdb.Document) (*mockdb.Document, error) {
+		if idoc != nil && !idoc.IsDeleted && !upsert {
+			return nil, errDuplicateKey
+		}
+
+		return &mockdb.Document{
+			VbID:         vbID,
+			CollectionID: ks.CollectionID,
+			Key:          []byte(key),
+			Value:        bytes,
+			Flags:        jsonFlags,
+			Datatype:     uint8(memd.DatatypeFlagJSON),
+			Cas:          mockdb.GenerateNewCas(ks.Store.Chrono().Now()),
+		}, nil
+	})
+}
+
+// replaceDocument updates the value of an existing document, leaving its flags
+// and xattrs intact.
+func replaceDocument(ks *Keyspace, key string, value interface{}) (*mockdb.Document, error) {
+	bytes, err := json.Marshal(stripMissing(value))
+	if err != nil {
+		return nil, err
+	}
+
+	vbID := ks.Store.VbucketForKey([]byte(key))
+	return ks.Store.Update(vbID, ks.CollectionID, []byte(key), func(idoc *mockdb.Document) (*mockdb.Document, error) {
+		if idoc == nil || idoc.IsDeleted {
+			return nil, mockdb.ErrDocNotFound
+		}
+
+		idoc.Value = bytes
+		idoc.Datatype = uint8(memd.DatatypeFlagJSON)
+		// Like the real server, updating a document through the query service
+		// clears its expiry.
+		idoc.Expiry = time.Time{}
+		idoc.Cas = mockdb.GenerateNewCas(ks.Store.Chrono().Now())
+		return idoc, nil
+	})
+}
+
+// removeDocument deletes a document.
+func removeDocument(ks *Keyspace, key string) (*mockdb.Document, error) {
+	vbID := ks.Store.VbucketForKey([]byte(key))
+	return ks.Store.Update(vbID, ks.CollectionID, []byte(key), func(idoc *mockdb.Document) (*mockdb.Document, error) {
+		if idoc == nil || idoc.IsDeleted {
+			return nil, mockdb.ErrDocNotFound
+		}
+
+		idoc.Expiry = ks.Store.Chrono().Now()
+		idoc.IsDeleted = true
+		idoc.Cas = mockdb.GenerateNewCas(ks.Store.Chrono().Now())
+		idoc.Value = []byte{}
+		idoc.Datatype &^= uint8(memd.DatatypeFlagCompressed)
+		// We need to keep the system xattrs, i.e. those which start with an _.
+		for key := range idoc.Xattrs {
+			if !strings.HasPrefix(key, "_") {
+				delete(idoc.Xattrs, key)
+			}
+		}
+		return idoc, nil
+	})
+}
@@ -0,0 +1,173 @@
+package mockn1ql
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokNamedParam
+	tokPositionalParam
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits a statement into its tokens.
+func lex(statement string) ([]token, error) {
+	var tokens []token
+	runes := []rune(statement)
+
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := strings.Index(string(runes[i+2:]), "*/")
+			if end < 0 {
+				return nil, newParseError("unterminated comment at position %d", i)
+			}
+			i += 2 + len([]rune(string(runes[i+2:])[:end])) + 2
+
+		case r == '`':
+			text, next, err := lexQuoted(runes, i, '`')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokQuotedIdent, text: text, pos: i})
+			i = next
+
+		case r == '\'' || r == '"':
+			text, next, err := lexQuoted(runes, i, r)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i})
+			i = next
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+
+		case r == '$':
+			start := i
+			i++
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			name := string(runes[start+1 : i])
+			if name == "" {
+				return nil, newParseError("invalid parameter at position %d", start)
+			}
+			if unicode.IsDigit([]rune(name)[0]) {
+				tokens = append(tokens, token{kind: tokPositionalParam, text: name, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokNamedParam, text: name, pos: start})
+			}
+
+		case r == '?':
+			tokens = append(tokens, token{kind: tokPositionalParam, text: "", pos: i})
+			i++
+
+		case isIdentRune(r):
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch string(runes[i : i+2]) {
+				case "==", "!=", "<>", "<=", ">=", "||":
+					op = string(runes[i : i+2])
+				}
+			}
+			if !strings.Contains("=<>!|+-*/%(),.[]{}:;", op[:1]) {
+				return nil, newParseError("unexpected character '%s' at position %d", op, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len([]rune(op))
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, pos: len(runes)})
+	return tokens, nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lexQuoted reads a quoted string or identifier starting at the opening quote,
+// returning its unescaped contents and the position after the closing quote.
+func lexQuoted(runes []rune, start int, quote rune) (string, int, error) {
+	var sb strings.Builder
+	i := start + 1
+	for i < len(runes) {
+		r := runes[i]
+		if r == quote {
+			// A doubled quote represents a literal quote.
+			if i+1 < len(runes) && runes[i+1] == quote {
+				sb.WriteRune(quote)
+				i += 2
+				continue
+			}
+			return sb.String(), i + 1, nil
+		}
+
+		if r == '\\' && quote != '`' && i+1 < len(runes) {
+			i++
+			switch runes[i] {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			default:
+				sb.WriteRune(runes[i])
+			}
+			i++
+			continue
+		}
+
+		sb.WriteRune(r)
+		i++
+	}
+
+	return "", 0, newParseError("unterminated string at position %d", start)
+}
@@ -0,0 +1,1392 @@
+package mockn1ql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keywords lists the reserved words which cannot be used as implicit aliases
+// or unescaped identifiers within expressions.
+var keywords = map[string]bool{
+	"ALL": true, "AND": true, "AS": true, "ASC": true, "BETWEEN": true,
+	"BUILD": true, "BY": true, "CASE": true, "CREATE": true, "DELETE": true,
+	"DESC": true, "DISTINCT": true, "DROP": true, "ELEMENT": true, "ELSE": true,
+	"END": true, "EXISTS": true, "FALSE": true, "FROM": true, "GROUP": true,
+	"HAVING": true, "IF": true, "IN": true, "INDEX": true, "INSERT": true,
+	"INTO": true, "IS": true, "KEY": true, "KEYS": true, "LIKE": true,
+	"LIMIT": true, "MISSING": true, "NOT": true, "NULL": true, "OFFSET": true,
+	"ON": true, "OR": true, "ORDER": true, "PRIMARY": true, "RAW": true,
+	"RETURNING": true, "SELECT": true, "SET": true, "THEN": true, "TRUE": true,
+	"UNSET": true, "UPDATE": true, "UPSERT": true, "USE": true, "USING": true,
+	"VALUE": true, "VALUED": true, "VALUES": true, "WHEN": true, "WHERE": true,
+	"WITH": true,
+}
+
+type parser struct {
+	text          string
+	tokens        []token
+	pos           int
+	numPositional int
+}
+
+// parseStatement parses a single N1QL statement.
+func parseStatement(text string) (statement, error) {
+	tokens, err := lex(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{text: text, tokens: tokens}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptOp(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.peek().text)
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	tok := p.peek()
+	if tok.kind == tokEOF {
+		return newParseError(format+" at end of input", args...)
+	}
+	return newParseError(format+" near position %d", append(args, tok.pos)...)
+}
+
+func isKeyword(tok token, keyword string) bool {
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	return isKeyword(p.peek(), keyword)
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.errorf("expected %s", keyword)
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.errorf("expected '%s'", op)
+	}
+	return nil
+}
+
+// parseName parses any identifier, including reserved words, or an escaped
+// identifier.
+func (p *parser) parseName() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent && tok.kind != tokQuotedIdent {
+		return "", p.errorf("expected identifier")
+	}
+	p.next()
+	return tok.text, nil
+}
+
+// parseAlias parses an optional alias, which is either introduced by AS or is
+// a bare identifier which is not a reserved word.
+func (p *parser) parseAlias() (string, error) {
+	if p.acceptKeyword("AS") {
+		return p.parseName()
+	}
+
+	tok := p.peek()
+	if tok.kind == tokQuotedIdent || (tok.kind == tokIdent && !keywords[strings.ToUpper(tok.text)]) {
+		p.next()
+		return tok.text, nil
+	}
+
+	return "", nil
+}
+
+func (p *parser) parseStatement() (statement, error) {
+	switch {
+	case p.isKeyword("SELECT"):
+		return p.parseSelect()
+	case p.isKeyword("INSERT"):
+		return p.parseInsert(false)
+	case p.isKeyword("UPSERT"):
+		return p.parseInsert(true)
+	case p.isKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.isKeyword("DELETE"):
+		return p.parseDelete()
+	case p.isKeyword("CREATE"):
+		return p.parseCreateIndex()
+	case p.isKeyword("DROP"):
+		return p.parseDropIndex()
+	case p.isKeyword("BUILD"):
+		return p.parseBuildIndex()
+	case p.isKeyword("PREPARE"):
+		return p.parsePrepare()
+	case p.isKeyword("EXECUTE"):
+		return p.parseExecute()
+	case p.isKeyword("BEGIN"), p.isKeyword("START"):
+		return p.parseBegin()
+	case p.isKeyword("COMMIT"):
+		p.next()
+		p.parseWork()
+		return &commitStmt{}, nil
+	case p.isKeyword("ROLLBACK"):
+		p.next()
+		p.parseWork()
+		return &rollbackStmt{}, nil
+	}
+
+	return nil, p.errorf("unsupported statement")
+}
+
+func (p *parser) parseKeyspace() (*keyspaceRef, error) {
+	ref := &keyspaceRef{}
+
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptOp(":") {
+		ref.namespace = name
+		name, err = p.parseName()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ref.parts = append(ref.parts, name)
+
+	for p.isOp(".") {
+		p.next()
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		ref.parts = append(ref.parts, name)
+	}
+
+	if len(ref.parts) > 3 {
+		return nil, p.errorf("invalid keyspace path")
+	}
+
+	ref.alias, err = p.parseAlias()
+	if err != nil {
+		return nil, err
+	}
+	if ref.alias == "" {
+		ref.alias = ref.parts[len(ref.parts)-1]
+	}
+
+	return ref, nil
+}
+
+func (p *parser) parseUseKeys() (expr, error) {
+	if !p.isKeyword("USE") {
+		return nil, nil
+	}
+	p.next()
+
+	if err := p.expectKeyword("KEYS"); err != nil {
+		return nil, err
+	}
+
+	return p.parseExpr()
+}
+
+func (p *parser) parseProjection() (*projection, error) {
+	proj := &projection{}
+
+	for {
+		if p.acceptKeyword("RAW") || p.acceptKeyword("ELEMENT") || p.acceptKeyword("VALUE") {
+			proj.raw = true
+		} else if p.acceptKeyword("DISTINCT") {
+			proj.distinct = true
+		} else if !p.acceptKeyword("ALL") {
+			break
+		}
+	}
+
+	for {
+		term, err := p.parseResultTerm(proj.raw)
+		if err != nil {
+			return nil, err
+		}
+		proj.terms = append(proj.terms, term)
+
+		if proj.raw || !p.acceptOp(",") {
+			break
+		}
+	}
+
+	return proj, nil
+}
+
+func (p *parser) parseResultTerm(raw bool) (resultTerm, error) {
+	if !raw && p.acceptOp("*") {
+		return resultTerm{star: true}, nil
+	}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return resultTerm{}, err
+	}
+
+	if !raw && p.isOp(".") && p.peekAt(1).kind == tokOp && p.peekAt(1).text == "*" {
+		p.next()
+		p.next()
+		return resultTerm{expr: e, star: true}, nil
+	}
+
+	alias, err := p.parseAlias()
+	if err != nil {
+		return resultTerm{}, err
+	}
+
+	return resultTerm{expr: e, alias: alias}, nil
+}
+
+func (p *parser) parseSelect() (*selectStmt, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	stmt := &selectStmt{}
+
+	proj, err := p.parseProjection()
+	if err != nil {
+		return nil, err
+	}
+	stmt.projection = *proj
+
+	if p.acceptKeyword("FROM") {
+		stmt.from, err = p.parseKeyspace()
+		if err != nil {
+			return nil, err
+		}
+
+		stmt.useKeys, err = p.parseUseKeys()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("WHERE") {
+		stmt.where, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.groupBy = append(stmt.groupBy, e)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+
+		if p.acceptKeyword("HAVING") {
+			stmt.having, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			term := orderTerm{expr: e}
+			if p.acceptKeyword("DESC") {
+				term.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.orderBy = append(stmt.orderBy, term)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	// LIMIT and OFFSET may appear in either order.
+	for {
+		if stmt.limit == nil && p.acceptKeyword("LIMIT") {
+			stmt.limit, err = p.parseExpr()
+		} else if stmt.offset == nil && p.acceptKeyword("OFFSET") {
+			stmt.offset, err = p.parseExpr()
+		} else {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseReturning() (*projection, error) {
+	if !p.acceptKeyword("RETURNING") {
+		return nil, nil
+	}
+
+	return p.parseProjection()
+}
+
+func (p *parser) parseInsert(upsert bool) (*insertStmt, error) {
+	p.next()
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+
+	ks, err := p.parseKeyspace()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &insertStmt{
+		upsert:   upsert,
+		keyspace: *ks,
+	}
+
+	if p.acceptOp("(") {
+		if err := p.expectKeyword("KEY"); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("VALUE"); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		key, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+
+		stmt.values = append(stmt.values, insertValue{key: key, value: value})
+
+		if !p.acceptOp(",") {
+			break
+		}
+		p.acceptKeyword("VALUES")
+	}
+
+	stmt.returning, err = p.parseReturning()
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseUpdate() (*updateStmt, error) {
+	p.next()
+
+	ks, err := p.parseKeyspace()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &updateStmt{
+		keyspace: *ks,
+	}
+
+	stmt.useKeys, err = p.parseUseKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("SET") {
+		for {
+			path, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("="); err != nil {
+				return nil, err
+			}
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.sets = append(stmt.sets, setTerm{path: path, value: value})
+
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("UNSET") {
+		for {
+			path, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			stmt.unsets = append(stmt.unsets, path)
+
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+
+	if len(stmt.sets) == 0 && len(stmt.unsets) == 0 {
+		return nil, p.errorf("expected SET or UNSET")
+	}
+
+	if p.acceptKeyword("WHERE") {
+		stmt.where, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		stmt.limit, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stmt.returning, err = p.parseReturning()
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseDelete() (*deleteStmt, error) {
+	p.next()
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+
+	ks, err := p.parseKeyspace()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &deleteStmt{
+		keyspace: *ks,
+	}
+
+	stmt.useKeys, err = p.parseUseKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("WHERE") {
+		stmt.where, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		stmt.limit, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stmt.returning, err = p.parseReturning()
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// parseIfExists parses an optional IF EXISTS, or IF NOT EXISTS when not is set.
+func (p *parser) parseIfExists(not bool) (bool, error) {
+	if !p.acceptKeyword("IF") {
+		return false, nil
+	}
+	if not {
+		if err := p.expectKeyword("NOT"); err != nil {
+			return false, err
+		}
+	}
+	if err := p.expectKeyword("EXISTS"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// parseUsing parses an optional USING clause, only GSI indexes are supported.
+func (p *parser) parseUsing() error {
+	if !p.acceptKeyword("USING") {
+		return nil
+	}
+
+	name, err := p.parseName()
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(name, "GSI") {
+		return newError(ErrCodeSemantic, "Only GSI indexes are supported, not %s.", strings.ToUpper(name))
+	}
+	return nil
+}
+
+func (p *parser) parseCreateIndex() (*createIndexStmt, error) {
+	p.next()
+
+	stmt := &createIndexStmt{
+		primary: p.acceptKeyword("PRIMARY"),
+	}
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+
+	var err error
+	stmt.ifNotExists, err = p.parseIfExists(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.isKeyword("ON") {
+		stmt.name, err = p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		if !stmt.ifNotExists {
+			stmt.ifNotExists, err = p.parseIfExists(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if !stmt.primary {
+		return nil, p.errorf("expected index name")
+	}
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	ks, err := p.parseKeyspace()
+	if err != nil {
+		return nil, err
+	}
+	stmt.keyspace = *ks
+
+	if !stmt.primary {
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			key := indexKey{expr: e}
+			if p.acceptKeyword("DESC") {
+				key.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.keys = append(stmt.keys, key)
+
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+
+		if p.acceptKeyword("WHERE") {
+			stmt.where, err = p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := p.parseUsing(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("WITH") {
+		stmt.with, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseDropIndex() (*dropIndexStmt, error) {
+	p.next()
+
+	stmt := &dropIndexStmt{
+		primary: p.acceptKeyword("PRIMARY"),
+	}
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+
+	var err error
+	stmt.ifExists, err = p.parseIfExists(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if stmt.primary && p.isKeyword("ON") {
+		p.next()
+		ks, err := p.parseKeyspace()
+		if err != nil {
+			return nil, err
+		}
+		stmt.keyspace = *ks
+		return stmt, p.parseUsing()
+	}
+
+	// Indexes can either be referenced as name ON keyspace, or using the older
+	// form of keyspace.name.
+	var namespace string
+	var parts []string
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptOp(":") {
+		namespace = name
+		name, err = p.parseName()
+		if err != nil {
+			return nil, err
+		}
+	}
+	parts = append(parts, name)
+	for p.acceptOp(".") {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, name)
+	}
+
+	if p.acceptKeyword("ON") {
+		if len(parts) != 1 || namespace != "" {
+			return nil, p.errorf("invalid index name")
+		}
+		stmt.name = parts[0]
+
+		ks, err := p.parseKeyspace()
+		if err != nil {
+			return nil, err
+		}
+		stmt.keyspace = *ks
+	} else {
+		if len(parts) != 2 && len(parts) != 4 {
+			return nil, p.errorf("expected keyspace and index name")
+		}
+		stmt.name = parts[len(parts)-1]
+		stmt.keyspace = keyspaceRef{
+			namespace: namespace,
+			parts:     parts[:len(parts)-1],
+		}
+	}
+
+	return stmt, p.parseUsing()
+}
+
+func (p *parser) parseBuildIndex() (*buildIndexStmt, error) {
+	p.next()
+
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+
+	ks, err := p.parseKeyspace()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &buildIndexStmt{
+		keyspace: *ks,
+	}
+
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.names = append(stmt.names, e)
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	return stmt, p.parseUsing()
+}
+
+// parsePreparedName parses the name of a prepared statement, which can be
+// given either as an identifier or as a string.
+func (p *parser) parsePreparedName() (string, error) {
+	if tok := p.peek(); tok.kind == tokString {
+		p.next()
+		return tok.text, nil
+	}
+	return p.parseName()
+}
+
+func (p *parser) parsePrepare() (*prepareStmt, error) {
+	p.next()
+
+	stmt := &prepareStmt{}
+
+	// FORCE is only a modifier when it is not itself the name of the statement.
+	if !isKeyword(p.peekAt(1), "FROM") && !isKeyword(p.peekAt(1), "AS") {
+		stmt.force = p.acceptKeyword("FORCE")
+	}
+
+	if isKeyword(p.peekAt(1), "FROM") || isKeyword(p.peekAt(1), "AS") {
+		name, err := p.parsePreparedName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.name = name
+		p.next()
+	}
+
+	// Token positions are counted in runes rather than bytes.
+	text := string([]rune(p.text)[p.peek().pos:])
+	stmt.text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), ";"))
+
+	if p.isKeyword("PREPARE") || p.isKeyword("EXECUTE") {
+		return nil, p.errorf("cannot prepare %s", strings.ToUpper(p.peek().text))
+	}
+
+	inner, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	stmt.inner = inner
+
+	return stmt, nil
+}
+
+func (p *parser) parseExecute() (*executeStmt, error) {
+	p.next()
+
+	name, err := p.parsePreparedName()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &executeStmt{
+		name: name,
+	}
+
+	if p.acceptKeyword("USING") {
+		stmt.using, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmt, nil
+}
+
+// parseWork skips the optional WORK or TRANSACTION keyword which follows the
+// transaction statements.
+func (p *parser) parseWork() {
+	if !p.acceptKeyword("WORK") {
+		p.acceptKeyword("TRANSACTION")
+	}
+}
+
+func (p *parser) parseBegin() (*beginStmt, error) {
+	p.next()
+
+	if !p.acceptKeyword("WORK") {
+		if err := p.expectKeyword("TRANSACTION"); err != nil {
+			return nil, err
+		}
+	}
+
+	// READ COMMITTED is the only isolation level which is supported.
+	if p.acceptKeyword("ISOLATION") {
+		for _, keyword := range []string{"LEVEL", "READ", "COMMITTED"} {
+			if err := p.expectKeyword(keyword); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &beginStmt{}, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", operand: operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.kind == tokOp {
+		switch tok.text {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+
+			op := tok.text
+			if op == "==" {
+				op = "="
+			} else if op == "<>" {
+				op = "!="
+			}
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+		return left, nil
+	}
+
+	not := false
+	if p.isKeyword("NOT") {
+		next := p.peekAt(1)
+		if isKeyword(next, "LIKE") || isKeyword(next, "IN") || isKeyword(next, "BETWEEN") {
+			p.next()
+			not = true
+		}
+	}
+
+	switch {
+	case p.acceptKeyword("LIKE"):
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		var e expr = &binaryExpr{op: "LIKE", left: left, right: right}
+		if not {
+			e = &unaryExpr{op: "NOT", operand: e}
+		}
+		return e, nil
+
+	case p.acceptKeyword("IN"):
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		var e expr = &binaryExpr{op: "IN", left: left, right: right}
+		if not {
+			e = &unaryExpr{op: "NOT", operand: e}
+		}
+		return e, nil
+
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{operand: left, low: low, high: high, not: not}, nil
+
+	case p.acceptKeyword("IS"):
+		isNot := p.acceptKeyword("NOT")
+		switch {
+		case p.acceptKeyword("NULL"):
+			return &isExpr{operand: left, what: "NULL", not: isNot}, nil
+		case p.acceptKeyword("MISSING"):
+			return &isExpr{operand: left, what: "MISSING", not: isNot}, nil
+		case p.acceptKeyword("VALUED"):
+			return &isExpr{operand: left, what: "VALUED", not: isNot}, nil
+		}
+		return nil, p.errorf("expected NULL, MISSING or VALUED")
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseConcat() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptOp("||") {
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "||", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.acceptOp("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := operand.(*literalExpr); ok {
+			if num, ok := lit.value.(float64); ok {
+				return &literalExpr{value: -num}, nil
+			}
+		}
+		return &unaryExpr{op: "-", operand: operand}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.isOp(".") {
+			// A trailing .* is handled by the projection.
+			if next := p.peekAt(1); next.kind == tokOp && next.text == "*" {
+				return e, nil
+			}
+			p.next()
+
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			e = &fieldExpr{target: e, name: name}
+		} else if p.acceptOp("[") {
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{target: e, index: index}
+		} else {
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	tok := p.peek()
+
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, newParseError("invalid number '%s'", tok.text)
+		}
+		return &literalExpr{value: num}, nil
+
+	case tokString:
+		p.next()
+		return &literalExpr{value: tok.text}, nil
+
+	case tokNamedParam:
+		p.next()
+		return &paramExpr{name: tok.text}, nil
+
+	case tokPositionalParam:
+		p.next()
+		if tok.text == "" {
+			p.numPositional++
+			return &paramExpr{position: p.numPositional}, nil
+		}
+		position, err := strconv.Atoi(tok.text)
+		if err != nil || position < 1 {
+			return nil, newParseError("invalid parameter '$%s'", tok.text)
+		}
+		return &paramExpr{position: position}, nil
+
+	case tokQuotedIdent:
+		p.next()
+		return &identExpr{name: tok.text}, nil
+
+	case tokOp:
+		switch tok.text {
+		case "(":
+			p.next()
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		case "[":
+			return p.parseArray()
+		case "{":
+			return p.parseObject()
+		}
+
+	case tokIdent:
+		upper := strings.ToUpper(tok.text)
+		switch upper {
+		case "TRUE":
+			p.next()
+			return &literalExpr{value: true}, nil
+		case "FALSE":
+			p.next()
+			return &literalExpr{value: false}, nil
+		case "NULL":
+			p.next()
+			return &literalExpr{value: nil}, nil
+		case "MISSING":
+			p.next()
+			return &literalExpr{value: missing}, nil
+		case "CASE":
+			return p.parseCase()
+		}
+
+		if next := p.peekAt(1); next.kind == tokOp && next.text == "(" {
+			return p.parseFunction()
+		}
+
+		if keywords[upper] {
+			return nil, p.errorf("unexpected '%s'", tok.text)
+		}
+
+		p.next()
+		return &identExpr{name: tok.text}, nil
+	}
+
+	if tok.kind == tokEOF {
+		return nil, p.errorf("unexpected end of statement")
+	}
+	return nil, p.errorf("unexpected '%s'", tok.text)
+}
+
+func (p *parser) parseArray() (expr, error) {
+	p.next()
+
+	e := &arrayExpr{}
+	if p.acceptOp("]") {
+		return e, nil
+	}
+
+	for {
+		elem, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.elems = append(e.elems, elem)
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if err := p.expectOp("]"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *parser) parseObject() (expr, error) {
+	p.next()
+
+	e := &objectExpr{}
+	if p.acceptOp("}") {
+		return e, nil
+	}
+
+	for {
+		tok := p.next()
+		if tok.kind != tokString && tok.kind != tokIdent && tok.kind != tokQuotedIdent {
+			return nil, p.errorf("expected object key")
+		}
+		if err := p.expectOp(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.keys = append(e.keys, tok.text)
+		e.values = append(e.values, value)
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if err := p.expectOp("}"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *parser) parseCase() (expr, error) {
+	p.next()
+
+	e := &caseExpr{}
+	if !p.isKeyword("WHEN") {
+		operand, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.operand = operand
+	}
+
+	for p.acceptKeyword("WHEN") {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.whens = append(e.whens, caseWhen{cond: cond, result: result})
+	}
+
+	if len(e.whens) == 0 {
+		return nil, p.errorf("expected WHEN")
+	}
+
+	if p.acceptKeyword("ELSE") {
+		elseExpr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.elseExpr = elseExpr
+	}
+
+	if err := p.expectKeyword("END"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *parser) parseFunction() (expr, error) {
+	name := strings.ToUpper(p.next().text)
+	p.next()
+
+	e := &funcExpr{name: name}
+	if p.acceptOp(")") {
+		return e, nil
+	}
+
+	if p.acceptOp("*") {
+		e.star = true
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	if p.acceptKeyword("DISTINCT") {
+		e.distinct = true
+	} else {
+		p.acceptKeyword("ALL")
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		e.args = append(e.args, arg)
+
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
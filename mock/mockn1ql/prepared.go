@@ -0,0 +1,273 @@
+package mockn1ql
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// preparedStatement represents a statement which has been prepared.  Plans
+// depend on the indexes of the buckets they reference, so we record the
+// index version of each of those buckets in order to detect stale plans.
+type preparedStatement struct {
+	Name          string            `json:"name"`
+	Text          string            `json:"text"`
+	QueryContext  string            `json:"query_context,omitempty"`
+	IndexVersions map[string]uint64 `json:"index_versions"`
+}
+
+func (e *Engine) bumpIndexVersionLocked(bucket string) {
+	if e.indexVersions == nil {
+		e.indexVersions = make(map[string]uint64)
+	}
+	e.indexVersions[bucket]++
+}
+
+func (e *Engine) isPlanStale(prep *preparedStatement) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for bucket, version := range prep.IndexVersions {
+		if e.indexVersions[bucket] != version {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) getPrepared(name string) *preparedStatement {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.prepared[name]
+}
+
+func (e *Engine) storePrepared(prep *preparedStatement) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.prepared == nil {
+		e.prepared = make(map[string]*preparedStatement)
+	}
+	e.prepared[prep.Name] = prep
+}
+
+func (e *Engine) removePrepared(name string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	delete(e.prepared, name)
+}
+
+func encodePlan(prep *preparedStatement) string {
+	bytes, _ := json.Marshal(prep)
+	return base64.StdEncoding.EncodeToString(bytes)
+}
+
+func decodePlan(name, encoded string) (*preparedStatement, error) {
+	bytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newError(ErrCodePreparedDecoding, "Unable to decode prepared statement - cause: %s", err)
+	}
+
+	var prep preparedStatement
+	if err := json.Unmarshal(bytes, &prep); err != nil {
+		return nil, newError(ErrCodePreparedDecoding, "Unable to decode prepared statement - cause: %s", err)
+	}
+	if prep.Name != name {
+		return nil, newError(ErrCodePreparedEncodingMismatch,
+			"Encoded plan parameter does not match encoded plan of %s", name)
+	}
+
+	return &prep, nil
+}
+
+// statementKeyspaces returns the keyspaces which a statement refers to.
+func statementKeyspaces(stmt statement) []*keyspaceRef {
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		if stmt.from != nil && stmt.from.namespace != "system" {
+			return []*keyspaceRef{stmt.from}
+		}
+	case *insertStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	case *updateStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	case *deleteStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	case *createIndexStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	case *dropIndexStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	case *buildIndexStmt:
+		return []*keyspaceRef{&stmt.keyspace}
+	}
+	return nil
+}
+
+func (x *execution) executePrepare(stmt *prepareStmt) error {
+	prep := &preparedStatement{
+		Name:          stmt.name,
+		Text:          stmt.text,
+		QueryContext:  x.opts.QueryContext,
+		IndexVersions: make(map[string]uint64),
+	}
+	if prep.Name == "" {
+		prep.Name = uuid.New().String()
+	}
+
+	for _, ref := range statementKeyspaces(stmt.inner) {
+		path, err := x.queryContext.resolvePath(ref)
+		if err != nil {
+			return err
+		}
+		x.engine.lock.Lock()
+		prep.IndexVersions[path.Bucket] = x.engine.indexVersions[path.Bucket]
+		x.engine.lock.Unlock()
+	}
+
+	if existing := x.engine.getPrepared(prep.Name); existing != nil && !stmt.force {
+		if existing.Text != prep.Text || existing.QueryContext != prep.QueryContext {
+			return newError(ErrCodePreparedName, "Unable to add name: duplicate name: %s", prep.Name)
+		}
+	}
+	x.engine.storePrepared(prep)
+
+	if x.opts.AutoExecute {
+		x.results.Prepared = prep.Name
+		return x.executeStatement(stmt.inner)
+	}
+
+	row := map[string]interface{}{
+		"name":         prep.Name,
+		"encoded_plan": encodePlan(prep),
+		"namespace":    "default",
+		"operator": map[string]interface{}{
+			"#operator": "Sequence",
+		},
+		"signature": x.statementSignature(stmt.inner),
+		"text":      "PREPARE " + prep.Text,
+	}
+	if prep.QueryContext != "" {
+		row["queryContext"] = prep.QueryContext
+	}
+
+	encoded, err := encodeValue(row)
+	if err != nil {
+		return err
+	}
+
+	x.results.Signature = "json"
+	x.results.Rows = append(x.results.Rows, encoded)
+	return nil
+}
+
+// statementSignature returns the signature which executing a statement will
+// produce, which is reported when it is prepared.
+func (x *execution) statementSignature(stmt statement) interface{} {
+	var proj *projection
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		proj = &stmt.projection
+	case *insertStmt:
+		proj = stmt.returning
+	case *updateStmt:
+		proj = stmt.returning
+	case *deleteStmt:
+		proj = stmt.returning
+	}
+	if proj == nil {
+		return nil
+	}
+	return projectionSignature(proj)
+}
+
+// lookupPrepared finds the prepared statement to execute, falling back to the
+// encoded plan if the statement is not known, as the real query service does
+// when a statement was prepared on a different node.
+func (x *execution) lookupPrepared(name, encodedPlan string) (*preparedStatement, error) {
+	prep := x.engine.getPrepared(name)
+	if prep != nil && x.engine.isPlanStale(prep) {
+		// The indexes which the plan was built from have changed, so it is no
+		// longer usable and must be prepared again.
+		x.engine.removePrepared(name)
+		prep = nil
+	}
+
+	if encodedPlan == "" {
+		if prep == nil {
+			return nil, newError(ErrCodeNoSuchPrepared, "No such prepared statement: %s", name)
+		}
+		return prep, nil
+	}
+
+	decoded, err := decodePlan(name, encodedPlan)
+	if err != nil {
+		return nil, err
+	}
+
+	if prep != nil {
+		if !reflect.DeepEqual(prep, decoded) {
+			return nil, newError(ErrCodePreparedEncodingMismatch,
+				"Encoded plan parameter does not match encoded plan of %s", name)
+		}
+		return prep, nil
+	}
+
+	if x.engine.isPlanStale(decoded) {
+		return nil, newError(ErrCodePreparedDecoding,
+			"Unable to decode prepared statement - cause: plan references indexes which have changed")
+	}
+
+	x.engine.storePrepared(decoded)
+	return decoded, nil
+}
+
+// runPrepared executes a prepared statement within this execution.
+func (x *execution) runPrepared(prep *preparedStatement) error {
+	stmt, err := parseStatement(prep.Text)
+	if err != nil {
+		return err
+	}
+
+	x.queryContext, err = parseQueryContext(prep.QueryContext)
+	if err != nil {
+		return err
+	}
+
+	return x.executeStatement(stmt)
+}
+
+func (x *execution) executeExecute(stmt *executeStmt) error {
+	prep, err := x.lookupPrepared(stmt.name, "")
+	if err != nil {
+		return err
+	}
+
+	if stmt.using != nil {
+		using, err := eval(x.baseContext(), stmt.using)
+		if err != nil {
+			return err
+		}
+
+		params := &queryParams{
+			named: make(map[string]interface{}),
+		}
+		switch args := using.(type) {
+		case []interface{}:
+			params.positional = args
+		case map[string]interface{}:
+			for name, val := range args {
+				params.named[strings.TrimPrefix(name, "$")] = val
+			}
+		default:
+			return newError(ErrCodeSemantic, "EXECUTE USING requires an array or an object, not %s.", typeName(using))
+		}
+		x.params = params
+	}
+
+	return x.runPrepared(prep)
+}
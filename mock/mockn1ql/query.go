@@ -1,29 +1,903 @@
 package mockn1ql
 
-import "github.com/couchbaselabs/gocaves/mock/mockdb"
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-// Index represents a single view index.
-type Index struct {
-	BucketName string
-	Fields     []string
-}
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
 
 // Engine represents the mock query engine.
 type Engine struct {
-	Indexes []*Index
+	chrono            *mocktime.Chrono
+	indexLatency      time.Duration
+	indexBuildLatency time.Duration
+
+	lock          sync.Mutex
+	indexes       []*Index
+	indexVersions map[string]uint64
+	prepared      map[string]*preparedStatement
+	transactions  map[string]*transaction
+}
+
+// NewEngineOptions provides options when creating a new query engine.  The
+// IndexLatency specifies how far indexes trail behind the data, with zero
+// meaning that they are always up to date.
+type NewEngineOptions struct {
+	Chrono            *mocktime.Chrono
+	IndexLatency      time.Duration
+	IndexBuildLatency time.Duration
+}
+
+// NewEngine creates a new query engine.
+func NewEngine(opts NewEngineOptions) *Engine {
+	if opts.Chrono == nil {
+		opts.Chrono = &mocktime.Chrono{}
+	}
+	if opts.IndexBuildLatency == 0 {
+		opts.IndexBuildLatency = 100 * time.Millisecond
+	}
+
+	return &Engine{
+		chrono:            opts.Chrono,
+		indexLatency:      opts.IndexLatency,
+		indexBuildLatency: opts.IndexBuildLatency,
+	}
 }
 
 // ExecuteOptions provides options when executing an query.
 type ExecuteOptions struct {
-	Data map[string]*mockdb.Bucket
+	Statement      string
+	NamedArgs      map[string]interface{}
+	PositionalArgs []interface{}
+	QueryContext   string
+	Keyspaces      KeyspaceResolver
+
+	ScanConsistency string
+	ScanVectors     map[string]ScanVector
+	ScanWait        time.Duration
+
+	// Prepared names a prepared statement to execute instead of Statement,
+	// optionally along with the EncodedPlan returned when it was prepared.
+	Prepared    string
+	EncodedPlan string
+
+	// AutoExecute causes PREPARE statements to also execute the statement
+	// they prepare, as part of the enhanced prepared statement protocol.
+	AutoExecute bool
+
+	// TxID identifies the transaction started by BEGIN WORK which the
+	// statement executes within, while TxImplicit executes the statement
+	// within a transaction of its own.
+	TxID       string
+	TxImplicit bool
+	TxTimeout  time.Duration
 }
 
-// ExecuteResults provides the results from an executed query.
+// ExecuteResults provides the results from an executed query.  Errors holds
+// any errors which occurred for individual documents during a DML statement,
+// which do not prevent the rest of the statement from executing.  Prepared
+// holds the name of the statement which was prepared when AutoExecute is used.
 type ExecuteResults struct {
-	Rows []interface{}
+	Rows          []json.RawMessage
+	Signature     interface{}
+	MutationCount int
+	Errors        []*Error
+	Prepared      string
+}
+
+type execution struct {
+	engine       *Engine
+	analytics    *AnalyticsEngine
+	opts         ExecuteOptions
+	params       *queryParams
+	queryContext *queryContext
+	results      *ExecuteResults
+	tx           *transaction
 }
 
 // Execute executes a query.
 func (e *Engine) Execute(opts ExecuteOptions) (*ExecuteResults, error) {
-	return nil, nil
+	qc, err := parseQueryContext(opts.QueryContext)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateScanConsistency(opts); err != nil {
+		return nil, err
+	}
+
+	x := &execution{
+		engine:       e,
+		opts:         opts,
+		params:       newQueryParams(opts),
+		queryContext: qc,
+		results:      &ExecuteResults{},
+	}
+
+	if opts.TxID != "" {
+		x.tx, err = e.getTransaction(opts.TxID)
+		if err != nil {
+			return nil, err
+		}
+
+		x.tx.lock.Lock()
+		defer x.tx.lock.Unlock()
+
+		// Statements within a transaction always read their own writes.
+		if x.opts.ScanConsistency == "" {
+			x.opts.ScanConsistency = ScanConsistencyRequestPlus
+		}
+	}
+
+	if opts.Prepared != "" {
+		var prep *preparedStatement
+		prep, err = x.lookupPrepared(opts.Prepared, opts.EncodedPlan)
+		if err == nil {
+			err = x.runPrepared(prep)
+		}
+	} else {
+		var stmt statement
+		stmt, err = parseStatement(opts.Statement)
+		if err == nil {
+			if opts.TxImplicit && opts.TxID == "" {
+				err = x.executeImplicit(stmt)
+			} else {
+				err = x.executeStatement(stmt)
+			}
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			err = newError(ErrCodeInternal, "%s", err)
+		}
+		return nil, err
+	}
+
+	if x.results.Rows == nil {
+		x.results.Rows = []json.RawMessage{}
+	}
+
+	return x.results, nil
+}
+
+func (x *execution) executeStatement(stmt statement) error {
+	if x.tx != nil {
+		switch stmt.(type) {
+		case *createIndexStmt, *dropIndexStmt, *buildIndexStmt:
+			return newError(ErrCodeTransactionContext,
+				"Transaction context error: index statements are not supported within a transaction")
+		}
+	}
+
+	switch stmt := stmt.(type) {
+	case *selectStmt:
+		return x.executeSelect(stmt)
+	case *insertStmt:
+		return x.executeInsert(stmt)
+	case *updateStmt:
+		return x.executeUpdate(stmt)
+	case *deleteStmt:
+		return x.executeDelete(stmt)
+	case *createIndexStmt:
+		return x.executeCreateIndex(stmt)
+	case *dropIndexStmt:
+		return x.executeDropIndex(stmt)
+	case *buildIndexStmt:
+		return x.executeBuildIndex(stmt)
+	case *prepareStmt:
+		return x.executePrepare(stmt)
+	case *executeStmt:
+		return x.executeExecute(stmt)
+	case *beginStmt:
+		return x.executeBegin(stmt)
+	case *commitStmt:
+		return x.executeCommit(stmt)
+	case *rollbackStmt:
+		return x.executeRollback(stmt)
+	}
+
+	return newError(ErrCodeInternal, "unsupported statement")
+}
+
+func newQueryParams(opts ExecuteOptions) *queryParams {
+	params := &queryParams{
+		named: make(map[string]interface{}),
+	}
+
+	// Named parameters may be provided with or without their leading $.
+	for name, val := range opts.NamedArgs {
+		params.named[strings.TrimPrefix(name, "$")] = normalizeValue(val)
+	}
+	for _, val := range opts.PositionalArgs {
+		params.positional = append(params.positional, normalizeValue(val))
+	}
+
+	return params
+}
+
+func (x *execution) baseContext() *evalContext {
+	return &evalContext{
+		params: x.params,
+	}
+}
+
+// evalCount evaluates a LIMIT or OFFSET expression.
+func (x *execution) evalCount(e expr, name string) (int, error) {
+	val, err := eval(x.baseContext(), e)
+	if err != nil {
+		return 0, err
+	}
+
+	num, ok := val.(float64)
+	if !ok || num < 0 {
+		return 0, newError(ErrCodeSemantic, "Invalid %s value %v", name, val)
+	}
+
+	return int(num), nil
+}
+
+// filterRows returns the rows which match a WHERE clause.
+func (x *execution) filterRows(c *evalContext, rows []*queryRow, where expr) ([]*queryRow, error) {
+	if where == nil {
+		return rows, nil
+	}
+
+	var out []*queryRow
+	for _, row := range rows {
+		val, err := eval(c.withRow(row), where)
+		if err != nil {
+			return nil, err
+		}
+		if toTriState(val) == true {
+			out = append(out, row)
+		}
+	}
+
+	return out, nil
+}
+
+// projectionNames returns the name of each result term in a projection, where
+// unnamed expressions are given names based on their position.
+func projectionNames(proj *projection) []string {
+	names := make([]string, len(proj.terms))
+	nextPos := 1
+	for i, term := range proj.terms {
+		if term.star {
+			continue
+		}
+
+		name := term.alias
+		if name == "" {
+			switch e := term.expr.(type) {
+			case *identExpr:
+				name = e.name
+			case *fieldExpr:
+				name = e.name
+			}
+		}
+		if name == "" {
+			name = "$" + strconv.Itoa(nextPos)
+			nextPos++
+		}
+
+		names[i] = name
+	}
+	return names
+}
+
+func projectionSignature(proj *projection) interface{} {
+	if proj.raw {
+		return "json"
+	}
+
+	sig := make(map[string]interface{})
+	for i, name := range projectionNames(proj) {
+		if proj.terms[i].star {
+			sig["*"] = "*"
+		} else {
+			sig[name] = "json"
+		}
+	}
+	return sig
+}
+
+// project evaluates a projection against a row, returning the projected value,
+// which is MISSING for a RAW projection of a MISSING value.
+func (x *execution) project(c *evalContext, proj *projection, names []string) (interface{}, error) {
+	if proj.raw {
+		return eval(c, proj.terms[0].expr)
+	}
+
+	out := make(map[string]interface{})
+	for i, term := range proj.terms {
+		if term.star && term.expr == nil {
+			row := c.row
+			if row == nil && len(c.group) > 0 {
+				row = c.group[0]
+			}
+			if row != nil && c.alias != "" && !isMissing(row.value) {
+				out[c.alias] = row.value
+			}
+			continue
+		}
+
+		val, err := eval(c, term.expr)
+		if err != nil {
+			return nil, err
+		}
+
+		if term.star {
+			if obj, ok := val.(map[string]interface{}); ok {
+				for key, fieldVal := range obj {
+					out[key] = fieldVal
+				}
+			}
+			continue
+		}
+
+		if !isMissing(val) {
+			out[names[i]] = val
+		}
+	}
+
+	return out, nil
+}
+
+func encodeValue(val interface{}) (json.RawMessage, error) {
+	bytes, err := json.Marshal(stripMissing(val))
+	if err != nil {
+		return nil, newError(ErrCodeInternal, "failed to encode result: %s", err)
+	}
+	return bytes, nil
+}
+
+type selectResult struct {
+	encoded json.RawMessage
+	context *evalContext
+}
+
+func (x *execution) executeSelect(stmt *selectStmt) error {
+	if stmt.where != nil && containsAggregate(stmt.where) {
+		return newError(ErrCodeSemantic, "Aggregates not allowed in WHERE.")
+	}
+
+	c := x.baseContext()
+
+	var rows []*queryRow
+	if stmt.from != nil && x.analytics != nil {
+		var err error
+		rows, err = x.analytics.readDataset(x, stmt.from)
+		if err != nil {
+			return err
+		}
+
+		c.alias = stmt.from.alias
+	} else if stmt.from != nil && stmt.from.namespace == "system" {
+		var err error
+		rows, err = x.readSystemKeyspace(stmt.from)
+		if err != nil {
+			return err
+		}
+
+		c.alias = stmt.from.alias
+	} else if stmt.from != nil {
+		ks, path, err := x.resolveKeyspace(stmt.from, KeyspaceAccessRead)
+		if err != nil {
+			return err
+		}
+
+		if stmt.useKeys == nil {
+			if err := x.checkIndexAvailable(path, stmt.from.alias, stmt.where); err != nil {
+				return err
+			}
+		}
+
+		rows, err = x.readKeyspace(c, ks, path, stmt.useKeys)
+		if err != nil {
+			return err
+		}
+
+		c.alias = stmt.from.alias
+	} else {
+		rows = []*queryRow{{value: missing}}
+	}
+
+	rows, err := x.filterRows(c, rows, stmt.where)
+	if err != nil {
+		return err
+	}
+
+	// Each entry here is the context that a projection is evaluated in, which
+	// is either a single row or a group of rows when aggregating.
+	var contexts []*evalContext
+	if x.isAggregating(stmt) {
+		contexts, err = x.groupRows(c, rows, stmt)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, row := range rows {
+			contexts = append(contexts, c.withRow(row))
+		}
+	}
+
+	names := projectionNames(&stmt.projection)
+
+	var results []*selectResult
+	seen := make(map[string]bool)
+	for _, rowCtx := range contexts {
+		val, err := x.project(rowCtx, &stmt.projection, names)
+		if err != nil {
+			return err
+		}
+		if isMissing(val) {
+			continue
+		}
+
+		encoded, err := encodeValue(val)
+		if err != nil {
+			return err
+		}
+
+		if stmt.projection.distinct {
+			if seen[string(encoded)] {
+				continue
+			}
+			seen[string(encoded)] = true
+		}
+
+		// ORDER BY is able to refer to the names of projected fields.
+		if obj, ok := val.(map[string]interface{}); ok {
+			rowCtx.projected = obj
+		}
+
+		results = append(results, &selectResult{
+			encoded: encoded,
+			context: rowCtx,
+		})
+	}
+
+	if len(stmt.orderBy) > 0 {
+		if err := x.sortResults(results, stmt.orderBy); err != nil {
+			return err
+		}
+	}
+
+	if stmt.offset != nil {
+		offset, err := x.evalCount(stmt.offset, "OFFSET")
+		if err != nil {
+			return err
+		}
+		if offset > len(results) {
+			offset = len(results)
+		}
+		results = results[offset:]
+	}
+
+	if stmt.limit != nil {
+		limit, err := x.evalCount(stmt.limit, "LIMIT")
+		if err != nil {
+			return err
+		}
+		if limit < len(results) {
+			results = results[:limit]
+		}
+	}
+
+	x.results.Signature = projectionSignature(&stmt.projection)
+	x.results.Rows = make([]json.RawMessage, 0, len(results))
+	for _, res := range results {
+		x.results.Rows = append(x.results.Rows, res.encoded)
+	}
+
+	return nil
+}
+
+func (x *execution) isAggregating(stmt *selectStmt) bool {
+	if len(stmt.groupBy) > 0 || stmt.having != nil {
+		return true
+	}
+	for _, term := range stmt.projection.terms {
+		if term.expr != nil && containsAggregate(term.expr) {
+			return true
+		}
+	}
+	for _, term := range stmt.orderBy {
+		if containsAggregate(term.expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupRows splits rows into groups based on the GROUP BY clause, returning
+// an evaluation context for each group which passes the HAVING clause.
+// Without a GROUP BY clause all of the rows form a single group.
+func (x *execution) groupRows(c *evalContext, rows []*queryRow, stmt *selectStmt) ([]*evalContext, error) {
+	var contexts []*evalContext
+
+	if len(stmt.groupBy) == 0 {
+		groupCtx := &evalContext{
+			params: c.params,
+			alias:  c.alias,
+			group:  rows,
+		}
+		if groupCtx.group == nil {
+			groupCtx.group = []*queryRow{}
+		}
+		if len(rows) > 0 {
+			groupCtx.row = rows[0]
+		}
+		contexts = append(contexts, groupCtx)
+	} else {
+		groupIdxs := make(map[string]int)
+		for _, row := range rows {
+			var groupKey []interface{}
+			for _, groupExpr := range stmt.groupBy {
+				val, err := eval(c.withRow(row), groupExpr)
+				if err != nil {
+					return nil, err
+				}
+				groupKey = append(groupKey, typeName(val), val)
+			}
+
+			encodedKey, err := encodeValue(groupKey)
+			if err != nil {
+				return nil, err
+			}
+
+			if idx, ok := groupIdxs[string(encodedKey)]; ok {
+				contexts[idx].group = append(contexts[idx].group, row)
+				continue
+			}
+
+			groupIdxs[string(encodedKey)] = len(contexts)
+			contexts = append(contexts, &evalContext{
+				params: c.params,
+				alias:  c.alias,
+				row:    row,
+				group:  []*queryRow{row},
+			})
+		}
+	}
+
+	if stmt.having == nil {
+		return contexts, nil
+	}
+
+	var filtered []*evalContext
+	for _, groupCtx := range contexts {
+		val, err := eval(groupCtx, stmt.having)
+		if err != nil {
+			return nil, err
+		}
+		if toTriState(val) == true {
+			filtered = append(filtered, groupCtx)
+		}
+	}
+	return filtered, nil
+}
+
+func (x *execution) sortResults(results []*selectResult, orderBy []orderTerm) error {
+	keys := make([][]interface{}, len(results))
+	for i, res := range results {
+		for _, term := range orderBy {
+			val, err := eval(res.context, term.expr)
+			if err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], val)
+		}
+	}
+
+	idxs := make([]int, len(results))
+	for i := range idxs {
+		idxs[i] = i
+	}
+
+	sort.SliceStable(idxs, func(a, b int) bool {
+		aKeys, bKeys := keys[idxs[a]], keys[idxs[b]]
+		for i, term := range orderBy {
+			cmp := collate(aKeys[i], bKeys[i])
+			if cmp == 0 {
+				continue
+			}
+			if term.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	sorted := make([]*selectResult, len(results))
+	for i, idx := range idxs {
+		sorted[i] = results[idx]
+	}
+	copy(results, sorted)
+
+	return nil
+}
+
+// appendReturning adds the RETURNING projection for a mutated row to the
+// results of a DML statement.
+func (x *execution) appendReturning(c *evalContext, proj *projection, row *queryRow) error {
+	if proj == nil {
+		return nil
+	}
+
+	val, err := x.project(c.withRow(row), proj, projectionNames(proj))
+	if err != nil {
+		return err
+	}
+	if isMissing(val) {
+		return nil
+	}
+
+	encoded, err := encodeValue(val)
+	if err != nil {
+		return err
+	}
+
+	x.results.Rows = append(x.results.Rows, encoded)
+	return nil
+}
+
+func (x *execution) finishDML(proj *projection) {
+	if proj != nil {
+		x.results.Signature = projectionSignature(proj)
+	}
+	if x.results.Rows == nil {
+		x.results.Rows = []json.RawMessage{}
+	}
+}
+
+func (x *execution) executeInsert(stmt *insertStmt) error {
+	ks, _, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessInsert)
+	if err != nil {
+		return err
+	}
+
+	if stmt.upsert {
+		// Upserts need to be able to overwrite existing documents as well.
+		if _, _, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessUpdate); err != nil {
+			return err
+		}
+	}
+
+	c := x.baseContext()
+	c.alias = stmt.keyspace.alias
+
+	for _, insertVal := range stmt.values {
+		keyVal, err := eval(c, insertVal.key)
+		if err != nil {
+			return err
+		}
+		value, err := eval(c, insertVal.value)
+		if err != nil {
+			return err
+		}
+
+		key, ok := keyVal.(string)
+		if !ok {
+			x.results.Errors = append(x.results.Errors, newError(ErrCodeDML,
+				"Cannot INSERT non-string key %v of type %s.", keyVal, typeName(keyVal)))
+			continue
+		}
+		if isMissing(value) {
+			x.results.Errors = append(x.results.Errors, newError(ErrCodeDML,
+				"Cannot INSERT MISSING value for key %s.", key))
+			continue
+		}
+
+		doc, err := x.writeDocument(ks, key, value, stmt.upsert)
+		if err == errDuplicateKey {
+			x.results.Errors = append(x.results.Errors, newError(ErrCodeDuplicateKey, "Duplicate Key: %s", key))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		x.results.MutationCount++
+
+		if err := x.appendReturning(c, stmt.returning, newQueryRow(doc)); err != nil {
+			return err
+		}
+	}
+
+	x.finishDML(stmt.returning)
+	return nil
+}
+
+// selectForMutation finds the rows which a DML statement applies to.
+func (x *execution) selectForMutation(c *evalContext, ks *Keyspace, path KeyspacePath, useKeys, where, limit expr) ([]*queryRow, error) {
+	if useKeys == nil {
+		if err := x.checkIndexAvailable(path, c.alias, where); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := x.readKeyspace(c, ks, path, useKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = x.filterRows(c, rows, where)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit != nil {
+		count, err := x.evalCount(limit, "LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		if count < len(rows) {
+			rows = rows[:count]
+		}
+	}
+
+	return rows, nil
+}
+
+func (x *execution) executeUpdate(stmt *updateStmt) error {
+	ks, path, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessUpdate)
+	if err != nil {
+		return err
+	}
+
+	c := x.baseContext()
+	c.alias = stmt.keyspace.alias
+
+	rows, err := x.selectForMutation(c, ks, path, stmt.useKeys, stmt.where, stmt.limit)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if _, ok := row.value.(map[string]interface{}); !ok {
+			// Only JSON objects can be updated.
+			continue
+		}
+
+		updated := &queryRow{
+			key:   row.key,
+			doc:   row.doc,
+			value: copyValue(row.value),
+		}
+		rowCtx := c.withRow(updated)
+
+		for _, set := range stmt.sets {
+			value, err := eval(rowCtx, set.value)
+			if err != nil {
+				return err
+			}
+			if err := assignPath(rowCtx, set.path, value); err != nil {
+				return err
+			}
+		}
+		for _, unset := range stmt.unsets {
+			if err := assignPath(rowCtx, unset, missing); err != nil {
+				return err
+			}
+		}
+
+		doc, err := x.replaceDocument(ks, row.key, updated.value)
+		if err != nil {
+			// The document was removed while we were working on it.
+			continue
+		}
+
+		x.results.MutationCount++
+
+		if err := x.appendReturning(c, stmt.returning, newQueryRow(doc)); err != nil {
+			return err
+		}
+	}
+
+	x.finishDML(stmt.returning)
+	return nil
+}
+
+// assignPath sets the value at a path within the current row, removing it if
+// the value is MISSING.  Paths which do not exist are ignored.
+func assignPath(c *evalContext, path expr, value interface{}) error {
+	var container interface{}
+	var index interface{}
+
+	switch p := path.(type) {
+	case *identExpr:
+		if p.name == c.alias {
+			return newError(ErrCodeSemantic, "Cannot SET or UNSET the entire document.")
+		}
+		container = c.row.value
+		index = p.name
+	case *fieldExpr:
+		target, err := eval(c, p.target)
+		if err != nil {
+			return err
+		}
+		container = target
+		index = p.name
+	case *indexExpr:
+		target, err := eval(c, p.target)
+		if err != nil {
+			return err
+		}
+		idx, err := eval(c, p.index)
+		if err != nil {
+			return err
+		}
+		container = target
+		index = idx
+	default:
+		return newError(ErrCodeParse, "Invalid path for SET or UNSET.")
+	}
+
+	switch cont := container.(type) {
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil
+		}
+		if isMissing(value) {
+			delete(cont, key)
+		} else {
+			cont[key] = value
+		}
+	case []interface{}:
+		pos, ok := index.(float64)
+		if !ok {
+			return nil
+		}
+		idx := int(pos)
+		if idx < 0 {
+			idx += len(cont)
+		}
+		if idx < 0 || idx >= len(cont) {
+			return nil
+		}
+		if isMissing(value) {
+			value = nil
+		}
+		cont[idx] = value
+	}
+
+	return nil
+}
+
+func (x *execution) executeDelete(stmt *deleteStmt) error {
+	ks, path, err := x.resolveKeyspace(&stmt.keyspace, KeyspaceAccessDelete)
+	if err != nil {
+		return err
+	}
+
+	c := x.baseContext()
+	c.alias = stmt.keyspace.alias
+
+	rows, err := x.selectForMutation(c, ks, path, stmt.useKeys, stmt.where, stmt.limit)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if _, err := x.removeDocument(ks, row.key); err != nil {
+			// The document was removed while we were working on it.
+			continue
+		}
+
+		x.results.MutationCount++
+
+		if err := x.appendReturning(c, stmt.returning, row); err != nil {
+			return err
+		}
+	}
+
+	x.finishDML(stmt.returning)
+	return nil
 }
@@ -0,0 +1,705 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/couchbaselabs/gocaves/mock/mocktime"
+)
+
+func newTestKeyspaces(t *testing.T) (KeyspaceResolver, *mockdb.Bucket) {
+	bucket, err := mockdb.NewBucket(mockdb.NewBucketOptions{
+		Chrono:      &mocktime.Chrono{},
+		NumVbuckets: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+
+	return func(path KeyspacePath, access KeyspaceAccess) (*Keyspace, error) {
+		if path.Bucket != "default" {
+			return nil, ErrKeyspaceNotFound
+		}
+		if path.Scope == "" && path.Collection == "" {
+			return &Keyspace{Store: bucket}, nil
+		}
+		if path.Scope == "inventory" && path.Collection == "hotels" {
+			return &Keyspace{Store: bucket, CollectionID: 8}, nil
+		}
+		return nil, ErrKeyspaceNotFound
+	}, bucket
+}
+
+func mustExecute(t *testing.T, e *Engine, opts ExecuteOptions) *ExecuteResults {
+	res, err := e.Execute(opts)
+	if err != nil {
+		t.Fatalf("failed to execute `%s`: %v", opts.Statement, err)
+	}
+	return res
+}
+
+func decodeRows(t *testing.T, res *ExecuteResults) []interface{} {
+	rows := make([]interface{}, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		var val interface{}
+		if err := json.Unmarshal(row, &val); err != nil {
+			t.Fatalf("failed to decode row %s: %v", row, err)
+		}
+		rows = append(rows, val)
+	}
+	return rows
+}
+
+func assertRows(t *testing.T, res *ExecuteResults, expected string) {
+	var expectedRows []interface{}
+	if err := json.Unmarshal([]byte(expected), &expectedRows); err != nil {
+		t.Fatalf("invalid expected rows: %v", err)
+	}
+
+	rows := decodeRows(t, res)
+	if !reflect.DeepEqual(rows, expectedRows) {
+		actual, _ := json.Marshal(rows)
+		t.Fatalf("unexpected rows:\nexpected: %s\nactual:   %s", expected, actual)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	e := NewEngine(NewEngineOptions{})
+	keyspaces, _ := newTestKeyspaces(t)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+
+	res := mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default (KEY, VALUE)
+			VALUES ("hotel-1", {"name": "Ritz", "city": "London", "rating": 5}),
+			VALUES ("hotel-2", {"name": "Savoy", "city": "London", "rating": 4}),
+			VALUES ("hotel-3", {"name": "Plaza", "city": "New York", "rating": 5}),
+			VALUES ("hotel-4", {"name": "Motel", "city": "Paris"})`,
+		Keyspaces: keyspaces,
+	})
+	if res.MutationCount != 4 {
+		t.Fatalf("expected 4 mutations but got %d", res.MutationCount)
+	}
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT name, META().id FROM default WHERE city = $city ORDER BY name DESC",
+		NamedArgs: map[string]interface{}{"$city": "London"},
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[{"name":"Savoy","id":"hotel-2"},{"name":"Ritz","id":"hotel-1"}]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement:      "SELECT RAW d.name FROM default AS d WHERE d.rating IS MISSING OR d.rating < ?",
+		PositionalArgs: []interface{}{5},
+		Keyspaces:      keyspaces,
+	})
+	assertRows(t, res, `["Savoy","Motel"]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT d.* FROM default d ORDER BY META(d).id LIMIT 2 OFFSET 1",
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[
+		{"name":"Savoy","city":"London","rating":4},
+		{"name":"Plaza","city":"New York","rating":5}
+	]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT * FROM default USE KEYS ['hotel-3', 'missing']",
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[{"default":{"name":"Plaza","city":"New York","rating":5}}]`)
+	if !reflect.DeepEqual(res.Signature, map[string]interface{}{"*": "*"}) {
+		t.Fatalf("unexpected signature %v", res.Signature)
+	}
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT city, COUNT(*) AS num FROM default GROUP BY city ORDER BY num DESC, city",
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[{"city":"London","num":2},{"city":"New York","num":1},{"city":"Paris","num":1}]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT UPPER('a') || LOWER('B'), 1 + 2 AS three",
+	})
+	assertRows(t, res, `[{"$1":"Ab","three":3}]`)
+}
+
+func TestMutations(t *testing.T) {
+	e := NewEngine(NewEngineOptions{})
+	keyspaces, _ := newTestKeyspaces(t)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default.inventory.hotels",
+		Keyspaces: keyspaces,
+	})
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement:    `UPSERT INTO hotels VALUES ("a", {"name": "A", "tags": ["x"]}), ("b", {"name": "B"})`,
+		QueryContext: "default:default.inventory",
+		Keyspaces:    keyspaces,
+	})
+
+	res := mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default.inventory.hotels VALUES ("a", {"name": "Dupe"})`,
+		Keyspaces: keyspaces,
+	})
+	if res.MutationCount != 0 || len(res.Errors) != 1 || res.Errors[0].Code != ErrCodeDuplicateKey {
+		t.Fatalf("expected a duplicate key error but got %+v", res)
+	}
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: `UPDATE default:default.inventory.hotels h SET h.rating = 3, tags[0] = "y" UNSET name
+			WHERE META(h).id = "a" RETURNING h.*`,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[{"rating":3,"tags":["y"]}]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement:    `DELETE FROM hotels WHERE name = "B" RETURNING RAW META().id`,
+		QueryContext: "default:default.inventory",
+		Keyspaces:    keyspaces,
+	})
+	assertRows(t, res, `["b"]`)
+	if res.MutationCount != 1 {
+		t.Fatalf("expected 1 mutation but got %d", res.MutationCount)
+	}
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT RAW META(h).id FROM default.inventory.hotels h",
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `["a"]`)
+
+	// The default collection should not have been touched.
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT RAW COUNT(*) FROM default",
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[0]`)
+}
+
+func TestErrors(t *testing.T) {
+	e := NewEngine(NewEngineOptions{})
+	keyspaces, _ := newTestKeyspaces(t)
+
+	tests := []struct {
+		statement string
+		code      int
+	}{
+		{"SELEC * FROM default", ErrCodeParse},
+		{"SELECT * FROM default WHERE", ErrCodeParse},
+		{"SELECT * FROM nobucket", ErrCodeKeyspaceNotFound},
+		{"SELECT $missing", ErrCodeInvalidParameters},
+		{"SELECT * FROM default WHERE COUNT(*) > 1", ErrCodeSemantic},
+		{"SELECT * FROM default", ErrCodePlan},
+		{"DELETE FROM default", ErrCodePlan},
+		{"DROP PRIMARY INDEX ON default", ErrCodeIndexNotFound},
+		{"CREATE INDEX ix ON default(name) USING VIEW", ErrCodeSemantic},
+	}
+
+	for _, test := range tests {
+		_, err := e.Execute(ExecuteOptions{
+			Statement: test.statement,
+			Keyspaces: keyspaces,
+		})
+		queryErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("expected a query error for `%s` but got %v", test.statement, err)
+		}
+		if queryErr.Code != test.code {
+			t.Fatalf("expected error code %d for `%s` but got %d", test.code, test.statement, queryErr.Code)
+		}
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	chrono := &mocktime.Chrono{}
+	e := NewEngine(NewEngineOptions{
+		Chrono:            chrono,
+		IndexBuildLatency: time.Second,
+	})
+	keyspaces, _ := newTestKeyspaces(t)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE INDEX by_city ON default(city, rating DESC) WHERE rating > 2",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement:    `CREATE PRIMARY INDEX ON hotels WITH {"defer_build": true}`,
+		QueryContext: "default:default.inventory",
+		Keyspaces:    keyspaces,
+	})
+
+	_, err := e.Execute(ExecuteOptions{
+		Statement: "CREATE INDEX by_city ON default(name)",
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeIndexExists {
+		t.Fatalf("expected an index exists error but got %v", err)
+	}
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE INDEX IF NOT EXISTS by_city ON default(name)",
+		Keyspaces: keyspaces,
+	})
+
+	res := mustExecute(t, e, ExecuteOptions{
+		Statement: `SELECT name, keyspace_id, bucket_id, index_key, ` + "`condition`" + `, is_primary, state
+			FROM system:indexes ORDER BY name`,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[
+		{"name":"#primary","keyspace_id":"hotels","bucket_id":"default","index_key":[],"is_primary":true,"state":"deferred"},
+		{"name":"by_city","keyspace_id":"default","index_key":["`+"`city`"+`","`+"`rating`"+` DESC"],
+			"condition":"(`+"`rating`"+` > 2)","state":"online"}
+	]`)
+
+	// The secondary index can only be used when the query refers to its key.
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "SELECT * FROM default d WHERE d.city = 'London'",
+		Keyspaces: keyspaces,
+	})
+	_, err = e.Execute(ExecuteOptions{
+		Statement: "SELECT * FROM default WHERE name = 'Ritz'",
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodePlan {
+		t.Fatalf("expected a no index error but got %v", err)
+	}
+
+	// Deferred indexes are unusable until they have been built.
+	hotelsQuery := ExecuteOptions{
+		Statement: "SELECT * FROM default.inventory.hotels",
+		Keyspaces: keyspaces,
+	}
+	if _, err := e.Execute(hotelsQuery); err == nil {
+		t.Fatalf("expected query on deferred index to fail")
+	}
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "BUILD INDEX ON default.inventory.hotels(`#primary`)",
+		Keyspaces: keyspaces,
+	})
+	if _, err := e.Execute(hotelsQuery); err == nil {
+		t.Fatalf("expected query on building index to fail")
+	}
+	chrono.TimeTravel(2 * time.Second)
+	mustExecute(t, e, hotelsQuery)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "DROP INDEX by_city ON default",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "DROP INDEX IF EXISTS default.by_city",
+		Keyspaces: keyspaces,
+	})
+
+	e.DropBucketIndexes("default")
+	if indexes := e.GetAllIndexes(); len(indexes) != 0 {
+		t.Fatalf("expected all indexes to be dropped but found %d", len(indexes))
+	}
+}
+
+func TestScanConsistency(t *testing.T) {
+	keyspaces, bucket := newTestKeyspaces(t)
+	e := NewEngine(NewEngineOptions{
+		Chrono:       bucket.Chrono(),
+		IndexLatency: 200 * time.Millisecond,
+	})
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+
+	res := mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("a", {"n": 1}) RETURNING RAW META().id`,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `["a"]`)
+
+	countQuery := ExecuteOptions{
+		Statement: "SELECT RAW COUNT(*) FROM default",
+		Keyspaces: keyspaces,
+	}
+
+	// The index has not yet caught up with the insert.
+	res = mustExecute(t, e, countQuery)
+	assertRows(t, res, `[0]`)
+
+	timeoutQuery := countQuery
+	timeoutQuery.ScanConsistency = ScanConsistencyRequestPlus
+	timeoutQuery.ScanWait = 10 * time.Millisecond
+	_, err := e.Execute(timeoutQuery)
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeIndexScanTimeout {
+		t.Fatalf("expected an index scan timeout but got %v", err)
+	}
+
+	requestPlusQuery := countQuery
+	requestPlusQuery.ScanConsistency = ScanConsistencyRequestPlus
+	res = mustExecute(t, e, requestPlusQuery)
+	assertRows(t, res, `[1]`)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("b", {"n": 2})`,
+		Keyspaces: keyspaces,
+	})
+
+	docB, err := bucket.Get(0, bucket.VbucketForKey([]byte("b")), 0, []byte("b"))
+	if err != nil {
+		t.Fatalf("failed to get document: %v", err)
+	}
+
+	atPlusQuery := countQuery
+	atPlusQuery.ScanConsistency = ScanConsistencyAtPlus
+	atPlusQuery.ScanVectors = map[string]ScanVector{
+		"default": {
+			docB.VbID: {SeqNo: docB.SeqNo},
+		},
+	}
+	res = mustExecute(t, e, atPlusQuery)
+	assertRows(t, res, `[2]`)
+}
+
+func TestPreparedStatements(t *testing.T) {
+	e := NewEngine(NewEngineOptions{})
+	keyspaces, _ := newTestKeyspaces(t)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("a", {"n": 1}), ("b", {"n": 2})`,
+		Keyspaces: keyspaces,
+	})
+
+	res := mustExecute(t, e, ExecuteOptions{
+		Statement: "PREPARE byN FROM SELECT RAW META().id FROM default WHERE n = $n",
+		Keyspaces: keyspaces,
+	})
+	rows := decodeRows(t, res)
+	if len(rows) != 1 {
+		t.Fatalf("expected a single prepared row but got %d", len(rows))
+	}
+	prepRow := rows[0].(map[string]interface{})
+	if prepRow["name"] != "byN" {
+		t.Fatalf("unexpected prepared name %v", prepRow["name"])
+	}
+	encodedPlan, _ := prepRow["encoded_plan"].(string)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement: `EXECUTE byN USING {"n": 2}`,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `["b"]`)
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Prepared:  "byN",
+		NamedArgs: map[string]interface{}{"n": 1},
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `["a"]`)
+
+	// Changing the indexes of the bucket makes the plan stale.
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE INDEX byN ON default(n)",
+		Keyspaces: keyspaces,
+	})
+
+	_, err := e.Execute(ExecuteOptions{
+		Prepared:  "byN",
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeNoSuchPrepared {
+		t.Fatalf("expected a no such prepared error but got %v", err)
+	}
+
+	_, err = e.Execute(ExecuteOptions{
+		Prepared:    "byN",
+		EncodedPlan: encodedPlan,
+		Keyspaces:   keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodePreparedDecoding {
+		t.Fatalf("expected a decoding error but got %v", err)
+	}
+
+	_, err = e.Execute(ExecuteOptions{
+		Prepared:    "byN",
+		EncodedPlan: "not-a-plan",
+		Keyspaces:   keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodePreparedDecoding {
+		t.Fatalf("expected a decoding error but got %v", err)
+	}
+
+	// The enhanced protocol prepares and executes the statement together.
+	res = mustExecute(t, e, ExecuteOptions{
+		Statement:   "PREPARE SELECT RAW n FROM default ORDER BY n",
+		AutoExecute: true,
+		Keyspaces:   keyspaces,
+	})
+	assertRows(t, res, `[1,2]`)
+	if res.Prepared == "" {
+		t.Fatalf("expected the prepared name to be returned")
+	}
+
+	res = mustExecute(t, e, ExecuteOptions{
+		Prepared:  res.Prepared,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, res, `[1,2]`)
+}
+
+func TestTransactions(t *testing.T) {
+	keyspaces, bucket := newTestKeyspaces(t)
+	e := NewEngine(NewEngineOptions{
+		Chrono: bucket.Chrono(),
+	})
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("a", {"n": 1}), ("b", {"n": 2})`,
+		Keyspaces: keyspaces,
+	})
+
+	beginTx := func() string {
+		res := mustExecute(t, e, ExecuteOptions{
+			Statement: "BEGIN WORK",
+			Keyspaces: keyspaces,
+		})
+		rows := decodeRows(t, res)
+		if len(rows) != 1 {
+			t.Fatalf("expected a single row from BEGIN WORK")
+		}
+		txID, _ := rows[0].(map[string]interface{})["txid"].(string)
+		if txID == "" {
+			t.Fatalf("expected BEGIN WORK to return a txid")
+		}
+		return txID
+	}
+
+	selectAll := func(txID string) *ExecuteResults {
+		return mustExecute(t, e, ExecuteOptions{
+			Statement: "SELECT RAW [META().id, n] FROM default ORDER BY META().id",
+			TxID:      txID,
+			Keyspaces: keyspaces,
+		})
+	}
+
+	txID := beginTx()
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("c", {"n": 3})`,
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `UPDATE default SET n = 10 WHERE META().id = "a"`,
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `DELETE FROM default USE KEYS "b"`,
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+
+	// The transaction sees its own writes, but nobody else does.
+	assertRows(t, selectAll(txID), `[["a",10],["c",3]]`)
+	assertRows(t, selectAll(""), `[["a",1],["b",2]]`)
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "COMMIT",
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, selectAll(""), `[["a",10],["c",3]]`)
+
+	_, err := e.Execute(ExecuteOptions{
+		Statement: "COMMIT",
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeTransactionContext {
+		t.Fatalf("expected a transaction context error but got %v", err)
+	}
+
+	// Rolled back writes are discarded.
+	txID = beginTx()
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `UPSERT INTO default VALUES ("d", {"n": 4})`,
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "ROLLBACK WORK",
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, selectAll(""), `[["a",10],["c",3]]`)
+
+	// Conflicting writes outside of the transaction cause the commit to fail.
+	txID = beginTx()
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `UPDATE default USE KEYS "a" SET n = 20`,
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `UPDATE default USE KEYS "a" SET n = 30`,
+		Keyspaces: keyspaces,
+	})
+	_, err = e.Execute(ExecuteOptions{
+		Statement: "COMMIT",
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeTransactionCommit {
+		t.Fatalf("expected a commit error but got %v", err)
+	}
+	assertRows(t, selectAll(""), `[["a",30],["c",3]]`)
+
+	// Transactions expire once their timeout has passed.
+	txID = beginTx()
+	bucket.Chrono().TimeTravel(20 * time.Second)
+	_, err = e.Execute(ExecuteOptions{
+		Statement: "COMMIT",
+		TxID:      txID,
+		Keyspaces: keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeTransactionExpired {
+		t.Fatalf("expected a transaction timeout but got %v", err)
+	}
+
+	// Implicit transactions only apply statements which fully succeed.
+	_, err = e.Execute(ExecuteOptions{
+		Statement:  `INSERT INTO default VALUES ("e", {"n": 5}), ("a", {"n": 6})`,
+		TxImplicit: true,
+		Keyspaces:  keyspaces,
+	})
+	if queryErr, ok := err.(*Error); !ok || queryErr.Code != ErrCodeDuplicateKey {
+		t.Fatalf("expected a duplicate key error but got %v", err)
+	}
+	mustExecute(t, e, ExecuteOptions{
+		Statement:  `INSERT INTO default VALUES ("e", {"n": 5})`,
+		TxImplicit: true,
+		Keyspaces:  keyspaces,
+	})
+	assertRows(t, selectAll(""), `[["a",30],["c",3],["e",5]]`)
+}
+
+func TestAnalytics(t *testing.T) {
+	keyspaces, bucket := newTestKeyspaces(t)
+	e := NewEngine(NewEngineOptions{
+		Chrono: bucket.Chrono(),
+	})
+	a := NewAnalyticsEngine(NewAnalyticsEngineOptions{
+		Chrono: bucket.Chrono(),
+	})
+
+	// Dataverse management is not specific to a bucket.
+	analyticsKeyspaces := func(path KeyspacePath, access KeyspaceAccess) (*Keyspace, error) {
+		if path.Bucket == "" {
+			return nil, nil
+		}
+		return keyspaces(path, access)
+	}
+
+	mustAnalytics := func(statement string) *ExecuteResults {
+		res, err := a.Execute(AnalyticsExecuteOptions{
+			Statement: statement,
+			Keyspaces: analyticsKeyspaces,
+		})
+		if err != nil {
+			t.Fatalf("failed to execute `%s`: %v", statement, err)
+		}
+		return res
+	}
+	assertAnalyticsError := func(statement string, code int) {
+		_, err := a.Execute(AnalyticsExecuteOptions{
+			Statement: statement,
+			Keyspaces: analyticsKeyspaces,
+		})
+		if analyticsErr, ok := err.(*Error); !ok || analyticsErr.Code != code {
+			t.Fatalf("expected error %d from `%s` but got %v", code, statement, err)
+		}
+	}
+
+	mustExecute(t, e, ExecuteOptions{
+		Statement: "CREATE PRIMARY INDEX ON default",
+		Keyspaces: keyspaces,
+	})
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES
+			("hotel-1", {"type": "hotel", "name": "Ritz"}),
+			("hotel-2", {"type": "hotel", "name": "Savoy"}),
+			("airline-1", {"type": "airline", "name": "Airways"})`,
+		Keyspaces: keyspaces,
+	})
+
+	mustAnalytics("CREATE DATAVERSE travel")
+	mustAnalytics("CREATE DATAVERSE travel IF NOT EXISTS")
+	assertAnalyticsError("CREATE DATAVERSE travel", ErrCodeAnalyticsDataverseExists)
+
+	mustAnalytics("CREATE DATASET travel.hotels ON default WHERE `type` = \"hotel\"")
+	assertAnalyticsError("CREATE DATASET travel.hotels ON default", ErrCodeAnalyticsDatasetExists)
+	assertAnalyticsError("CREATE DATASET missing.hotels ON default", ErrCodeAnalyticsDataverseNotFound)
+	mustAnalytics("CREATE INDEX names ON travel.hotels (name: string)")
+	assertAnalyticsError("CREATE INDEX names ON travel.hotels (name: string)", ErrCodeAnalyticsIndexExists)
+
+	// Nothing is ingested until the link has been connected.
+	res := mustAnalytics("SELECT RAW name FROM travel.hotels ORDER BY name")
+	assertRows(t, res, `[]`)
+
+	mustAnalytics("CONNECT LINK travel.Local")
+	res = mustAnalytics("SELECT RAW name FROM travel.hotels ORDER BY name")
+	assertRows(t, res, `["Ritz","Savoy"]`)
+	if !reflect.DeepEqual(res.Signature, map[string]interface{}{"*": "*"}) {
+		t.Fatalf("unexpected signature %v", res.Signature)
+	}
+
+	// Once disconnected, new mutations are no longer shadowed.
+	mustAnalytics("DISCONNECT LINK travel.Local")
+	bucket.Chrono().TimeTravel(time.Second)
+	mustExecute(t, e, ExecuteOptions{
+		Statement: `INSERT INTO default VALUES ("hotel-3", {"type": "hotel", "name": "Plaza"})`,
+		Keyspaces: keyspaces,
+	})
+	assertRows(t, mustAnalytics("SELECT COUNT(*) AS n FROM travel.hotels"), `[{"n":2}]`)
+	if pending := a.PendingMutations(keyspaces); pending["travel"]["hotels"] != 1 {
+		t.Fatalf("expected a single pending mutation but got %v", pending)
+	}
+
+	mustAnalytics("CONNECT LINK travel.Local")
+	assertRows(t, mustAnalytics("SELECT COUNT(*) AS n FROM travel.hotels"), `[{"n":3}]`)
+
+	assertRows(t, mustAnalytics("SELECT d.DatasetName, d.BucketName FROM Metadata.`Dataset` d"),
+		`[{"DatasetName":"hotels","BucketName":"default"}]`)
+	assertRows(t, mustAnalytics("SELECT RAW IndexName FROM Metadata.`Index` WHERE NOT IsPrimary"),
+		`["names"]`)
+
+	assertAnalyticsError("SELECT * FROM travel.missing", ErrCodeAnalyticsDatasetNotFound)
+	assertAnalyticsError("SELEKT 1", ErrCodeAnalyticsCompilation)
+	assertAnalyticsError("CONNECT LINK travel.Remote", ErrCodeAnalyticsLinkNotFound)
+
+	mustAnalytics("DROP INDEX travel.hotels.names")
+	assertAnalyticsError("DROP INDEX travel.hotels.names", ErrCodeAnalyticsIndexNotFound)
+	mustAnalytics("DROP DATASET travel.hotels")
+	assertAnalyticsError("DROP DATASET travel.hotels", ErrCodeAnalyticsDatasetNotFound)
+	mustAnalytics("DROP DATASET travel.hotels IF EXISTS")
+	mustAnalytics("DROP DATAVERSE travel")
+	assertAnalyticsError("DROP DATAVERSE travel", ErrCodeAnalyticsDataverseNotFound)
+}
@@ -0,0 +1,353 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/couchbase/gocbcore/v9/memd"
+	"github.com/couchbaselabs/gocaves/mock/mockdb"
+	"github.com/google/uuid"
+)
+
+// defaultTxTimeout is the timeout used for transactions which do not specify
+// one, which matches the default of the real query service.
+const defaultTxTimeout = 15 * time.Second
+
+type stagedKey struct {
+	store        *mockdb.Bucket
+	collectionID uint
+	key          string
+}
+
+// stagedWrite represents a mutation which has been made within a transaction
+// but not yet committed.  The original document is kept in order to detect
+// conflicting writes when the transaction is committed.
+type stagedWrite struct {
+	ks       *Keyspace
+	original *mockdb.Document
+	value    interface{}
+	deleted  bool
+}
+
+// transaction represents a query transaction which was started by BEGIN WORK.
+// Only a single statement can execute within a transaction at any one time.
+type transaction struct {
+	id     string
+	expiry time.Time
+
+	lock   sync.Mutex
+	keys   []stagedKey
+	writes map[stagedKey]*stagedWrite
+}
+
+func newTransaction(expiry time.Time) *transaction {
+	return &transaction{
+		id:     uuid.New().String(),
+		expiry: expiry,
+		writes: make(map[stagedKey]*stagedWrite),
+	}
+}
+
+func (e *Engine) beginTransaction(timeout time.Duration) *transaction {
+	if timeout <= 0 {
+		timeout = defaultTxTimeout
+	}
+
+	tx := newTransaction(e.chrono.Now().Add(timeout))
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.transactions == nil {
+		e.transactions = make(map[string]*transaction)
+	}
+	e.transactions[tx.id] = tx
+
+	return tx
+}
+
+// getTransaction finds an active transaction, removing it if it has expired.
+func (e *Engine) getTransaction(txID string) (*transaction, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	tx := e.transactions[txID]
+	if tx == nil {
+		return nil, newError(ErrCodeTransactionContext, "Transaction context error: transaction (%s) not found", txID)
+	}
+
+	if !e.chrono.Now().Before(tx.expiry) {
+		delete(e.transactions, txID)
+		return nil, newError(ErrCodeTransactionExpired, "Transaction timeout")
+	}
+
+	return tx, nil
+}
+
+func (e *Engine) endTransaction(txID string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	delete(e.transactions, txID)
+}
+
+func txKey(ks *Keyspace, key string) stagedKey {
+	return stagedKey{
+		store:        ks.Store,
+		collectionID: ks.CollectionID,
+		key:          key,
+	}
+}
+
+// currentDocument returns the document which a transaction currently sees for
+// a key, along with its value.  A nil document means it does not exist.
+func (tx *transaction) currentDocument(ks *Keyspace, key string) (*mockdb.Document, interface{}, error) {
+	if write := tx.writes[txKey(ks, key)]; write != nil {
+		if write.deleted {
+			return nil, nil, nil
+		}
+		return write.stagedDocument(key), write.value, nil
+	}
+
+	doc, err := fetchDocument(ks, key)
+	if err != nil || doc == nil {
+		return nil, nil, err
+	}
+
+	row := newQueryRow(doc)
+	return doc, row.value, nil
+}
+
+// stagedDocument builds the document which a staged write would produce.
+func (write *stagedWrite) stagedDocument(key string) *mockdb.Document {
+	bytes, _ := json.Marshal(stripMissing(write.value))
+
+	doc := &mockdb.Document{
+		CollectionID: write.ks.CollectionID,
+		Key:          []byte(key),
+		Flags:        jsonFlags,
+	}
+	if write.original != nil && !write.original.IsDeleted {
+		doc.Cas = write.original.Cas
+		doc.Flags = write.original.Flags
+	}
+	doc.Value = bytes
+	doc.Datatype = uint8(memd.DatatypeFlagJSON)
+
+	return doc
+}
+
+func (tx *transaction) stage(ks *Keyspace, key string, value interface{}, deleted bool) (*mockdb.Document, error) {
+	skey := txKey(ks, key)
+
+	write := tx.writes[skey]
+	if write == nil {
+		original, err := fetchDocument(ks, key)
+		if err != nil {
+			return nil, err
+		}
+
+		write = &stagedWrite{
+			ks:       ks,
+			original: original,
+		}
+		tx.writes[skey] = write
+		tx.keys = append(tx.keys, skey)
+	}
+
+	write.value = copyValue(value)
+	write.deleted = deleted
+
+	return write.stagedDocument(key), nil
+}
+
+func (tx *transaction) writeDocument(ks *Keyspace, key string, value interface{}, upsert bool) (*mockdb.Document, error) {
+	doc, _, err := tx.currentDocument(ks, key)
+	if err != nil {
+		return nil, err
+	}
+	if doc != nil && !upsert {
+		return nil, errDuplicateKey
+	}
+
+	return tx.stage(ks, key, value, false)
+}
+
+func (tx *transaction) replaceDocument(ks *Keyspace, key string, value interface{}) (*mockdb.Document, error) {
+	doc, _, err := tx.currentDocument(ks, key)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, mockdb.ErrDocNotFound
+	}
+
+	return tx.stage(ks, key, value, false)
+}
+
+func (tx *transaction) removeDocument(ks *Keyspace, key string) (*mockdb.Document, error) {
+	doc, _, err := tx.currentDocument(ks, key)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, mockdb.ErrDocNotFound
+	}
+
+	return tx.stage(ks, key, nil, true)
+}
+
+// overlay applies the writes staged by the transaction to rows read from a
+// keyspace.  When keys is nil the rows come from a scan and every document
+// inserted by the transaction is added, otherwise only those which were
+// requested are.
+func (tx *transaction) overlay(ks *Keyspace, rows []*queryRow, keys []string) []*queryRow {
+	var out []*queryRow
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		seen[row.key] = true
+
+		write := tx.writes[txKey(ks, row.key)]
+		if write == nil {
+			out = append(out, row)
+			continue
+		}
+		if write.deleted {
+			continue
+		}
+		out = append(out, &queryRow{
+			key:   row.key,
+			doc:   write.stagedDocument(row.key),
+			value: copyValue(write.value),
+		})
+	}
+
+	var wanted map[string]bool
+	if keys != nil {
+		wanted = make(map[string]bool)
+		for _, key := range keys {
+			wanted[key] = true
+		}
+	}
+
+	for _, skey := range tx.keys {
+		write := tx.writes[skey]
+		if skey != txKey(ks, skey.key) || write.deleted || seen[skey.key] {
+			continue
+		}
+		if wanted != nil && !wanted[skey.key] {
+			continue
+		}
+		out = append(out, &queryRow{
+			key:   skey.key,
+			doc:   write.stagedDocument(skey.key),
+			value: copyValue(write.value),
+		})
+	}
+
+	return out
+}
+
+// commit applies all of the staged writes of the transaction.  Every document
+// is first checked to make sure it has not been modified since the transaction
+// first read it, in which case none of the writes are applied.
+func (tx *transaction) commit() error {
+	for _, skey := range tx.keys {
+		write := tx.writes[skey]
+
+		current, err := fetchDocument(write.ks, skey.key)
+		if err != nil {
+			return err
+		}
+
+		var originalCas, currentCas uint64
+		if write.original != nil {
+			originalCas = write.original.Cas
+		}
+		if current != nil {
+			currentCas = current.Cas
+		}
+		if originalCas != currentCas {
+			return newError(ErrCodeTransactionCommit,
+				"Commit Transaction statement error - cause: write write conflict on key %s", skey.key)
+		}
+	}
+
+	for _, skey := range tx.keys {
+		write := tx.writes[skey]
+
+		var err error
+		if write.deleted {
+			if write.original != nil {
+				_, err = removeDocument(write.ks, skey.key)
+			}
+		} else if write.original != nil {
+			_, err = replaceDocument(write.ks, skey.key, write.value)
+		} else {
+			_, err = writeDocument(write.ks, skey.key, write.value, true)
+		}
+		if err != nil {
+			return newError(ErrCodeTransactionCommit, "Commit Transaction statement error - cause: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (x *execution) executeBegin(stmt *beginStmt) error {
+	if x.tx != nil {
+		return newError(ErrCodeTransactionContext, "Transaction context error: a transaction is already active")
+	}
+
+	tx := x.engine.beginTransaction(x.opts.TxTimeout)
+
+	encoded, err := encodeValue(map[string]interface{}{
+		"txid": tx.id,
+	})
+	if err != nil {
+		return err
+	}
+
+	x.results.Signature = "json"
+	x.results.Rows = append(x.results.Rows, encoded)
+	return nil
+}
+
+func (x *execution) executeCommit(stmt *commitStmt) error {
+	if x.tx == nil || x.opts.TxImplicit {
+		return newError(ErrCodeTransactionContext, "Transaction context error: COMMIT requires an active transaction")
+	}
+
+	x.engine.endTransaction(x.tx.id)
+	return x.tx.commit()
+}
+
+func (x *execution) executeRollback(stmt *rollbackStmt) error {
+	if x.tx == nil || x.opts.TxImplicit {
+		return newError(ErrCodeTransactionContext, "Transaction context error: ROLLBACK requires an active transaction")
+	}
+
+	x.engine.endTransaction(x.tx.id)
+	return nil
+}
+
+// executeImplicit executes a single statement within its own transaction,
+// which is committed only if the statement succeeds completely.
+func (x *execution) executeImplicit(stmt statement) error {
+	timeout := x.opts.TxTimeout
+	if timeout <= 0 {
+		timeout = defaultTxTimeout
+	}
+	x.tx = newTransaction(x.engine.chrono.Now().Add(timeout))
+
+	if err := x.executeStatement(stmt); err != nil {
+		return err
+	}
+
+	if len(x.results.Errors) > 0 {
+		return x.results.Errors[0]
+	}
+
+	return x.tx.commit()
+}
@@ -0,0 +1,264 @@
+package mockn1ql
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// missingValue represents the MISSING value, which is distinct from null and
+// is used when a field does not exist in a document.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+func isMissing(val interface{}) bool {
+	_, ok := val.(missingValue)
+	return ok
+}
+
+// The order in which the different types of values collate.
+const (
+	typeOrderMissing = iota
+	typeOrderNull
+	typeOrderBoolean
+	typeOrderNumber
+	typeOrderString
+	typeOrderArray
+	typeOrderObject
+	typeOrderBinary
+)
+
+func typeOrder(val interface{}) int {
+	switch val.(type) {
+	case missingValue:
+		return typeOrderMissing
+	case nil:
+		return typeOrderNull
+	case bool:
+		return typeOrderBoolean
+	case float64:
+		return typeOrderNumber
+	case string:
+		return typeOrderString
+	case []interface{}:
+		return typeOrderArray
+	case map[string]interface{}:
+		return typeOrderObject
+	}
+	return typeOrderBinary
+}
+
+// typeName returns the name of the type of a value as reported by TYPE().
+func typeName(val interface{}) string {
+	switch typeOrder(val) {
+	case typeOrderMissing:
+		return "missing"
+	case typeOrderNull:
+		return "null"
+	case typeOrderBoolean:
+		return "boolean"
+	case typeOrderNumber:
+		return "number"
+	case typeOrderString:
+		return "string"
+	case typeOrderArray:
+		return "array"
+	case typeOrderObject:
+		return "object"
+	}
+	return "binary"
+}
+
+// collate compares two values using the N1QL collation order, returning a
+// negative number, zero or a positive number, similar to strings.Compare.
+func collate(a, b interface{}) int {
+	aOrder, bOrder := typeOrder(a), typeOrder(b)
+	if aOrder != bOrder {
+		return aOrder - bOrder
+	}
+
+	switch aVal := a.(type) {
+	case bool:
+		bVal := b.(bool)
+		if aVal == bVal {
+			return 0
+		} else if !aVal {
+			return -1
+		}
+		return 1
+
+	case float64:
+		bVal := b.(float64)
+		if aVal < bVal {
+			return -1
+		} else if aVal > bVal {
+			return 1
+		}
+		return 0
+
+	case string:
+		bVal := b.(string)
+		if aVal < bVal {
+			return -1
+		} else if aVal > bVal {
+			return 1
+		}
+		return 0
+
+	case []interface{}:
+		bVal := b.([]interface{})
+		for i := 0; i < len(aVal) && i < len(bVal); i++ {
+			if cmp := collate(aVal[i], bVal[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(aVal) - len(bVal)
+
+	case map[string]interface{}:
+		bVal := b.(map[string]interface{})
+		if len(aVal) != len(bVal) {
+			return len(aVal) - len(bVal)
+		}
+
+		aKeys := sortedKeys(aVal)
+		bKeys := sortedKeys(bVal)
+		for i := range aKeys {
+			if aKeys[i] != bKeys[i] {
+				if aKeys[i] < bKeys[i] {
+					return -1
+				}
+				return 1
+			}
+		}
+		for _, key := range aKeys {
+			if cmp := collate(aVal[key], bVal[key]); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	}
+
+	return 0
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isTruthy reports whether a value is considered true when used as a
+// condition.  Only MISSING, null, false, 0, "", [] and {} are false.
+func isTruthy(val interface{}) bool {
+	switch v := val.(type) {
+	case missingValue, nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// normalizeValue converts any values decoded from JSON, or provided as query
+// parameters, into the set of types used by the evaluator.
+func normalizeValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, bool, float64, string, missingValue:
+		return v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil
+		}
+		return f
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = normalizeValue(item)
+		}
+		return out
+	}
+
+	// Anything else is round-tripped through JSON to get it into a known form.
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(bytes, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// copyValue performs a deep copy of a value so that it can be modified
+// without affecting the original.
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = copyValue(item)
+		}
+		return out
+	}
+	return val
+}
+
+// stripMissing removes any MISSING values from within arrays and objects so
+// that a value can be encoded as JSON.  Missing array elements become null.
+func stripMissing(val interface{}) interface{} {
+	switch v := val.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			if isMissing(item) {
+				out[i] = nil
+			} else {
+				out[i] = stripMissing(item)
+			}
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if !isMissing(item) {
+				out[key] = stripMissing(item)
+			}
+		}
+		return out
+	}
+	return val
+}
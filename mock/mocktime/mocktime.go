@@ -72,6 +72,13 @@ func (t *Chrono) addTimer(mtmr *mockTimer) {
 func (t *Chrono) removeTimer(tmr *mockTimer) {
 	t.timersLock.Lock()
 	defer t.timersLock.Unlock()
+
+	for tmrIdx, mtmr := range t.timers {
+		if mtmr == tmr {
+			t.timers = append(t.timers[:tmrIdx], t.timers[tmrIdx+1:]...)
+			break
+		}
+	}
 }
 
 func (t *Chrono) rescheduleTimers() {
@@ -112,7 +119,7 @@ func (t *Chrono) AfterFunc(d time.Duration, f func()) {
 
 // After returns a channel which signals when the duration has passed.
 func (t *Chrono) After(d time.Duration) <-chan time.Time {
-	tmrCh := make(chan time.Time)
+	tmrCh := make(chan time.Time, 1)
 	t.AfterFunc(d, func() {
 		tmrCh <- t.Now()
 	})
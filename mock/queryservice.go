@@ -1,6 +1,9 @@
 package mock
 
-import "github.com/couchbaselabs/gocaves/mock/mockauth"
+import (
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockn1ql"
+)
 
 // QueryService represents a query service running somewhere in the cluster.
 type QueryService interface {
@@ -22,3 +25,15 @@ type QueryService interface {
 	// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 	CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string, request *HTTPRequest) bool
 }
+
+// QueryEngine represents the query engine shared by the query services of a cluster.
+type QueryEngine interface {
+	// Execute executes a query.
+	Execute(opts mockn1ql.ExecuteOptions) (*mockn1ql.ExecuteResults, error)
+
+	// GetAllIndexes returns all of the GSI indexes in the cluster.
+	GetAllIndexes() []*mockn1ql.Index
+
+	// DropBucketIndexes removes all of the GSI indexes belonging to a bucket.
+	DropBucketIndexes(bucket string)
+}
@@ -1,6 +1,9 @@
 package mock
 
-import "github.com/couchbaselabs/gocaves/mock/mockauth"
+import (
+	"github.com/couchbaselabs/gocaves/mock/mockauth"
+	"github.com/couchbaselabs/gocaves/mock/mockfts"
+)
 
 // SearchService represents a views service running somewhere in the cluster.
 type SearchService interface {
@@ -22,3 +25,36 @@ type SearchService interface {
 	// CheckAuthenticated verifies that the currently authenticated user has the specified permissions.
 	CheckAuthenticated(permission mockauth.Permission, bucket, scope, collection string, request *HTTPRequest) bool
 }
+
+// SearchEngine represents the search engine shared by the search services of a cluster.
+type SearchEngine interface {
+	// UpsertIndex creates or updates a search index.
+	UpsertIndex(def *mockfts.IndexDefinition, sources mockfts.SourceResolver) (*mockfts.IndexDefinition, error)
+
+	// GetIndex returns the definition of a search index.
+	GetIndex(name string) (*mockfts.IndexDefinition, error)
+
+	// GetAllIndexes returns the definitions of all of the search indexes.
+	GetAllIndexes() []*mockfts.IndexDefinition
+
+	// DeleteIndex removes a search index.
+	DeleteIndex(name string) (*mockfts.IndexDefinition, error)
+
+	// DropBucketIndexes removes all of the search indexes sourced from a bucket.
+	DropBucketIndexes(bucket string)
+
+	// SetIngestPaused pauses or resumes ingestion into a search index.
+	SetIngestPaused(name string, paused bool) error
+
+	// SetQueryAllowed allows or disallows querying of a search index.
+	SetQueryAllowed(name string, allowed bool) error
+
+	// SetPlanFrozen freezes or unfreezes the plan of a search index.
+	SetPlanFrozen(name string, frozen bool) error
+
+	// DocCount returns the number of documents in a search index.
+	DocCount(name string, sources mockfts.SourceResolver) (int, error)
+
+	// Search executes a search request against a search index.
+	Search(name string, req *mockfts.SearchRequest, sources mockfts.SourceResolver) (*mockfts.SearchResults, error)
+}
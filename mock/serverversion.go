@@ -0,0 +1,85 @@
+package mock
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ServerVersion specifies the version of Couchbase Server which a cluster
+// is emulating.  Versions are encoded as major*100 + minor*10 so that they
+// can be compared directly.
+type ServerVersion uint
+
+// The following lists the server versions which can be emulated.
+const (
+	ServerVersion60 = ServerVersion(600)
+	ServerVersion65 = ServerVersion(650)
+	ServerVersion66 = ServerVersion(660)
+	ServerVersion70 = ServerVersion(700)
+	ServerVersion72 = ServerVersion(720)
+
+	// ServerVersionLatest is the most recent server version that is supported.
+	ServerVersionLatest = ServerVersion72
+)
+
+// ErrUnsupportedServerVersion is returned when a server version is specified
+// which the mock does not know how to emulate.
+var ErrUnsupportedServerVersion = errors.New("unsupported server version")
+
+// ParseServerVersion parses a server version string such as "6.5" or "6.5.0".
+func ParseServerVersion(versionStr string) (ServerVersion, error) {
+	switch versionStr {
+	case "6.0", "6.0.0":
+		return ServerVersion60, nil
+	case "6.5", "6.5.0":
+		return ServerVersion65, nil
+	case "6.6", "6.6.0":
+		return ServerVersion66, nil
+	case "7.0", "7.0.0":
+		return ServerVersion70, nil
+	case "7.2", "7.2.0":
+		return ServerVersion72, nil
+	}
+
+	return 0, ErrUnsupportedServerVersion
+}
+
+// Major returns the major component of this version.
+func (v ServerVersion) Major() uint {
+	return uint(v) / 100
+}
+
+// Minor returns the minor component of this version.
+func (v ServerVersion) Minor() uint {
+	return (uint(v) % 100) / 10
+}
+
+// String returns the version formatted as it is by the server, for instance "6.5.0".
+func (v ServerVersion) String() string {
+	return fmt.Sprintf("%d.%d.0", v.Major(), v.Minor())
+}
+
+// BuildString returns the full build string reported by the server for this version.
+func (v ServerVersion) BuildString() string {
+	var buildNum int
+	switch v {
+	case ServerVersion60:
+		buildNum = 1693
+	case ServerVersion65:
+		buildNum = 4960
+	case ServerVersion66:
+		buildNum = 7909
+	case ServerVersion72:
+		buildNum = 5325
+	default:
+		buildNum = 3016
+	}
+
+	return fmt.Sprintf("%s-%d-enterprise", v.String(), buildNum)
+}
+
+// ClusterCompatibility returns the cluster compatibility version reported by
+// nodes running this version.
+func (v ServerVersion) ClusterCompatibility() int {
+	return int(v.Major())*0x10000 + int(v.Minor())
+}
@@ -12,4 +12,5 @@ const (
 	ServiceTypeQuery     = ServiceType(4)
 	ServiceTypeSearch    = ServiceType(5)
 	ServiceTypeAnalytics = ServiceType(6)
+	ServiceTypeEventing  = ServiceType(7)
 )
@@ -18,4 +18,10 @@ type UserManager interface {
 
 	// GetAllClusterRoles will return all roles from the cluster.
 	GetAllClusterRoles() []*mockauth.ClusterRole
+
+	// ClientCertAuth returns the current client certificate auth settings.
+	ClientCertAuth() mockauth.ClientCertAuthSettings
+
+	// SetClientCertAuth updates the client certificate auth settings.
+	SetClientCertAuth(settings mockauth.ClientCertAuthSettings) error
 }
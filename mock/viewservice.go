@@ -34,6 +34,10 @@ type ViewIndexManager interface {
 	// DropDesignDocument removes a design document.
 	DropDesignDocument(name string) error
 
+	// PublishDesignDocument copies a development design document into the
+	// production namespace.
+	PublishDesignDocument(name string) error
+
 	// GetDesignDocument retrieves a single design document.
 	GetDesignDocument(name string) (*mockmr.DesignDocument, error)
 
@@ -42,4 +46,7 @@ type ViewIndexManager interface {
 
 	// Execute executes a query.
 	Execute(opts mockmr.ExecuteOptions) (int, *mockmr.ExecuteResults, error)
+
+	// ExecuteSpatial executes a spatial query.
+	ExecuteSpatial(opts mockmr.ExecuteSpatialOptions) (*mockmr.ExecuteResults, error)
 }